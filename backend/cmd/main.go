@@ -1,61 +1,262 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"step-ca-webui/internal/acme"
 	"step-ca-webui/internal/api"
+	"step-ca-webui/internal/approval"
+	"step-ca-webui/internal/auth"
 	"step-ca-webui/internal/config"
 	"step-ca-webui/internal/db"
+	"step-ca-webui/internal/deploy"
+	"step-ca-webui/internal/logging"
+	"step-ca-webui/internal/metrics"
+	"step-ca-webui/internal/policy"
+	"step-ca-webui/internal/profile"
 	"step-ca-webui/internal/step"
+	"step-ca-webui/internal/storage"
+	"step-ca-webui/internal/tracing"
 
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
 )
 
 func main() {
+	// Log JSON from the start; the level is applied once the configuration
+	// is loaded
+	logging.Setup(os.Stdout, "")
+
 	// Load environment variables
-	if err := godotenv.Load(); err != nil {
-		log.Println("No .env file found, using environment variables")
-	}
+	envErr := godotenv.Load()
 
 	// Load configuration
 	cfg := config.Load()
-	
-	// DEBUG: Print configuration at startup
-	log.Printf("=== CONFIGURATION ===")
-	log.Printf("CA_URL: %s", cfg.CAURL)
-	log.Printf("CA_ROOT_FINGERPRINT: %s", cfg.CARootFingerprint)
-	log.Printf("PROVISIONER_NAME: %s", cfg.ProvisionerName)
-	log.Printf("====================")
+	if err := logging.Setup(os.Stdout, cfg.LogLevel); err != nil {
+		fatal("Invalid LOG_LEVEL", err)
+	}
+	if envErr != nil {
+		slog.Info("No .env file found, using environment variables")
+	}
+	slog.Info("Configuration loaded",
+		"ca_url", cfg.CAURL,
+		"provisioner_type", cfg.ProvisionerType,
+		"provisioner", cfg.ProvisionerName,
+		"root_pinned", cfg.CARootFingerprint != "",
+		"metrics_protected", cfg.MetricsToken != "",
+		"otlp_endpoint", cfg.OTLPEndpoint,
+		"log_level", cfg.LogLevel)
+
+	// Export traces if an OTLP endpoint is configured
+	shutdownTracing := func(context.Context) error { return nil }
+	if cfg.OTLPEndpoint != "" {
+		var err error
+		shutdownTracing, err = tracing.Setup(context.Background())
+		if err != nil {
+			fatal("Failed to set up tracing", err)
+		}
+	}
 
 	// Initialize database
 	database, err := db.NewDatabase(cfg.DBPath)
 	if err != nil {
-		log.Fatalf("Failed to initialize database: %v", err)
+		fatal("Failed to initialize database", err)
+	}
+
+	// Report the inventory to Prometheus
+	if err := metrics.RegisterCertificates(database); err != nil {
+		fatal("Failed to register certificate metrics", err)
+	}
+
+	// Deployments run in memory; any left running by a previous process
+	// can no longer finish
+	if err := database.FailInterruptedDeployments(context.Background()); err != nil {
+		slog.Error("Failed to clean up interrupted deployments", "error", err)
+	}
+
+	// Load issuance profiles
+	profiles, err := profile.Load(cfg.ProfilesFile, profile.Limits{
+		Min:         cfg.MinCertLifetime,
+		Max:         cfg.MaxCertLifetime,
+		MaxBackdate: cfg.MaxCertBackdate,
+	})
+	if err != nil {
+		fatal("Failed to load issuance profiles", err)
+	}
+
+	// Load naming policy
+	policyEngine, err := policy.Load(cfg.PolicyFile)
+	if err != nil {
+		fatal("Failed to load naming policy", err)
+	}
+
+	// Load approval workflow rules
+	approvals, err := approval.Load(cfg.ApprovalFile)
+	if err != nil {
+		fatal("Failed to load approval rules", err)
+	}
+
+	// Load API keys used to identify callers
+	apiKeys, err := auth.LoadAPIKeys(cfg.APIKeysFile)
+	if err != nil {
+		fatal("Failed to load API keys", err)
+	}
+
+	// Select where issued certificates and keys are kept
+	store, err := storage.New(storage.Config{
+		Type: cfg.StorageBackend,
+		Vault: storage.VaultConfig{
+			Addr:         cfg.VaultAddr,
+			Namespace:    cfg.VaultNamespace,
+			CACert:       cfg.VaultCACert,
+			Token:        cfg.VaultToken,
+			RoleID:       cfg.VaultRoleID,
+			SecretID:     cfg.VaultSecretID,
+			AppRoleMount: cfg.VaultAppRoleMount,
+			Mount:        cfg.VaultKVMount,
+			Path:         cfg.VaultKVPath,
+		},
+	})
+	if err != nil {
+		fatal("Failed to initialize storage backend", err)
+	}
+
+	// Read ACME accounts and certificates from the CA database if configured
+	var acmeReader *acme.Reader
+	if cfg.CADBType != "" {
+		acmeReader, err = acme.Open(acme.Config{
+			Type:       cfg.CADBType,
+			DataSource: cfg.CADBDataSource,
+			Database:   cfg.CADBName,
+		})
+		if err != nil {
+			fatal("Failed to open CA database", err)
+		}
 	}
 
-	// Initialize step client
+	// Initialize step client with the default provisioner
+	credentials := step.Credentials{
+		Type:         cfg.ProvisionerType,
+		Provisioner:  cfg.ProvisionerName,
+		Password:     cfg.ProvisionerPassword,
+		PasswordFile: cfg.ProvisionerPassFile,
+		CertFile:     cfg.ProvisionerCert,
+		KeyFile:      cfg.ProvisionerKey,
+		TokenFile:    cfg.ProvisionerToken,
+	}
+	if err := credentials.Validate(); err != nil {
+		fatal("Invalid provisioner configuration", err)
+	}
 	stepClient := step.NewStepClient(
 		cfg.CAURL,
 		cfg.CARootFingerprint,
-		cfg.ProvisionerName,
-		cfg.ProvisionerPassword,
+		credentials,
 	)
-	
-	log.Printf("StepClient initialized with fingerprint: %s", stepClient.CARootFingerprint)
+	stepClient.OCSPURL = cfg.OCSPURL
+
+	// Manage provisioners through the CA admin API if an admin certificate is configured
+	var adminClient *step.AdminClient
+	if cfg.StepAdminCert != "" {
+		if len(cfg.ProvisionerRoles) == 0 {
+			fatal("Invalid provisioner management configuration", errors.New("STEP_ADMIN_CERT requires PROVISIONER_ADMIN_ROLES"))
+		}
+		adminClient, err = step.NewAdminClient(stepClient, step.AdminConfig{
+			CertFile: cfg.StepAdminCert,
+			KeyFile:  cfg.StepAdminKey,
+		})
+		if err != nil {
+			fatal("Failed to load step-ca admin credentials", err)
+		}
+	}
+
+	// Publish a CRL of revocations made here if a CRL signing certificate is configured
+	var crlPublisher *step.CRLPublisher
+	if cfg.CRLSigningCert != "" {
+		if cfg.CRLPublishInterval <= 0 || cfg.CRLPublishInterval >= cfg.CRLValidity {
+			fatal("Invalid CRL configuration", errors.New("CRL_PUBLISH_INTERVAL must be positive and shorter than CRL_VALIDITY"))
+		}
+		crlPublisher, err = step.NewCRLPublisher(step.CRLPublisherConfig{
+			Name:     cfg.CRLName,
+			CertFile: cfg.CRLSigningCert,
+			KeyFile:  cfg.CRLSigningKey,
+			Validity: cfg.CRLValidity,
+		})
+		if err != nil {
+			fatal("Failed to load CRL signing certificate", err)
+		}
+	}
 
 	// Initialize handlers
-	handlers := api.NewHandlers(database, stepClient)
+	handlers := api.NewHandlers(database, stepClient, profiles, policyEngine, approvals, deploy.Options{
+		SSHKeyFile:    cfg.DeploySSHKeyFile,
+		SSHKnownHosts: cfg.DeployKnownHosts,
+		Kubeconfig:    cfg.DeployKubeconfig,
+		FileRoots:     cfg.DeployFileRoots,
+		LocalCommands: cfg.DeployLocalCommands,
+		SSHCommands:   cfg.DeploySSHCommands,
+		Timeout:       cfg.DeployTimeout,
+	}, cfg.DeployRoles, store, acmeReader, adminClient, cfg.ProvisionerRoles, crlPublisher)
+
+	// Pin the CA root before serving requests and keep checking it
+	if err := handlers.RefreshRoot(); err != nil {
+		slog.Warn("Failed to load CA root, retrying on first issuance", "error", err)
+	}
+	go handlers.RunRootCheck(cfg.RootCheckInterval)
+
+	// Import certificates issued through ACME into the inventory
+	if acmeReader != nil {
+		go func() {
+			if err := handlers.SyncACME(); err != nil {
+				slog.Error("ACME sync failed", "error", err)
+			}
+			handlers.RunACMESync(cfg.ACMESyncInterval)
+		}()
+	}
 
-	// Setup Gin router
-	r := gin.Default()
+	// Pick up revocations from the CA's CRL
+	if cfg.RevocationInterval > 0 {
+		go func() {
+			if err := handlers.SyncRevocations(); err != nil {
+				slog.Error("Revocation sync failed", "error", err)
+			}
+			handlers.RunRevocationSync(cfg.RevocationInterval)
+		}()
+	}
+
+	// Sign the CRL now and republish it before it goes stale
+	if crlPublisher != nil {
+		if err := handlers.PublishCRL(context.Background()); err != nil {
+			slog.Error("CRL publication failed", "error", err)
+		}
+		go handlers.RunCRLPublisher(cfg.CRLPublishInterval)
+	}
+
+	// Expire stale approval requests in the background
+	go handlers.RunApprovalExpiry(time.Minute)
+
+	// Setup Gin router. Requests are logged as JSON by the logging
+	// middleware, so gin's own logger and route listing stay off. The
+	// metrics middleware runs outside recovery so panics count as 500s.
+	if os.Getenv(gin.EnvGinMode) == "" {
+		gin.SetMode(gin.ReleaseMode)
+	}
+	r := gin.New()
+	r.Use(logging.Middleware(), metrics.Middleware(), tracing.Middleware(), logging.Recovery())
 
 	// Add CORS middleware
 	r.Use(func(c *gin.Context) {
 		c.Header("Access-Control-Allow-Origin", "*")
 		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key, X-Request-ID")
+		c.Header("Access-Control-Expose-Headers", "X-Request-ID")
 
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(204)
@@ -65,12 +266,42 @@ func main() {
 		c.Next()
 	})
 
+	// Resolve the caller identity for audit and policy decisions
+	r.Use(auth.Middleware(apiKeys, cfg.TrustProxyAuth))
+
 	// Setup routes
 	api.SetupRoutes(r, handlers)
 
-	// Start server
-	log.Printf("Starting server on port %d", cfg.Port)
-	if err := r.Run(fmt.Sprintf(":%d", cfg.Port)); err != nil {
-		log.Fatalf("Failed to start server: %v", err)
+	// Prometheus metrics, protected by METRICS_TOKEN if set
+	r.GET("/metrics", metrics.Handler(cfg.MetricsToken))
+
+	// Start server. On SIGINT or SIGTERM requests in flight may finish and
+	// pending spans are exported before exiting.
+	srv := &http.Server{Addr: fmt.Sprintf(":%d", cfg.Port), Handler: r}
+	go func() {
+		slog.Info("Starting server", "port", cfg.Port)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			fatal("Failed to start server", err)
+		}
+	}()
+
+	stop, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	<-stop.Done()
+	slog.Info("Shutting down")
+
+	ctx, cancelShutdown := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancelShutdown()
+	if err := srv.Shutdown(ctx); err != nil {
+		slog.Error("Failed to stop server", "error", err)
 	}
+	if err := shutdownTracing(ctx); err != nil {
+		slog.Error("Failed to flush traces", "error", err)
+	}
+}
+
+// fatal logs err and exits
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
 }
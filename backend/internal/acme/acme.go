@@ -0,0 +1,235 @@
+package acme
+
+import (
+	"context"
+	"crypto/x509"
+	"database/sql"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+)
+
+// Database types step-ca supports that can be read while the CA runs.
+// Badger keeps an exclusive lock on its directory and cannot be shared.
+const (
+	TypePostgreSQL = "postgresql"
+	TypeMySQL      = "mysql"
+)
+
+// Tables of step-ca's key/value store. Each holds JSON values keyed by ID.
+const (
+	accountsTable     = "acme_accounts"
+	ordersTable       = "acme_orders"
+	certsTable        = "acme_certs"
+	revokedCertsTable = "revoked_x509_certs"
+)
+
+// Config points at the database of the CA, as configured by the "db"
+// section of its ca.json
+type Config struct {
+	Type       string // postgresql or mysql
+	DataSource string // "dataSource" in ca.json
+	Database   string // "database" in ca.json
+}
+
+// Account is an ACME account registered with the CA
+type Account struct {
+	ID            string    `json:"id"`
+	Contact       []string  `json:"contact"`
+	Status        string    `json:"status"` // valid, deactivated, revoked
+	CreatedAt     time.Time `json:"createdAt"`
+	DeactivatedAt time.Time `json:"deactivatedAt"`
+}
+
+// Identifier is a name an ACME order asks for
+type Identifier struct {
+	Type  string `json:"type"` // dns, ip, permanent-identifier
+	Value string `json:"value"`
+}
+
+// Order is an ACME order placed by an account
+type Order struct {
+	ID            string       `json:"id"`
+	AccountID     string       `json:"accountID"`
+	ProvisionerID string       `json:"provisionerID"`
+	Identifiers   []Identifier `json:"identifiers"`
+	Status        string       `json:"status"` // pending, ready, processing, valid, invalid
+	CreatedAt     time.Time    `json:"createdAt"`
+	ExpiresAt     time.Time    `json:"expiresAt"`
+	CertificateID string       `json:"certificate"`
+}
+
+// Provisioner returns the name of the provisioner the order was placed with.
+// step-ca records "acme/<name>" unless provisioners live in its database,
+// in which case the ID is returned unchanged.
+func (o *Order) Provisioner() string {
+	if name, ok := strings.CutPrefix(o.ProvisionerID, "acme/"); ok {
+		return name
+	}
+	return o.ProvisionerID
+}
+
+// Certificate is a certificate the CA issued for an ACME order
+type Certificate struct {
+	ID        string
+	AccountID string
+	OrderID   string
+	CreatedAt time.Time
+	Leaf      *x509.Certificate
+}
+
+type dbCertificate struct {
+	ID        string    `json:"id"`
+	AccountID string    `json:"accountID"`
+	OrderID   string    `json:"orderID"`
+	CreatedAt time.Time `json:"createdAt"`
+	Leaf      []byte    `json:"leaf"` // PEM, or DER in older versions
+}
+
+// Reader reads ACME data from the database of the CA. It never writes.
+type Reader struct {
+	db    *sql.DB
+	quote func(string) string
+}
+
+// Open connects to the database of the CA
+func Open(cfg Config) (*Reader, error) {
+	switch cfg.Type {
+	case TypePostgreSQL:
+		connConfig, err := pgx.ParseConfig(cfg.DataSource)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CA database data source: %w", err)
+		}
+		if cfg.Database != "" {
+			connConfig.Database = cfg.Database
+		}
+		return &Reader{
+			db:    stdlib.OpenDB(*connConfig),
+			quote: func(name string) string { return `"` + name + `"` },
+		}, nil
+	case TypeMySQL:
+		// step-ca appends the database name to the data source
+		if _, err := mysql.ParseDSN(cfg.DataSource + cfg.Database); err != nil {
+			return nil, fmt.Errorf("invalid CA database data source: %w", err)
+		}
+		db, err := sql.Open("mysql", cfg.DataSource+cfg.Database)
+		if err != nil {
+			return nil, err
+		}
+		return &Reader{
+			db:    db,
+			quote: func(name string) string { return "`" + name + "`" },
+		}, nil
+	case "badger", "badgerv2", "badgerV2", "bbolt":
+		return nil, fmt.Errorf("CA database type %q is locked by the running CA; only %s and %s can be read", cfg.Type, TypePostgreSQL, TypeMySQL)
+	default:
+		return nil, fmt.Errorf("unsupported CA database type %q", cfg.Type)
+	}
+}
+
+// Close closes the connection pool
+func (r *Reader) Close() error {
+	return r.db.Close()
+}
+
+// rows returns every key and value of a table
+func (r *Reader) rows(ctx context.Context, table string, fn func(key, value []byte) error) error {
+	rows, err := r.db.QueryContext(ctx, "SELECT nkey, nvalue FROM "+r.quote(table))
+	if err != nil {
+		return fmt.Errorf("failed to read %s from the CA database: %w", table, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var key, value []byte
+		if err := rows.Scan(&key, &value); err != nil {
+			return fmt.Errorf("failed to read %s from the CA database: %w", table, err)
+		}
+		if err := fn(key, value); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read %s from the CA database: %w", table, err)
+	}
+	return nil
+}
+
+// Accounts returns every ACME account
+func (r *Reader) Accounts(ctx context.Context) ([]Account, error) {
+	var accounts []Account
+	err := r.rows(ctx, accountsTable, func(key, value []byte) error {
+		var account Account
+		if err := json.Unmarshal(value, &account); err != nil {
+			return fmt.Errorf("invalid ACME account %s: %w", key, err)
+		}
+		accounts = append(accounts, account)
+		return nil
+	})
+	return accounts, err
+}
+
+// Orders returns every ACME order
+func (r *Reader) Orders(ctx context.Context) ([]Order, error) {
+	var orders []Order
+	err := r.rows(ctx, ordersTable, func(key, value []byte) error {
+		var order Order
+		if err := json.Unmarshal(value, &order); err != nil {
+			return fmt.Errorf("invalid ACME order %s: %w", key, err)
+		}
+		orders = append(orders, order)
+		return nil
+	})
+	return orders, err
+}
+
+// Certificates returns every certificate issued through ACME
+func (r *Reader) Certificates(ctx context.Context) ([]Certificate, error) {
+	var certs []Certificate
+	err := r.rows(ctx, certsTable, func(key, value []byte) error {
+		var stored dbCertificate
+		if err := json.Unmarshal(value, &stored); err != nil {
+			return fmt.Errorf("invalid ACME certificate %s: %w", key, err)
+		}
+		der := stored.Leaf
+		if block, _ := pem.Decode(stored.Leaf); block != nil {
+			der = block.Bytes
+		}
+		leaf, err := x509.ParseCertificate(der)
+		if err != nil {
+			return fmt.Errorf("invalid ACME certificate %s: %w", key, err)
+		}
+		certs = append(certs, Certificate{
+			ID:        stored.ID,
+			AccountID: stored.AccountID,
+			OrderID:   stored.OrderID,
+			CreatedAt: stored.CreatedAt,
+			Leaf:      leaf,
+		})
+		return nil
+	})
+	return certs, err
+}
+
+// RevokedSerials returns the serial numbers of revoked certificates as
+// upper case hex, the format the inventory uses
+func (r *Reader) RevokedSerials(ctx context.Context) (map[string]bool, error) {
+	serials := map[string]bool{}
+	err := r.rows(ctx, revokedCertsTable, func(key, _ []byte) error {
+		// The CA keys revocations by the decimal serial number
+		serial, ok := new(big.Int).SetString(string(key), 10)
+		if !ok {
+			return fmt.Errorf("invalid serial number %q in %s", key, revokedCertsTable)
+		}
+		serials[fmt.Sprintf("%X", serial)] = true
+		return nil
+	})
+	return serials, err
+}
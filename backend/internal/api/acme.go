@@ -0,0 +1,312 @@
+package api
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sort"
+	"time"
+
+	"step-ca-webui/internal/acme"
+	"step-ca-webui/internal/db"
+	"step-ca-webui/internal/step"
+	"step-ca-webui/internal/storage"
+	"step-ca-webui/internal/tracing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// keyStrategyACME marks certificates an ACME client obtained directly from
+// the CA; the client holds the key and renews on its own
+const keyStrategyACME = "acme"
+
+// ACMEProvisioner is an ACME provisioner of the CA and its directory URL
+type ACMEProvisioner struct {
+	Name      string `json:"name"`
+	Directory string `json:"directory"`
+}
+
+// ACMEAccountResponse summarizes an ACME account and its orders
+type ACMEAccountResponse struct {
+	ID           string         `json:"id"`
+	Contact      []string       `json:"contact"`
+	Status       string         `json:"status"`
+	Provisioners []string       `json:"provisioners"`
+	Orders       map[string]int `json:"orders"` // count by order status
+	Certificates int            `json:"certificates"`
+	CreatedAt    time.Time      `json:"created_at"`
+}
+
+// ACMEOrderResponse is an ACME order with the inventory entry of its
+// certificate
+type ACMEOrderResponse struct {
+	ID          string            `json:"id"`
+	Provisioner string            `json:"provisioner"`
+	Identifiers []acme.Identifier `json:"identifiers"`
+	Status      string            `json:"status"`
+	CertID      string            `json:"cert_id,omitempty"`
+	CreatedAt   time.Time         `json:"created_at"`
+	ExpiresAt   time.Time         `json:"expires_at"`
+}
+
+// acmeProvisioners lists the ACME provisioners of the CA with their
+// directory URLs
+func (h *Handlers) acmeProvisioners(ctx context.Context) ([]ACMEProvisioner, error) {
+	provisioners, err := h.stepClient.Provisioners(ctx)
+	if err != nil {
+		return nil, err
+	}
+	acmeProvisioners := []ACMEProvisioner{}
+	for _, p := range provisioners {
+		if p.Type == step.ProvisionerACME {
+			acmeProvisioners = append(acmeProvisioners, ACMEProvisioner{
+				Name:      p.Name,
+				Directory: h.stepClient.ACMEDirectory(p.Name),
+			})
+		}
+	}
+	return acmeProvisioners, nil
+}
+
+// SyncACME imports the certificates the CA issued through ACME into the
+// inventory and updates the status of those imported before. The CA
+// database is only read.
+func (h *Handlers) SyncACME() (err error) {
+	if h.acme == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	ctx, span := tracing.Start(ctx, "SyncACME")
+	defer func() { tracing.End(span, err) }()
+
+	orders, err := h.acme.Orders(ctx)
+	if err != nil {
+		return err
+	}
+	issued, err := h.acme.Certificates(ctx)
+	if err != nil {
+		return err
+	}
+	revoked, err := h.acme.RevokedSerials(ctx)
+	if err != nil {
+		return err
+	}
+	existing, err := h.db.ListACMECertificates(ctx)
+	if err != nil {
+		return err
+	}
+
+	provisioners := make(map[string]string, len(orders))
+	for _, order := range orders {
+		provisioners[order.ID] = order.Provisioner()
+	}
+	bySerial := make(map[string]*db.Certificate, len(existing))
+	for i := range existing {
+		bySerial[existing[i].Serial] = &existing[i]
+	}
+
+	now := time.Now()
+	imported := 0
+	for _, ic := range issued {
+		serial := fmt.Sprintf("%X", ic.Leaf.SerialNumber)
+		status := "active"
+		if revoked[serial] {
+			status = "revoked"
+		} else if now.After(ic.Leaf.NotAfter) {
+			status = "expired"
+		}
+
+		// Known certificates only move from active to expired or revoked
+		if cert, ok := bySerial[serial]; ok {
+			if cert.Status == "active" && status != "active" {
+				cert.Status = status
+				cert.UpdatedAt = now
+				if err := h.db.UpdateCertificate(ctx, cert); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		sans := leafSANs(ic.Leaf)
+		cn := ic.Leaf.Subject.CommonName
+		if cn == "" && len(sans) > 0 {
+			cn = sans[0]
+		}
+		sansJSON, _ := json.Marshal(sans)
+		createdAt := ic.CreatedAt
+		if createdAt.IsZero() {
+			createdAt = ic.Leaf.NotBefore
+		}
+		cert := &db.Certificate{
+			ID:          uuid.New().String(),
+			CertType:    "x509",
+			CN:          cn,
+			SANs:        string(sansJSON),
+			Serial:      serial,
+			NotAfter:    ic.Leaf.NotAfter,
+			Status:      status,
+			KeyStrategy: keyStrategyACME,
+			StorageRef:  storage.TypeEphemeral,
+			ACMEAccount: ic.AccountID,
+			ACMEOrder:   ic.OrderID,
+			CreatedAt:   createdAt,
+			UpdatedAt:   now,
+		}
+		if err := h.db.CreateCertificate(ctx, cert); err != nil {
+			return err
+		}
+		h.db.LogAuditEvent(ctx, &db.AuditEvent{
+			CertID:    cert.ID,
+			Who:       "acme:" + ic.AccountID,
+			Action:    "acme_imported",
+			Details:   fmt.Sprintf("Serial: %s, Provisioner: %s, Order: %s", serial, provisioners[ic.OrderID], ic.OrderID),
+			Timestamp: now,
+		})
+		imported++
+	}
+
+	if imported > 0 {
+		slog.Info("Imported ACME certificates from the CA database", "count", imported)
+	}
+	return nil
+}
+
+// RunACMESync calls SyncACME on every tick of interval
+func (h *Handlers) RunACMESync(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := h.SyncACME(); err != nil {
+			slog.Error("ACME sync failed", "error", err)
+		}
+	}
+}
+
+// leafSANs returns every subject alternative name of a certificate
+func leafSANs(cert *x509.Certificate) []string {
+	var sans []string
+	sans = append(sans, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	sans = append(sans, cert.EmailAddresses...)
+	for _, uri := range cert.URIs {
+		sans = append(sans, uri.String())
+	}
+	return sans
+}
+
+// requireACME writes an error response when the CA database is not
+// configured
+func (h *Handlers) requireACME(c *gin.Context) bool {
+	if h.acme == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "ACME accounts are only available with CA_DB_TYPE set"})
+		return false
+	}
+	return true
+}
+
+// ListACMEAccounts returns the ACME accounts of the CA with a summary of
+// their orders
+func (h *Handlers) ListACMEAccounts(c *gin.Context) {
+	if !h.requireACME(c) {
+		return
+	}
+	ctx := c.Request.Context()
+
+	accounts, err := h.acme.Accounts(ctx)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+	orders, err := h.acme.Orders(ctx)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+
+	byID := make(map[string]*ACMEAccountResponse, len(accounts))
+	responses := make([]*ACMEAccountResponse, 0, len(accounts))
+	for _, account := range accounts {
+		resp := &ACMEAccountResponse{
+			ID:           account.ID,
+			Contact:      account.Contact,
+			Status:       account.Status,
+			Provisioners: []string{},
+			Orders:       map[string]int{},
+			CreatedAt:    account.CreatedAt,
+		}
+		byID[account.ID] = resp
+		responses = append(responses, resp)
+	}
+	for _, order := range orders {
+		resp, ok := byID[order.AccountID]
+		if !ok {
+			continue
+		}
+		resp.Orders[order.Status]++
+		if order.CertificateID != "" {
+			resp.Certificates++
+		}
+		if p := order.Provisioner(); !slices.Contains(resp.Provisioners, p) {
+			resp.Provisioners = append(resp.Provisioners, p)
+		}
+	}
+
+	sort.Slice(responses, func(i, j int) bool {
+		return responses[i].CreatedAt.After(responses[j].CreatedAt)
+	})
+	c.JSON(http.StatusOK, gin.H{"accounts": responses})
+}
+
+// ListACMEOrders returns the orders of an ACME account, newest first
+func (h *Handlers) ListACMEOrders(c *gin.Context) {
+	if !h.requireACME(c) {
+		return
+	}
+	accountID := c.Param("id")
+
+	orders, err := h.acme.Orders(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Link orders to the certificates imported for them
+	certs, err := h.db.ListCertificates(c.Request.Context(), 0, 0, "", "", accountID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve certificates"})
+		return
+	}
+	certIDs := make(map[string]string, len(certs))
+	for _, cert := range certs {
+		certIDs[cert.ACMEOrder] = cert.ID
+	}
+
+	responses := []ACMEOrderResponse{}
+	for _, order := range orders {
+		if order.AccountID != accountID {
+			continue
+		}
+		responses = append(responses, ACMEOrderResponse{
+			ID:          order.ID,
+			Provisioner: order.Provisioner(),
+			Identifiers: order.Identifiers,
+			Status:      order.Status,
+			CertID:      certIDs[order.ID],
+			CreatedAt:   order.CreatedAt,
+			ExpiresAt:   order.ExpiresAt,
+		})
+	}
+	sort.Slice(responses, func(i, j int) bool {
+		return responses[i].CreatedAt.After(responses[j].CreatedAt)
+	})
+	c.JSON(http.StatusOK, gin.H{"orders": responses})
+}
@@ -0,0 +1,433 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"step-ca-webui/internal/approval"
+	"step-ca-webui/internal/auth"
+	"step-ca-webui/internal/db"
+	"step-ca-webui/internal/step"
+	"step-ca-webui/internal/tracing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type ApprovalResponse struct {
+	ID           string     `json:"id"`
+	Kind         string     `json:"kind"`
+	CN           string     `json:"cn"`
+	SANs         []string   `json:"sans"`
+	Profile      string     `json:"profile,omitempty"`
+	Status       string     `json:"status"`
+	MatchedRules []string   `json:"matched_rules"`
+	RequestedBy  string     `json:"requested_by"`
+	DecidedBy    string     `json:"decided_by,omitempty"`
+	Reason       string     `json:"reason,omitempty"`
+	CertID       string     `json:"cert_id,omitempty"`
+	ExpiresAt    time.Time  `json:"expires_at"`
+	DecidedAt    *time.Time `json:"decided_at,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+}
+
+type ApprovalDecisionRequest struct {
+	Reason string `json:"reason"`
+}
+
+type ApprovalDownloadRequest struct {
+	BundleRequest
+}
+
+// requestApproval stores a sensitive request as pending and notifies approvers
+func (h *Handlers) requestApproval(c *gin.Context, identity *auth.Identity, kind, cn string, sans []string, profileName string, payload interface{}, rules []string) {
+	payloadJSON, err := json.Marshal(payload)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store approval request"})
+		return
+	}
+	sansJSON, _ := json.Marshal(sans)
+	rulesJSON, _ := json.Marshal(rules)
+	rolesJSON, _ := json.Marshal(identity.Roles)
+
+	ir := &db.IssuanceRequest{
+		ID:             uuid.New().String(),
+		Kind:           kind,
+		CN:             cn,
+		SANs:           string(sansJSON),
+		Profile:        profileName,
+		Payload:        string(payloadJSON),
+		Status:         approval.StatusPending,
+		MatchedRules:   string(rulesJSON),
+		RequestedBy:    identity.User,
+		RequesterRoles: string(rolesJSON),
+		RequesterKey:   identity.APIKey,
+		ExpiresAt:      time.Now().Add(h.approvals.Timeout),
+		CreatedAt:      time.Now(),
+		UpdatedAt:      time.Now(),
+	}
+	if err := h.db.CreateIssuanceRequest(c.Request.Context(), ir); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store approval request"})
+		return
+	}
+
+	h.db.LogAuditEvent(c.Request.Context(), &db.AuditEvent{
+		Who:       identity.User,
+		Action:    "approval_requested",
+		Details:   fmt.Sprintf("Request: %s, CN: %s, SANs: %v, Rules: %v", ir.ID, cn, sans, rules),
+		Timestamp: time.Now(),
+	})
+
+	response := approvalResponse(ir)
+	h.approvals.Notify("approval_requested", response)
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"message":  "Request requires approval",
+		"approval": response,
+	})
+}
+
+// ListApprovals returns issuance requests, optionally filtered by status
+func (h *Handlers) ListApprovals(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil {
+		limit = 50
+	}
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil {
+		offset = 0
+	}
+
+	reqs, err := h.db.ListIssuanceRequests(c.Request.Context(), limit, offset, c.Query("status"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list approval requests"})
+		return
+	}
+
+	responses := make([]ApprovalResponse, 0, len(reqs))
+	for i := range reqs {
+		responses = append(responses, approvalResponse(&reqs[i]))
+	}
+
+	c.JSON(http.StatusOK, gin.H{"approvals": responses})
+}
+
+// GetApproval returns a single issuance request
+func (h *Handlers) GetApproval(c *gin.Context) {
+	ir, err := h.db.GetIssuanceRequest(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Approval request not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"approval": approvalResponse(ir)})
+}
+
+// ApproveRequest approves a pending request and completes the issuance
+func (h *Handlers) ApproveRequest(c *gin.Context) {
+	var body ApprovalDecisionRequest
+	if c.Request.ContentLength > 0 && !bindJSON(c, &body) {
+		return
+	}
+
+	identity := auth.FromContext(c)
+
+	// OIDC provisioners receive the approver's ID token
+	if pending, err := h.db.GetIssuanceRequest(c.Request.Context(), c.Param("id")); err == nil && !h.requireIDToken(c, identity, h.profileCredentials(pending.Profile)) {
+		return
+	}
+	ir, ok := h.decide(c, identity, approval.StatusApproved, body.Reason)
+	if !ok {
+		return
+	}
+
+	// Issue on behalf of the original requester
+	requester := &auth.Identity{User: ir.RequestedBy, APIKey: ir.RequesterKey, IDToken: identity.IDToken}
+	json.Unmarshal([]byte(ir.RequesterRoles), &requester.Roles)
+	cert, bundle, err := h.completeRequest(c.Request.Context(), requester, ir)
+	if err != nil {
+		ir.Status = approval.StatusFailed
+		ir.Reason = err.Error()
+		ir.UpdatedAt = time.Now()
+		h.db.UpdateIssuanceRequest(c.Request.Context(), ir)
+		h.db.LogAuditEvent(c.Request.Context(), &db.AuditEvent{
+			Who:       identity.User,
+			Action:    "approval_failed",
+			Details:   fmt.Sprintf("Request: %s, Error: %v", ir.ID, err),
+			Timestamp: time.Now(),
+		})
+		h.approvals.Notify("approval_failed", approvalResponse(ir))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Approved, but issuance failed: %v", err)})
+		return
+	}
+
+	ir.CertID = cert.ID
+	ir.UpdatedAt = time.Now()
+	if err := h.db.UpdateIssuanceRequest(c.Request.Context(), ir); err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to link approval request to its certificate", "approval_id", ir.ID, "cert_id", cert.ID, "error", err)
+	}
+
+	// Hold the bundle for the requester to collect
+	h.pickups.Put(ir.ID, ir.RequestedBy, bundle, h.approvals.PickupTTL)
+
+	response := approvalResponse(ir)
+	h.approvals.Notify("approval_granted", response)
+
+	c.JSON(http.StatusOK, gin.H{
+		"approval":    response,
+		"certificate": certResponse(cert),
+	})
+}
+
+// RejectRequest rejects a pending request
+func (h *Handlers) RejectRequest(c *gin.Context) {
+	var body ApprovalDecisionRequest
+	if c.Request.ContentLength > 0 && !bindJSON(c, &body) {
+		return
+	}
+
+	identity := auth.FromContext(c)
+	ir, ok := h.decide(c, identity, approval.StatusRejected, body.Reason)
+	if !ok {
+		return
+	}
+
+	response := approvalResponse(ir)
+	h.approvals.Notify("approval_rejected", response)
+
+	c.JSON(http.StatusOK, gin.H{"approval": response})
+}
+
+// DownloadApproved hands the issued bundle of an approved request to the
+// requester. Bundles can be collected once.
+func (h *Handlers) DownloadApproved(c *gin.Context) {
+	var req ApprovalDownloadRequest
+	if c.Request.ContentLength > 0 && !bindJSON(c, &req) {
+		return
+	}
+	if errs := req.validate(); len(errs) > 0 {
+		respondValidation(c, errs)
+		return
+	}
+
+	ir, err := h.db.GetIssuanceRequest(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Approval request not found"})
+		return
+	}
+	if ir.Status != approval.StatusApproved || ir.CertID == "" {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Request is %s", ir.Status)})
+		return
+	}
+
+	identity := auth.FromContext(c)
+	bundle, err := h.pickups.Take(ir.ID, identity.User)
+	switch {
+	case errors.Is(err, errPickupForbidden):
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the requester can download this bundle"})
+		return
+	case err != nil:
+		c.JSON(http.StatusGone, gin.H{"error": "Bundle was already downloaded or has expired"})
+		return
+	}
+
+	h.db.LogAuditEvent(c.Request.Context(), &db.AuditEvent{
+		CertID:    ir.CertID,
+		Who:       identity.User,
+		Action:    "approval_downloaded",
+		Details:   fmt.Sprintf("Request: %s", ir.ID),
+		Timestamp: time.Now(),
+	})
+
+	if ir.Kind == "sign_csr" {
+		c.JSON(http.StatusOK, gin.H{
+			"cert_pem":      string(bundle.CertPEM),
+			"chain_pem":     string(bundle.ChainPEM),
+			"fullchain_pem": string(bundle.FullChainPEM),
+		})
+		return
+	}
+
+	download, err := h.offerDownload(identity, bundle, req.downloadOptions())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to create download: %v", err)})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"download": download})
+}
+
+// ExpireApprovals marks pending requests past their timeout as expired and
+// drops bundles nobody collected
+func (h *Handlers) ExpireApprovals(ctx context.Context) {
+	ctx, span := tracing.Start(ctx, "ExpireApprovals")
+	defer span.End()
+
+	now := time.Now()
+	h.pickups.Sweep(now)
+	h.downloads.Sweep(now)
+
+	reqs, err := h.db.ListExpiredIssuanceRequests(ctx, now)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to list expired approval requests", "error", err)
+		return
+	}
+
+	for i := range reqs {
+		ir := &reqs[i]
+		if err := h.db.DecideIssuanceRequest(ctx, ir, approval.StatusExpired, "system", "approval timed out"); err != nil {
+			continue
+		}
+		h.db.LogAuditEvent(ctx, &db.AuditEvent{
+			Who:       "system",
+			Action:    "approval_expired",
+			Details:   fmt.Sprintf("Request: %s, CN: %s", ir.ID, ir.CN),
+			Timestamp: now,
+		})
+		h.approvals.Notify("approval_expired", approvalResponse(ir))
+	}
+}
+
+// RunApprovalExpiry calls ExpireApprovals on every tick of interval
+func (h *Handlers) RunApprovalExpiry(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		h.ExpireApprovals(context.Background())
+	}
+}
+
+// decide checks that the caller may decide the request and records the
+// decision. It writes an error response and returns false otherwise.
+func (h *Handlers) decide(c *gin.Context, identity *auth.Identity, status, reason string) (*db.IssuanceRequest, bool) {
+	ir, err := h.db.GetIssuanceRequest(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Approval request not found"})
+		return nil, false
+	}
+	if ir.Status != approval.StatusPending {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Request is already %s", ir.Status)})
+		return nil, false
+	}
+	if time.Now().After(ir.ExpiresAt) {
+		h.ExpireApprovals(c.Request.Context())
+		c.JSON(http.StatusConflict, gin.H{"error": "Request has expired"})
+		return nil, false
+	}
+	if !h.approvals.CanApprove(identity, ir.RequestedBy) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You are not allowed to decide this request"})
+		return nil, false
+	}
+
+	if err := h.db.DecideIssuanceRequest(c.Request.Context(), ir, status, identity.User, reason); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Request was decided concurrently"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update approval request"})
+		}
+		return nil, false
+	}
+
+	action := "approval_granted"
+	if status == approval.StatusRejected {
+		action = "approval_rejected"
+	}
+	h.db.LogAuditEvent(c.Request.Context(), &db.AuditEvent{
+		Who:       identity.User,
+		Action:    action,
+		Details:   fmt.Sprintf("Request: %s, CN: %s, Requested by: %s, Reason: %s", ir.ID, ir.CN, ir.RequestedBy, reason),
+		Timestamp: time.Now(),
+	})
+
+	return ir, true
+}
+
+// completeRequest re-validates the stored request against the current
+// profiles and naming policy and sends it to the CA
+func (h *Handlers) completeRequest(ctx context.Context, requester *auth.Identity, ir *db.IssuanceRequest) (*db.Certificate, *step.CertBundle, error) {
+	switch ir.Kind {
+	case "issue":
+		var req IssueRequest
+		if err := json.Unmarshal([]byte(ir.Payload), &req); err != nil {
+			return nil, nil, fmt.Errorf("failed to decode stored request: %w", err)
+		}
+		opts, errs := h.prepareIssue(&req)
+		if len(errs) > 0 {
+			return nil, nil, fmt.Errorf("request is no longer valid: %s: %s", errs[0].Field, errs[0].Message)
+		}
+		if _, err := h.checkPolicy(ctx, requester, req.CN, req.SANs); err != nil {
+			return nil, nil, err
+		}
+		return h.issue(ctx, requester, &req, opts)
+	case "sign_csr":
+		var req SignCSRRequest
+		if err := json.Unmarshal([]byte(ir.Payload), &req); err != nil {
+			return nil, nil, fmt.Errorf("failed to decode stored request: %w", err)
+		}
+		csr, validity, errs := h.prepareSignCSR(&req)
+		if len(errs) > 0 {
+			return nil, nil, fmt.Errorf("request is no longer valid: %s: %s", errs[0].Field, errs[0].Message)
+		}
+		cn, sans := csr.Subject.CommonName, step.CSRSANs(csr)
+		if _, err := h.checkPolicy(ctx, requester, cn, sans); err != nil {
+			return nil, nil, err
+		}
+		return h.signCSR(ctx, requester, req.CSRPEM, cn, sans, validity, req.ExcludeRoot, req.Targets)
+	case "renew":
+		var req RenewRequest
+		if err := json.Unmarshal([]byte(ir.Payload), &req); err != nil {
+			return nil, nil, fmt.Errorf("failed to decode stored request: %w", err)
+		}
+		cert, err := h.db.GetCertificate(ctx, req.CertID)
+		if err != nil {
+			return nil, nil, errors.New("certificate no longer exists")
+		}
+		var sans []string
+		json.Unmarshal([]byte(cert.SANs), &sans)
+		opts, p, err := h.prepareRenew(cert, sans)
+		if err != nil {
+			return nil, nil, fmt.Errorf("request is no longer valid: %w", err)
+		}
+		if _, err := h.checkPolicy(ctx, requester, cert.CN, sans); err != nil {
+			return nil, nil, err
+		}
+		opts.Auth.IDToken = requester.IDToken
+		bundle, err := h.renew(ctx, requester, cert, p, opts)
+		if err != nil {
+			return nil, nil, err
+		}
+		return cert, bundle, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown request kind %q", ir.Kind)
+	}
+}
+
+func approvalResponse(ir *db.IssuanceRequest) ApprovalResponse {
+	var sans, rules []string
+	json.Unmarshal([]byte(ir.SANs), &sans)
+	json.Unmarshal([]byte(ir.MatchedRules), &rules)
+
+	return ApprovalResponse{
+		ID:           ir.ID,
+		Kind:         ir.Kind,
+		CN:           ir.CN,
+		SANs:         sans,
+		Profile:      ir.Profile,
+		Status:       ir.Status,
+		MatchedRules: rules,
+		RequestedBy:  ir.RequestedBy,
+		DecidedBy:    ir.DecidedBy,
+		Reason:       ir.Reason,
+		CertID:       ir.CertID,
+		ExpiresAt:    ir.ExpiresAt,
+		DecidedAt:    ir.DecidedAt,
+		CreatedAt:    ir.CreatedAt,
+	}
+}
@@ -0,0 +1,113 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"step-ca-webui/internal/approval"
+	"step-ca-webui/internal/policy"
+)
+
+// requireApproval makes names below prod.example.com wait for the platform role
+func requireApproval(t *testing.T, h *Handlers) {
+	t.Helper()
+	approvals, err := approval.New(approval.Config{
+		Rules:         []approval.Rule{{Name: "production", DNSSuffixes: []string{"prod.example.com"}}},
+		ApproverRoles: []string{"platform"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.approvals = approvals
+}
+
+// pendingID returns the ID of the approval request in an HTTP 202 response
+func pendingID(t *testing.T, code int, body []byte) string {
+	t.Helper()
+	if code != http.StatusAccepted {
+		t.Fatalf("request returned %d, want approval: %s", code, body)
+	}
+	var resp struct {
+		Approval ApprovalResponse `json:"approval"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp.Approval.ID
+}
+
+func TestRenewRequiresApproval(t *testing.T) {
+	h, database := newTestHandlers(t, newFakeCA(t))
+	r := testRoutes(h)
+	ctx := context.Background()
+
+	certID := issueAs(t, h, "alice-key", "web.prod.example.com")
+	issued, err := database.GetCertificate(ctx, certID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	requireApproval(t, h)
+	w := serve(r, http.MethodPost, "/api/certs/"+certID+"/renew", "", "X-API-Key", "alice-key", "Authorization", "Bearer eyJ.test.token")
+	id := pendingID(t, w.Code, w.Body.Bytes())
+	if cert, _ := database.GetCertificate(ctx, certID); cert.Serial != issued.Serial {
+		t.Fatal("certificate was renewed before approval")
+	}
+
+	w = serve(r, http.MethodPost, "/api/approvals/"+id+"/approve", "", "X-API-Key", "alice-key", "Authorization", "Bearer eyJ.test.token")
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("requester approved their own renewal: %d %s", w.Code, w.Body)
+	}
+	w = serve(r, http.MethodPost, "/api/approvals/"+id+"/approve", "", "X-API-Key", "platform-key", "Authorization", "Bearer eyJ.test.token")
+	if w.Code != http.StatusOK {
+		t.Fatalf("approve returned %d: %s", w.Code, w.Body)
+	}
+	renewed, err := database.GetCertificate(ctx, certID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if renewed.Serial == issued.Serial {
+		t.Error("approved renewal kept the old serial")
+	}
+
+	w = serve(r, http.MethodPost, "/api/approvals/"+id+"/download", `{"format": "pem"}`, "X-API-Key", "alice-key")
+	if w.Code != http.StatusOK {
+		t.Errorf("requester could not collect the renewed bundle: %d %s", w.Code, w.Body)
+	}
+}
+
+func TestApprovalRechecksPolicy(t *testing.T) {
+	h, database := newTestHandlers(t, newFakeCA(t))
+	r := testRoutes(h)
+	requireApproval(t, h)
+
+	w := serve(r, http.MethodPost, "/api/certs/issue", `{"cn": "db.prod.example.com", "not_after_days": 1}`,
+		"X-API-Key", "alice-key", "Authorization", "Bearer eyJ.test.token")
+	id := pendingID(t, w.Code, w.Body.Bytes())
+
+	// The policy tightened while the request was pending
+	engine, err := policy.New(policy.Config{Rules: []policy.Rule{{
+		Name:   "no-production",
+		Effect: policy.EffectDeny,
+		DNS:    policy.DNSRules{Suffixes: []string{"prod.example.com"}},
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.policy = engine
+
+	w = serve(r, http.MethodPost, "/api/approvals/"+id+"/approve", "", "X-API-Key", "platform-key", "Authorization", "Bearer eyJ.test.token")
+	if w.Code == http.StatusOK {
+		t.Fatalf("approval issued a certificate the policy denies: %s", w.Body)
+	}
+	ir, err := database.GetIssuanceRequest(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ir.Status != approval.StatusFailed || ir.CertID != "" || !strings.Contains(ir.Reason, "naming policy") {
+		t.Errorf("request is %s (%s) with certificate %q, want failed by the policy", ir.Status, ir.Reason, ir.CertID)
+	}
+}
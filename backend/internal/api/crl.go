@@ -0,0 +1,113 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"step-ca-webui/internal/db"
+	"step-ca-webui/internal/step"
+	"step-ca-webui/internal/tracing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// recordRevocation remembers a revoked certificate for the published CRL
+// and, when publishing is enabled, signs a new CRL listing it
+func (h *Handlers) recordRevocation(ctx context.Context, cert *db.Certificate, who string) error {
+	err := h.db.CreateRevocation(ctx, &db.Revocation{
+		Serial:    cert.Serial,
+		CertID:    cert.ID,
+		NotAfter:  cert.NotAfter,
+		RevokedBy: who,
+		RevokedAt: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	if h.crl != nil {
+		if err := h.PublishCRL(ctx); err != nil {
+			slog.ErrorContext(ctx, "Failed to publish CRL after a revocation", "serial", cert.Serial, "error", err)
+		}
+	}
+	return nil
+}
+
+// PublishCRL signs a new CRL listing the certificates revoked through the
+// backend that have not expired yet
+func (h *Handlers) PublishCRL(ctx context.Context) (err error) {
+	if h.crl == nil {
+		return nil
+	}
+	ctx, span := tracing.Start(ctx, "PublishCRL")
+	defer func() { tracing.End(span, err) }()
+
+	revocations, err := h.db.ListRevocations(ctx, time.Now())
+	if err != nil {
+		return err
+	}
+	entries := make([]step.CRLEntry, 0, len(revocations))
+	for _, revocation := range revocations {
+		serial, err := step.ParseSerial(revocation.Serial)
+		if err != nil {
+			slog.Warn("Skipping revocation with invalid serial", "serial", revocation.Serial)
+			continue
+		}
+		entries = append(entries, step.CRLEntry{Serial: serial, RevokedAt: revocation.RevokedAt})
+	}
+	_, err = h.crl.Publish(entries)
+	return err
+}
+
+// RunCRLPublisher calls PublishCRL on every tick of interval, so the CRL is
+// renewed before its next update even without new revocations
+func (h *Handlers) RunCRLPublisher(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := h.PublishCRL(context.Background()); err != nil {
+			slog.Error("CRL publication failed", "error", err)
+		}
+	}
+}
+
+// ServeCRL serves the published CRL as <name>.crl in DER or <name>.pem. Like
+// the trust files it needs no credentials.
+func (h *Handlers) ServeCRL(c *gin.Context) {
+	if h.crl == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "CRL publication is not enabled"})
+		return
+	}
+
+	file := c.Param("file")
+	var contentType string
+	switch file {
+	case h.crl.Name() + ".crl":
+		contentType = "application/pkix-crl"
+	case h.crl.Name() + ".pem":
+		contentType = "application/x-pem-file"
+	default:
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown CRL"})
+		return
+	}
+
+	crl := h.crl.Published()
+	if crl == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "CRL has not been published yet"})
+		return
+	}
+	data := crl.DER
+	if contentType == "application/x-pem-file" {
+		data = crl.PEM()
+	}
+
+	// New revocations republish the CRL at once, so caches hold it for at
+	// most five minutes and never past its next update
+	maxAge := min(int(time.Until(crl.NextUpdate).Seconds()), 300)
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", file))
+	c.Header("Cache-Control", fmt.Sprintf("public, max-age=%d", max(maxAge, 0)))
+	c.Header("Last-Modified", crl.ThisUpdate.Format(http.TimeFormat))
+	c.Data(http.StatusOK, contentType, data)
+}
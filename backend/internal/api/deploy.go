@@ -0,0 +1,437 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"step-ca-webui/internal/auth"
+	"step-ca-webui/internal/db"
+	"step-ca-webui/internal/deploy"
+	"step-ca-webui/internal/step"
+	"step-ca-webui/internal/tracing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TargetRequest defines a deployment target. Config holds the settings of
+// the target type, see the deploy package.
+type TargetRequest struct {
+	Name    string          `json:"name" binding:"required"`
+	Type    string          `json:"type" binding:"required"`
+	Config  json.RawMessage `json:"config" binding:"required"`
+	Enabled *bool           `json:"enabled,omitempty"` // default true
+}
+
+type TargetResponse struct {
+	ID        string          `json:"id"`
+	CertID    string          `json:"cert_id"`
+	Name      string          `json:"name"`
+	Type      string          `json:"type"`
+	Config    json.RawMessage `json:"config"`
+	Enabled   bool            `json:"enabled"`
+	CreatedBy string          `json:"created_by"`
+	CreatedAt time.Time       `json:"created_at"`
+	UpdatedAt time.Time       `json:"updated_at"`
+}
+
+func targetResponse(target *db.DeployTarget) TargetResponse {
+	return TargetResponse{
+		ID:        target.ID,
+		CertID:    target.CertID,
+		Name:      target.Name,
+		Type:      target.Type,
+		Config:    json.RawMessage(target.Config),
+		Enabled:   target.Enabled,
+		CreatedBy: target.CreatedBy,
+		CreatedAt: target.CreatedAt,
+		UpdatedAt: target.UpdatedAt,
+	}
+}
+
+// validateTargets checks target definitions before anything is issued
+func (h *Handlers) validateTargets(field string, targets []TargetRequest) ValidationErrors {
+	var errs ValidationErrors
+	for i, t := range targets {
+		errs = append(errs, h.validateTarget(fmt.Sprintf("%s[%d].", field, i), t)...)
+	}
+	return errs
+}
+
+func (h *Handlers) validateTarget(prefix string, t TargetRequest) ValidationErrors {
+	var errs ValidationErrors
+	if t.Name == "" {
+		errs.Add(prefix+"name", "is required")
+	}
+	if _, err := deploy.New(t.Type, t.Config, h.deployOpts); err != nil {
+		errs.Add(prefix+"config", "%v", err)
+	}
+	return errs
+}
+
+// requireTargetAccess writes an error response unless the caller may define
+// deployment targets for cert, or for a certificate about to be issued to
+// them when cert is nil. Targets receive the private key on every renewal,
+// so the caller must be authenticated and own the certificate or hold one of
+// the deploy roles.
+func (h *Handlers) requireTargetAccess(c *gin.Context, identity *auth.Identity, cert *db.Certificate) bool {
+	if !identity.Authenticated() {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Deployment targets can only be managed by authenticated callers"})
+		return false
+	}
+	if cert == nil || cert.OwnerUser == identity.User {
+		return true
+	}
+	for _, role := range h.deployRoles {
+		if identity.HasRole(role) {
+			return true
+		}
+	}
+	c.JSON(http.StatusForbidden, gin.H{"error": "Deployment targets can only be managed by the certificate's owner or a deploy role"})
+	return false
+}
+
+// claimedPath returns an error if a file t writes is already written by a
+// target of another certificate. certID is empty for a certificate about to
+// be issued.
+func (h *Handlers) claimedPath(ctx context.Context, certID string, t TargetRequest) error {
+	paths := deploy.Paths(t.Type, t.Config)
+	if len(paths) == 0 {
+		return nil
+	}
+	targets, err := h.db.ListDeployTargetsByType(ctx, t.Type)
+	if err != nil {
+		return fmt.Errorf("failed to list deployment targets: %w", err)
+	}
+	for _, other := range targets {
+		if other.CertID == certID {
+			continue
+		}
+		for _, p := range deploy.Paths(other.Type, []byte(other.Config)) {
+			if slices.Contains(paths, p) {
+				return &pathClaimedError{path: p, certID: other.CertID}
+			}
+		}
+	}
+	return nil
+}
+
+type pathClaimedError struct {
+	path, certID string
+}
+
+func (e *pathClaimedError) Error() string {
+	return fmt.Sprintf("%s is already deployed by certificate %s", e.path, e.certID)
+}
+
+// requireUnclaimed writes an error response unless no target of another
+// certificate writes the files of targets
+func (h *Handlers) requireUnclaimed(c *gin.Context, certID string, targets []TargetRequest) bool {
+	for _, t := range targets {
+		err := h.claimedPath(c.Request.Context(), certID, t)
+		var claimed *pathClaimedError
+		switch {
+		case errors.As(err, &claimed):
+			c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Target %s: %v", t.Name, err)})
+			return false
+		case err != nil:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return false
+		}
+	}
+	return true
+}
+
+func compactJSON(raw json.RawMessage) string {
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, raw); err != nil {
+		return string(raw)
+	}
+	return buf.String()
+}
+
+// createTargets stores validated target definitions for a certificate
+func (h *Handlers) createTargets(ctx context.Context, identity *auth.Identity, certID string, targets []TargetRequest) ([]*db.DeployTarget, error) {
+	var created []*db.DeployTarget
+	for _, t := range targets {
+		// Checked again as approved requests are stored long before
+		if err := h.claimedPath(ctx, certID, t); err != nil {
+			return created, fmt.Errorf("deployment target %s not stored: %w", t.Name, err)
+		}
+		target := &db.DeployTarget{
+			ID:        uuid.New().String(),
+			CertID:    certID,
+			Name:      t.Name,
+			Type:      t.Type,
+			Config:    compactJSON(t.Config),
+			Enabled:   t.Enabled == nil || *t.Enabled,
+			CreatedBy: identity.User,
+			CreatedAt: time.Now(),
+			UpdatedAt: time.Now(),
+		}
+		if err := h.db.CreateDeployTarget(ctx, target); err != nil {
+			return created, fmt.Errorf("failed to store deployment target %s: %w", t.Name, err)
+		}
+		h.db.LogAuditEvent(ctx, &db.AuditEvent{
+			CertID:    certID,
+			Who:       identity.User,
+			Action:    "deploy_target_created",
+			Details:   fmt.Sprintf("Target: %s (%s)", target.Name, target.Type),
+			Timestamp: time.Now(),
+		})
+		created = append(created, target)
+	}
+	return created, nil
+}
+
+// bundleFiles returns the files of a bundle handed to deployers and storage
+func bundleFiles(bundle *step.CertBundle) *deploy.Files {
+	return &deploy.Files{
+		Cert:      bundle.CertPEM,
+		Chain:     bundle.ChainPEM,
+		FullChain: bundle.FullChainPEM,
+		Key:       bundle.KeyPEM,
+		Root:      bundle.RootPEM,
+		Serial:    bundle.Serial,
+		NotAfter:  bundle.NotAfter,
+	}
+}
+
+// deployBundle delivers a freshly issued bundle to every enabled target of
+// the certificate in the background. The bundle, including its key, lives
+// only in memory until all deployments finish. Deployments outlive the
+// request but stay in its trace.
+func (h *Handlers) deployBundle(ctx context.Context, who string, cert *db.Certificate, bundle *step.CertBundle, trigger string) {
+	targets, err := h.db.ListDeployTargets(ctx, cert.ID, true)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to list deployment targets", "cert_id", cert.ID, "error", err)
+		return
+	}
+
+	files := bundleFiles(bundle)
+	for i := range targets {
+		target := &targets[i]
+		deployment := &db.Deployment{
+			ID:        uuid.New().String(),
+			TargetID:  target.ID,
+			CertID:    cert.ID,
+			Trigger:   trigger,
+			Status:    deploy.StatusPending,
+			Serial:    bundle.Serial,
+			CreatedAt: time.Now(),
+		}
+		if err := h.db.CreateDeployment(ctx, deployment); err != nil {
+			slog.ErrorContext(ctx, "Failed to record deployment", "target", target.Name, "error", err)
+			continue
+		}
+		go h.runDeployment(context.WithoutCancel(ctx), who, target, deployment, files)
+	}
+}
+
+// runDeployment performs one deployment. Deployments to the same target run
+// one at a time so a quick renewal cannot overtake an earlier upload.
+func (h *Handlers) runDeployment(ctx context.Context, who string, target *db.DeployTarget, deployment *db.Deployment, files *deploy.Files) {
+	lock, _ := h.deployLocks.LoadOrStore(target.ID, &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	defer lock.(*sync.Mutex).Unlock()
+
+	ctx, span := tracing.Start(ctx, "Deploy "+target.Type, trace.WithAttributes(
+		attribute.String("deploy.target_id", target.ID),
+		attribute.String("deploy.target_name", target.Name),
+	))
+
+	started := time.Now()
+	deployment.Status = deploy.StatusRunning
+	deployment.StartedAt = &started
+	h.db.UpdateDeployment(ctx, deployment)
+
+	output, err := func() (string, error) {
+		deployer, err := deploy.New(target.Type, []byte(target.Config), h.deployOpts)
+		if err != nil {
+			return "", err
+		}
+		return deployer.Deploy(ctx, files)
+	}()
+	tracing.End(span, err)
+
+	finished := time.Now()
+	deployment.Output = output
+	deployment.FinishedAt = &finished
+	action := "deployed"
+	details := fmt.Sprintf("Target: %s (%s), Deployment: %s, Serial: %s", target.Name, target.Type, deployment.ID, deployment.Serial)
+	if err != nil {
+		deployment.Status = deploy.StatusFailed
+		deployment.Error = err.Error()
+		action = "deploy_failed"
+		details += fmt.Sprintf(", Error: %v", err)
+	} else {
+		deployment.Status = deploy.StatusSucceeded
+	}
+	if err := h.db.UpdateDeployment(ctx, deployment); err != nil {
+		slog.ErrorContext(ctx, "Failed to update deployment", "deployment_id", deployment.ID, "error", err)
+	}
+
+	h.db.LogAuditEvent(ctx, &db.AuditEvent{
+		CertID:    deployment.CertID,
+		Who:       who,
+		Action:    action,
+		Details:   details,
+		Timestamp: finished,
+	})
+}
+
+// ListTargets returns the deployment targets of a certificate
+func (h *Handlers) ListTargets(c *gin.Context) {
+	if _, err := h.db.GetCertificate(c.Request.Context(), c.Param("id")); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Certificate not found"})
+		return
+	}
+
+	targets, err := h.db.ListDeployTargets(c.Request.Context(), c.Param("id"), false)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list deployment targets"})
+		return
+	}
+
+	responses := make([]TargetResponse, 0, len(targets))
+	for i := range targets {
+		responses = append(responses, targetResponse(&targets[i]))
+	}
+	c.JSON(http.StatusOK, gin.H{"targets": responses})
+}
+
+// CreateTarget adds a deployment target to a certificate. It is used from
+// the next issuance or renewal on.
+func (h *Handlers) CreateTarget(c *gin.Context) {
+	var req TargetRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+	if errs := h.validateTarget("", req); len(errs) > 0 {
+		respondValidation(c, errs)
+		return
+	}
+
+	cert, err := h.db.GetCertificate(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Certificate not found"})
+		return
+	}
+	if isSSHCertType(cert.CertType) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "SSH certificates cannot be deployed"})
+		return
+	}
+	if cert.KeyStrategy == keyStrategyACME {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ACME certificates are deployed by their ACME client"})
+		return
+	}
+	identity := auth.FromContext(c)
+	if !h.requireTargetAccess(c, identity, cert) || !h.requireUnclaimed(c, cert.ID, []TargetRequest{req}) {
+		return
+	}
+
+	created, err := h.createTargets(c.Request.Context(), identity, cert.ID, []TargetRequest{req})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"target": targetResponse(created[0])})
+}
+
+// accessTarget loads the target of the request once the caller may manage
+// the targets of its certificate
+func (h *Handlers) accessTarget(c *gin.Context) (*db.DeployTarget, bool) {
+	cert, err := h.db.GetCertificate(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Certificate not found"})
+		return nil, false
+	}
+	if !h.requireTargetAccess(c, auth.FromContext(c), cert) {
+		return nil, false
+	}
+	target, err := h.db.GetDeployTarget(c.Request.Context(), cert.ID, c.Param("targetId"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Deployment target not found"})
+		return nil, false
+	}
+	return target, true
+}
+
+// UpdateTarget replaces the definition of a deployment target
+func (h *Handlers) UpdateTarget(c *gin.Context) {
+	var req TargetRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+	if errs := h.validateTarget("", req); len(errs) > 0 {
+		respondValidation(c, errs)
+		return
+	}
+
+	target, ok := h.accessTarget(c)
+	if !ok || !h.requireUnclaimed(c, target.CertID, []TargetRequest{req}) {
+		return
+	}
+
+	target.Name = req.Name
+	target.Type = req.Type
+	target.Config = compactJSON(req.Config)
+	target.Enabled = req.Enabled == nil || *req.Enabled
+	target.UpdatedAt = time.Now()
+	if err := h.db.UpdateDeployTarget(c.Request.Context(), target); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update deployment target"})
+		return
+	}
+
+	h.db.LogAuditEvent(c.Request.Context(), &db.AuditEvent{
+		CertID:    target.CertID,
+		Who:       auth.FromContext(c).User,
+		Action:    "deploy_target_updated",
+		Details:   fmt.Sprintf("Target: %s (%s), Enabled: %t", target.Name, target.Type, target.Enabled),
+		Timestamp: time.Now(),
+	})
+
+	c.JSON(http.StatusOK, gin.H{"target": targetResponse(target)})
+}
+
+// DeleteTarget removes a deployment target. Deployed files stay in place.
+func (h *Handlers) DeleteTarget(c *gin.Context) {
+	target, ok := h.accessTarget(c)
+	if !ok {
+		return
+	}
+	if err := h.db.DeleteDeployTarget(c.Request.Context(), target); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete deployment target"})
+		return
+	}
+
+	h.db.LogAuditEvent(c.Request.Context(), &db.AuditEvent{
+		CertID:    target.CertID,
+		Who:       auth.FromContext(c).User,
+		Action:    "deploy_target_deleted",
+		Details:   fmt.Sprintf("Target: %s (%s)", target.Name, target.Type),
+		Timestamp: time.Now(),
+	})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Deployment target deleted"})
+}
+
+// ListDeployments returns the most recent deployments of a certificate
+func (h *Handlers) ListDeployments(c *gin.Context) {
+	deployments, err := h.db.ListDeployments(c.Request.Context(), c.Param("id"), 50)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list deployments"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"deployments": deployments})
+}
@@ -0,0 +1,167 @@
+package api
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+// issueAs issues a certificate for cn as the caller of apiKey and returns
+// its ID
+func issueAs(t *testing.T, h *Handlers, apiKey, cn string) string {
+	t.Helper()
+	w := serve(testRoutes(h), http.MethodPost, "/api/certs/issue", `{"cn": "`+cn+`", "not_after_days": 1}`,
+		"X-API-Key", apiKey, "Authorization", "Bearer eyJ.test.token")
+	if w.Code != http.StatusOK {
+		t.Fatalf("issue returned %d: %s", w.Code, w.Body)
+	}
+	var resp struct {
+		Certificate CertResponse `json:"certificate"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp.Certificate.ID
+}
+
+// fileTarget returns a disabled filesystem target writing name below the
+// file root of h
+func fileTarget(h *Handlers, name string) string {
+	path := filepath.Join(h.deployOpts.FileRoots[0], name)
+	return `{"name": "` + name + `", "type": "filesystem", "enabled": false, "config": {"cert_path": ` + strconv.Quote(path) + `}}`
+}
+
+func TestTargetAccess(t *testing.T) {
+	h, _ := newTestHandlers(t, newFakeCA(t))
+	r := testRoutes(h)
+	certID := issueAs(t, h, "alice-key", "web.example.com")
+	targets := "/api/certs/" + certID + "/targets"
+
+	for _, tt := range []struct {
+		name    string
+		headers []string
+		want    int
+	}{
+		{"anonymous", nil, http.StatusForbidden},
+		{"bearer token only", []string{"Authorization", "Bearer eyJ.test.token"}, http.StatusForbidden},
+		{"proxy as system", []string{"X-Forwarded-User", "system"}, http.StatusForbidden},
+		{"other user", []string{"X-API-Key", "bob-key"}, http.StatusForbidden},
+		{"owner", []string{"X-API-Key", "alice-key"}, http.StatusCreated},
+		{"owner through proxy", []string{"X-Forwarded-User", "alice"}, http.StatusCreated},
+		{"deploy role", []string{"X-Forwarded-User", "carol", "X-Forwarded-Groups", "platform"}, http.StatusCreated},
+	} {
+		if w := serve(r, http.MethodPost, targets, fileTarget(h, tt.name+".crt"), tt.headers...); w.Code != tt.want {
+			t.Errorf("%s: create returned %d, want %d: %s", tt.name, w.Code, tt.want, w.Body)
+		}
+	}
+
+	list, err := h.db.ListDeployTargets(context.Background(), certID, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 3 {
+		t.Fatalf("certificate has %d targets, want 3", len(list))
+	}
+	target := targets + "/" + list[0].ID
+
+	if w := serve(r, http.MethodPut, target, fileTarget(h, "moved.crt"), "X-API-Key", "bob-key"); w.Code != http.StatusForbidden {
+		t.Errorf("other user updated a target: %d", w.Code)
+	}
+	if w := serve(r, http.MethodDelete, target, "", "X-API-Key", "bob-key"); w.Code != http.StatusForbidden {
+		t.Errorf("other user deleted a target: %d", w.Code)
+	}
+	if w := serve(r, http.MethodDelete, target, ""); w.Code != http.StatusForbidden {
+		t.Errorf("anonymous caller deleted a target: %d", w.Code)
+	}
+	if w := serve(r, http.MethodPut, target, fileTarget(h, "moved.crt"), "X-API-Key", "alice-key"); w.Code != http.StatusOK {
+		t.Errorf("owner could not update a target: %d %s", w.Code, w.Body)
+	}
+	if w := serve(r, http.MethodDelete, target, "", "X-API-Key", "platform-key"); w.Code != http.StatusOK {
+		t.Errorf("deploy role could not delete a target: %d %s", w.Code, w.Body)
+	}
+
+	// Certificates issued without credentials are owned by nobody
+	anonymousID := issueAs(t, h, "", "anon.example.com")
+	if w := serve(r, http.MethodPost, "/api/certs/"+anonymousID+"/targets", fileTarget(h, "anon.crt"), "X-Forwarded-User", "system"); w.Code != http.StatusForbidden {
+		t.Errorf("create on an anonymous certificate returned %d", w.Code)
+	}
+}
+
+// testCSR returns a PEM encoded CSR for cn
+func testCSR(t *testing.T, cn string) string {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: cn},
+		DNSNames: []string{cn},
+	}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}))
+}
+
+func TestIssueTargetAccess(t *testing.T) {
+	h, _ := newTestHandlers(t, newFakeCA(t))
+	r := testRoutes(h)
+
+	for path, body := range map[string]string{
+		"/api/certs/issue":    `{"cn": "web.example.com", "not_after_days": 1, "targets": [` + fileTarget(h, "issued.crt") + `]}`,
+		"/api/certs/sign-csr": `{"csr_pem": ` + strconv.Quote(testCSR(t, "web.example.com")) + `, "not_after_days": 1, "targets": [` + fileTarget(h, "signed.crt") + `]}`,
+	} {
+		if w := serve(r, http.MethodPost, path, body, "Authorization", "Bearer eyJ.test.token"); w.Code != http.StatusForbidden {
+			t.Errorf("%s with targets and no credentials returned %d: %s", path, w.Code, w.Body)
+		}
+		if w := serve(r, http.MethodPost, path, body, "X-API-Key", "alice-key", "Authorization", "Bearer eyJ.test.token"); w.Code != http.StatusOK {
+			t.Errorf("%s with targets as alice returned %d: %s", path, w.Code, w.Body)
+		}
+	}
+}
+
+func TestTargetPathClaims(t *testing.T) {
+	h, _ := newTestHandlers(t, newFakeCA(t))
+	r := testRoutes(h)
+	first := issueAs(t, h, "alice-key", "haproxy.example.com")
+	second := issueAs(t, h, "alice-key", "web.example.com")
+
+	if w := serve(r, http.MethodPost, "/api/certs/"+first+"/targets", fileTarget(h, "haproxy.pem"), "X-API-Key", "alice-key"); w.Code != http.StatusCreated {
+		t.Fatalf("create returned %d: %s", w.Code, w.Body)
+	}
+	// The same certificate may write its files from several targets
+	if w := serve(r, http.MethodPost, "/api/certs/"+first+"/targets", fileTarget(h, "haproxy.pem"), "X-API-Key", "alice-key"); w.Code != http.StatusCreated {
+		t.Errorf("second target of the same certificate returned %d: %s", w.Code, w.Body)
+	}
+
+	// Another certificate may not overwrite them, also not through a deploy
+	// role or at issue time
+	if w := serve(r, http.MethodPost, "/api/certs/"+second+"/targets", fileTarget(h, "haproxy.pem"), "X-API-Key", "platform-key"); w.Code != http.StatusConflict {
+		t.Errorf("create on another certificate returned %d: %s", w.Code, w.Body)
+	}
+	body := `{"cn": "new.example.com", "not_after_days": 1, "targets": [` + fileTarget(h, "haproxy.pem") + `]}`
+	if w := serve(r, http.MethodPost, "/api/certs/issue", body, "X-API-Key", "alice-key", "Authorization", "Bearer eyJ.test.token"); w.Code != http.StatusConflict {
+		t.Errorf("issue with a claimed path returned %d: %s", w.Code, w.Body)
+	}
+
+	if w := serve(r, http.MethodPost, "/api/certs/"+second+"/targets", fileTarget(h, "web.pem"), "X-API-Key", "alice-key"); w.Code != http.StatusCreated {
+		t.Fatalf("create returned %d: %s", w.Code, w.Body)
+	}
+	list, err := h.db.ListDeployTargets(context.Background(), second, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := serve(r, http.MethodPut, "/api/certs/"+second+"/targets/"+list[0].ID, fileTarget(h, "haproxy.pem"), "X-API-Key", "alice-key"); w.Code != http.StatusConflict {
+		t.Errorf("update to a claimed path returned %d: %s", w.Code, w.Body)
+	}
+}
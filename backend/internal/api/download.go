@@ -0,0 +1,55 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"step-ca-webui/internal/auth"
+	"step-ca-webui/internal/step"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// downloadTTL bounds how long a rendered download waits for its owner
+const downloadTTL = 10 * time.Minute
+
+// offerDownload renders the bundle in the requested format and parks the file
+// for a single GET of /api/downloads/:token by the same caller
+func (h *Handlers) offerDownload(identity *auth.Identity, bundle *step.CertBundle, opts step.DownloadOptions) (gin.H, error) {
+	file, err := h.stepClient.RenderDownload(bundle, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	token := uuid.New().String()
+	expires := time.Now().Add(downloadTTL)
+	h.downloads.Put(token, identity.User, file, downloadTTL)
+
+	return gin.H{
+		"url":        "/api/downloads/" + token,
+		"filename":   file.Filename,
+		"mime_type":  file.ContentType,
+		"expires_at": expires,
+	}, nil
+}
+
+// Download sends a rendered file once, as an attachment
+func (h *Handlers) Download(c *gin.Context) {
+	identity := auth.FromContext(c)
+	file, err := h.downloads.Take(c.Param("token"), identity.User)
+	switch {
+	case errors.Is(err, errPickupForbidden):
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the requester can download this file"})
+		return
+	case err != nil:
+		c.JSON(http.StatusGone, gin.H{"error": "File was already downloaded or has expired"})
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", file.Filename))
+	c.Header("Cache-Control", "no-store")
+	c.Data(http.StatusOK, file.ContentType, file.Data)
+}
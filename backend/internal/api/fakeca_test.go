@@ -0,0 +1,337 @@
+package api
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"step-ca-webui/internal/approval"
+	"step-ca-webui/internal/auth"
+	"step-ca-webui/internal/db"
+	"step-ca-webui/internal/deploy"
+	"step-ca-webui/internal/policy"
+	"step-ca-webui/internal/profile"
+	"step-ca-webui/internal/step"
+	"step-ca-webui/internal/storage"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/ocsp"
+)
+
+// fakeCA serves the step-ca endpoints the backend uses. It signs with one
+// intermediate below a self-signed root.
+type fakeCA struct {
+	*httptest.Server
+
+	root, intermediate       *x509.Certificate
+	rootKey, intermediateKey *ecdsa.PrivateKey
+	// intermediates are served at /intermediates, in this order
+	intermediates []*x509.Certificate
+	// revoked serials are listed in the CRL at /crl
+	revoked []*big.Int
+	serial  atomic.Int64
+}
+
+// testCert creates a certificate for template signed by parent, or a
+// self-signed one when parent is nil
+func testCert(t *testing.T, template *x509.Certificate, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+// caTemplate returns the template of a CA certificate
+func caTemplate(serial int64, cn string) *x509.Certificate {
+	return &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+}
+
+func newFakeCA(t *testing.T) *fakeCA {
+	t.Helper()
+
+	ca := &fakeCA{}
+	ca.serial.Store(100)
+	ca.root, ca.rootKey = testCert(t, caTemplate(1, "Fake Root CA"), nil, nil)
+	ca.intermediate, ca.intermediateKey = testCert(t, caTemplate(2, "Fake Intermediate CA"), ca.root, ca.rootKey)
+	ca.intermediates = []*x509.Certificate{ca.intermediate}
+
+	server, serverKey := testCert(t, &x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca.intermediate, ca.intermediateKey)
+
+	ca.Server = httptest.NewUnstartedServer(http.HandlerFunc(ca.serve))
+	ca.TLS = &tls.Config{Certificates: []tls.Certificate{{
+		Certificate: [][]byte{server.Raw, ca.intermediate.Raw},
+		PrivateKey:  serverKey,
+	}}}
+	ca.StartTLS()
+	t.Cleanup(ca.Close)
+	return ca
+}
+
+func pemCerts(certs ...*x509.Certificate) string {
+	var out []byte
+	for _, cert := range certs {
+		out = append(out, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})...)
+	}
+	return string(out)
+}
+
+func (ca *fakeCA) serve(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/root/"):
+		json.NewEncoder(w).Encode(map[string]string{"ca": pemCerts(ca.root)})
+	case r.Method == http.MethodGet && r.URL.Path == "/roots":
+		json.NewEncoder(w).Encode(map[string][]string{"crts": {pemCerts(ca.root)}})
+	case r.Method == http.MethodGet && r.URL.Path == "/intermediates":
+		var crts []string
+		for _, cert := range ca.intermediates {
+			crts = append(crts, pemCerts(cert))
+		}
+		json.NewEncoder(w).Encode(map[string][]string{"crts": crts})
+	case r.Method == http.MethodGet && r.URL.Path == "/crl":
+		ca.crl(w)
+	case r.Method == http.MethodPost && r.URL.Path == "/1.0/sign":
+		ca.sign(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// crl writes a CRL of the revoked serials signed by the intermediate
+func (ca *fakeCA) crl(w http.ResponseWriter) {
+	var entries []x509.RevocationListEntry
+	for _, serial := range ca.revoked {
+		entries = append(entries, x509.RevocationListEntry{SerialNumber: serial, RevocationTime: time.Now()})
+	}
+	der, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:                    big.NewInt(1),
+		ThisUpdate:                time.Now().Add(-time.Minute),
+		NextUpdate:                time.Now().Add(time.Hour),
+		RevokedCertificateEntries: entries,
+	}, ca.intermediate, ca.intermediateKey)
+	if err != nil {
+		http.Error(w, "signing failed", http.StatusInternalServerError)
+		return
+	}
+	w.Write(der)
+}
+
+// ocsp answers OCSP requests about certificates of the intermediate like
+// the CA's responder. It is served over plain HTTP by the caller.
+func (ca *fakeCA) ocsp(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	req, err := ocsp.ParseRequest(body)
+	if err != nil {
+		w.Write(ocsp.MalformedRequestErrorResponse)
+		return
+	}
+	// Requests naming another issuer are not answered
+	own, err := ocsp.CreateRequest(&x509.Certificate{SerialNumber: req.SerialNumber}, ca.intermediate, nil)
+	if err != nil {
+		w.Write(ocsp.InternalErrorErrorResponse)
+		return
+	}
+	if parsed, err := ocsp.ParseRequest(own); err != nil || !bytes.Equal(parsed.IssuerKeyHash, req.IssuerKeyHash) {
+		w.Write(ocsp.UnauthorizedErrorResponse)
+		return
+	}
+
+	template := ocsp.Response{
+		Status:       ocsp.Good,
+		SerialNumber: req.SerialNumber,
+		ThisUpdate:   time.Now().Add(-time.Minute),
+		NextUpdate:   time.Now().Add(time.Hour),
+	}
+	for _, serial := range ca.revoked {
+		if serial.Cmp(req.SerialNumber) == 0 {
+			template.Status = ocsp.Revoked
+			template.RevokedAt = time.Now().Add(-time.Minute)
+		}
+	}
+	der, err := ocsp.CreateResponse(ca.intermediate, ca.intermediate, template, ca.intermediateKey)
+	if err != nil {
+		w.Write(ocsp.InternalErrorErrorResponse)
+		return
+	}
+	w.Header().Set("Content-Type", "application/ocsp-response")
+	w.Write(der)
+}
+
+// sign issues a certificate for the CSR of a sign request. The one-time
+// token is not checked.
+func (ca *fakeCA) sign(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		CSR      string `json:"csr"`
+		OTT      string `json:"ott"`
+		NotAfter string `json:"notAfter"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.OTT == "" {
+		http.Error(w, `{"message":"bad request"}`, http.StatusBadRequest)
+		return
+	}
+	block, _ := pem.Decode([]byte(req.CSR))
+	if block == nil {
+		http.Error(w, `{"message":"invalid CSR"}`, http.StatusBadRequest)
+		return
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		http.Error(w, `{"message":"invalid CSR"}`, http.StatusBadRequest)
+		return
+	}
+	notAfter, err := time.Parse(time.RFC3339, req.NotAfter)
+	if err != nil {
+		notAfter = time.Now().Add(24 * time.Hour)
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(ca.serial.Add(1)),
+		Subject:      pkix.Name{CommonName: csr.Subject.CommonName},
+		DNSNames:     csr.DNSNames,
+		IPAddresses:  csr.IPAddresses,
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}, ca.intermediate, csr.PublicKey, ca.intermediateKey)
+	if err != nil {
+		http.Error(w, `{"message":"signing failed"}`, http.StatusInternalServerError)
+		return
+	}
+	leaf := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"crt":       leaf,
+		"ca":        pemCerts(ca.intermediate),
+		"certChain": []string{leaf, pemCerts(ca.intermediate)},
+	})
+}
+
+// testAPIKeys identify callers of newTestRouter. Members of the platform
+// role may manage every certificate's deployment targets.
+var testAPIKeys = []auth.APIKey{
+	{Name: "alice", Key: "alice-key"},
+	{Name: "bob", Key: "bob-key"},
+	{Name: "platform", Key: "platform-key", Roles: []string{"platform"}},
+}
+
+// newTestHandlers returns handlers backed by a new database and ca.
+// Requests go through the OIDC provisioner, so callers send an ID token as
+// the sign token instead of the backend running step ca token. Filesystem
+// targets may write below the returned directory.
+func newTestHandlers(t *testing.T, ca *fakeCA) (*Handlers, *db.Database) {
+	t.Helper()
+
+	database, err := db.NewDatabase(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	creds := step.Credentials{Type: step.CredentialsOIDC, Provisioner: "oidc"}
+	if err := creds.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	stepClient := step.NewStepClient(ca.URL, step.Fingerprint(ca.root), creds)
+
+	profiles, err := profile.Load("", profile.Limits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	policyEngine, err := policy.Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	approvals, err := approval.Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := storage.New(storage.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deployOpts := deploy.Options{FileRoots: []string{t.TempDir()}, Timeout: 10 * time.Second}
+	return NewHandlers(database, stepClient, profiles, policyEngine, approvals, deployOpts, []string{"platform"}, store, nil, nil, nil, nil), database
+}
+
+// newTestRouter returns the API routes of newTestHandlers behind middleware
+func newTestRouter(t *testing.T, ca *fakeCA, middleware ...gin.HandlerFunc) *gin.Engine {
+	t.Helper()
+	h, _ := newTestHandlers(t, ca)
+	return testRoutes(h, middleware...)
+}
+
+// testRoutes returns the API routes of h behind middleware. Callers are
+// identified by testAPIKeys or proxy headers.
+func testRoutes(h *Handlers, middleware ...gin.HandlerFunc) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(append(middleware, auth.Middleware(testAPIKeys, true))...)
+	SetupRoutes(r, h)
+	return r
+}
+
+// serve sends a request with a JSON body, if any, and headers given as
+// name, value pairs
+func serve(r http.Handler, method, target, body string, headers ...string) *httptest.ResponseRecorder {
+	var req *http.Request
+	if body != "" {
+		req = httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+	} else {
+		req = httptest.NewRequest(method, target, nil)
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
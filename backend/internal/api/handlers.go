@@ -1,15 +1,30 @@
 package api
 
 import (
+	"context"
+	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
+	"regexp"
+	"slices"
 	"strconv"
+	"sync"
 	"time"
 
+	"step-ca-webui/internal/acme"
+	"step-ca-webui/internal/approval"
+	"step-ca-webui/internal/auth"
+	"step-ca-webui/internal/certfmt"
 	"step-ca-webui/internal/db"
+	"step-ca-webui/internal/deploy"
+	"step-ca-webui/internal/metrics"
+	"step-ca-webui/internal/policy"
+	"step-ca-webui/internal/profile"
 	"step-ca-webui/internal/step"
+	"step-ca-webui/internal/storage"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
@@ -18,194 +33,447 @@ import (
 type Handlers struct {
 	db         *db.Database
 	stepClient *step.StepClient
+	profiles   *profile.Registry
+	policy     *policy.Engine
+	approvals  *approval.Workflow
+	pickups    *pickupStore[*step.CertBundle]
+	downloads  *pickupStore[*step.DownloadFile]
+	deployOpts deploy.Options
+	storage    storage.Backend
+	acme       *acme.Reader // nil unless CA_DB_TYPE is set
+	// admin manages provisioners; nil unless STEP_ADMIN_CERT is set
+	admin                 *step.AdminClient
+	provisionerAdminRoles []string
+	// deployRoles may manage the targets of certificates they do not own
+	deployRoles []string
+	// crl publishes revocations made here; nil unless CRL_SIGNING_CERT is set
+	crl *step.CRLPublisher
+	// deployLocks serializes deployments per target ID
+	deployLocks sync.Map
 }
 
-func NewHandlers(database *db.Database, stepClient *step.StepClient) *Handlers {
+func NewHandlers(database *db.Database, stepClient *step.StepClient, profiles *profile.Registry, policyEngine *policy.Engine, approvals *approval.Workflow, deployOpts deploy.Options, deployRoles []string, store storage.Backend, acmeReader *acme.Reader, adminClient *step.AdminClient, provisionerAdminRoles []string, crlPublisher *step.CRLPublisher) *Handlers {
 	return &Handlers{
 		db:         database,
 		stepClient: stepClient,
+		profiles:   profiles,
+		policy:     policyEngine,
+		approvals:  approvals,
+		pickups:    newPickupStore[*step.CertBundle](),
+		downloads:  newPickupStore[*step.DownloadFile](),
+		deployOpts: deployOpts,
+		storage:    store,
+		acme:       acmeReader,
+
+		admin:                 adminClient,
+		provisionerAdminRoles: provisionerAdminRoles,
+		deployRoles:           deployRoles,
+		crl:                   crlPublisher,
 	}
 }
 
 type IssueRequest struct {
-	CN           string   `json:"cn" binding:"required"`
-	SANs         []string `json:"sans"`
-	NotAfterDays int      `json:"not_after_days" binding:"required"`
-	Format       string   `json:"format"` // pem, pfx
-	PFXPassword  string   `json:"pfx_password,omitempty"`
+	CN   string   `json:"cn" binding:"required"`
+	SANs []string `json:"sans"`
+	LifetimeRequest
+	BundleRequest
+	Profile     string          `json:"profile,omitempty"`
+	KeyType     string          `json:"key_type,omitempty"` // ec-p256, ec-p384, rsa-2048, rsa-3072, rsa-4096, ed25519
+	ExcludeRoot bool            `json:"exclude_root,omitempty"`
+	Targets     []TargetRequest `json:"targets,omitempty"` // deployment targets stored with the certificate
+}
+
+// BundleRequest selects the download bundle format and its secrets
+type BundleRequest struct {
+	Format           string `json:"format"` // see step.Format constants
+	PFXPassword      string `json:"pfx_password,omitempty"`
+	PFXEncryption    string `json:"pfx_encryption,omitempty"` // modern (default), legacy
+	FriendlyName     string `json:"friendly_name,omitempty"`
+	KeystoreAlias    string `json:"keystore_alias,omitempty"`
+	KeystorePassword string `json:"keystore_password,omitempty"`
+	KeyPassword      string `json:"key_password,omitempty"`
+	ZipPassword      string `json:"zip_password,omitempty"`
+	SecretName       string `json:"secret_name,omitempty"`
+	Namespace        string `json:"namespace,omitempty"`
+}
+
+var (
+	dns1123Subdomain = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*$`)
+	dns1123Label     = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+)
+
+func (b BundleRequest) validate() ValidationErrors {
+	var errs ValidationErrors
+	switch b.Format {
+	case "", step.FormatPEM, step.FormatTarGz, step.FormatCombined, step.FormatDER, step.FormatP7B:
+	case step.FormatPFX:
+		if b.PFXEncryption != "" && b.PFXEncryption != certfmt.PFXModern && b.PFXEncryption != certfmt.PFXLegacy {
+			errs.Add("pfx_encryption", "must be %s or %s", certfmt.PFXModern, certfmt.PFXLegacy)
+		}
+	case step.FormatJKS:
+		if len(b.KeystorePassword) < certfmt.MinKeystorePasswordLen {
+			errs.Add("keystore_password", "must be at least %d characters for jks", certfmt.MinKeystorePasswordLen)
+		}
+	case step.FormatPKCS8:
+		if b.KeyPassword == "" {
+			errs.Add("key_password", "is required for pkcs8")
+		}
+	case step.FormatK8s:
+		if b.SecretName != "" && (len(b.SecretName) > 253 || !dns1123Subdomain.MatchString(b.SecretName)) {
+			errs.Add("secret_name", "must be a lowercase DNS subdomain")
+		}
+		if b.Namespace != "" && (len(b.Namespace) > 63 || !dns1123Label.MatchString(b.Namespace)) {
+			errs.Add("namespace", "must be a lowercase DNS label")
+		}
+	default:
+		errs.Add("format", "unsupported format %s", b.Format)
+	}
+
+	// Keys are only encrypted where the consumer can read an encrypted key
+	if b.KeyPassword != "" {
+		switch b.Format {
+		case step.FormatCombined, step.FormatK8s, step.FormatDER, step.FormatP7B:
+			errs.Add("key_password", "is not supported for %s", b.Format)
+		default:
+			if len(b.KeyPassword) < certfmt.MinKeyPasswordLen {
+				errs.Add("key_password", "must be at least %d characters", certfmt.MinKeyPasswordLen)
+			}
+		}
+	}
+	if b.ZipPassword != "" {
+		switch b.Format {
+		case "", step.FormatPEM, step.FormatPFX, step.FormatJKS:
+			if len(b.ZipPassword) < step.MinZipPasswordLen {
+				errs.Add("zip_password", "must be at least %d characters", step.MinZipPasswordLen)
+			}
+		default:
+			errs.Add("zip_password", "is only supported for ZIP formats")
+		}
+	}
+	return errs
+}
+
+func (b BundleRequest) downloadOptions() step.DownloadOptions {
+	return step.DownloadOptions{
+		Format:           b.Format,
+		PFXPassword:      b.PFXPassword,
+		PFXEncryption:    b.PFXEncryption,
+		FriendlyName:     b.FriendlyName,
+		KeystoreAlias:    b.KeystoreAlias,
+		KeystorePassword: b.KeystorePassword,
+		KeyPassword:      b.KeyPassword,
+		ZipPassword:      b.ZipPassword,
+		SecretName:       b.SecretName,
+		Namespace:        b.Namespace,
+	}
 }
 
 type SignCSRRequest struct {
-	CSRPEM       string `json:"csr_pem" binding:"required"`
-	NotAfterDays int    `json:"not_after_days" binding:"required"`
+	CSRPEM string `json:"csr_pem" binding:"required"`
+	LifetimeRequest
+	ExcludeRoot bool            `json:"exclude_root,omitempty"`
+	Targets     []TargetRequest `json:"targets,omitempty"`
 }
 
 type CertResponse struct {
 	ID          string    `json:"id"`
+	CertType    string    `json:"cert_type"`
 	CN          string    `json:"cn"`
 	SANs        []string  `json:"sans"`
+	Serial      string    `json:"serial,omitempty"`
 	NotAfter    time.Time `json:"not_after"`
 	Status      string    `json:"status"`
 	KeyStrategy string    `json:"key_strategy"`
+	KeyType     string    `json:"key_type,omitempty"`
+	Profile     string    `json:"profile,omitempty"`
+	StorageRef  string    `json:"storage_ref"`
+	ACMEAccount string    `json:"acme_account,omitempty"`
+	ACMEOrder   string    `json:"acme_order,omitempty"`
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
 }
 
-type DownloadResponse struct {
-	Data     []byte `json:"data"`
-	Filename string `json:"filename"`
-	MimeType string `json:"mime_type"`
-}
-
 // IssueCertificate issues a new certificate
 func (h *Handlers) IssueCertificate(c *gin.Context) {
 	var req IssueRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if !bindJSON(c, &req) {
 		return
 	}
 
-	log.Printf("DEBUG [Handler]: IssueCertificate handler called with CN=%s\n", req.CN)
-	
-	// Generate certificate using step CLI
-	bundle, err := h.stepClient.IssueCertificate(req.CN, req.SANs, req.NotAfterDays)
+	opts, errs := h.prepareIssue(&req)
+	if len(errs) > 0 {
+		respondValidation(c, errs)
+		return
+	}
+
+	// Check requested names against the naming policy
+	identity := auth.FromContext(c)
+	if !h.enforcePolicy(c, identity, req.CN, req.SANs) {
+		return
+	}
+	if len(req.Targets) > 0 && (!h.requireTargetAccess(c, identity, nil) || !h.requireUnclaimed(c, "", req.Targets)) {
+		return
+	}
+
+	// Sensitive requests wait for an approver instead of going to the CA
+	if rules := h.approvals.Match(req.CN, req.SANs, req.Profile); len(rules) > 0 {
+		pending := req
+		pending.BundleRequest = BundleRequest{} // chosen again when the bundle is picked up
+		h.requestApproval(c, identity, "issue", req.CN, req.SANs, req.Profile, pending, rules)
+		return
+	}
+
+	if !h.requireIDToken(c, identity, opts.Auth.Credentials) {
+		return
+	}
+	cert, bundle, err := h.issue(c.Request.Context(), identity, &req, opts)
 	if err != nil {
-		log.Printf("DEBUG [Handler]: IssueCertificate returned error: %v\n", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to issue certificate: %v", err)})
 		return
 	}
 
-	// Generate unique ID for the certificate
-	certID := uuid.New().String()
+	// Render the requested format and hand out a one-time download link
+	download, err := h.offerDownload(identity, bundle, req.downloadOptions())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to create download: %v", err)})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"certificate": certResponse(cert),
+		"download":    download,
+	})
+}
+
+// prepareIssue validates an issue request, fills in profile defaults and
+// resolves the options passed to the CA
+func (h *Handlers) prepareIssue(req *IssueRequest) (step.IssueOptions, ValidationErrors) {
+	opts := step.IssueOptions{
+		CN:          req.CN,
+		SANs:        req.SANs,
+		KeyType:     req.KeyType,
+		ExcludeRoot: req.ExcludeRoot,
+	}
+
+	// Fill in defaults from the issuance profile and validate against it
+	var errs ValidationErrors
+	var p *profile.Profile
+	if req.Profile != "" {
+		var ok bool
+		if p, ok = h.profiles.Get(req.Profile); !ok {
+			errs.Add("profile", "unknown profile %s", req.Profile)
+			return opts, errs
+		}
+		errs = append(errs, applyProfile(p, req, &opts)...)
+	}
+	errs = append(errs, req.BundleRequest.validate()...)
+	errs = append(errs, h.validateTargets("targets", req.Targets)...)
+	if opts.KeyType == "" {
+		opts.KeyType = step.DefaultKeyType
+	}
+	if !step.IsValidKeyType(opts.KeyType) {
+		errs.Add("key_type", "unsupported key type %s", opts.KeyType)
+	}
+
+	defaultDays := 0
+	if p != nil {
+		defaultDays = p.DefaultDays
+	}
+	validity, lifetimeErrs := resolveValidity(time.Now(), req.LifetimeRequest, defaultDays, h.profiles.Limits(p))
+	errs = append(errs, lifetimeErrs...)
+	opts.Validity = validity
+
+	return opts, errs
+}
+
+// issue has the CA issue a certificate with a server-generated key and
+// records it in the inventory
+func (h *Handlers) issue(ctx context.Context, identity *auth.Identity, req *IssueRequest, opts step.IssueOptions) (*db.Certificate, *step.CertBundle, error) {
+	// Generate certificate using step CLI
+	opts.Auth.IDToken = identity.IDToken
+	start := time.Now()
+	bundle, err := h.stepClient.IssueCertificate(ctx, opts)
+	if p, ok := h.profiles.Get(req.Profile); ok && err == nil {
+		err = checkProfile(p, bundle)
+	}
+	metrics.ObserveOperation(metrics.OpIssue, start, err)
+	if err != nil {
+		return nil, nil, err
+	}
 
 	// Store certificate metadata in database
 	sansJSON, _ := json.Marshal(req.SANs)
 	cert := &db.Certificate{
-		ID:          certID,
+		ID:          uuid.New().String(),
+		CertType:    "x509",
 		CN:          req.CN,
 		SANs:        string(sansJSON),
+		Serial:      bundle.Serial,
 		NotAfter:    bundle.NotAfter,
 		Status:      "active",
 		KeyStrategy: "server",
-		StorageRef:  "ephemeral",
-		OwnerUser:   "system", // No auth for MVP
+		KeyType:     opts.KeyType,
+		Profile:     req.Profile,
+		StorageRef:  storage.TypeEphemeral,
+		OwnerUser:   identity.User,
 		CreatedAt:   time.Now(),
 		UpdatedAt:   time.Now(),
 	}
 
-	if err := h.db.CreateCertificate(cert); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store certificate metadata"})
-		return
+	if err := h.db.CreateCertificate(ctx, cert); err != nil {
+		return nil, nil, fmt.Errorf("failed to store certificate metadata: %w", err)
 	}
 
 	// Log audit event
 	auditEvent := &db.AuditEvent{
-		CertID:    certID,
-		Who:       "system",
+		CertID:    cert.ID,
+		Who:       identity.User,
 		Action:    "issued",
-		Details:   fmt.Sprintf("CN: %s, SANs: %v", req.CN, req.SANs),
+		Details:   fmt.Sprintf("CN: %s, SANs: %v, Profile: %s", req.CN, req.SANs, req.Profile),
 		Timestamp: time.Now(),
 	}
-	h.db.LogAuditEvent(auditEvent)
+	h.db.LogAuditEvent(ctx, auditEvent)
 
-	// Create download bundle
-	downloadData, err := h.stepClient.CreateDownloadBundle(bundle, req.Format, req.PFXPassword)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create download bundle"})
-		return
-	}
-
-	// Return certificate info and download data
-	response := CertResponse{
-		ID:          certID,
-		CN:          req.CN,
-		SANs:        req.SANs,
-		NotAfter:    bundle.NotAfter,
-		Status:      "active",
-		KeyStrategy: "server",
-		CreatedAt:   cert.CreatedAt,
-		UpdatedAt:   cert.UpdatedAt,
+	h.storeBundle(ctx, identity.User, cert, bundle)
+	if _, err := h.createTargets(ctx, identity, cert.ID, req.Targets); err != nil {
+		slog.WarnContext(ctx, "Certificate issued, but its deployment targets were not saved", "cert_id", cert.ID, "error", err)
 	}
+	h.deployBundle(ctx, identity.User, cert, bundle, "issued")
 
-	c.JSON(http.StatusOK, gin.H{
-		"certificate": response,
-		"download": gin.H{
-			"data":     downloadData,
-			"filename": fmt.Sprintf("%s-cert-bundle.zip", req.CN),
-			"mime_type": "application/zip",
-		},
-	})
+	return cert, bundle, nil
 }
 
 // SignCSR signs a certificate signing request
 func (h *Handlers) SignCSR(c *gin.Context) {
 	var req SignCSRRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if !bindJSON(c, &req) {
 		return
 	}
 
-	// Sign CSR using step CLI
-	bundle, err := h.stepClient.SignCSR(req.CSRPEM, req.NotAfterDays)
+	csr, validity, errs := h.prepareSignCSR(&req)
+	if len(errs) > 0 {
+		respondValidation(c, errs)
+		return
+	}
+	cn := csr.Subject.CommonName
+	sans := step.CSRSANs(csr)
+
+	// Check requested names against the naming policy
+	identity := auth.FromContext(c)
+	if !h.enforcePolicy(c, identity, cn, sans) {
+		return
+	}
+	if len(req.Targets) > 0 && (!h.requireTargetAccess(c, identity, nil) || !h.requireUnclaimed(c, "", req.Targets)) {
+		return
+	}
+
+	// Sensitive requests wait for an approver instead of going to the CA
+	if rules := h.approvals.Match(cn, sans, ""); len(rules) > 0 {
+		h.requestApproval(c, identity, "sign_csr", cn, sans, "", req, rules)
+		return
+	}
+
+	if !h.requireIDToken(c, identity, nil) {
+		return
+	}
+	cert, bundle, err := h.signCSR(c.Request.Context(), identity, req.CSRPEM, cn, sans, validity, req.ExcludeRoot, req.Targets)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to sign CSR: %v", err)})
 		return
 	}
 
-	// Generate unique ID for the certificate
-	certID := uuid.New().String()
+	c.JSON(http.StatusOK, gin.H{
+		"certificate":   certResponse(cert),
+		"cert_pem":      string(bundle.CertPEM),
+		"chain_pem":     string(bundle.ChainPEM),
+		"fullchain_pem": string(bundle.FullChainPEM),
+	})
+}
+
+// prepareSignCSR parses the CSR and resolves the requested validity
+func (h *Handlers) prepareSignCSR(req *SignCSRRequest) (*x509.CertificateRequest, step.Validity, ValidationErrors) {
+	// Extract the requested names from the CSR
+	csr, err := step.ParseCSR([]byte(req.CSRPEM))
+	if err != nil {
+		return nil, step.Validity{}, ValidationErrors{{Field: "csr_pem", Message: err.Error()}}
+	}
 
-	// Parse CSR to extract CN and SANs (simplified - in real implementation, parse CSR)
-	cn := "unknown" // Would need to parse CSR properly
-	sans := []string{}
+	validity, errs := resolveValidity(time.Now(), req.LifetimeRequest, 0, h.profiles.Limits(nil))
+	errs = append(errs, h.validateTargets("targets", req.Targets)...)
+	return csr, validity, errs
+}
+
+// signCSR has the CA sign a CSR and records the certificate in the inventory
+func (h *Handlers) signCSR(ctx context.Context, identity *auth.Identity, csrPEM, cn string, sans []string, validity step.Validity, excludeRoot bool, targets []TargetRequest) (*db.Certificate, *step.CertBundle, error) {
+	// Sign CSR using step CLI
+	start := time.Now()
+	bundle, err := h.stepClient.SignCSR(ctx, step.Auth{IDToken: identity.IDToken}, csrPEM, validity, excludeRoot)
+	metrics.ObserveOperation(metrics.OpSign, start, err)
+	if err != nil {
+		return nil, nil, err
+	}
 
 	// Store certificate metadata in database
 	sansJSON, _ := json.Marshal(sans)
 	cert := &db.Certificate{
-		ID:          certID,
+		ID:          uuid.New().String(),
+		CertType:    "x509",
 		CN:          cn,
 		SANs:        string(sansJSON),
+		Serial:      bundle.Serial,
 		NotAfter:    bundle.NotAfter,
 		Status:      "active",
 		KeyStrategy: "csr",
-		StorageRef:  "ephemeral",
-		OwnerUser:   "system",
+		StorageRef:  storage.TypeEphemeral,
+		OwnerUser:   identity.User,
 		CreatedAt:   time.Now(),
 		UpdatedAt:   time.Now(),
 	}
 
-	if err := h.db.CreateCertificate(cert); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store certificate metadata"})
-		return
+	if err := h.db.CreateCertificate(ctx, cert); err != nil {
+		return nil, nil, fmt.Errorf("failed to store certificate metadata: %w", err)
 	}
 
 	// Log audit event
 	auditEvent := &db.AuditEvent{
-		CertID:    certID,
-		Who:       "system",
+		CertID:    cert.ID,
+		Who:       identity.User,
 		Action:    "signed_csr",
-		Details:   fmt.Sprintf("CN: %s", cn),
+		Details:   fmt.Sprintf("CN: %s, SANs: %v", cn, sans),
 		Timestamp: time.Now(),
 	}
-	h.db.LogAuditEvent(auditEvent)
+	h.db.LogAuditEvent(ctx, auditEvent)
 
-	// Return certificate info
-	response := CertResponse{
-		ID:          certID,
-		CN:          cn,
+	h.storeBundle(ctx, identity.User, cert, bundle)
+	if _, err := h.createTargets(ctx, identity, cert.ID, targets); err != nil {
+		slog.WarnContext(ctx, "Certificate signed, but its deployment targets were not saved", "cert_id", cert.ID, "error", err)
+	}
+	h.deployBundle(ctx, identity.User, cert, bundle, "issued")
+
+	return cert, bundle, nil
+}
+
+func certResponse(cert *db.Certificate) CertResponse {
+	var sans []string
+	json.Unmarshal([]byte(cert.SANs), &sans)
+
+	return CertResponse{
+		ID:          cert.ID,
+		CertType:    cert.CertType,
+		CN:          cert.CN,
 		SANs:        sans,
-		NotAfter:    bundle.NotAfter,
-		Status:      "active",
-		KeyStrategy: "csr",
+		Serial:      cert.Serial,
+		NotAfter:    cert.NotAfter,
+		Status:      cert.Status,
+		KeyStrategy: cert.KeyStrategy,
+		KeyType:     cert.KeyType,
+		Profile:     cert.Profile,
+		StorageRef:  cert.StorageRef,
+		ACMEAccount: cert.ACMEAccount,
+		ACMEOrder:   cert.ACMEOrder,
 		CreatedAt:   cert.CreatedAt,
 		UpdatedAt:   cert.UpdatedAt,
 	}
-
-	c.JSON(http.StatusOK, gin.H{
-		"certificate": response,
-		"cert_pem":    string(bundle.CertPEM),
-		"chain_pem":   string(bundle.ChainPEM),
-	})
 }
 
 // ListCertificates returns a list of certificates
@@ -214,6 +482,8 @@ func (h *Handlers) ListCertificates(c *gin.Context) {
 	limitStr := c.DefaultQuery("limit", "50")
 	offsetStr := c.DefaultQuery("offset", "0")
 	status := c.Query("status")
+	certType := c.Query("type")
+	acmeAccount := c.Query("acme_account")
 
 	limit, err := strconv.Atoi(limitStr)
 	if err != nil {
@@ -226,7 +496,7 @@ func (h *Handlers) ListCertificates(c *gin.Context) {
 	}
 
 	// Get certificates from database
-	certs, err := h.db.ListCertificates(limit, offset, status)
+	certs, err := h.db.ListCertificates(c.Request.Context(), limit, offset, status, certType, acmeAccount)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list certificates"})
 		return
@@ -234,20 +504,8 @@ func (h *Handlers) ListCertificates(c *gin.Context) {
 
 	// Convert to response format
 	var responses []CertResponse
-	for _, cert := range certs {
-		var sans []string
-		json.Unmarshal([]byte(cert.SANs), &sans)
-		
-		responses = append(responses, CertResponse{
-			ID:          cert.ID,
-			CN:          cert.CN,
-			SANs:        sans,
-			NotAfter:    cert.NotAfter,
-			Status:      cert.Status,
-			KeyStrategy: cert.KeyStrategy,
-			CreatedAt:   cert.CreatedAt,
-			UpdatedAt:   cert.UpdatedAt,
-		})
+	for i := range certs {
+		responses = append(responses, certResponse(&certs[i]))
 	}
 
 	c.JSON(http.StatusOK, gin.H{"certificates": responses})
@@ -257,27 +515,13 @@ func (h *Handlers) ListCertificates(c *gin.Context) {
 func (h *Handlers) GetCertificate(c *gin.Context) {
 	certID := c.Param("id")
 	
-	cert, err := h.db.GetCertificate(certID)
+	cert, err := h.db.GetCertificate(c.Request.Context(), certID)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Certificate not found"})
 		return
 	}
 
-	var sans []string
-	json.Unmarshal([]byte(cert.SANs), &sans)
-	
-	response := CertResponse{
-		ID:          cert.ID,
-		CN:          cert.CN,
-		SANs:        sans,
-		NotAfter:    cert.NotAfter,
-		Status:      cert.Status,
-		KeyStrategy: cert.KeyStrategy,
-		CreatedAt:   cert.CreatedAt,
-		UpdatedAt:   cert.UpdatedAt,
-	}
-
-	c.JSON(http.StatusOK, gin.H{"certificate": response})
+	c.JSON(http.StatusOK, gin.H{"certificate": certResponse(cert)})
 }
 
 // RenewCertificate renews a certificate
@@ -285,57 +529,134 @@ func (h *Handlers) RenewCertificate(c *gin.Context) {
 	certID := c.Param("id")
 	
 	// Get existing certificate
-	cert, err := h.db.GetCertificate(certID)
+	cert, err := h.db.GetCertificate(c.Request.Context(), certID)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Certificate not found"})
 		return
 	}
+	if isSSHCertType(cert.CertType) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "SSH certificates are renewed by signing the public key again"})
+		return
+	}
+	if cert.KeyStrategy == keyStrategyACME {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ACME certificates are renewed by their ACME client"})
+		return
+	}
 
 	// Parse SANs
 	var sans []string
 	json.Unmarshal([]byte(cert.SANs), &sans)
 
-	// Issue new certificate with same CN and SANs
-	bundle, err := h.stepClient.IssueCertificate(cert.CN, sans, 90) // Default 90 days
+	// Check names against the current naming policy
+	identity := auth.FromContext(c)
+	if !h.enforcePolicy(c, identity, cert.CN, sans) {
+		return
+	}
+	opts, p, err := h.prepareRenew(cert, sans)
 	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Profile %s no longer exists", cert.Profile)})
+		return
+	}
+
+	// Renewals of sensitive certificates wait for an approver like new ones
+	if rules := h.approvals.Match(cert.CN, sans, cert.Profile); len(rules) > 0 {
+		h.requestApproval(c, identity, "renew", cert.CN, sans, cert.Profile, RenewRequest{CertID: cert.ID}, rules)
+		return
+	}
+
+	if !h.requireIDToken(c, identity, opts.Auth.Credentials) {
+		return
+	}
+	opts.Auth.IDToken = identity.IDToken
+	if _, err := h.renew(c.Request.Context(), identity, cert, p, opts); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to renew certificate: %v", err)})
 		return
 	}
 
+	// Return new certificate info
+	c.JSON(http.StatusOK, gin.H{"certificate": certResponse(cert)})
+}
+
+// RenewRequest identifies the certificate of a renewal awaiting approval
+type RenewRequest struct {
+	CertID string `json:"cert_id"`
+}
+
+// prepareRenew resolves the options for issuing cert again with the same
+// CN, SANs and profile, within the current lifetime limits
+func (h *Handlers) prepareRenew(cert *db.Certificate, sans []string) (step.IssueOptions, *profile.Profile, error) {
+	opts := step.IssueOptions{
+		CN:      cert.CN,
+		SANs:    sans,
+		KeyType: cert.KeyType,
+	}
+	lifetime := 90 * 24 * time.Hour // Default 90 days
+	var p *profile.Profile
+	if cert.Profile != "" {
+		var ok bool
+		if p, ok = h.profiles.Get(cert.Profile); !ok {
+			return opts, nil, fmt.Errorf("profile %s no longer exists", cert.Profile)
+		}
+		if p.DefaultDays > 0 {
+			lifetime = time.Duration(p.DefaultDays) * 24 * time.Hour
+		}
+		if p.KeyType != "" {
+			opts.KeyType = p.KeyType
+		}
+		opts.TemplateData = p.TemplateData()
+		opts.Auth.Credentials = p.Provisioner
+	}
+
+	// Keep the renewed lifetime within the current limits
+	limits := h.profiles.Limits(p)
+	if limits.Max > 0 && lifetime > limits.Max {
+		lifetime = limits.Max
+	}
+	if lifetime < limits.Min {
+		lifetime = limits.Min
+	}
+	opts.Validity = step.Validity{NotAfter: time.Now().Add(lifetime)}
+	return opts, p, nil
+}
+
+// renew issues cert again, records the new serial and pushes the bundle to
+// storage and the certificate's deployment targets
+func (h *Handlers) renew(ctx context.Context, identity *auth.Identity, cert *db.Certificate, p *profile.Profile, opts step.IssueOptions) (*step.CertBundle, error) {
+	start := time.Now()
+	bundle, err := h.stepClient.IssueCertificate(ctx, opts)
+	if p != nil && err == nil {
+		err = checkProfile(p, bundle)
+	}
+	metrics.ObserveOperation(metrics.OpRenew, start, err)
+	if err != nil {
+		return nil, err
+	}
+
 	// Update certificate in database
+	cert.Serial = bundle.Serial
 	cert.NotAfter = bundle.NotAfter
 	cert.UpdatedAt = time.Now()
-	if err := h.db.UpdateCertificate(cert); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update certificate"})
-		return
+	if err := h.db.UpdateCertificate(ctx, cert); err != nil {
+		return nil, errors.New("failed to update certificate")
 	}
 
 	// Log audit event
 	auditEvent := &db.AuditEvent{
-		CertID:    certID,
-		Who:       "system",
+		CertID:    cert.ID,
+		Who:       identity.User,
 		Action:    "renewed",
 		Details:   fmt.Sprintf("CN: %s", cert.CN),
 		Timestamp: time.Now(),
 	}
-	h.db.LogAuditEvent(auditEvent)
+	h.db.LogAuditEvent(ctx, auditEvent)
 
-	// Return new certificate info
-	var responseSans []string
-	json.Unmarshal([]byte(cert.SANs), &responseSans)
-	
-	response := CertResponse{
-		ID:          cert.ID,
-		CN:          cert.CN,
-		SANs:        responseSans,
-		NotAfter:    cert.NotAfter,
-		Status:      cert.Status,
-		KeyStrategy: cert.KeyStrategy,
-		CreatedAt:   cert.CreatedAt,
-		UpdatedAt:   cert.UpdatedAt,
-	}
+	// Keep the renewed certificate and key in the storage backend
+	h.storeBundle(ctx, identity.User, cert, bundle)
+
+	// Push the renewed certificate to its deployment targets
+	h.deployBundle(ctx, identity.User, cert, bundle, "renewed")
 
-	c.JSON(http.StatusOK, gin.H{"certificate": response})
+	return bundle, nil
 }
 
 // RevokeCertificate revokes a certificate
@@ -343,52 +664,201 @@ func (h *Handlers) RevokeCertificate(c *gin.Context) {
 	certID := c.Param("id")
 	
 	// Get certificate
-	cert, err := h.db.GetCertificate(certID)
+	cert, err := h.db.GetCertificate(c.Request.Context(), certID)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Certificate not found"})
 		return
 	}
 
-	// Revoke using step CLI (would need serial number)
-	// For now, just mark as revoked in database
+	identity := auth.FromContext(c)
+
+	// X.509 certificates are revoked at the CA through the provisioner that
+	// issued them, so the CRL and OCSP report it. SSH certificates are only
+	// marked in the inventory.
+	if cert.CertType == "" || cert.CertType == "x509" {
+		serial, err := step.ParseSerial(cert.Serial)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Certificate has no serial number on record"})
+			return
+		}
+		creds := h.profileCredentials(cert.Profile)
+		if !h.requireIDToken(c, identity, creds) {
+			return
+		}
+		start := time.Now()
+		err = h.stepClient.RevokeCertificate(c.Request.Context(), step.Auth{Credentials: creds, IDToken: identity.IDToken}, serial.String())
+		if errors.Is(err, step.ErrAlreadyRevoked) {
+			err = nil
+		}
+		metrics.ObserveOperation(metrics.OpRevoke, start, err)
+		if err != nil {
+			c.JSON(http.StatusBadGateway, gin.H{"error": fmt.Sprintf("Failed to revoke certificate at the CA: %v", err)})
+			return
+		}
+	}
+
 	cert.Status = "revoked"
 	cert.UpdatedAt = time.Now()
-	if err := h.db.UpdateCertificate(cert); err != nil {
+	if err := h.db.UpdateCertificate(c.Request.Context(), cert); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke certificate"})
 		return
 	}
+	if cert.CertType == "" || cert.CertType == "x509" {
+		if err := h.recordRevocation(c.Request.Context(), cert, identity.User); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record revocation"})
+			return
+		}
+	}
 
 	// Log audit event
 	auditEvent := &db.AuditEvent{
 		CertID:    certID,
-		Who:       "system",
+		Who:       identity.User,
 		Action:    "revoked",
 		Details:   fmt.Sprintf("CN: %s", cert.CN),
 		Timestamp: time.Now(),
 	}
-	h.db.LogAuditEvent(auditEvent)
+	h.db.LogAuditEvent(c.Request.Context(), auditEvent)
 
 	c.JSON(http.StatusOK, gin.H{"message": "Certificate revoked successfully"})
 }
 
+// ListProfiles returns the configured issuance profiles
+func (h *Handlers) ListProfiles(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"profiles": h.profiles.List()})
+}
+
+// applyProfile fills request defaults from the profile and reports values
+// the profile does not allow. Lifetimes are checked by resolveValidity.
+func applyProfile(p *profile.Profile, req *IssueRequest, opts *step.IssueOptions) ValidationErrors {
+	var errs ValidationErrors
+
+	if !p.AllowsName(req.CN) {
+		errs.Add("cn", "%s is not allowed by profile %s", req.CN, p.Name)
+	}
+	for i, san := range req.SANs {
+		if !p.AllowsName(san) {
+			errs.Add(fmt.Sprintf("sans[%d]", i), "%s is not allowed by profile %s", san, p.Name)
+		}
+	}
+
+	if p.KeyType != "" {
+		if opts.KeyType != "" && opts.KeyType != p.KeyType {
+			errs.Add("key_type", "%s is not allowed by profile %s, which requires %s", opts.KeyType, p.Name, p.KeyType)
+		}
+		opts.KeyType = p.KeyType
+	}
+
+	if req.Format == "" {
+		req.Format = p.Format
+	}
+	opts.TemplateData = p.TemplateData()
+	opts.Auth.Credentials = p.Provisioner
+
+	return errs
+}
+
+// checkProfile verifies that the CA applied the profile to an issued
+// certificate. Certificates failing the check are not stored, so the error
+// names the serial for the operator to revoke.
+func checkProfile(p *profile.Profile, bundle *step.CertBundle) error {
+	if err := p.CheckCertificate(bundle.Leaf); err != nil {
+		return fmt.Errorf("certificate %s does not match profile %s: %w", bundle.Serial, p.Name, err)
+	}
+	return nil
+}
+
+// requireIDToken writes an error response when the provisioner a request
+// goes through authenticates with OIDC and the caller sent no ID token.
+// Nil credentials stand for the default provisioner.
+func (h *Handlers) requireIDToken(c *gin.Context, identity *auth.Identity, creds *step.Credentials) bool {
+	if creds == nil {
+		creds = &h.stepClient.Credentials
+	}
+	if creds.NeedsIDToken() && identity.IDToken == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": fmt.Sprintf("Provisioner %s authenticates with OIDC; send your ID token as \"Authorization: Bearer <token>\"", creds.Provisioner)})
+		return false
+	}
+	return true
+}
+
+// profileCredentials returns the provisioner credentials of the named
+// profile, or nil for the default provisioner
+func (h *Handlers) profileCredentials(name string) *step.Credentials {
+	if p, ok := h.profiles.Get(name); ok {
+		return p.Provisioner
+	}
+	return nil
+}
+
+// issuingProvisioners returns the names of the provisioners certificates
+// are issued through: the default one and those of the profiles
+func (h *Handlers) issuingProvisioners() []string {
+	names := []string{h.stepClient.Credentials.Provisioner}
+	for _, p := range h.profiles.List() {
+		if p.Provisioner != nil && !slices.Contains(names, p.Provisioner.Provisioner) {
+			names = append(names, p.Provisioner.Provisioner)
+		}
+	}
+	return names
+}
+
 // GetCASettings returns CA configuration
 func (h *Handlers) GetCASettings(c *gin.Context) {
-	// This would typically read from configuration
 	settings := gin.H{
 		"ca_url":           h.stepClient.CAURL,
-		"root_fingerprint": "TODO: Calculate from CA root",
-		"acme_directories": []string{
-			h.stepClient.CAURL + "/acme/acme/directory",
-		},
+		"root_fingerprint": nil,
+	}
+
+	// List the ACME provisioners the CA has now, falling back to the ones
+	// seen last when it cannot be reached
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+	stored, err := h.db.GetCASettings(c.Request.Context())
+	if err != nil {
+		stored = &db.CASettings{CreatedAt: time.Now()}
+	}
+	if provisioners, err := h.acmeProvisioners(ctx); err == nil {
+		directories := make([]string, 0, len(provisioners))
+		for _, p := range provisioners {
+			directories = append(directories, p.Directory)
+		}
+		if !slices.Equal(directories, stored.ACMEDirectories) {
+			stored.ACMEDirectories = directories
+			stored.UpdatedAt = time.Now()
+			if err := h.db.SaveCASettings(c.Request.Context(), stored); err != nil {
+				slog.ErrorContext(c.Request.Context(), "Failed to save ACME directories", "error", err)
+			}
+		}
+		settings["acme_directories"] = directories
+		settings["acme_provisioners"] = provisioners
+	} else {
+		slog.WarnContext(c.Request.Context(), "Failed to list CA provisioners", "error", err)
+		settings["acme_directories"] = stored.ACMEDirectories
+		settings["acme_provisioners_error"] = err.Error()
+	}
+
+	if info := h.stepClient.RootInfo(); info != nil {
+		status := "ok"
+		if info.Err != nil {
+			status = info.Err.Error()
+		}
+		settings["root_fingerprint"] = info.Fingerprint
+		settings["root"] = gin.H{
+			"fingerprint": info.Fingerprint,
+			"subject":     info.Subject,
+			"not_before":  info.NotBefore,
+			"not_after":   info.NotAfter,
+			"checked_at":  info.CheckedAt,
+			"status":      status,
+		}
 	}
 
 	c.JSON(http.StatusOK, settings)
 }
 
-// Health check endpoint  
+// Health check endpoint
 func (h *Handlers) Health(c *gin.Context) {
-	// NEW VERSION WITH ROOT FINGERPRINT SUPPORT
-	fmt.Println("=== HEALTH ENDPOINT HIT ===")
 	response := map[string]interface{}{
 		"status":    "healthy-NEW",
 		"timestamp": time.Now().Format(time.RFC3339),
@@ -0,0 +1,66 @@
+package api
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	errPickupNotFound  = errors.New("nothing is waiting for pickup")
+	errPickupForbidden = errors.New("item belongs to another user")
+)
+
+// pickupStore holds issued bundles and rendered downloads, including private
+// keys, in memory until their owner collects them. Nothing is written to disk
+// or the database.
+type pickupStore[T any] struct {
+	mu    sync.Mutex
+	items map[string]*pickupItem[T]
+}
+
+type pickupItem[T any] struct {
+	value   T
+	owner   string
+	expires time.Time
+}
+
+func newPickupStore[T any]() *pickupStore[T] {
+	return &pickupStore[T]{items: make(map[string]*pickupItem[T])}
+}
+
+func (p *pickupStore[T]) Put(id, owner string, value T, ttl time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.items[id] = &pickupItem[T]{value: value, owner: owner, expires: time.Now().Add(ttl)}
+}
+
+// Take returns the item and removes it, so each item is handed out once
+func (p *pickupStore[T]) Take(id, owner string) (T, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var zero T
+	item, ok := p.items[id]
+	if !ok || time.Now().After(item.expires) {
+		delete(p.items, id)
+		return zero, errPickupNotFound
+	}
+	if item.owner != owner {
+		return zero, errPickupForbidden
+	}
+
+	delete(p.items, id)
+	return item.value, nil
+}
+
+// Sweep drops items that were not collected in time
+func (p *pickupStore[T]) Sweep(now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for id, item := range p.items {
+		if now.After(item.expires) {
+			delete(p.items, id)
+		}
+	}
+}
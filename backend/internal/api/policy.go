@@ -0,0 +1,93 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"step-ca-webui/internal/auth"
+	"step-ca-webui/internal/db"
+	"step-ca-webui/internal/policy"
+	"step-ca-webui/internal/step"
+
+	"github.com/gin-gonic/gin"
+)
+
+type PolicyEvaluateRequest struct {
+	CN     string   `json:"cn"`
+	SANs   []string `json:"sans"`
+	CSRPEM string   `json:"csr_pem,omitempty"`
+	// Optional subject to evaluate for instead of the caller
+	User   string   `json:"user,omitempty"`
+	Roles  []string `json:"roles,omitempty"`
+	APIKey string   `json:"api_key,omitempty"`
+}
+
+// EvaluatePolicy runs the naming policy without issuing anything
+func (h *Handlers) EvaluatePolicy(c *gin.Context) {
+	var req PolicyEvaluateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	cn, sans := req.CN, req.SANs
+	if req.CSRPEM != "" {
+		csr, err := step.ParseCSR([]byte(req.CSRPEM))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		cn = csr.Subject.CommonName
+		sans = step.CSRSANs(csr)
+	}
+
+	subject := policySubject(auth.FromContext(c))
+	if req.User != "" || len(req.Roles) > 0 || req.APIKey != "" {
+		subject = policy.Subject{User: req.User, Roles: req.Roles, APIKey: req.APIKey}
+	}
+
+	result := h.policy.Evaluate(subject, append([]string{cn}, sans...))
+	c.JSON(http.StatusOK, gin.H{
+		"subject": subject,
+		"result":  result,
+	})
+}
+
+// enforcePolicy evaluates the names for the caller and writes a 403 response
+// if any of them is denied. It returns whether the request may proceed.
+func (h *Handlers) enforcePolicy(c *gin.Context, identity *auth.Identity, cn string, sans []string) bool {
+	result, err := h.checkPolicy(c.Request.Context(), identity, cn, sans)
+	if err != nil {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error":     err.Error(),
+			"decisions": result.Decisions,
+		})
+		return false
+	}
+	return true
+}
+
+// checkPolicy evaluates the names for the caller and records a denial in
+// the audit log
+func (h *Handlers) checkPolicy(ctx context.Context, identity *auth.Identity, cn string, sans []string) (*policy.Result, error) {
+	result := h.policy.Evaluate(policySubject(identity), append([]string{cn}, sans...))
+	if err := result.Error(); err != nil {
+		h.db.LogAuditEvent(ctx, &db.AuditEvent{
+			Who:       identity.User,
+			Action:    "policy_denied",
+			Details:   err.Error(),
+			Timestamp: time.Now(),
+		})
+		return result, err
+	}
+	return result, nil
+}
+
+func policySubject(identity *auth.Identity) policy.Subject {
+	return policy.Subject{
+		User:   identity.User,
+		Roles:  identity.Roles,
+		APIKey: identity.APIKey,
+	}
+}
@@ -0,0 +1,500 @@
+package api
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"step-ca-webui/internal/auth"
+	"step-ca-webui/internal/db"
+	"step-ca-webui/internal/step"
+
+	"github.com/gin-gonic/gin"
+)
+
+// provisionerNamePattern limits provisioner names to characters that need no
+// escaping in admin API paths
+var provisionerNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._@-]{0,127}$`)
+
+// ProvisionerRequest creates a provisioner, or changes one when sent to
+// PUT /api/provisioners/:name. Type and name cannot change; only the block
+// matching the type may be set.
+type ProvisionerRequest struct {
+	Type   string                   `json:"type"`
+	Name   string                   `json:"name"`
+	Claims *step.ProvisionerClaims  `json:"claims,omitempty"`
+	JWK    *JWKProvisionerRequest   `json:"jwk,omitempty"`
+	OIDC   *OIDCProvisionerRequest  `json:"oidc,omitempty"`
+	ACME   *ACMEProvisionerRequest  `json:"acme,omitempty"`
+	X5C    *X5CProvisionerRequest   `json:"x5c,omitempty"`
+	K8sSA  *K8sSAProvisionerRequest `json:"k8ssa,omitempty"`
+}
+
+// JWKProvisionerRequest either generates a key protected by Password or
+// brings an existing public JWK with its optional encrypted private key
+type JWKProvisionerRequest struct {
+	Password            string          `json:"password,omitempty"`
+	PublicKey           json.RawMessage `json:"public_key,omitempty"`
+	EncryptedPrivateKey string          `json:"encrypted_private_key,omitempty"` // JWE, compact or JSON
+}
+
+type OIDCProvisionerRequest struct {
+	ClientID              string   `json:"client_id"`
+	ClientSecret          string   `json:"client_secret,omitempty"`
+	ConfigurationEndpoint string   `json:"configuration_endpoint"`
+	Admins                []string `json:"admins,omitempty"`
+	Domains               []string `json:"domains,omitempty"`
+	Groups                []string `json:"groups,omitempty"`
+	ListenAddress         string   `json:"listen_address,omitempty"`
+	TenantID              string   `json:"tenant_id,omitempty"`
+}
+
+type ACMEProvisionerRequest struct {
+	ForceCN    *bool    `json:"force_cn,omitempty"`
+	RequireEAB *bool    `json:"require_eab,omitempty"`
+	Challenges []string `json:"challenges,omitempty"` // http-01, dns-01, tls-alpn-01, device-attest-01
+}
+
+type X5CProvisionerRequest struct {
+	Roots string `json:"roots"` // PEM certificates
+}
+
+type K8sSAProvisionerRequest struct {
+	PublicKeys string `json:"public_keys"` // PEM public keys or certificates
+}
+
+// ProvisionerResponse is a provisioner with its type specific settings,
+// secrets left out
+type ProvisionerResponse struct {
+	step.ProvisionerInfo
+	Details map[string]interface{} `json:"details"`
+}
+
+// spec validates the request and converts it for the step package. typ is
+// the type of the provisioner being changed, or empty on create.
+func (r *ProvisionerRequest) spec(typ string) (step.ProvisionerSpec, ValidationErrors) {
+	var errs ValidationErrors
+	create := typ == ""
+
+	if create {
+		typ = strings.ToUpper(r.Type)
+		if !step.IsManagedProvisionerType(typ) {
+			errs.Add("type", "must be one of JWK, OIDC, ACME, X5C or K8SSA")
+		}
+		if !provisionerNamePattern.MatchString(r.Name) {
+			errs.Add("name", "must start with a letter or digit and contain only letters, digits, '.', '_', '@' and '-'")
+		}
+	} else {
+		if r.Type != "" && !strings.EqualFold(r.Type, typ) {
+			errs.Add("type", "cannot be changed")
+		}
+		if r.Name != "" {
+			errs.Add("name", "cannot be changed")
+		}
+	}
+	spec := step.ProvisionerSpec{Type: typ, Name: r.Name, Claims: r.Claims}
+
+	if r.Claims != nil {
+		validateProvisionerClaims(r.Claims, &errs)
+	}
+
+	blocks := map[string]bool{
+		step.ProvisionerTypeJWK:   r.JWK != nil,
+		step.ProvisionerTypeOIDC:  r.OIDC != nil,
+		step.ProvisionerTypeACME:  r.ACME != nil,
+		step.ProvisionerTypeX5C:   r.X5C != nil,
+		step.ProvisionerTypeK8sSA: r.K8sSA != nil,
+	}
+	for blockType, set := range blocks {
+		if set && blockType != typ {
+			errs.Add(strings.ToLower(blockType), "only applies to %s provisioners", blockType)
+		}
+	}
+
+	switch typ {
+	case step.ProvisionerTypeJWK:
+		if r.JWK == nil {
+			if create {
+				errs.Add("jwk", "is required")
+			}
+			break
+		}
+		if (r.JWK.Password == "") == (len(r.JWK.PublicKey) == 0) {
+			errs.Add("jwk", "set either password or public_key")
+			break
+		}
+		if len(r.JWK.PublicKey) > 0 {
+			var jwk map[string]interface{}
+			if err := json.Unmarshal(r.JWK.PublicKey, &jwk); err != nil || jwk["kty"] == nil {
+				errs.Add("jwk.public_key", "must be a JWK object")
+			} else if jwk["d"] != nil {
+				errs.Add("jwk.public_key", "must not contain the private key")
+			}
+			spec.JWK = &step.JWKSpec{PublicKey: r.JWK.PublicKey}
+			if r.JWK.EncryptedPrivateKey != "" {
+				encrypted, err := step.CompactJWE([]byte(r.JWK.EncryptedPrivateKey))
+				if err != nil {
+					errs.Add("jwk.encrypted_private_key", "%v", err)
+				}
+				spec.JWK.EncryptedKey = encrypted
+			}
+		} else if r.JWK.EncryptedPrivateKey != "" {
+			errs.Add("jwk.encrypted_private_key", "only applies with public_key")
+		}
+	case step.ProvisionerTypeOIDC:
+		if r.OIDC == nil {
+			if create {
+				errs.Add("oidc", "is required")
+			}
+			break
+		}
+		if create && r.OIDC.ClientID == "" {
+			errs.Add("oidc.client_id", "is required")
+		}
+		if create && r.OIDC.ConfigurationEndpoint == "" {
+			errs.Add("oidc.configuration_endpoint", "is required")
+		} else if r.OIDC.ConfigurationEndpoint != "" {
+			if u, err := url.Parse(r.OIDC.ConfigurationEndpoint); err != nil || u.Scheme != "https" || u.Host == "" {
+				errs.Add("oidc.configuration_endpoint", "must be an https URL")
+			}
+		}
+		spec.OIDC = &step.OIDCSpec{
+			ClientID:              r.OIDC.ClientID,
+			ClientSecret:          r.OIDC.ClientSecret,
+			ConfigurationEndpoint: r.OIDC.ConfigurationEndpoint,
+			Admins:                r.OIDC.Admins,
+			Domains:               r.OIDC.Domains,
+			Groups:                r.OIDC.Groups,
+			ListenAddress:         r.OIDC.ListenAddress,
+			TenantID:              r.OIDC.TenantID,
+		}
+	case step.ProvisionerTypeACME:
+		if r.ACME == nil {
+			break
+		}
+		for i, challenge := range r.ACME.Challenges {
+			if !step.IsACMEChallenge(challenge) {
+				errs.Add(fmt.Sprintf("acme.challenges[%d]", i), "unknown challenge %q", challenge)
+			}
+		}
+		spec.ACME = &step.ACMESpec{
+			ForceCN:    r.ACME.ForceCN,
+			RequireEAB: r.ACME.RequireEAB,
+			Challenges: r.ACME.Challenges,
+		}
+	case step.ProvisionerTypeX5C:
+		if r.X5C == nil {
+			if create {
+				errs.Add("x5c", "is required")
+			}
+			break
+		}
+		if err := validatePEMBlocks([]byte(r.X5C.Roots), false); err != nil {
+			errs.Add("x5c.roots", "%v", err)
+		}
+		spec.X5C = &step.X5CSpec{Roots: []byte(r.X5C.Roots)}
+	case step.ProvisionerTypeK8sSA:
+		if r.K8sSA == nil {
+			if create {
+				errs.Add("k8ssa", "is required")
+			}
+			break
+		}
+		if err := validatePEMBlocks([]byte(r.K8sSA.PublicKeys), true); err != nil {
+			errs.Add("k8ssa.public_keys", "%v", err)
+		}
+		spec.K8sSA = &step.K8sSASpec{PublicKeys: []byte(r.K8sSA.PublicKeys)}
+	}
+
+	return spec, errs
+}
+
+// validateProvisionerClaims checks that the durations parse and are ordered
+// min <= default <= max where set
+func validateProvisionerClaims(claims *step.ProvisionerClaims, errs *ValidationErrors) {
+	fields := []struct {
+		name  string
+		value string
+	}{
+		{"claims.min_tls_duration", claims.MinTLSDuration},
+		{"claims.default_tls_duration", claims.DefaultTLSDuration},
+		{"claims.max_tls_duration", claims.MaxTLSDuration},
+	}
+	var parsed []time.Duration
+	for _, field := range fields {
+		if field.value == "" {
+			continue
+		}
+		d, err := time.ParseDuration(field.value)
+		if err != nil || d <= 0 {
+			errs.Add(field.name, "must be a positive Go duration such as 24h")
+			return
+		}
+		if len(parsed) > 0 && d < parsed[len(parsed)-1] {
+			errs.Add(field.name, "must not be shorter than the durations before it (min <= default <= max)")
+			return
+		}
+		parsed = append(parsed, d)
+	}
+}
+
+// validatePEMBlocks checks that data holds at least one certificate, or
+// with keys also public keys
+func validatePEMBlocks(data []byte, keys bool) error {
+	count := 0
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		switch {
+		case block.Type == "CERTIFICATE":
+			if _, err := x509.ParseCertificate(block.Bytes); err != nil {
+				return fmt.Errorf("invalid certificate: %w", err)
+			}
+		case keys && block.Type == "PUBLIC KEY":
+			if _, err := x509.ParsePKIXPublicKey(block.Bytes); err != nil {
+				return fmt.Errorf("invalid public key: %w", err)
+			}
+		case keys && block.Type == "RSA PUBLIC KEY":
+			if _, err := x509.ParsePKCS1PublicKey(block.Bytes); err != nil {
+				return fmt.Errorf("invalid public key: %w", err)
+			}
+		default:
+			return fmt.Errorf("unexpected PEM block %q", block.Type)
+		}
+		count++
+	}
+	if count == 0 {
+		return errors.New("must contain at least one PEM block")
+	}
+	return nil
+}
+
+// requireProvisionerAdmin writes an error response unless provisioner
+// management is configured and the caller is authenticated and holds one of
+// the admin roles. Without admin roles nobody may manage provisioners.
+func (h *Handlers) requireProvisionerAdmin(c *gin.Context) bool {
+	if h.admin == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Provisioner management is only available with STEP_ADMIN_CERT and STEP_ADMIN_KEY set"})
+		return false
+	}
+	if identity := auth.FromContext(c); identity.Authenticated() {
+		for _, role := range h.provisionerAdminRoles {
+			if identity.HasRole(role) {
+				return true
+			}
+		}
+	}
+	c.JSON(http.StatusForbidden, gin.H{"error": "Managing provisioners requires an authenticated caller with one of the roles: " + strings.Join(h.provisionerAdminRoles, ", ")})
+	return false
+}
+
+// respondCAError reports a failed admin API call, passing client errors of
+// the CA through and treating everything else as a bad gateway
+func respondCAError(c *gin.Context, action string, err error) {
+	status := http.StatusBadGateway
+	var caErr *step.CAError
+	if errors.As(err, &caErr) && caErr.StatusCode >= 400 && caErr.StatusCode < 500 {
+		status = caErr.StatusCode
+	}
+	c.JSON(status, gin.H{"error": fmt.Sprintf("Failed to %s: %v", action, err)})
+}
+
+func provisionerResponse(prov step.LinkedProvisioner) ProvisionerResponse {
+	return ProvisionerResponse{ProvisionerInfo: prov.Info(), Details: prov.Details()}
+}
+
+// provisionerAuditDetails describes a provisioner change for the audit log;
+// keys and secrets are never included
+func provisionerAuditDetails(info step.ProvisionerInfo) string {
+	details := fmt.Sprintf("Provisioner: %s, Type: %s", info.Name, info.Type)
+	if c := info.Claims; c.DefaultTLSDuration != "" || c.MinTLSDuration != "" || c.MaxTLSDuration != "" {
+		details += fmt.Sprintf(", TLS durations: min %s, default %s, max %s",
+			orDefault(c.MinTLSDuration), orDefault(c.DefaultTLSDuration), orDefault(c.MaxTLSDuration))
+	}
+	return details
+}
+
+func orDefault(value string) string {
+	if value == "" {
+		return "CA default"
+	}
+	return value
+}
+
+// ListProvisioners returns every provisioner of the CA with its claims
+func (h *Handlers) ListProvisioners(c *gin.Context) {
+	if !h.requireProvisionerAdmin(c) {
+		return
+	}
+	provisioners, err := h.admin.ListProvisioners(c.Request.Context())
+	if err != nil {
+		respondCAError(c, "list provisioners", err)
+		return
+	}
+	if provisioners == nil {
+		provisioners = []step.ProvisionerInfo{}
+	}
+	c.JSON(http.StatusOK, gin.H{"provisioners": provisioners})
+}
+
+// GetProvisioner returns a provisioner with its type specific settings
+func (h *Handlers) GetProvisioner(c *gin.Context) {
+	if !h.requireProvisionerAdmin(c) {
+		return
+	}
+	prov, err := h.admin.GetProvisioner(c.Request.Context(), c.Param("name"))
+	if err != nil {
+		respondCAError(c, "get provisioner", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"provisioner": provisionerResponse(prov)})
+}
+
+// CreateProvisioner adds a provisioner to the CA. JWK provisioners given a
+// password get a newly generated key.
+func (h *Handlers) CreateProvisioner(c *gin.Context) {
+	if !h.requireProvisionerAdmin(c) {
+		return
+	}
+	var req ProvisionerRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+	spec, errs := req.spec("")
+	if len(errs) > 0 {
+		respondValidation(c, errs)
+		return
+	}
+
+	if spec.Type == step.ProvisionerTypeJWK && req.JWK.Password != "" {
+		publicKey, encryptedKey, err := step.GenerateJWK(req.JWK.Password)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to generate provisioner key: %v", err)})
+			return
+		}
+		spec.JWK = &step.JWKSpec{PublicKey: publicKey, EncryptedKey: encryptedKey}
+	}
+
+	prov, err := step.NewLinkedProvisioner(spec)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	created, err := h.admin.CreateProvisioner(c.Request.Context(), prov)
+	if err != nil {
+		respondCAError(c, "create provisioner", err)
+		return
+	}
+
+	response := provisionerResponse(created)
+	h.db.LogAuditEvent(c.Request.Context(), &db.AuditEvent{
+		Who:       auth.FromContext(c).User,
+		Action:    "provisioner_created",
+		Details:   provisionerAuditDetails(response.ProvisionerInfo),
+		Timestamp: time.Now(),
+	})
+	c.JSON(http.StatusCreated, gin.H{"provisioner": response})
+}
+
+// UpdateProvisioner changes the claims or type specific settings of a
+// provisioner. Fields left out of the request keep their values.
+func (h *Handlers) UpdateProvisioner(c *gin.Context) {
+	if !h.requireProvisionerAdmin(c) {
+		return
+	}
+	var req ProvisionerRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+	ctx := c.Request.Context()
+	name := c.Param("name")
+
+	prov, err := h.admin.GetProvisioner(ctx, name)
+	if err != nil {
+		respondCAError(c, "get provisioner", err)
+		return
+	}
+	if !step.IsManagedProvisionerType(prov.Type()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Provisioners of type %s cannot be edited here", prov.Type())})
+		return
+	}
+	spec, errs := req.spec(prov.Type())
+	if len(errs) > 0 {
+		respondValidation(c, errs)
+		return
+	}
+	if spec.Type == step.ProvisionerTypeJWK && req.JWK != nil && req.JWK.Password != "" {
+		publicKey, encryptedKey, err := step.GenerateJWK(req.JWK.Password)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to generate provisioner key: %v", err)})
+			return
+		}
+		spec.JWK = &step.JWKSpec{PublicKey: publicKey, EncryptedKey: encryptedKey}
+	}
+
+	if err := prov.Apply(spec); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	updated, err := h.admin.UpdateProvisioner(ctx, name, prov)
+	if err != nil {
+		respondCAError(c, "update provisioner", err)
+		return
+	}
+
+	response := provisionerResponse(updated)
+	details := provisionerAuditDetails(response.ProvisionerInfo)
+	if spec.JWK != nil {
+		details += ", key replaced"
+	}
+	h.db.LogAuditEvent(c.Request.Context(), &db.AuditEvent{
+		Who:       auth.FromContext(c).User,
+		Action:    "provisioner_updated",
+		Details:   details,
+		Timestamp: time.Now(),
+	})
+	c.JSON(http.StatusOK, gin.H{"provisioner": response})
+}
+
+// DeleteProvisioner removes a provisioner from the CA. The provisioners this
+// service issues through cannot be removed.
+func (h *Handlers) DeleteProvisioner(c *gin.Context) {
+	if !h.requireProvisionerAdmin(c) {
+		return
+	}
+	name := c.Param("name")
+	if slices.Contains(h.issuingProvisioners(), name) {
+		c.JSON(http.StatusConflict, gin.H{"error": "This service issues certificates through this provisioner; it cannot be removed here"})
+		return
+	}
+	ctx := c.Request.Context()
+
+	prov, err := h.admin.GetProvisioner(ctx, name)
+	if err != nil {
+		respondCAError(c, "get provisioner", err)
+		return
+	}
+	if err := h.admin.DeleteProvisioner(ctx, name); err != nil {
+		respondCAError(c, "delete provisioner", err)
+		return
+	}
+
+	h.db.LogAuditEvent(c.Request.Context(), &db.AuditEvent{
+		Who:       auth.FromContext(c).User,
+		Action:    "provisioner_deleted",
+		Details:   provisionerAuditDetails(prov.Info()),
+		Timestamp: time.Now(),
+	})
+	c.JSON(http.StatusOK, gin.H{"message": "Provisioner deleted"})
+}
@@ -0,0 +1,57 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"step-ca-webui/internal/auth"
+	"step-ca-webui/internal/step"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRequireProvisionerAdmin(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	keys := []auth.APIKey{
+		{Name: "ops", Key: "ops-key", Roles: []string{"admin"}},
+		{Name: "ci", Key: "ci-key", Roles: []string{"deployer"}},
+	}
+
+	for _, tt := range []struct {
+		name    string
+		admin   *step.AdminClient
+		roles   []string
+		headers map[string]string
+		want    int
+	}{
+		{"not configured", nil, []string{"admin"}, map[string]string{"X-API-Key": "ops-key"}, http.StatusNotFound},
+		{"admin key", &step.AdminClient{}, []string{"admin"}, map[string]string{"X-API-Key": "ops-key"}, http.StatusNoContent},
+		{"admin group", &step.AdminClient{}, []string{"admin"}, map[string]string{"X-Forwarded-User": "alice", "X-Forwarded-Groups": "admin"}, http.StatusNoContent},
+		{"other role", &step.AdminClient{}, []string{"admin"}, map[string]string{"X-API-Key": "ci-key"}, http.StatusForbidden},
+		{"anonymous", &step.AdminClient{}, []string{"admin"}, nil, http.StatusForbidden},
+		{"bearer token only", &step.AdminClient{}, []string{"admin"}, map[string]string{"Authorization": "Bearer eyJ.test.token"}, http.StatusForbidden},
+		{"proxy as system", &step.AdminClient{}, []string{"admin"}, map[string]string{"X-Forwarded-User": "system", "X-Forwarded-Groups": "admin"}, http.StatusForbidden},
+		{"no admin roles", &step.AdminClient{}, nil, map[string]string{"X-API-Key": "ops-key"}, http.StatusForbidden},
+		{"no admin roles anonymous", &step.AdminClient{}, nil, nil, http.StatusForbidden},
+	} {
+		h := &Handlers{admin: tt.admin, provisionerAdminRoles: tt.roles}
+		r := gin.New()
+		r.Use(auth.Middleware(keys, true))
+		r.GET("/", func(c *gin.Context) {
+			if h.requireProvisionerAdmin(c) {
+				c.Status(http.StatusNoContent)
+			}
+		})
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		for k, v := range tt.headers {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != tt.want {
+			t.Errorf("%s: got %d, want %d", tt.name, w.Code, tt.want)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"step-ca-webui/internal/db"
+	"step-ca-webui/internal/step"
+	"step-ca-webui/internal/tracing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RevocationStatusResponse compares the inventory status of a certificate
+// with what the CA publishes about it
+type RevocationStatusResponse struct {
+	CertID          string                  `json:"cert_id"`
+	Serial          string                  `json:"serial"`
+	InventoryStatus string                  `json:"inventory_status"`
+	CAStatus        string                  `json:"ca_status"`            // good, revoked or unknown
+	Consistent      *bool                   `json:"consistent,omitempty"` // unset while the CA status is unknown
+	Reconciled      bool                    `json:"reconciled"`           // the inventory was marked revoked by this check
+	Checks          []step.RevocationStatus `json:"checks"`
+	CheckedAt       time.Time               `json:"checked_at"`
+}
+
+// caRevocationStatus combines the sources: any source reporting the
+// certificate revoked wins, otherwise one reporting it good
+func caRevocationStatus(checks []step.RevocationStatus) string {
+	status := step.RevocationUnknown
+	for _, check := range checks {
+		switch check.Status {
+		case step.RevocationRevoked:
+			return step.RevocationRevoked
+		case step.RevocationGood:
+			status = step.RevocationGood
+		}
+	}
+	return status
+}
+
+// markRevokedByCA records a revocation the CA published but the inventory
+// did not know about
+func (h *Handlers) markRevokedByCA(ctx context.Context, cert *db.Certificate, source string) error {
+	previous := cert.Status
+	cert.Status = "revoked"
+	cert.UpdatedAt = time.Now()
+	if err := h.db.UpdateCertificate(ctx, cert); err != nil {
+		return err
+	}
+	h.db.LogAuditEvent(ctx, &db.AuditEvent{
+		CertID:    cert.ID,
+		Who:       "ca",
+		Action:    "revocation_detected",
+		Details:   fmt.Sprintf("Serial: %s, Source: %s, Previous status: %s", cert.Serial, source, previous),
+		Timestamp: time.Now(),
+	})
+	return nil
+}
+
+// GetRevocationStatus checks a certificate against the CA's CRL and, when
+// configured, its OCSP responder. Revocations the inventory missed are
+// recorded.
+func (h *Handlers) GetRevocationStatus(c *gin.Context) {
+	cert, err := h.db.GetCertificate(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Certificate not found"})
+		return
+	}
+	if cert.CertType != "" && cert.CertType != "x509" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Revocation status is only published for X.509 certificates"})
+		return
+	}
+	serial, err := step.ParseSerial(cert.Serial)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Certificate has no serial number on record"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 30*time.Second)
+	defer cancel()
+
+	var checks []step.RevocationStatus
+	if crl, err := h.stepClient.CRL(ctx); err != nil {
+		checks = append(checks, step.RevocationStatus{
+			Source: "crl",
+			URL:    h.stepClient.CRLURL(),
+			Status: step.RevocationUnknown,
+			Error:  err.Error(),
+		})
+	} else {
+		checks = append(checks, h.stepClient.CRLStatus(crl, serial))
+	}
+	if h.stepClient.OCSPURL != "" {
+		checks = append(checks, h.stepClient.OCSPStatus(ctx, h.stepClient.OCSPURL, serial))
+	}
+
+	response := RevocationStatusResponse{
+		CertID:    cert.ID,
+		Serial:    cert.Serial,
+		CAStatus:  caRevocationStatus(checks),
+		Checks:    checks,
+		CheckedAt: time.Now(),
+	}
+	if response.CAStatus == step.RevocationRevoked && cert.Status != "revoked" {
+		source := ""
+		for _, check := range checks {
+			if check.Status == step.RevocationRevoked {
+				source = check.Source
+				break
+			}
+		}
+		if err := h.markRevokedByCA(ctx, cert, source); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update certificate"})
+			return
+		}
+		response.Reconciled = true
+	}
+	response.InventoryStatus = cert.Status
+	if response.CAStatus != step.RevocationUnknown {
+		consistent := (cert.Status == "revoked") == (response.CAStatus == step.RevocationRevoked)
+		response.Consistent = &consistent
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// SyncRevocations marks certificates the CA's CRL lists as revoked
+func (h *Handlers) SyncRevocations() (err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	ctx, span := tracing.Start(ctx, "SyncRevocations")
+	defer func() { tracing.End(span, err) }()
+
+	crl, err := h.stepClient.CRL(ctx)
+	if err != nil {
+		return err
+	}
+	if len(crl.RevokedCertificateEntries) == 0 {
+		return nil
+	}
+
+	bySerial := map[string]*db.Certificate{}
+	for _, status := range []string{"active", "expired"} {
+		certs, err := h.db.ListCertificates(ctx, 0, 0, status, "x509", "")
+		if err != nil {
+			return err
+		}
+		// Serials are recorded as hex in whatever padding and case their
+		// source used, so they are compared as numbers
+		for i := range certs {
+			if serial, err := step.ParseSerial(certs[i].Serial); err == nil {
+				bySerial[serial.String()] = &certs[i]
+			}
+		}
+	}
+
+	reconciled := 0
+	for _, entry := range crl.RevokedCertificateEntries {
+		cert, ok := bySerial[entry.SerialNumber.String()]
+		if !ok {
+			continue
+		}
+		if err := h.markRevokedByCA(ctx, cert, "crl"); err != nil {
+			return err
+		}
+		reconciled++
+	}
+	if reconciled > 0 {
+		slog.Info("Marked certificates revoked from the CA's CRL", "count", reconciled)
+	}
+	return nil
+}
+
+// RunRevocationSync calls SyncRevocations on every tick of interval
+func (h *Handlers) RunRevocationSync(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := h.SyncRevocations(); err != nil {
+			slog.Error("Revocation sync failed", "error", err)
+		}
+	}
+}
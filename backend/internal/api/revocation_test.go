@@ -0,0 +1,90 @@
+package api
+
+import (
+	"context"
+	"crypto/x509"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"step-ca-webui/internal/db"
+	"step-ca-webui/internal/step"
+)
+
+func TestSyncRevocations(t *testing.T) {
+	ca := newFakeCA(t)
+	ca.revoked = []*big.Int{big.NewInt(0x0a1b2c), big.NewInt(0xff01), big.NewInt(0x42)}
+	h, database := newTestHandlers(t, ca)
+	ctx := context.Background()
+
+	for _, cert := range []db.Certificate{
+		// openssl x509 -serial pads to whole bytes
+		{ID: "padded", Serial: "0A1B2C", Status: "active"},
+		{ID: "lower", Serial: "ff01", Status: "expired"},
+		{ID: "formatted", Serial: "42", Status: "active"},
+		{ID: "valid", Serial: "0A1B2D", Status: "active"},
+		{ID: "invalid", Serial: "not hex", Status: "active"},
+		{ID: "ssh", CertType: "ssh-user", Serial: "42", Status: "active"},
+	} {
+		cert := cert
+		if cert.CertType == "" {
+			cert.CertType = "x509"
+		}
+		cert.NotAfter = time.Now().Add(time.Hour)
+		if err := database.CreateCertificate(ctx, &cert); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := h.SyncRevocations(); err != nil {
+		t.Fatal(err)
+	}
+	for id, want := range map[string]string{
+		"padded":    "revoked",
+		"lower":     "revoked",
+		"formatted": "revoked",
+		"valid":     "active",
+		"invalid":   "active",
+		"ssh":       "active",
+	} {
+		cert, err := database.GetCertificate(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cert.Status != want {
+			t.Errorf("%s certificate is %s, want %s", id, cert.Status, want)
+		}
+	}
+
+	events, err := database.GetAuditEvents(ctx, "padded", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Action != "revocation_detected" {
+		t.Errorf("padded certificate has audit events %+v", events)
+	}
+}
+
+func TestOCSPStatusIssuingIntermediate(t *testing.T) {
+	ca := newFakeCA(t)
+	ca.revoked = []*big.Int{big.NewInt(0x42)}
+
+	// Certificates are signed by an intermediate below another one, which
+	// the CA lists first
+	issuing, issuingKey := testCert(t, caTemplate(4, "Fake Issuing CA"), ca.intermediate, ca.intermediateKey)
+	ca.intermediates = []*x509.Certificate{ca.intermediate, issuing}
+	ca.intermediate, ca.intermediateKey = issuing, issuingKey
+
+	responder := httptest.NewServer(http.HandlerFunc(ca.ocsp))
+	defer responder.Close()
+	h, _ := newTestHandlers(t, ca)
+
+	for serial, want := range map[int64]string{0x42: step.RevocationRevoked, 0x43: step.RevocationGood} {
+		status := h.stepClient.OCSPStatus(context.Background(), responder.URL, big.NewInt(serial))
+		if status.Status != want || status.Error != "" {
+			t.Errorf("serial %x is %s (%s), want %s", serial, status.Status, status.Error, want)
+		}
+	}
+}
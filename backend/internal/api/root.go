@@ -0,0 +1,67 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"step-ca-webui/internal/auth"
+	"step-ca-webui/internal/db"
+	"step-ca-webui/internal/step"
+	"step-ca-webui/internal/tracing"
+)
+
+// RefreshRoot pins the CA root on first use and re-checks it afterwards,
+// storing the result in the CA settings
+func (h *Handlers) RefreshRoot() (err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	ctx, span := tracing.Start(ctx, "RefreshRoot")
+	defer func() { tracing.End(span, err) }()
+
+	settings, err := h.db.GetCASettings(ctx)
+	if err != nil {
+		settings = &db.CASettings{CreatedAt: time.Now()}
+	}
+
+	if previous := h.stepClient.RootInfo(); previous == nil {
+		if _, err := h.stepClient.LoadRoot(ctx, settings.RootFingerprint); err != nil {
+			return err
+		}
+	} else if err := h.stepClient.CheckRoot(ctx); err != nil {
+		// Record the change once rather than on every check
+		if errors.Is(err, step.ErrRootChanged) && previous.Err == nil {
+			h.db.LogAuditEvent(ctx, &db.AuditEvent{
+				Who:       auth.Anonymous.User,
+				Action:    "root_changed",
+				Details:   fmt.Sprintf("CA no longer serves pinned root %s, issuance disabled", previous.Fingerprint),
+				Timestamp: time.Now(),
+			})
+		}
+		return err
+	}
+
+	info := h.stepClient.RootInfo()
+	settings.CAURL = h.stepClient.CAURL
+	settings.RootFingerprint = info.Fingerprint
+	settings.RootSubject = info.Subject
+	settings.RootNotBefore = info.NotBefore
+	settings.RootNotAfter = info.NotAfter
+	settings.RootPEM = string(info.PEM)
+	settings.RootCheckedAt = info.CheckedAt
+	settings.UpdatedAt = time.Now()
+	return h.db.SaveCASettings(ctx, settings)
+}
+
+// RunRootCheck calls RefreshRoot on every tick of interval
+func (h *Handlers) RunRootCheck(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := h.RefreshRoot(); err != nil {
+			slog.Error("CA root check failed", "error", err)
+		}
+	}
+}
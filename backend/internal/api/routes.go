@@ -8,6 +8,25 @@ func SetupRoutes(r *gin.Engine, handlers *Handlers) {
 	// Health check
 	r.GET("/health", handlers.Health)
 
+	// Trust distribution, no credentials required
+	trust := r.Group("/trust")
+	{
+		for _, file := range []string{"root.pem", "root.crt", "root.der", "root.cer"} {
+			trust.GET("/"+file, handlers.TrustRoot)
+		}
+		for _, file := range []string{"intermediates.pem", "intermediate.pem", "intermediate.crt", "intermediate.der", "intermediate.cer"} {
+			trust.GET("/"+file, handlers.TrustIntermediate)
+		}
+		trust.GET("/bundle.pem", handlers.TrustBundle)
+		trust.GET("/bundle.p7b", handlers.TrustBundle)
+		trust.GET("/install/:script", handlers.TrustInstallScript)
+		trust.GET("/ssh_user_ca.pub", handlers.TrustSSHUserCA)
+		trust.GET("/ssh_known_hosts", handlers.TrustSSHKnownHosts)
+	}
+
+	// CRL of certificates revoked through the backend, no credentials required
+	r.GET("/crl/:file", handlers.ServeCRL)
+
 	// API routes
 	api := r.Group("/api")
 	{
@@ -18,6 +37,45 @@ func SetupRoutes(r *gin.Engine, handlers *Handlers) {
 		api.GET("/certs/:id", handlers.GetCertificate)
 		api.POST("/certs/:id/renew", handlers.RenewCertificate)
 		api.POST("/certs/:id/revoke", handlers.RevokeCertificate)
+		api.GET("/certs/:id/revocation-status", handlers.GetRevocationStatus)
+
+		// SSH certificates
+		api.POST("/ssh/sign", handlers.SignSSH)
+		api.GET("/ssh/roots", handlers.GetSSHRoots)
+
+		// ACME accounts and orders from the CA database
+		api.GET("/acme/accounts", handlers.ListACMEAccounts)
+		api.GET("/acme/accounts/:id/orders", handlers.ListACMEOrders)
+
+		// Provisioners, through the CA admin API
+		api.GET("/provisioners", handlers.ListProvisioners)
+		api.POST("/provisioners", handlers.CreateProvisioner)
+		api.GET("/provisioners/:name", handlers.GetProvisioner)
+		api.PUT("/provisioners/:name", handlers.UpdateProvisioner)
+		api.DELETE("/provisioners/:name", handlers.DeleteProvisioner)
+
+		// Deployment targets
+		api.GET("/certs/:id/targets", handlers.ListTargets)
+		api.POST("/certs/:id/targets", handlers.CreateTarget)
+		api.PUT("/certs/:id/targets/:targetId", handlers.UpdateTarget)
+		api.DELETE("/certs/:id/targets/:targetId", handlers.DeleteTarget)
+		api.GET("/certs/:id/deployments", handlers.ListDeployments)
+
+		// One-time downloads of rendered bundles
+		api.GET("/downloads/:token", handlers.Download)
+
+		// Approval workflow
+		api.GET("/approvals", handlers.ListApprovals)
+		api.GET("/approvals/:id", handlers.GetApproval)
+		api.POST("/approvals/:id/approve", handlers.ApproveRequest)
+		api.POST("/approvals/:id/reject", handlers.RejectRequest)
+		api.POST("/approvals/:id/download", handlers.DownloadApproved)
+
+		// Naming policy
+		api.POST("/policy/evaluate", handlers.EvaluatePolicy)
+
+		// Issuance profiles
+		api.GET("/profiles", handlers.ListProfiles)
 
 		// Settings
 		api.GET("/settings/ca", handlers.GetCASettings)
@@ -0,0 +1,345 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"step-ca-webui/internal/auth"
+	"step-ca-webui/internal/config"
+	"step-ca-webui/internal/db"
+	"step-ca-webui/internal/metrics"
+	"step-ca-webui/internal/step"
+	"step-ca-webui/internal/storage"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/ssh"
+)
+
+// Inventory types of SSH certificates
+const (
+	certTypeSSHUser = "ssh-user"
+	certTypeSSHHost = "ssh-host"
+)
+
+// Lifetimes used when an SSH request sets none
+const (
+	defaultSSHUserLifetime = 16 * time.Hour
+	defaultSSHHostLifetime = 30 * 24 * time.Hour
+)
+
+// sshExtensions are the extensions OpenSSH understands; they carry no value
+var sshExtensions = map[string]bool{
+	"no-touch-required":       true,
+	"permit-X11-forwarding":   true,
+	"permit-agent-forwarding": true,
+	"permit-port-forwarding":  true,
+	"permit-pty":              true,
+	"permit-user-rc":          true,
+}
+
+var validPrincipal = regexp.MustCompile(`^[^\s,]+$`)
+
+// SSHSignRequest asks for an SSH certificate for a public key held by the
+// caller. Critical options and extensions apply to user certificates only;
+// leaving extensions out keeps the defaults of the CA.
+type SSHSignRequest struct {
+	PublicKey       string            `json:"public_key" binding:"required"` // authorized_keys format
+	CertType        string            `json:"cert_type" binding:"required"`  // user, host
+	KeyID           string            `json:"key_id,omitempty"`              // defaults to the first principal
+	Principals      []string          `json:"principals" binding:"required"`
+	ValidFor        string            `json:"valid_for,omitempty"`  // duration such as 16h or 30d
+	NotBefore       *time.Time        `json:"not_before,omitempty"` // RFC 3339, may be backdated
+	NotAfter        *time.Time        `json:"not_after,omitempty"`  // RFC 3339, instead of valid_for
+	CriticalOptions map[string]string `json:"critical_options,omitempty"`
+	Extensions      map[string]string `json:"extensions,omitempty"`
+}
+
+func isSSHCertType(certType string) bool {
+	return certType == certTypeSSHUser || certType == certTypeSSHHost
+}
+
+// prepareSSH validates an SSH request and resolves the signing options
+func (h *Handlers) prepareSSH(req *SSHSignRequest) (step.SSHSignOptions, ValidationErrors) {
+	var errs ValidationErrors
+	opts := step.SSHSignOptions{
+		CertType:        req.CertType,
+		KeyID:           req.KeyID,
+		Principals:      req.Principals,
+		CriticalOptions: req.CriticalOptions,
+		Extensions:      req.Extensions,
+	}
+
+	key, err := step.ParseSSHPublicKey(req.PublicKey)
+	if err != nil {
+		errs.Add("public_key", "%v", err)
+	}
+	opts.PublicKey = key
+
+	lifetime := defaultSSHUserLifetime
+	switch req.CertType {
+	case step.SSHUserCert:
+	case step.SSHHostCert:
+		lifetime = defaultSSHHostLifetime
+		if len(req.CriticalOptions) > 0 {
+			errs.Add("critical_options", "only apply to user certificates")
+		}
+		if req.Extensions != nil {
+			errs.Add("extensions", "only apply to user certificates")
+		}
+	default:
+		errs.Add("cert_type", "must be user or host")
+	}
+
+	if len(req.Principals) == 0 {
+		errs.Add("principals", "at least one principal is required")
+	}
+	for i, principal := range req.Principals {
+		if !validPrincipal.MatchString(principal) {
+			errs.Add(fmt.Sprintf("principals[%d]", i), "must be non-empty without spaces or commas")
+		}
+	}
+	if opts.KeyID == "" && len(req.Principals) > 0 {
+		opts.KeyID = req.Principals[0]
+	}
+
+	for name, value := range req.CriticalOptions {
+		field := "critical_options." + name
+		switch name {
+		case "force-command":
+			if value == "" {
+				errs.Add(field, "must name a command")
+			}
+		case "source-address":
+			for _, addr := range strings.Split(value, ",") {
+				if _, _, err := net.ParseCIDR(addr); err != nil && net.ParseIP(addr) == nil {
+					errs.Add(field, "%q is not an address or CIDR block", addr)
+				}
+			}
+		case "verify-required":
+			if value != "" {
+				errs.Add(field, "takes no value")
+			}
+		default:
+			if !strings.Contains(name, "@") {
+				errs.Add(field, "unknown critical option; vendor options must be named name@domain")
+			}
+		}
+	}
+	for name, value := range req.Extensions {
+		field := "extensions." + name
+		if sshExtensions[name] {
+			if value != "" {
+				errs.Add(field, "takes no value")
+			}
+		} else if !strings.Contains(name, "@") {
+			errs.Add(field, "unknown extension; vendor extensions must be named name@domain")
+		}
+	}
+
+	// Resolve the validity window within the global lifetime limits
+	limits := h.profiles.Limits(nil)
+	now := time.Now()
+	start := now
+	if req.NotBefore != nil {
+		start = *req.NotBefore
+		opts.ValidAfter = start
+		if limits.MaxBackdate > 0 && start.Before(now.Add(-limits.MaxBackdate)) {
+			errs.Add("not_before", "cannot be backdated by more than %s", formatDuration(limits.MaxBackdate))
+		}
+	}
+	switch {
+	case req.NotAfter != nil && req.ValidFor != "":
+		errs.Add("not_after", "cannot be combined with valid_for")
+	case req.NotAfter != nil:
+		opts.ValidBefore = *req.NotAfter
+	case req.ValidFor != "":
+		d, err := config.ParseDuration(req.ValidFor)
+		if err != nil || d <= 0 {
+			errs.Add("valid_for", "must be a positive duration such as 16h or 30d")
+		}
+		opts.ValidBefore = start.Add(d)
+	default:
+		if limits.Max > 0 && lifetime > limits.Max {
+			lifetime = limits.Max
+		}
+		opts.ValidBefore = start.Add(lifetime)
+	}
+	if len(errs) == 0 && !opts.ValidBefore.After(start) {
+		errs.Add("not_after", "must be after not_before")
+	}
+	if len(errs) == 0 && !opts.ValidBefore.After(now) {
+		errs.Add("not_after", "must be in the future")
+	}
+	if len(errs) == 0 {
+		field := "valid_for"
+		if req.NotAfter != nil {
+			field = "not_after"
+		}
+		lifetime := opts.ValidBefore.Sub(start)
+		if limits.Min > 0 && lifetime < limits.Min {
+			errs.Add(field, "lifetime %s is below the minimum of %s", formatDuration(lifetime), formatDuration(limits.Min))
+		}
+		if limits.Max > 0 && lifetime > limits.Max {
+			errs.Add(field, "lifetime %s exceeds the maximum of %s", formatDuration(lifetime), formatDuration(limits.Max))
+		}
+	}
+
+	return opts, errs
+}
+
+// SignSSH signs an SSH user or host certificate and records it in the
+// inventory
+func (h *Handlers) SignSSH(c *gin.Context) {
+	var req SSHSignRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+
+	opts, errs := h.prepareSSH(&req)
+	if len(errs) > 0 {
+		respondValidation(c, errs)
+		return
+	}
+
+	// Host principals are host names and fall under the naming policy
+	identity := auth.FromContext(c)
+	if opts.CertType == step.SSHHostCert && !h.enforcePolicy(c, identity, opts.Principals[0], opts.Principals[1:]) {
+		return
+	}
+
+	if !h.requireIDToken(c, identity, nil) {
+		return
+	}
+	opts.Auth.IDToken = identity.IDToken
+	start := time.Now()
+	sshCert, err := h.stepClient.SignSSH(c.Request.Context(), opts)
+	metrics.ObserveOperation(metrics.OpSignSSH, start, err)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to sign SSH certificate: %v", err)})
+		return
+	}
+
+	principalsJSON, _ := json.Marshal(sshCert.ValidPrincipals)
+	certType := certTypeSSHUser
+	if sshCert.CertType == ssh.HostCert {
+		certType = certTypeSSHHost
+	}
+	cert := &db.Certificate{
+		ID:          uuid.New().String(),
+		CertType:    certType,
+		CN:          sshCert.KeyId,
+		SANs:        string(principalsJSON),
+		Serial:      strconv.FormatUint(sshCert.Serial, 10),
+		NotAfter:    time.Unix(int64(sshCert.ValidBefore), 0),
+		Status:      "active",
+		KeyStrategy: "public_key",
+		KeyType:     sshCert.Key.Type(),
+		StorageRef:  storage.TypeEphemeral,
+		OwnerUser:   identity.User,
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
+	}
+	if err := h.db.CreateCertificate(c.Request.Context(), cert); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to store certificate metadata: %v", err)})
+		return
+	}
+
+	h.db.LogAuditEvent(c.Request.Context(), &db.AuditEvent{
+		CertID:    cert.ID,
+		Who:       identity.User,
+		Action:    "ssh_signed",
+		Details:   fmt.Sprintf("Type: %s, Key ID: %s, Principals: %v, Serial: %s", opts.CertType, sshCert.KeyId, sshCert.ValidPrincipals, cert.Serial),
+		Timestamp: time.Now(),
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"certificate":     certResponse(cert),
+		"ssh_certificate": step.MarshalSSHCertificate(sshCert),
+		"valid_after":     time.Unix(int64(sshCert.ValidAfter), 0).UTC(),
+		"valid_before":    time.Unix(int64(sshCert.ValidBefore), 0).UTC(),
+	})
+}
+
+// sshRoots returns the SSH CA keys, or writes an error response when the CA
+// has SSH disabled or cannot be reached
+func (h *Handlers) sshRoots(c *gin.Context) (*step.SSHRoots, bool) {
+	roots, err := h.stepClient.SSHRoots(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": fmt.Sprintf("SSH CA keys unavailable: %v", err)})
+		return nil, false
+	}
+	return roots, true
+}
+
+func authorizedKeys(keys []ssh.PublicKey) []string {
+	lines := make([]string, 0, len(keys))
+	for _, key := range keys {
+		lines = append(lines, strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key))))
+	}
+	return lines
+}
+
+// knownHostsLines marks each host CA key as trusted for the host pattern
+func knownHostsLines(keys []ssh.PublicKey, hosts string) []string {
+	var lines []string
+	for _, key := range authorizedKeys(keys) {
+		lines = append(lines, "@cert-authority "+hosts+" "+key)
+	}
+	return lines
+}
+
+var validHostPattern = regexp.MustCompile(`^[A-Za-z0-9.*?!\[\]:,_-]+$`)
+
+// GetSSHRoots returns the SSH user and host CA keys together with ready
+// made known_hosts lines
+func (h *Handlers) GetSSHRoots(c *gin.Context) {
+	roots, ok := h.sshRoots(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"user_ca_keys": authorizedKeys(roots.UserKeys),
+		"host_ca_keys": authorizedKeys(roots.HostKeys),
+		"known_hosts":  knownHostsLines(roots.HostKeys, "*"),
+	})
+}
+
+// TrustSSHUserCA serves the user CA keys for sshd's TrustedUserCAKeys
+func (h *Handlers) TrustSSHUserCA(c *gin.Context) {
+	roots, ok := h.sshRoots(c)
+	if !ok {
+		return
+	}
+	if len(roots.UserKeys) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "CA has no SSH user key"})
+		return
+	}
+	sendTrustFile(c, "ssh_user_ca.pub", "text/plain", []byte(strings.Join(authorizedKeys(roots.UserKeys), "\n")+"\n"))
+}
+
+// TrustSSHKnownHosts serves @cert-authority lines for the host CA keys. The
+// hosts query parameter limits them to a pattern such as *.example.com.
+func (h *Handlers) TrustSSHKnownHosts(c *gin.Context) {
+	hosts := c.DefaultQuery("hosts", "*")
+	if !validHostPattern.MatchString(hosts) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "hosts must be a known_hosts pattern such as *.example.com"})
+		return
+	}
+
+	roots, ok := h.sshRoots(c)
+	if !ok {
+		return
+	}
+	if len(roots.HostKeys) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "CA has no SSH host key"})
+		return
+	}
+	sendTrustFile(c, "ssh_known_hosts", "text/plain", []byte(strings.Join(knownHostsLines(roots.HostKeys, hosts), "\n")+"\n"))
+}
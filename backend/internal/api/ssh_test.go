@@ -0,0 +1,69 @@
+package api
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"step-ca-webui/internal/profile"
+	"step-ca-webui/internal/step"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestPrepareSSHLifetime(t *testing.T) {
+	h, _ := newTestHandlers(t, newFakeCA(t))
+	profiles, err := profile.Load("", profile.Limits{Min: 5 * time.Minute, Max: 7 * 24 * time.Hour, MaxBackdate: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.profiles = profiles
+
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sshKey, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKey := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(sshKey)))
+	farFuture := time.Now().Add(365 * 24 * time.Hour)
+	backdated := time.Now().Add(-2 * time.Hour)
+
+	for _, tt := range []struct {
+		name     string
+		req      SSHSignRequest
+		field    string
+		lifetime time.Duration
+	}{
+		{name: "user default", req: SSHSignRequest{CertType: step.SSHUserCert}, lifetime: defaultSSHUserLifetime},
+		{name: "host default capped", req: SSHSignRequest{CertType: step.SSHHostCert}, lifetime: 7 * 24 * time.Hour},
+		{name: "valid_for within limits", req: SSHSignRequest{CertType: step.SSHUserCert, ValidFor: "7d"}, lifetime: 7 * 24 * time.Hour},
+		{name: "valid_for too long", req: SSHSignRequest{CertType: step.SSHUserCert, ValidFor: "3650d"}, field: "valid_for"},
+		{name: "valid_for too short", req: SSHSignRequest{CertType: step.SSHUserCert, ValidFor: "1m"}, field: "valid_for"},
+		{name: "not_after too late", req: SSHSignRequest{CertType: step.SSHHostCert, NotAfter: &farFuture}, field: "not_after"},
+		{name: "backdated too far", req: SSHSignRequest{CertType: step.SSHUserCert, NotBefore: &backdated}, field: "not_before"},
+	} {
+		req := tt.req
+		req.PublicKey = publicKey
+		req.Principals = []string{"alice"}
+		opts, errs := h.prepareSSH(&req)
+
+		if tt.field != "" {
+			if len(errs) != 1 || errs[0].Field != tt.field {
+				t.Errorf("%s: got errors %+v, want one for %s", tt.name, errs, tt.field)
+			}
+			continue
+		}
+		if len(errs) > 0 {
+			t.Errorf("%s: %+v", tt.name, errs)
+			continue
+		}
+		if got := time.Until(opts.ValidBefore); got > tt.lifetime || got < tt.lifetime-time.Minute {
+			t.Errorf("%s: certificate is valid for %s, want %s", tt.name, got, tt.lifetime)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"step-ca-webui/internal/db"
+	"step-ca-webui/internal/step"
+	"step-ca-webui/internal/storage"
+)
+
+// storeBundle hands a freshly issued bundle to the storage backend and
+// records the returned reference as the StorageRef of the certificate. The
+// certificate is already issued, so a failure is audited rather than
+// returned and the previous reference is kept. Storing goes on if the client
+// goes away.
+func (h *Handlers) storeBundle(ctx context.Context, who string, cert *db.Certificate, bundle *step.CertBundle) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), time.Minute)
+	defer cancel()
+
+	ref, err := h.storage.Store(ctx, cert.ID, bundleFiles(bundle))
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to store certificate", "cert_id", cert.ID, "error", err)
+		h.db.LogAuditEvent(ctx, &db.AuditEvent{
+			CertID:    cert.ID,
+			Who:       who,
+			Action:    "storage_failed",
+			Details:   fmt.Sprintf("Serial: %s, Error: %v", bundle.Serial, err),
+			Timestamp: time.Now(),
+		})
+		return
+	}
+
+	if ref != cert.StorageRef {
+		cert.StorageRef = ref
+		if err := h.db.UpdateCertificate(ctx, cert); err != nil {
+			slog.ErrorContext(ctx, "Failed to record storage reference", "cert_id", cert.ID, "error", err)
+		}
+	}
+	if ref != storage.TypeEphemeral {
+		h.db.LogAuditEvent(ctx, &db.AuditEvent{
+			CertID:    cert.ID,
+			Who:       who,
+			Action:    "stored",
+			Details:   fmt.Sprintf("Serial: %s, Ref: %s", bundle.Serial, ref),
+			Timestamp: time.Now(),
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"step-ca-webui/internal/tracing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestIssueTracing(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider, err := tracing.NewProvider(context.Background(), exporter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous, previousPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previous)
+		otel.SetTextMapPropagator(previousPropagator)
+		provider.Shutdown(context.Background())
+	})
+
+	r := newTestRouter(t, newFakeCA(t), tracing.Middleware())
+
+	req := httptest.NewRequest(http.MethodPost, "/api/certs/issue", strings.NewReader(`{"cn": "web.example.com", "sans": ["web.example.com"], "not_after_days": 1}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer eyJ.test.token")
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("issue returned %d: %s", w.Code, w.Body)
+	}
+
+	if err := provider.ForceFlush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	spans := map[string]tracetest.SpanStub{}
+	for _, span := range exporter.GetSpans() {
+		spans[span.Name] = span
+	}
+	get := func(name string) tracetest.SpanStub {
+		t.Helper()
+		span, ok := spans[name]
+		if !ok {
+			names := make([]string, 0, len(spans))
+			for n := range spans {
+				names = append(names, n)
+			}
+			t.Fatalf("no span %q, got %v", name, names)
+		}
+		return span
+	}
+
+	server := get("POST /api/certs/issue")
+	if server.SpanKind != trace.SpanKindServer {
+		t.Errorf("request span kind is %s", server.SpanKind)
+	}
+	// The request continues the trace of its traceparent header
+	if got := server.SpanContext.TraceID().String(); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("request span has trace ID %s", got)
+	}
+	if got := server.Parent.SpanID().String(); got != "00f067aa0ba902b7" {
+		t.Errorf("request span has parent %s", got)
+	}
+
+	issue := get("StepClient.IssueCertificate")
+	for _, tt := range []struct {
+		name   string
+		parent tracetest.SpanStub
+		kind   trace.SpanKind
+	}{
+		{"StepClient.IssueCertificate", server, trace.SpanKindInternal},
+		{"GenerateKey", issue, trace.SpanKindInternal},
+		{"POST /1.0/sign", issue, trace.SpanKindClient},
+		{"Database.CreateCertificate", server, trace.SpanKindClient},
+		{"Database.LogAuditEvent", server, trace.SpanKindClient},
+	} {
+		span := get(tt.name)
+		if span.Parent.SpanID() != tt.parent.SpanContext.SpanID() {
+			t.Errorf("%s has parent %s, want %s", tt.name, span.Parent.SpanID(), tt.parent.Name)
+		}
+		if span.SpanKind != tt.kind {
+			t.Errorf("%s has kind %s, want %s", tt.name, span.SpanKind, tt.kind)
+		}
+		if span.SpanContext.TraceID() != server.SpanContext.TraceID() {
+			t.Errorf("%s is in another trace", tt.name)
+		}
+	}
+
+	for _, span := range exporter.GetSpans() {
+		if span.Status.Code == codes.Error {
+			t.Errorf("%s failed: %s", span.Name, span.Status.Description)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package api
+
+import (
+	"bytes"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"step-ca-webui/internal/certfmt"
+	"step-ca-webui/internal/step"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Trust endpoints serve the pinned root and the CA intermediates so clients
+// can install them. They need no credentials; everything served is public.
+
+// trustCerts returns the pinned root and the intermediates, or writes an
+// error response when the root is unavailable
+func (h *Handlers) trustCerts(c *gin.Context) (*x509.Certificate, []*x509.Certificate, bool) {
+	root, err := h.stepClient.Root(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": fmt.Sprintf("CA root unavailable: %v", err)})
+		return nil, nil, false
+	}
+	intermediates, err := h.stepClient.Intermediates(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": fmt.Sprintf("CA intermediates unavailable: %v", err)})
+		return nil, nil, false
+	}
+	return root, intermediates, true
+}
+
+func sendTrustFile(c *gin.Context, filename, contentType string, data []byte) {
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	c.Header("Cache-Control", "public, max-age=300")
+	c.Data(http.StatusOK, contentType, data)
+}
+
+// TrustRoot serves the root as root.pem or root.der
+func (h *Handlers) TrustRoot(c *gin.Context) {
+	root, _, ok := h.trustCerts(c)
+	if !ok {
+		return
+	}
+
+	switch file := path.Base(c.FullPath()); file {
+	case "root.pem", "root.crt":
+		sendTrustFile(c, file, "application/x-pem-file", step.EncodeCertificates(root))
+	case "root.der", "root.cer":
+		sendTrustFile(c, file, "application/pkix-cert", root.Raw)
+	default:
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown trust file"})
+	}
+}
+
+// TrustIntermediate serves the issuing intermediate as PEM or DER, or all
+// intermediates as intermediates.pem
+func (h *Handlers) TrustIntermediate(c *gin.Context) {
+	root, intermediates, ok := h.trustCerts(c)
+	if !ok {
+		return
+	}
+	if len(intermediates) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "CA has no intermediates"})
+		return
+	}
+	issuing, err := step.IssuingIntermediate(intermediates, root)
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": fmt.Sprintf("CA intermediates unavailable: %v", err)})
+		return
+	}
+
+	switch file := path.Base(c.FullPath()); file {
+	case "intermediates.pem":
+		sendTrustFile(c, "intermediates.pem", "application/x-pem-file", step.EncodeCertificates(intermediates...))
+	case "intermediate.pem", "intermediate.crt":
+		sendTrustFile(c, file, "application/x-pem-file", step.EncodeCertificates(issuing))
+	case "intermediate.der", "intermediate.cer":
+		sendTrustFile(c, file, "application/pkix-cert", issuing.Raw)
+	default:
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown trust file"})
+	}
+}
+
+// TrustBundle serves the root and intermediates together as PEM or PKCS#7
+func (h *Handlers) TrustBundle(c *gin.Context) {
+	root, intermediates, ok := h.trustCerts(c)
+	if !ok {
+		return
+	}
+	certs := append([]*x509.Certificate{root}, intermediates...)
+
+	switch path.Base(c.FullPath()) {
+	case "bundle.pem":
+		sendTrustFile(c, "bundle.pem", "application/x-pem-file", step.EncodeCertificates(certs...))
+	case "bundle.p7b":
+		data, err := certfmt.PKCS7(certs)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to build PKCS#7 bundle: %v", err)})
+			return
+		}
+		sendTrustFile(c, "bundle.p7b", "application/x-pkcs7-certificates", data)
+	default:
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown trust file"})
+	}
+}
+
+var installScripts = map[string]*template.Template{
+	"debian.sh": template.Must(template.New("debian").Parse(`#!/bin/sh
+# Installs the {{.Name}} root CA into the Debian/Ubuntu system trust store
+set -e
+cat > /usr/local/share/ca-certificates/{{.Slug}}.crt <<'EOF'
+{{.RootPEM}}EOF
+update-ca-certificates
+`)),
+	"rhel.sh": template.Must(template.New("rhel").Parse(`#!/bin/sh
+# Installs the {{.Name}} root CA into the RHEL/Fedora/CentOS system trust store
+set -e
+cat > /etc/pki/ca-trust/source/anchors/{{.Slug}}.crt <<'EOF'
+{{.RootPEM}}EOF
+update-ca-trust extract
+`)),
+	"alpine.sh": template.Must(template.New("alpine").Parse(`#!/bin/sh
+# Installs the {{.Name}} root CA into the Alpine system trust store
+set -e
+command -v update-ca-certificates >/dev/null || apk add --no-cache ca-certificates
+mkdir -p /usr/local/share/ca-certificates
+cat > /usr/local/share/ca-certificates/{{.Slug}}.crt <<'EOF'
+{{.RootPEM}}EOF
+update-ca-certificates
+`)),
+	"java.sh": template.Must(template.New("java").Parse(`#!/bin/sh
+# Imports the {{.Name}} root CA into a Java truststore.
+# Usage: java.sh [truststore] [storepass]
+# Defaults to the JDK cacerts file of $JAVA_HOME with the password "changeit".
+set -e
+TRUSTSTORE="${1:-${JAVA_HOME:?set JAVA_HOME or pass a truststore path}/lib/security/cacerts}"
+STOREPASS="${2:-changeit}"
+CERT="$(mktemp)"
+trap 'rm -f "$CERT"' EXIT
+cat > "$CERT" <<'EOF'
+{{.RootPEM}}EOF
+keytool -delete -noprompt -alias {{.Slug}} -keystore "$TRUSTSTORE" -storepass "$STOREPASS" >/dev/null 2>&1 || true
+keytool -importcert -noprompt -trustcacerts -alias {{.Slug}} -file "$CERT" -keystore "$TRUSTSTORE" -storepass "$STOREPASS"
+`)),
+}
+
+var nonSlug = regexp.MustCompile(`[^a-z0-9]+`)
+
+// TrustInstallScript serves a shell script that installs the root into a
+// system or Java trust store
+func (h *Handlers) TrustInstallScript(c *gin.Context) {
+	tmpl, ok := installScripts[c.Param("script")]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown install script"})
+		return
+	}
+	root, _, ok := h.trustCerts(c)
+	if !ok {
+		return
+	}
+
+	name := root.Subject.CommonName
+	if name == "" {
+		name = "step-ca"
+	}
+	slug := strings.Trim(nonSlug.ReplaceAllString(strings.ToLower(name), "-"), "-")
+	if slug == "" {
+		slug = "step-ca"
+	}
+
+	var buf bytes.Buffer
+	err := tmpl.Execute(&buf, map[string]string{
+		"Name":    strings.NewReplacer("\n", " ", "\r", " ").Replace(name),
+		"Slug":    slug,
+		"RootPEM": string(step.EncodeCertificates(root)),
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to render install script"})
+		return
+	}
+	sendTrustFile(c, "install-"+c.Param("script"), "text/x-shellscript", buf.Bytes())
+}
@@ -0,0 +1,50 @@
+package api
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTrustIntermediate(t *testing.T) {
+	ca := newFakeCA(t)
+	// The CA serves its issuing intermediate after the one that signed it,
+	// and an intermediate of another root first
+	policy, policyKey := testCert(t, caTemplate(10, "Fake Policy CA"), ca.root, ca.rootKey)
+	ca.intermediate, ca.intermediateKey = testCert(t, caTemplate(11, "Fake Issuing CA"), policy, policyKey)
+	otherRoot, otherKey := testCert(t, caTemplate(12, "Other Root CA"), nil, nil)
+	other, _ := testCert(t, caTemplate(13, "Other Intermediate CA"), otherRoot, otherKey)
+	ca.intermediates = []*x509.Certificate{other, policy, ca.intermediate}
+	r := newTestRouter(t, ca)
+
+	get := func(file string) []byte {
+		t.Helper()
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/trust/"+file, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s returned %d: %s", file, w.Code, w.Body)
+		}
+		return w.Body.Bytes()
+	}
+
+	block, rest := pem.Decode(get("intermediate.pem"))
+	if block == nil || len(rest) != 0 {
+		t.Fatal("intermediate.pem does not hold one PEM block")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cert.Equal(ca.intermediate) {
+		t.Errorf("intermediate.pem holds %s, want the issuing intermediate", cert.Subject.CommonName)
+	}
+	if der := get("intermediate.der"); string(der) != string(ca.intermediate.Raw) {
+		t.Error("intermediate.der does not hold the issuing intermediate")
+	}
+	// Intermediates of other roots are dropped when fetched
+	if got, want := string(get("intermediates.pem")), pemCerts(policy, ca.intermediate); got != want {
+		t.Errorf("intermediates.pem holds\n%s\nwant\n%s", got, want)
+	}
+}
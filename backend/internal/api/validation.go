@@ -0,0 +1,160 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"step-ca-webui/internal/profile"
+	"step-ca-webui/internal/step"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+func init() {
+	// Report binding errors using JSON field names
+	if v, ok := binding.Validator.Engine().(*validator.Validate); ok {
+		v.RegisterTagNameFunc(func(field reflect.StructField) string {
+			name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+			if name == "-" || name == "" {
+				return field.Name
+			}
+			return name
+		})
+	}
+}
+
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+type ValidationErrors []FieldError
+
+func (v *ValidationErrors) Add(field, format string, args ...interface{}) {
+	*v = append(*v, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// respondValidation writes a 422 response listing every invalid field
+func respondValidation(c *gin.Context, errs ValidationErrors) {
+	c.JSON(http.StatusUnprocessableEntity, gin.H{
+		"error":   "Validation failed",
+		"details": errs,
+	})
+}
+
+// bindJSON binds the request body and reports binding failures as
+// structured validation errors. It returns whether binding succeeded.
+func bindJSON(c *gin.Context, req interface{}) bool {
+	err := c.ShouldBindJSON(req)
+	if err == nil {
+		return true
+	}
+
+	var verrs validator.ValidationErrors
+	if errors.As(err, &verrs) {
+		var errs ValidationErrors
+		for _, fe := range verrs {
+			errs.Add(fe.Field(), "failed %s validation", fe.Tag())
+			if fe.Tag() == "required" {
+				errs[len(errs)-1].Message = "is required"
+			}
+		}
+		respondValidation(c, errs)
+		return false
+	}
+
+	var terr *time.ParseError
+	if errors.As(err, &terr) {
+		respondValidation(c, ValidationErrors{{Field: "body", Message: fmt.Sprintf("timestamps must be RFC 3339: %v", err)}})
+		return false
+	}
+
+	c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	return false
+}
+
+// LifetimeRequest holds the lifetime fields shared by issuance requests
+type LifetimeRequest struct {
+	NotAfterDays int        `json:"not_after_days"`       // relative lifetime in days
+	NotBefore    *time.Time `json:"not_before,omitempty"` // RFC 3339, may be backdated
+	NotAfter     *time.Time `json:"not_after,omitempty"`  // RFC 3339, instead of not_after_days
+}
+
+// resolveValidity turns the requested lifetime into absolute timestamps and
+// checks it against the limits. defaultDays is used when the request has no
+// explicit lifetime.
+func resolveValidity(now time.Time, req LifetimeRequest, defaultDays int, limits profile.Limits) (step.Validity, ValidationErrors) {
+	var errs ValidationErrors
+
+	if req.NotAfterDays < 0 {
+		errs.Add("not_after_days", "must be a positive number of days")
+	}
+	if req.NotAfterDays > 0 && req.NotAfter != nil {
+		errs.Add("not_after", "cannot be combined with not_after_days")
+	}
+	if len(errs) > 0 {
+		return step.Validity{}, errs
+	}
+
+	start := now
+	validity := step.Validity{}
+	if req.NotBefore != nil {
+		start = *req.NotBefore
+		validity.NotBefore = start
+		if limits.MaxBackdate > 0 && start.Before(now.Add(-limits.MaxBackdate)) {
+			errs.Add("not_before", "cannot be backdated by more than %s", formatDuration(limits.MaxBackdate))
+		}
+	}
+
+	switch {
+	case req.NotAfter != nil:
+		validity.NotAfter = *req.NotAfter
+	case req.NotAfterDays > 0:
+		validity.NotAfter = start.Add(time.Duration(req.NotAfterDays) * 24 * time.Hour)
+	case defaultDays > 0:
+		validity.NotAfter = start.Add(time.Duration(defaultDays) * 24 * time.Hour)
+	default:
+		errs.Add("not_after_days", "is required when neither not_after nor a profile default is given")
+		return step.Validity{}, errs
+	}
+
+	if !validity.NotAfter.After(now) {
+		errs.Add("not_after", "must be in the future")
+	}
+	if !validity.NotAfter.After(start) {
+		errs.Add("not_after", "must be after not_before")
+		return step.Validity{}, errs
+	}
+
+	// Attribute lifetime errors to the field the caller used
+	field := "not_after_days"
+	if req.NotAfter != nil {
+		field = "not_after"
+	}
+
+	lifetime := validity.NotAfter.Sub(start)
+	if limits.Min > 0 && lifetime < limits.Min {
+		errs.Add(field, "lifetime %s is below the minimum of %s", formatDuration(lifetime), formatDuration(limits.Min))
+	}
+	if limits.Max > 0 && lifetime > limits.Max {
+		errs.Add(field, "lifetime %s exceeds the maximum of %s", formatDuration(lifetime), formatDuration(limits.Max))
+	}
+
+	if len(errs) > 0 {
+		return step.Validity{}, errs
+	}
+	return validity, nil
+}
+
+func formatDuration(d time.Duration) string {
+	if d >= 24*time.Hour && d%(24*time.Hour) == 0 {
+		return fmt.Sprintf("%dd", d/(24*time.Hour))
+	}
+	return d.String()
+}
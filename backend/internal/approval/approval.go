@@ -0,0 +1,202 @@
+package approval
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"step-ca-webui/internal/auth"
+	"step-ca-webui/internal/config"
+)
+
+// Statuses of an issuance request awaiting approval
+const (
+	StatusPending  = "pending"
+	StatusApproved = "approved"
+	StatusRejected = "rejected"
+	StatusExpired  = "expired"
+	StatusFailed   = "failed"
+)
+
+// Rule marks requests as sensitive. A request matches when any of the
+// configured conditions holds for its CN, SANs or profile.
+type Rule struct {
+	Name        string   `json:"name"`
+	Wildcards   bool     `json:"wildcards"`    // any wildcard name
+	DNSSuffixes []string `json:"dns_suffixes"` // "prod.example.com" matches it and its subdomains
+	Profiles    []string `json:"profiles"`
+}
+
+type Config struct {
+	Rules         []Rule   `json:"rules"`
+	ApproverRoles []string `json:"approver_roles"` // required when rules are set
+	Timeout       string   `json:"timeout"`        // pending requests expire after this, default 72h
+	PickupTTL     string   `json:"pickup_ttl"`     // how long issued bundles wait for the requester, default 24h
+	WebhookURL    string   `json:"webhook_url"`
+}
+
+type Workflow struct {
+	rules         []Rule
+	approverRoles []string
+	Timeout       time.Duration
+	PickupTTL     time.Duration
+	notifier      *Notifier
+}
+
+// Load reads the approval configuration from a JSON file. An empty path
+// yields a workflow without rules, so no request needs approval.
+func Load(path string) (*Workflow, error) {
+	var cfg Config
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read approval file: %w", err)
+		}
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			return nil, fmt.Errorf("failed to parse approval file: %w", err)
+		}
+	}
+	return New(cfg)
+}
+
+func New(cfg Config) (*Workflow, error) {
+	if len(cfg.Rules) > 0 && len(cfg.ApproverRoles) == 0 {
+		return nil, errors.New("approval rules require approver_roles")
+	}
+
+	w := &Workflow{
+		rules:         cfg.Rules,
+		approverRoles: cfg.ApproverRoles,
+		Timeout:       72 * time.Hour,
+		PickupTTL:     24 * time.Hour,
+		notifier:      &Notifier{WebhookURL: cfg.WebhookURL, client: &http.Client{Timeout: 10 * time.Second}},
+	}
+
+	if cfg.Timeout != "" {
+		d, err := config.ParseDuration(cfg.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid approval timeout: %w", err)
+		}
+		w.Timeout = d
+	}
+	if cfg.PickupTTL != "" {
+		d, err := config.ParseDuration(cfg.PickupTTL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid approval pickup_ttl: %w", err)
+		}
+		w.PickupTTL = d
+	}
+
+	for i := range w.rules {
+		if w.rules[i].Name == "" {
+			w.rules[i].Name = fmt.Sprintf("rule-%d", i+1)
+		}
+	}
+
+	return w, nil
+}
+
+// Match returns the names of the rules requiring approval for the request
+func (w *Workflow) Match(cn string, sans []string, profile string) []string {
+	names := append([]string{cn}, sans...)
+
+	var matched []string
+	for _, rule := range w.rules {
+		if rule.matches(names, profile) {
+			matched = append(matched, rule.Name)
+		}
+	}
+	return matched
+}
+
+func (r Rule) matches(names []string, profile string) bool {
+	for _, p := range r.Profiles {
+		if p == profile && profile != "" {
+			return true
+		}
+	}
+
+	for _, name := range names {
+		name = strings.ToLower(name)
+		if r.Wildcards && strings.Contains(name, "*") {
+			return true
+		}
+		for _, suffix := range r.DNSSuffixes {
+			suffix = strings.ToLower(strings.TrimPrefix(suffix, "."))
+			if name == suffix || strings.HasSuffix(name, "."+suffix) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// CanApprove reports whether identity may decide a request made by
+// requester. Approvers must be authenticated and hold one of the approver
+// roles, and requesters can never approve their own requests.
+func (w *Workflow) CanApprove(identity *auth.Identity, requester string) bool {
+	if !identity.Authenticated() || identity.User == requester {
+		return false
+	}
+	for _, role := range identity.Roles {
+		for _, allowed := range w.approverRoles {
+			if role == allowed {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Notify sends an approval event to the configured webhook in the background
+func (w *Workflow) Notify(event string, request interface{}) {
+	w.notifier.Send(event, request)
+}
+
+// Notifier posts approval events as JSON to a webhook
+type Notifier struct {
+	WebhookURL string
+	client     *http.Client
+}
+
+func (n *Notifier) Send(event string, request interface{}) {
+	slog.Info("Approval event", "event", event)
+	if n.WebhookURL == "" {
+		return
+	}
+
+	payload, err := json.Marshal(map[string]interface{}{
+		"event":     event,
+		"request":   request,
+		"timestamp": time.Now().UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		slog.Error("Failed to encode approval notification", "error", err)
+		return
+	}
+
+	go func() {
+		resp, err := n.client.Post(n.WebhookURL, "application/json", bytes.NewReader(payload))
+		if err != nil {
+			// The webhook URL often embeds a secret, so only the cause is logged
+			var urlErr *url.Error
+			if errors.As(err, &urlErr) {
+				err = urlErr.Err
+			}
+			slog.Error("Failed to send approval notification", "error", err)
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			slog.Warn("Approval webhook returned an error", "status", resp.StatusCode)
+		}
+	}()
+}
@@ -0,0 +1,104 @@
+package approval
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"step-ca-webui/internal/auth"
+)
+
+func TestNew(t *testing.T) {
+	if _, err := New(Config{Rules: []Rule{{Wildcards: true}}}); err == nil {
+		t.Error("rules accepted without approver_roles")
+	}
+	if _, err := New(Config{ApproverRoles: []string{"security"}, Timeout: "soon"}); err == nil {
+		t.Error("invalid timeout accepted")
+	}
+	if _, err := New(Config{ApproverRoles: []string{"security"}, PickupTTL: "-"}); err == nil {
+		t.Error("invalid pickup_ttl accepted")
+	}
+
+	w, err := New(Config{})
+	if err != nil {
+		t.Fatalf("empty configuration rejected: %v", err)
+	}
+	if w.Timeout != 72*time.Hour || w.PickupTTL != 24*time.Hour {
+		t.Errorf("defaults are %s and %s", w.Timeout, w.PickupTTL)
+	}
+
+	w, err = New(Config{
+		Rules:         []Rule{{Wildcards: true}, {Name: "production", Profiles: []string{"kafka-broker"}}},
+		ApproverRoles: []string{"security"},
+		Timeout:       "1h",
+		PickupTTL:     "30m",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.Timeout != time.Hour || w.PickupTTL != 30*time.Minute {
+		t.Errorf("durations are %s and %s", w.Timeout, w.PickupTTL)
+	}
+	if got := w.Match("*.example.com", nil, "kafka-broker"); strings.Join(got, " ") != "rule-1 production" {
+		t.Errorf("Match named the rules %v", got)
+	}
+}
+
+func TestMatch(t *testing.T) {
+	w, err := New(Config{
+		Rules: []Rule{
+			{Name: "wildcards", Wildcards: true},
+			{Name: "production", DNSSuffixes: []string{".Prod.example.com"}, Profiles: []string{"kafka-broker"}},
+		},
+		ApproverRoles: []string{"security"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		cn      string
+		sans    []string
+		profile string
+		want    string
+	}{
+		{"web.example.com", []string{"www.example.com"}, "", ""},
+		{"web.example.com", []string{"*.example.com"}, "", "wildcards"},
+		{"prod.example.com", nil, "", "production"},
+		{"DB.PROD.example.com", nil, "", "production"},
+		{"web.example.com", []string{"api.prod.example.com"}, "", "production"},
+		{"notprod.example.com", nil, "", ""},
+		{"broker.example.com", nil, "kafka-broker", "production"},
+		{"*.prod.example.com", nil, "", "wildcards production"},
+	} {
+		if got := strings.Join(w.Match(tt.cn, tt.sans, tt.profile), " "); got != tt.want {
+			t.Errorf("Match(%s, %v, %q) = %q, want %q", tt.cn, tt.sans, tt.profile, got, tt.want)
+		}
+	}
+}
+
+func TestCanApprove(t *testing.T) {
+	w, err := New(Config{Rules: []Rule{{Wildcards: true}}, ApproverRoles: []string{"security", "pki"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name     string
+		identity *auth.Identity
+		want     bool
+	}{
+		{"approver role", &auth.Identity{User: "carol", Roles: []string{"dev", "security"}}, true},
+		{"second approver role", &auth.Identity{User: "dave", Roles: []string{"pki"}, APIKey: "dave"}, true},
+		{"own request", &auth.Identity{User: "alice", Roles: []string{"security"}}, false},
+		{"no approver role", &auth.Identity{User: "bob", Roles: []string{"dev"}}, false},
+		{"no roles", &auth.Identity{User: "bob"}, false},
+		{"anonymous", auth.Anonymous, false},
+		{"anonymous with roles", &auth.Identity{User: "system", Roles: []string{"security"}}, false},
+		{"nil", nil, false},
+	} {
+		if got := w.CanApprove(tt.identity, "alice"); got != tt.want {
+			t.Errorf("%s: CanApprove = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
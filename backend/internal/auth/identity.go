@@ -0,0 +1,142 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const identityKey = "identity"
+
+// Identity describes the caller of an API request
+type Identity struct {
+	User   string   `json:"user"`
+	Roles  []string `json:"roles"`
+	APIKey string   `json:"api_key,omitempty"` // name of the API key, never the secret
+	// IDToken is the caller's OIDC ID token, forwarded to OIDC provisioners
+	IDToken string `json:"-"`
+}
+
+// Anonymous is used when a request carries no credentials
+var Anonymous = &Identity{User: "system"}
+
+// Authenticated reports whether the caller presented an API key or was
+// identified by a trusted proxy. Anonymous callers, including those sending
+// only a bearer token, are not authenticated.
+func (i *Identity) Authenticated() bool {
+	if i == nil || i == Anonymous {
+		return false
+	}
+	return i.APIKey != "" || (i.User != "" && i.User != Anonymous.User)
+}
+
+func (i *Identity) HasRole(role string) bool {
+	for _, r := range i.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+type APIKey struct {
+	Name  string   `json:"name"`
+	Key   string   `json:"key"`
+	User  string   `json:"user"`
+	Roles []string `json:"roles"`
+}
+
+// LoadAPIKeys reads API key definitions from a JSON file containing an array
+// of keys. An empty path yields no keys.
+func LoadAPIKeys(path string) ([]APIKey, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read API keys file: %w", err)
+	}
+
+	var keys []APIKey
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, fmt.Errorf("failed to parse API keys file: %w", err)
+	}
+
+	for _, k := range keys {
+		if k.Name == "" || k.Key == "" {
+			return nil, fmt.Errorf("API keys require a name and a key")
+		}
+	}
+
+	return keys, nil
+}
+
+// Middleware resolves the caller identity from an X-API-Key header or, when
+// trustProxy is set, from the X-Forwarded-User and X-Forwarded-Groups headers
+// of an authenticating reverse proxy. Requests without credentials run as
+// Anonymous; an unknown API key is rejected. A bearer token in the
+// Authorization header is kept as the caller's ID token; it is not verified
+// here but by the CA when an OIDC provisioner receives it.
+func Middleware(keys []APIKey, trustProxy bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		identity := Anonymous
+
+		if presented := c.GetHeader("X-API-Key"); presented != "" {
+			identity = nil
+			for _, k := range keys {
+				if subtle.ConstantTimeCompare([]byte(presented), []byte(k.Key)) == 1 {
+					user := k.User
+					if user == "" {
+						user = k.Name
+					}
+					identity = &Identity{User: user, Roles: k.Roles, APIKey: k.Name}
+					break
+				}
+			}
+			if identity == nil {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid API key"})
+				return
+			}
+		} else if trustProxy && c.GetHeader("X-Forwarded-User") != "" {
+			identity = &Identity{
+				User:  c.GetHeader("X-Forwarded-User"),
+				Roles: splitList(c.GetHeader("X-Forwarded-Groups")),
+			}
+		}
+
+		if token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer "); ok && token != "" {
+			withToken := *identity
+			withToken.IDToken = strings.TrimSpace(token)
+			identity = &withToken
+		}
+
+		c.Set(identityKey, identity)
+		c.Next()
+	}
+}
+
+// FromContext returns the identity resolved by Middleware
+func FromContext(c *gin.Context) *Identity {
+	if value, ok := c.Get(identityKey); ok {
+		if identity, ok := value.(*Identity); ok {
+			return identity
+		}
+	}
+	return Anonymous
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
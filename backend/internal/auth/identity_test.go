@@ -0,0 +1,152 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+var testKeys = []APIKey{
+	{Name: "ci", Key: "ci-secret", Roles: []string{"deploy"}},
+	{Name: "ops-key", Key: "ops-secret", User: "ops", Roles: []string{"security", "pki"}},
+}
+
+// resolve sends a request with headers given as name, value pairs through
+// Middleware and returns the status and the identity handlers saw
+func resolve(t *testing.T, trustProxy bool, headers ...string) (int, *Identity) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	var identity *Identity
+	r := gin.New()
+	r.Use(Middleware(testKeys, trustProxy))
+	r.GET("/", func(c *gin.Context) {
+		identity = FromContext(c)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w.Code, identity
+}
+
+func TestMiddleware(t *testing.T) {
+	for _, tt := range []struct {
+		name          string
+		trustProxy    bool
+		headers       []string
+		user          string
+		roles         string
+		apiKey        string
+		idToken       string
+		authenticated bool
+	}{
+		{name: "no credentials", user: "system"},
+		{name: "API key", headers: []string{"X-API-Key", "ci-secret"}, user: "ci", roles: "deploy", apiKey: "ci", authenticated: true},
+		{name: "API key with user", headers: []string{"X-API-Key", "ops-secret"}, user: "ops", roles: "security pki", apiKey: "ops-key", authenticated: true},
+		{
+			name:          "proxy headers",
+			trustProxy:    true,
+			headers:       []string{"X-Forwarded-User", "carol", "X-Forwarded-Groups", "dev, security,,"},
+			user:          "carol",
+			roles:         "dev security",
+			authenticated: true,
+		},
+		{name: "untrusted proxy headers", headers: []string{"X-Forwarded-User", "carol", "X-Forwarded-Groups", "security"}, user: "system"},
+		{
+			name:          "API key before proxy headers",
+			trustProxy:    true,
+			headers:       []string{"X-API-Key", "ci-secret", "X-Forwarded-User", "carol"},
+			user:          "ci",
+			roles:         "deploy",
+			apiKey:        "ci",
+			authenticated: true,
+		},
+		{name: "proxy user named like anonymous", trustProxy: true, headers: []string{"X-Forwarded-User", "system"}, user: "system"},
+		{name: "bearer token only", headers: []string{"Authorization", "Bearer eyJ.id.token"}, user: "system", idToken: "eyJ.id.token"},
+		{
+			name:          "API key and bearer token",
+			headers:       []string{"X-API-Key", "ci-secret", "Authorization", "Bearer eyJ.id.token"},
+			user:          "ci",
+			roles:         "deploy",
+			apiKey:        "ci",
+			idToken:       "eyJ.id.token",
+			authenticated: true,
+		},
+		{name: "basic authorization", headers: []string{"Authorization", "Basic Y2k6c2VjcmV0"}, user: "system"},
+	} {
+		code, identity := resolve(t, tt.trustProxy, tt.headers...)
+		if code != http.StatusOK || identity == nil {
+			t.Errorf("%s: request returned %d", tt.name, code)
+			continue
+		}
+		if identity.User != tt.user || strings.Join(identity.Roles, " ") != tt.roles || identity.APIKey != tt.apiKey || identity.IDToken != tt.idToken {
+			t.Errorf("%s: identity is %+v", tt.name, identity)
+		}
+		if identity.Authenticated() != tt.authenticated {
+			t.Errorf("%s: Authenticated() = %v, want %v", tt.name, identity.Authenticated(), tt.authenticated)
+		}
+	}
+
+	if Anonymous.IDToken != "" {
+		t.Error("a bearer token was stored on the shared anonymous identity")
+	}
+	if code, identity := resolve(t, true, "X-API-Key", "wrong", "X-Forwarded-User", "carol"); code != http.StatusUnauthorized || identity != nil {
+		t.Errorf("unknown API key returned %d with identity %+v", code, identity)
+	}
+}
+
+func TestAuthenticated(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		identity *Identity
+		want     bool
+	}{
+		{"nil", nil, false},
+		{"anonymous", Anonymous, false},
+		{"copy of anonymous", &Identity{User: "system", Roles: []string{"admin"}}, false},
+		{"empty", &Identity{}, false},
+		{"user", &Identity{User: "alice"}, true},
+		{"API key", &Identity{User: "system", APIKey: "automation"}, true},
+	} {
+		if got := tt.identity.Authenticated(); got != tt.want {
+			t.Errorf("%s: Authenticated() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestLoadAPIKeys(t *testing.T) {
+	if keys, err := LoadAPIKeys(""); err != nil || keys != nil {
+		t.Errorf("empty path returned %v, %v", keys, err)
+	}
+
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"valid.json":   `[{"name": "ci", "key": "ci-secret", "roles": ["deploy"]}]`,
+		"nokey.json":   `[{"name": "ci"}]`,
+		"noname.json":  `[{"key": "ci-secret"}]`,
+		"invalid.json": `{"name": "ci"}`,
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	keys, err := LoadAPIKeys(filepath.Join(dir, "valid.json"))
+	if err != nil || len(keys) != 1 || keys[0].Name != "ci" || !(&Identity{Roles: keys[0].Roles}).HasRole("deploy") {
+		t.Errorf("valid file loaded as %+v, %v", keys, err)
+	}
+	for _, name := range []string{"nokey.json", "noname.json", "invalid.json", "missing.json"} {
+		if _, err := LoadAPIKeys(filepath.Join(dir, name)); err == nil {
+			t.Errorf("%s was accepted", name)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package certfmt
+
+import (
+	"bytes"
+	"crypto/x509"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pavlo-v-chernykh/keystore-go/v4"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// MinKeystorePasswordLen matches the minimum keytool accepts
+const MinKeystorePasswordLen = 6
+
+// JKS returns a Java keystore holding one private key entry under alias.
+// chain starts with the certificate for key, followed by its issuers. The
+// key entry is protected with the store password, as keytool expects.
+func JKS(alias, password string, key interface{}, chain []*x509.Certificate) ([]byte, error) {
+	if len(password) < MinKeystorePasswordLen {
+		return nil, fmt.Errorf("keystore password must be at least %d characters", MinKeystorePasswordLen)
+	}
+	if len(chain) == 0 {
+		return nil, fmt.Errorf("no certificate for the keystore entry")
+	}
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode private key: %w", err)
+	}
+
+	entry := keystore.PrivateKeyEntry{
+		CreationTime: time.Now(),
+		PrivateKey:   keyDER,
+	}
+	for _, cert := range chain {
+		entry.CertificateChain = append(entry.CertificateChain, keystore.Certificate{Type: "X509", Content: cert.Raw})
+	}
+
+	ks := keystore.New(keystore.WithOrderedAliases())
+	if err := ks.SetPrivateKeyEntry(alias, entry, []byte(password)); err != nil {
+		return nil, fmt.Errorf("failed to add keystore entry: %w", err)
+	}
+
+	var buf bytes.Buffer
+	if err := ks.Store(&buf, []byte(password)); err != nil {
+		return nil, fmt.Errorf("failed to write keystore: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// TrustStore returns a PKCS#12 truststore with the given CA certificates,
+// each aliased by its common name. Java 8 and newer read it as a truststore.
+func TrustStore(password string, certs []*x509.Certificate) ([]byte, error) {
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificates for the truststore")
+	}
+
+	var entries []pkcs12.TrustStoreEntry
+	seen := make(map[string]int)
+	for _, cert := range certs {
+		name := strings.ToLower(cert.Subject.CommonName)
+		if name == "" {
+			name = "ca"
+		}
+		// Java treats entries sharing a friendly name as one
+		if seen[name]++; seen[name] > 1 {
+			name = fmt.Sprintf("%s-%d", name, seen[name])
+		}
+		entries = append(entries, pkcs12.TrustStoreEntry{Cert: cert, FriendlyName: name})
+	}
+
+	return pkcs12.Modern.EncodeTrustStoreEntries(entries, password)
+}
@@ -0,0 +1,430 @@
+package certfmt
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"hash"
+	"math/big"
+	"unicode/utf16"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// PFX encryption modes
+const (
+	// PFXModern encrypts keys and certificates with AES-256-CBC (PBES2,
+	// PBKDF2-HMAC-SHA-256) and uses an HMAC-SHA-256 MAC. Windows Server 2019
+	// and newer, OpenSSL 1.1+, Java 8u301+ and macOS read it.
+	PFXModern = "modern"
+	// PFXLegacy uses PBE-SHA1-3DES and an HMAC-SHA-1 MAC for Windows Server
+	// 2016 and older, IIS and other software that predates PBES2
+	PFXLegacy = "legacy"
+)
+
+// pfxIterations matches OpenSSL's default for both encryption and MAC
+const pfxIterations = 2048
+
+var (
+	oidEncryptedData     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 6}
+	oidCertBag           = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 3}
+	oidShroudedKeyBag    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 2}
+	oidX509Certificate   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 22, 1}
+	oidFriendlyName      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 20}
+	oidLocalKeyID        = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 21}
+	oidPBEWithSHA3DES    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 1, 3}
+	oidPBES2             = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2            = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidHMACWithSHA256    = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidAES256CBC         = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+	oidSHA1              = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidSHA256            = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	asn1NULL             = asn1.RawValue{Tag: asn1.TagNull}
+	pkcs12KeyMaterialKey = byte(1)
+	pkcs12KeyMaterialIV  = byte(2)
+	pkcs12KeyMaterialMAC = byte(3)
+)
+
+type pfxPDU struct {
+	Version  int
+	AuthSafe contentInfo
+	MacData  macData
+}
+
+type macData struct {
+	Mac        digestInfo
+	MacSalt    []byte
+	Iterations int
+}
+
+type digestInfo struct {
+	Algorithm pkix.AlgorithmIdentifier
+	Digest    []byte
+}
+
+type encryptedData struct {
+	Version              int
+	EncryptedContentInfo encryptedContentInfo
+}
+
+type encryptedContentInfo struct {
+	ContentType                asn1.ObjectIdentifier
+	ContentEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedContent           []byte `asn1:"tag:0,optional"`
+}
+
+type safeBag struct {
+	ID         asn1.ObjectIdentifier
+	Value      asn1.RawValue     `asn1:"tag:0,explicit"`
+	Attributes []pkcs12Attribute `asn1:"set,optional"`
+}
+
+type pkcs12Attribute struct {
+	ID    asn1.ObjectIdentifier
+	Value asn1.RawValue `asn1:"set"`
+}
+
+type certBag struct {
+	ID   asn1.ObjectIdentifier
+	Data []byte `asn1:"tag:0,explicit"`
+}
+
+type encryptedPrivateKeyInfo struct {
+	Algorithm     pkix.AlgorithmIdentifier
+	EncryptedData []byte
+}
+
+type pbeParams struct {
+	Salt       []byte
+	Iterations int
+}
+
+type pbes2Params struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	EncryptionScheme  pkix.AlgorithmIdentifier
+}
+
+type pbkdf2Params struct {
+	Salt       []byte
+	Iterations int
+	PRF        pkix.AlgorithmIdentifier
+}
+
+// PFX returns a password protected PKCS#12 file holding key, its certificate
+// chain[0] and the issuers in the rest of chain. The key and leaf certificate
+// carry friendlyName, which Windows and Java show as the name or alias.
+// Everything is built in memory.
+func PFX(key interface{}, chain []*x509.Certificate, password, mode, friendlyName string) ([]byte, error) {
+	if len(chain) == 0 {
+		return nil, fmt.Errorf("no certificate for the PFX")
+	}
+
+	var enc pfxEncryption
+	switch mode {
+	case PFXModern, "":
+		enc = pbes2Encryption{}
+	case PFXLegacy:
+		enc = pbe3DESEncryption{}
+	default:
+		return nil, fmt.Errorf("unsupported PFX encryption %q", mode)
+	}
+
+	keyID := sha1.Sum(chain[0].Raw)
+	leafAttrs, err := bagAttributes(keyID[:], friendlyName)
+	if err != nil {
+		return nil, err
+	}
+
+	// Certificates go into an encrypted SafeContents
+	var certBags []safeBag
+	for i, cert := range chain {
+		value, err := asn1.Marshal(certBag{ID: oidX509Certificate, Data: cert.Raw})
+		if err != nil {
+			return nil, err
+		}
+		bag := safeBag{ID: oidCertBag, Value: asn1.RawValue{FullBytes: explicitTag0(value)}}
+		if i == 0 {
+			bag.Attributes = leafAttrs
+		}
+		certBags = append(certBags, bag)
+	}
+	certContents, err := asn1.Marshal(certBags)
+	if err != nil {
+		return nil, err
+	}
+	certAlg, certCiphertext, err := enc.encrypt(certContents, password)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt certificates: %w", err)
+	}
+	encryptedCerts, err := asn1.Marshal(encryptedData{
+		EncryptedContentInfo: encryptedContentInfo{
+			ContentType:                oidData,
+			ContentEncryptionAlgorithm: certAlg,
+			EncryptedContent:           certCiphertext,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// The key is shrouded on its own and stored in a plain SafeContents
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode private key: %w", err)
+	}
+	keyAlg, keyCiphertext, err := enc.encrypt(keyDER, password)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt private key: %w", err)
+	}
+	shrouded, err := asn1.Marshal(encryptedPrivateKeyInfo{Algorithm: keyAlg, EncryptedData: keyCiphertext})
+	if err != nil {
+		return nil, err
+	}
+	keyContents, err := asn1.Marshal([]safeBag{{
+		ID:         oidShroudedKeyBag,
+		Value:      asn1.RawValue{FullBytes: explicitTag0(shrouded)},
+		Attributes: leafAttrs,
+	}})
+	if err != nil {
+		return nil, err
+	}
+
+	authSafe, err := asn1.Marshal([]contentInfo{
+		{ContentType: oidEncryptedData, Content: explicitContent(encryptedCerts)},
+		{ContentType: oidData, Content: explicitContent(mustOctetString(keyContents))},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	mac, err := computeMAC(enc.macHash(), authSafe, password)
+	if err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(pfxPDU{
+		Version:  3,
+		AuthSafe: contentInfo{ContentType: oidData, Content: explicitContent(mustOctetString(authSafe))},
+		MacData:  mac,
+	})
+}
+
+func bagAttributes(keyID []byte, friendlyName string) ([]pkcs12Attribute, error) {
+	localKeyID, err := asn1.Marshal(keyID)
+	if err != nil {
+		return nil, err
+	}
+	attrs := []pkcs12Attribute{{ID: oidLocalKeyID, Value: asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: localKeyID}}}
+
+	if friendlyName != "" {
+		name, err := asn1.Marshal(asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagBMPString, Bytes: bmpString(friendlyName)})
+		if err != nil {
+			return nil, err
+		}
+		attrs = append([]pkcs12Attribute{{ID: oidFriendlyName, Value: asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: name}}}, attrs...)
+	}
+	return attrs, nil
+}
+
+func explicitTag0(der []byte) []byte {
+	out, _ := asn1.Marshal(asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: der})
+	return out
+}
+
+func explicitContent(der []byte) asn1.RawValue {
+	return asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: der}
+}
+
+func mustOctetString(data []byte) []byte {
+	out, _ := asn1.Marshal(data)
+	return out
+}
+
+// bmpString encodes s as big-endian UTF-16 without a terminator
+func bmpString(s string) []byte {
+	var out []byte
+	for _, r := range utf16.Encode([]rune(s)) {
+		out = append(out, byte(r>>8), byte(r))
+	}
+	return out
+}
+
+// pkcs12Password is the BMPString form with a two byte terminator used by
+// the PKCS#12 key derivation function
+func pkcs12Password(password string) []byte {
+	return append(bmpString(password), 0, 0)
+}
+
+func computeMAC(newHash func() hash.Hash, content []byte, password string) (macData, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return macData{}, err
+	}
+
+	oid := oidSHA256
+	if newHash().Size() == sha1.Size {
+		oid = oidSHA1
+	}
+
+	key := pkcs12KDF(newHash, pkcs12KeyMaterialMAC, pkcs12Password(password), salt, pfxIterations, newHash().Size())
+	mac := hmac.New(newHash, key)
+	mac.Write(content)
+
+	return macData{
+		Mac: digestInfo{
+			Algorithm: pkix.AlgorithmIdentifier{Algorithm: oid, Parameters: asn1NULL},
+			Digest:    mac.Sum(nil),
+		},
+		MacSalt:    salt,
+		Iterations: pfxIterations,
+	}, nil
+}
+
+// pkcs12KDF derives key material as described in RFC 7292 appendix B.2
+func pkcs12KDF(newHash func() hash.Hash, id byte, password, salt []byte, iterations, size int) []byte {
+	h := newHash()
+	u := h.Size()
+	v := h.BlockSize()
+
+	fill := func(data []byte) []byte {
+		if len(data) == 0 {
+			return nil
+		}
+		out := make([]byte, v*((len(data)+v-1)/v))
+		for i := range out {
+			out[i] = data[i%len(data)]
+		}
+		return out
+	}
+
+	D := bytes.Repeat([]byte{id}, v)
+	I := append(fill(salt), fill(password)...)
+
+	var out []byte
+	one := big.NewInt(1)
+	for len(out) < size {
+		h.Reset()
+		h.Write(D)
+		h.Write(I)
+		A := h.Sum(nil)
+		for i := 1; i < iterations; i++ {
+			h.Reset()
+			h.Write(A)
+			A = h.Sum(A[:0])
+		}
+		out = append(out, A...)
+
+		if len(out) >= size {
+			break
+		}
+
+		// I_j = (I_j + B + 1) mod 2^(8v) for every v-byte block of I
+		B := new(big.Int).SetBytes(fill(A[:u])[:v])
+		B.Add(B, one)
+		for j := 0; j < len(I); j += v {
+			block := new(big.Int).SetBytes(I[j : j+v])
+			block.Add(block, B)
+			sum := block.Bytes()
+			if len(sum) > v {
+				sum = sum[len(sum)-v:]
+			}
+			copy(I[j:j+v], make([]byte, v))
+			copy(I[j+v-len(sum):j+v], sum)
+		}
+	}
+	return out[:size]
+}
+
+type pfxEncryption interface {
+	encrypt(plaintext []byte, password string) (pkix.AlgorithmIdentifier, []byte, error)
+	macHash() func() hash.Hash
+}
+
+type pbes2Encryption struct{}
+
+func (pbes2Encryption) macHash() func() hash.Hash { return sha256.New }
+
+func (pbes2Encryption) encrypt(plaintext []byte, password string) (pkix.AlgorithmIdentifier, []byte, error) {
+	salt := make([]byte, 16)
+	iv := make([]byte, aes.BlockSize)
+	if _, err := rand.Read(salt); err != nil {
+		return pkix.AlgorithmIdentifier{}, nil, err
+	}
+	if _, err := rand.Read(iv); err != nil {
+		return pkix.AlgorithmIdentifier{}, nil, err
+	}
+
+	// PBES2 takes the password as UTF-8, unlike the PKCS#12 KDF
+	key := pbkdf2.Key([]byte(password), salt, pfxIterations, 32, sha256.New)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return pkix.AlgorithmIdentifier{}, nil, err
+	}
+	ciphertext := cbcEncrypt(cipher.NewCBCEncrypter(block, iv), plaintext)
+
+	kdfParams, err := asn1.Marshal(pbkdf2Params{
+		Salt:       salt,
+		Iterations: pfxIterations,
+		PRF:        pkix.AlgorithmIdentifier{Algorithm: oidHMACWithSHA256, Parameters: asn1NULL},
+	})
+	if err != nil {
+		return pkix.AlgorithmIdentifier{}, nil, err
+	}
+	ivParam, err := asn1.Marshal(iv)
+	if err != nil {
+		return pkix.AlgorithmIdentifier{}, nil, err
+	}
+	params, err := asn1.Marshal(pbes2Params{
+		KeyDerivationFunc: pkix.AlgorithmIdentifier{Algorithm: oidPBKDF2, Parameters: asn1.RawValue{FullBytes: kdfParams}},
+		EncryptionScheme:  pkix.AlgorithmIdentifier{Algorithm: oidAES256CBC, Parameters: asn1.RawValue{FullBytes: ivParam}},
+	})
+	if err != nil {
+		return pkix.AlgorithmIdentifier{}, nil, err
+	}
+
+	return pkix.AlgorithmIdentifier{Algorithm: oidPBES2, Parameters: asn1.RawValue{FullBytes: params}}, ciphertext, nil
+}
+
+type pbe3DESEncryption struct{}
+
+func (pbe3DESEncryption) macHash() func() hash.Hash { return sha1.New }
+
+func (pbe3DESEncryption) encrypt(plaintext []byte, password string) (pkix.AlgorithmIdentifier, []byte, error) {
+	salt := make([]byte, 8)
+	if _, err := rand.Read(salt); err != nil {
+		return pkix.AlgorithmIdentifier{}, nil, err
+	}
+
+	pw := pkcs12Password(password)
+	key := pkcs12KDF(sha1.New, pkcs12KeyMaterialKey, pw, salt, pfxIterations, 24)
+	iv := pkcs12KDF(sha1.New, pkcs12KeyMaterialIV, pw, salt, pfxIterations, des.BlockSize)
+	block, err := des.NewTripleDESCipher(key)
+	if err != nil {
+		return pkix.AlgorithmIdentifier{}, nil, err
+	}
+	ciphertext := cbcEncrypt(cipher.NewCBCEncrypter(block, iv), plaintext)
+
+	params, err := asn1.Marshal(pbeParams{Salt: salt, Iterations: pfxIterations})
+	if err != nil {
+		return pkix.AlgorithmIdentifier{}, nil, err
+	}
+	return pkix.AlgorithmIdentifier{Algorithm: oidPBEWithSHA3DES, Parameters: asn1.RawValue{FullBytes: params}}, ciphertext, nil
+}
+
+// cbcEncrypt pads plaintext as in PKCS#7 and encrypts it
+func cbcEncrypt(mode cipher.BlockMode, plaintext []byte) []byte {
+	size := mode.BlockSize()
+	padding := size - len(plaintext)%size
+	padded := append(append([]byte{}, plaintext...), bytes.Repeat([]byte{byte(padding)}, padding)...)
+	mode.CryptBlocks(padded, padded)
+	return padded
+}
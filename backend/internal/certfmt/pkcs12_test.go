@@ -0,0 +1,185 @@
+package certfmt
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// testChain returns a key and its certificate followed by an intermediate
+// and a root
+func testChain(t *testing.T) (*ecdsa.PrivateKey, []*x509.Certificate) {
+	t.Helper()
+
+	var chain []*x509.Certificate
+	var parent *x509.Certificate
+	var parentKey *ecdsa.PrivateKey
+	for i, cn := range []string{"Test Root", "Test Intermediate", "leaf.example.com"} {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tmpl := &x509.Certificate{
+			SerialNumber:          big.NewInt(int64(i + 1)),
+			Subject:               pkix.Name{CommonName: cn},
+			NotBefore:             time.Now().Add(-time.Hour),
+			NotAfter:              time.Now().Add(time.Hour),
+			BasicConstraintsValid: true,
+			IsCA:                  i < 2,
+		}
+		if parent == nil {
+			parent, parentKey = tmpl, key
+		}
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		chain = append([]*x509.Certificate{cert}, chain...)
+		parent, parentKey = cert, key
+	}
+	return parentKey, chain
+}
+
+func TestPFXRoundTrip(t *testing.T) {
+	key, chain := testChain(t)
+
+	tests := []struct {
+		mode    string
+		macAlg  asn1.ObjectIdentifier
+		certAlg asn1.ObjectIdentifier
+	}{
+		{PFXModern, oidSHA256, oidPBES2},
+		{PFXLegacy, oidSHA1, oidPBEWithSHA3DES},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			pfx, err := PFX(key, chain, "secret-pw", tt.mode, "web server")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			gotKey, leaf, caCerts, err := pkcs12.DecodeChain(pfx, "secret-pw")
+			if err != nil {
+				t.Fatalf("DecodeChain: %v", err)
+			}
+			if !key.Equal(gotKey) {
+				t.Error("decoded key differs")
+			}
+			if !leaf.Equal(chain[0]) {
+				t.Errorf("leaf is %s, want %s", leaf.Subject.CommonName, chain[0].Subject.CommonName)
+			}
+			if len(caCerts) != 2 {
+				t.Fatalf("got %d CA certificates, want 2", len(caCerts))
+			}
+			for i, cert := range caCerts {
+				if !cert.Equal(chain[i+1]) {
+					t.Errorf("CA certificate %d is %s, want %s", i, cert.Subject.CommonName, chain[i+1].Subject.CommonName)
+				}
+			}
+
+			var pdu pfxPDU
+			if _, err := asn1.Unmarshal(pfx, &pdu); err != nil {
+				t.Fatal(err)
+			}
+			if alg := pdu.MacData.Mac.Algorithm.Algorithm; !alg.Equal(tt.macAlg) {
+				t.Errorf("MAC algorithm is %s, want %s", alg, tt.macAlg)
+			}
+			if alg := encryptedCertsAlgorithm(t, pdu); !alg.Equal(tt.certAlg) {
+				t.Errorf("certificate encryption is %s, want %s", alg, tt.certAlg)
+			}
+		})
+	}
+}
+
+// encryptedCertsAlgorithm returns the algorithm encrypting the certificates
+// of a PFX built by PFX
+func encryptedCertsAlgorithm(t *testing.T, pdu pfxPDU) asn1.ObjectIdentifier {
+	t.Helper()
+
+	var authSafeData []byte
+	if _, err := asn1.Unmarshal(pdu.AuthSafe.Content.Bytes, &authSafeData); err != nil {
+		t.Fatal(err)
+	}
+	var authSafe []contentInfo
+	if _, err := asn1.Unmarshal(authSafeData, &authSafe); err != nil {
+		t.Fatal(err)
+	}
+	var ed encryptedData
+	if _, err := asn1.Unmarshal(authSafe[0].Content.Bytes, &ed); err != nil {
+		t.Fatal(err)
+	}
+	return ed.EncryptedContentInfo.ContentEncryptionAlgorithm.Algorithm
+}
+
+func TestPFXFriendlyName(t *testing.T) {
+	key, chain := testChain(t)
+
+	for _, mode := range []string{PFXModern, PFXLegacy} {
+		t.Run(mode, func(t *testing.T) {
+			pfx, err := PFX(key, chain[:1], "secret-pw", mode, "Wëb Server")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// ToPEM exposes bag attributes but only takes a key and one certificate
+			blocks, err := pkcs12.ToPEM(pfx, "secret-pw")
+			if err != nil {
+				t.Fatalf("ToPEM: %v", err)
+			}
+			if len(blocks) != 2 {
+				t.Fatalf("got %d blocks, want 2", len(blocks))
+			}
+			for _, block := range blocks {
+				if got := block.Headers["friendlyName"]; got != "Wëb Server" {
+					t.Errorf("%s friendly name is %q", block.Type, got)
+				}
+				if block.Headers["localKeyId"] == "" {
+					t.Errorf("%s has no local key ID", block.Type)
+				}
+			}
+			if blocks[0].Headers["localKeyId"] != blocks[1].Headers["localKeyId"] {
+				t.Error("key and certificate local key IDs differ")
+			}
+		})
+	}
+}
+
+func TestPFXWrongPassword(t *testing.T) {
+	key, chain := testChain(t)
+
+	for _, mode := range []string{PFXModern, PFXLegacy} {
+		t.Run(mode, func(t *testing.T) {
+			pfx, err := PFX(key, chain, "secret-pw", mode, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, _, _, err := pkcs12.DecodeChain(pfx, "wrong-pw"); !errors.Is(err, pkcs12.ErrIncorrectPassword) {
+				t.Errorf("DecodeChain with the wrong password returned %v", err)
+			}
+		})
+	}
+}
+
+func TestPFXErrors(t *testing.T) {
+	key, chain := testChain(t)
+
+	if _, err := PFX(key, nil, "secret-pw", PFXModern, ""); err == nil {
+		t.Error("PFX without certificates succeeded")
+	}
+	if _, err := PFX(key, chain, "secret-pw", "rc2", ""); err == nil {
+		t.Error("PFX with an unknown mode succeeded")
+	}
+}
@@ -0,0 +1,50 @@
+package certfmt
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"testing"
+)
+
+func TestPKCS7(t *testing.T) {
+	_, chain := testChain(t)
+
+	der, err := PKCS7(chain)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ci contentInfo
+	if rest, err := asn1.Unmarshal(der, &ci); err != nil || len(rest) != 0 {
+		t.Fatalf("failed to parse content info: %v", err)
+	}
+	if !ci.ContentType.Equal(oidSignedData) {
+		t.Fatalf("content type is %s, want signedData", ci.ContentType)
+	}
+	var sd signedData
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, &sd); err != nil {
+		t.Fatalf("failed to parse signed data: %v", err)
+	}
+	if len(sd.SignerInfos.Bytes) != 0 {
+		t.Error("certificates-only SignedData has signer infos")
+	}
+
+	certs, err := x509.ParseCertificates(sd.Certificates.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(certs) != len(chain) {
+		t.Fatalf("got %d certificates, want %d", len(certs), len(chain))
+	}
+	for i, cert := range certs {
+		if !cert.Equal(chain[i]) {
+			t.Errorf("certificate %d is %s, want %s", i, cert.Subject.CommonName, chain[i].Subject.CommonName)
+		}
+	}
+}
+
+func TestPKCS7Empty(t *testing.T) {
+	if _, err := PKCS7(nil); err == nil {
+		t.Error("PKCS7 without certificates succeeded")
+	}
+}
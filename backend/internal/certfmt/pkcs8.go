@@ -0,0 +1,34 @@
+package certfmt
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"fmt"
+)
+
+// MinKeyPasswordLen matches the minimum OpenSSL accepts for key passphrases
+const MinKeyPasswordLen = 4
+
+// EncryptedPKCS8 returns key as an "ENCRYPTED PRIVATE KEY" PEM block,
+// encrypted with AES-256-CBC under PBES2 with PBKDF2-HMAC-SHA-256. OpenSSL,
+// Java and most TLS servers read it given the passphrase.
+func EncryptedPKCS8(key interface{}, password string) ([]byte, error) {
+	if len(password) < MinKeyPasswordLen {
+		return nil, fmt.Errorf("key password must be at least %d characters", MinKeyPasswordLen)
+	}
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode private key: %w", err)
+	}
+	alg, ciphertext, err := pbes2Encryption{}.encrypt(keyDER, password)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt private key: %w", err)
+	}
+	der, err := asn1.Marshal(encryptedPrivateKeyInfo{Algorithm: alg, EncryptedData: ciphertext})
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "ENCRYPTED PRIVATE KEY", Bytes: der}), nil
+}
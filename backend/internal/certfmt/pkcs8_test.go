@@ -0,0 +1,89 @@
+package certfmt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"testing"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// decryptPKCS8 decrypts an "ENCRYPTED PRIVATE KEY" block written by
+// EncryptedPKCS8, checking the algorithms on the way
+func decryptPKCS8(t *testing.T, data []byte, password string) (interface{}, error) {
+	t.Helper()
+
+	block, rest := pem.Decode(data)
+	if block == nil || block.Type != "ENCRYPTED PRIVATE KEY" || len(rest) != 0 {
+		t.Fatalf("unexpected PEM output %q", data)
+	}
+	var info encryptedPrivateKeyInfo
+	if _, err := asn1.Unmarshal(block.Bytes, &info); err != nil {
+		t.Fatal(err)
+	}
+	if !info.Algorithm.Algorithm.Equal(oidPBES2) {
+		t.Fatalf("encryption is %s, want PBES2", info.Algorithm.Algorithm)
+	}
+	var params pbes2Params
+	if _, err := asn1.Unmarshal(info.Algorithm.Parameters.FullBytes, &params); err != nil {
+		t.Fatal(err)
+	}
+	var kdf pbkdf2Params
+	if _, err := asn1.Unmarshal(params.KeyDerivationFunc.Parameters.FullBytes, &kdf); err != nil {
+		t.Fatal(err)
+	}
+	if !params.KeyDerivationFunc.Algorithm.Equal(oidPBKDF2) || !kdf.PRF.Algorithm.Equal(oidHMACWithSHA256) {
+		t.Fatalf("key derivation is %s with %s, want PBKDF2 with HMAC-SHA-256", params.KeyDerivationFunc.Algorithm, kdf.PRF.Algorithm)
+	}
+	if !params.EncryptionScheme.Algorithm.Equal(oidAES256CBC) {
+		t.Fatalf("cipher is %s, want AES-256-CBC", params.EncryptionScheme.Algorithm)
+	}
+	var iv []byte
+	if _, err := asn1.Unmarshal(params.EncryptionScheme.Parameters.FullBytes, &iv); err != nil {
+		t.Fatal(err)
+	}
+
+	key := pbkdf2.Key([]byte(password), kdf.Salt, kdf.Iterations, 32, sha256.New)
+	c, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext := make([]byte, len(info.EncryptedData))
+	cipher.NewCBCDecrypter(c, iv).CryptBlocks(plaintext, info.EncryptedData)
+	if padding := int(plaintext[len(plaintext)-1]); padding > 0 && padding <= aes.BlockSize {
+		plaintext = plaintext[:len(plaintext)-padding]
+	}
+	return x509.ParsePKCS8PrivateKey(plaintext)
+}
+
+func TestEncryptedPKCS8RoundTrip(t *testing.T) {
+	key, _ := testChain(t)
+
+	data, err := EncryptedPKCS8(key, "passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := decryptPKCS8(t, data, "passphrase")
+	if err != nil {
+		t.Fatalf("failed to decrypt key: %v", err)
+	}
+	if !key.Equal(got) {
+		t.Error("decrypted key differs")
+	}
+
+	if got, err := decryptPKCS8(t, data, "wrong-passphrase"); err == nil && key.Equal(got) {
+		t.Error("key decrypted with the wrong passphrase")
+	}
+}
+
+func TestEncryptedPKCS8ShortPassword(t *testing.T) {
+	key, _ := testChain(t)
+
+	if _, err := EncryptedPKCS8(key, "abc"); err == nil {
+		t.Errorf("EncryptedPKCS8 accepted a password shorter than %d characters", MinKeyPasswordLen)
+	}
+}
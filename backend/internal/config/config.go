@@ -1,16 +1,68 @@
 package config
 
 import (
+	"log/slog"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 )
 
 type Config struct {
 	CAURL               string
 	CARootFingerprint   string
+	RootCheckInterval   time.Duration
+	ProvisionerType     string
 	ProvisionerName     string
 	ProvisionerPassword string
+	ProvisionerPassFile string
+	ProvisionerCert     string
+	ProvisionerKey      string
+	ProvisionerToken    string
 	DBPath              string
+	ProfilesFile        string
+	PolicyFile          string
+	APIKeysFile         string
+	ApprovalFile        string
+	TrustProxyAuth      bool
+	MinCertLifetime     time.Duration
+	MaxCertLifetime     time.Duration
+	MaxCertBackdate     time.Duration
+	DeploySSHKeyFile    string
+	DeployKnownHosts    string
+	DeployKubeconfig    string
+	DeployFileRoots     []string
+	DeployLocalCommands []string
+	DeploySSHCommands   []string
+	DeployTimeout       time.Duration
+	DeployRoles         []string
+	StorageBackend      string
+	VaultAddr           string
+	VaultNamespace      string
+	VaultCACert         string
+	VaultToken          string
+	VaultRoleID         string
+	VaultSecretID       string
+	VaultAppRoleMount   string
+	VaultKVMount        string
+	VaultKVPath         string
+	CADBType            string
+	CADBDataSource      string
+	CADBName            string
+	ACMESyncInterval    time.Duration
+	OCSPURL             string
+	RevocationInterval  time.Duration
+	CRLSigningCert      string
+	CRLSigningKey       string
+	CRLName             string
+	CRLValidity         time.Duration
+	CRLPublishInterval  time.Duration
+	StepAdminCert       string
+	StepAdminKey        string
+	ProvisionerRoles    []string
+	LogLevel            string
+	MetricsToken        string
+	OTLPEndpoint        string
 	Port                int
 }
 
@@ -20,9 +72,58 @@ func Load() *Config {
 	return &Config{
 		CAURL:               getEnv("CA_URL", ""),
 		CARootFingerprint:   getEnv("CA_ROOT_FINGERPRINT", ""),
+		RootCheckInterval:   getDuration("CA_ROOT_CHECK_INTERVAL", "1h"),
+		ProvisionerType:     getEnv("PROVISIONER_TYPE", "jwk"),
 		ProvisionerName:     getEnv("PROVISIONER_NAME", "ui-admin"),
 		ProvisionerPassword: getEnv("PROVISIONER_PASSWORD", ""),
+		ProvisionerPassFile: getEnv("PROVISIONER_PASSWORD_FILE", ""),
+		ProvisionerCert:     getEnv("PROVISIONER_CERT", ""),
+		ProvisionerKey:      getEnv("PROVISIONER_KEY", ""),
+		ProvisionerToken:    getEnv("PROVISIONER_TOKEN_FILE", ""),
 		DBPath:              getEnv("DB_PATH", "./data/certs.db"),
+		ProfilesFile:        getEnv("PROFILES_FILE", ""),
+		PolicyFile:          getEnv("POLICY_FILE", ""),
+		APIKeysFile:         getEnv("API_KEYS_FILE", ""),
+		ApprovalFile:        getEnv("APPROVAL_FILE", ""),
+		TrustProxyAuth:      getEnv("TRUST_PROXY_AUTH", "false") == "true",
+		MinCertLifetime:     getDuration("CERT_MIN_LIFETIME", "5m"),
+		MaxCertLifetime:     getDuration("CERT_MAX_LIFETIME", "397d"),
+		MaxCertBackdate:     getDuration("CERT_MAX_BACKDATE", "24h"),
+		DeploySSHKeyFile:    getEnv("DEPLOY_SSH_KEY_FILE", ""),
+		DeployKnownHosts:    getEnv("DEPLOY_SSH_KNOWN_HOSTS", ""),
+		DeployKubeconfig:    getEnv("DEPLOY_KUBECONFIG", ""),
+		DeployFileRoots:     getList("DEPLOY_FILE_ROOTS", ","),
+		DeployLocalCommands: getList("DEPLOY_LOCAL_COMMANDS", ";"),
+		DeploySSHCommands:   getList("DEPLOY_SSH_COMMANDS", ";"),
+		DeployTimeout:       getDuration("DEPLOY_TIMEOUT", "2m"),
+		DeployRoles:         getList("DEPLOY_ROLES", ","),
+		StorageBackend:      getEnv("STORAGE_BACKEND", "ephemeral"),
+		VaultAddr:           getEnv("VAULT_ADDR", ""),
+		VaultNamespace:      getEnv("VAULT_NAMESPACE", ""),
+		VaultCACert:         getEnv("VAULT_CACERT", ""),
+		VaultToken:          getEnv("VAULT_TOKEN", ""),
+		VaultRoleID:         getEnv("VAULT_ROLE_ID", ""),
+		VaultSecretID:       getEnv("VAULT_SECRET_ID", ""),
+		VaultAppRoleMount:   getEnv("VAULT_APPROLE_MOUNT", "approle"),
+		VaultKVMount:        getEnv("VAULT_KV_MOUNT", "secret"),
+		VaultKVPath:         getEnv("VAULT_KV_PATH", "step-ca-webui"),
+		CADBType:            getEnv("CA_DB_TYPE", ""),
+		CADBDataSource:      getEnv("CA_DB_DATASOURCE", ""),
+		CADBName:            getEnv("CA_DB_NAME", ""),
+		ACMESyncInterval:    getDuration("ACME_SYNC_INTERVAL", "5m"),
+		OCSPURL:             getEnv("OCSP_URL", ""),
+		RevocationInterval:  getDuration("REVOCATION_CHECK_INTERVAL", "1h"),
+		CRLSigningCert:      getEnv("CRL_SIGNING_CERT", ""),
+		CRLSigningKey:       getEnv("CRL_SIGNING_KEY", ""),
+		CRLName:             getEnv("CRL_NAME", "ca"),
+		CRLValidity:         getDuration("CRL_VALIDITY", "24h"),
+		CRLPublishInterval:  getDuration("CRL_PUBLISH_INTERVAL", "1h"),
+		StepAdminCert:       getEnv("STEP_ADMIN_CERT", ""),
+		StepAdminKey:        getEnv("STEP_ADMIN_KEY", ""),
+		ProvisionerRoles:    getList("PROVISIONER_ADMIN_ROLES", ","),
+		LogLevel:            getEnv("LOG_LEVEL", "info"),
+		MetricsToken:        getEnv("METRICS_TOKEN", ""),
+		OTLPEndpoint:        getEnv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", getEnv("OTEL_EXPORTER_OTLP_ENDPOINT", "")),
 		Port:                port,
 	}
 }
@@ -33,3 +134,38 @@ func getEnv(key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+// getList splits an environment variable into its non-empty trimmed items
+func getList(key, sep string) []string {
+	var items []string
+	for _, item := range strings.Split(getEnv(key, ""), sep) {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// getDuration reads a Go duration from the environment, additionally
+// accepting a "d" suffix for whole days
+func getDuration(key, defaultValue string) time.Duration {
+	value := getEnv(key, defaultValue)
+	d, err := ParseDuration(value)
+	if err != nil {
+		slog.Warn("Invalid duration, using the default", "variable", key, "value", value, "default", defaultValue)
+		d, _ = ParseDuration(defaultValue)
+	}
+	return d
+}
+
+// ParseDuration parses a Go duration string or a number of days such as "90d"
+func ParseDuration(value string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(value)
+}
@@ -1,11 +1,22 @@
 package db
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
+	"step-ca-webui/internal/tracing"
+
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
 )
 
 type Database struct {
@@ -19,36 +30,85 @@ func NewDatabase(dbPath string) (*Database, error) {
 		return nil, err
 	}
 
-	db, err := gorm.Open(sqlite.Open(dbPath), &gorm.Config{})
+	db, err := gorm.Open(sqlite.Open(dbPath), &gorm.Config{
+		// Slow queries and errors go to the structured log, without the
+		// values of the query, which can hold request payloads
+		Logger: logger.New(slogWriter{}, logger.Config{
+			SlowThreshold:             200 * time.Millisecond,
+			LogLevel:                  logger.Warn,
+			IgnoreRecordNotFoundError: true,
+			ParameterizedQueries:      true,
+		}),
+	})
 	if err != nil {
 		return nil, err
 	}
 
 	// Auto-migrate the schema
-	if err := db.AutoMigrate(&Certificate{}, &AuditEvent{}, &CASettings{}); err != nil {
+	if err := db.AutoMigrate(&Certificate{}, &AuditEvent{}, &CASettings{}, &IssuanceRequest{}, &DeployTarget{}, &Deployment{}, &Revocation{}); err != nil {
 		return nil, err
 	}
 
 	return &Database{DB: db}, nil
 }
 
-func (d *Database) CreateCertificate(cert *Certificate) error {
-	return d.DB.Create(cert).Error
+// slogWriter passes gorm's log lines to slog
+type slogWriter struct{}
+
+func (slogWriter) Printf(format string, args ...interface{}) {
+	slog.Warn("Database: " + strings.TrimSpace(fmt.Sprintf(format, args...)))
+}
+
+// trace starts a span for the Database method named method and returns the
+// session to query with and a function that ends the span with the error of
+// the method. Missing records are an answer, not a failure of the span.
+// Queries outside a traced request or job, such as metrics scrapes, get no
+// span.
+func (d *Database) trace(ctx context.Context, method string) (*gorm.DB, func(error) error) {
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		return d.DB.WithContext(ctx), func(err error) error { return err }
+	}
+	ctx, span := tracing.Start(ctx, "Database."+method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemSqlite, semconv.DBOperationName(method)))
+	return d.DB.WithContext(ctx), func(err error) error {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			tracing.End(span, nil)
+		} else {
+			tracing.End(span, err)
+		}
+		return err
+	}
 }
 
-func (d *Database) GetCertificate(id string) (*Certificate, error) {
+func (d *Database) CreateCertificate(ctx context.Context, cert *Certificate) error {
+	db, end := d.trace(ctx, "CreateCertificate")
+	return end(db.Create(cert).Error)
+}
+
+func (d *Database) GetCertificate(ctx context.Context, id string) (*Certificate, error) {
+	db, end := d.trace(ctx, "GetCertificate")
 	var cert Certificate
-	err := d.DB.Where("id = ?", id).First(&cert).Error
-	return &cert, err
+	err := db.Where("id = ?", id).First(&cert).Error
+	return &cert, end(err)
 }
 
-func (d *Database) ListCertificates(limit, offset int, status string) ([]Certificate, error) {
+func (d *Database) ListCertificates(ctx context.Context, limit, offset int, status, certType, acmeAccount string) ([]Certificate, error) {
+	db, end := d.trace(ctx, "ListCertificates")
 	var certs []Certificate
-	query := d.DB.Order("created_at DESC")
+	query := db.Order("created_at DESC")
 	
 	if status != "" {
 		query = query.Where("status = ?", status)
 	}
+
+	if certType != "" {
+		query = query.Where("cert_type = ?", certType)
+	}
+
+	if acmeAccount != "" {
+		query = query.Where("acme_account = ?", acmeAccount)
+	}
 	
 	if limit > 0 {
 		query = query.Limit(limit)
@@ -59,24 +119,37 @@ func (d *Database) ListCertificates(limit, offset int, status string) ([]Certifi
 	}
 	
 	err := query.Find(&certs).Error
-	return certs, err
+	return certs, end(err)
+}
+
+// ListACMECertificates returns the certificates imported from the ACME
+// data of the CA
+func (d *Database) ListACMECertificates(ctx context.Context) ([]Certificate, error) {
+	db, end := d.trace(ctx, "ListACMECertificates")
+	var certs []Certificate
+	err := db.Where("key_strategy = ?", "acme").Find(&certs).Error
+	return certs, end(err)
 }
 
-func (d *Database) UpdateCertificate(cert *Certificate) error {
-	return d.DB.Save(cert).Error
+func (d *Database) UpdateCertificate(ctx context.Context, cert *Certificate) error {
+	db, end := d.trace(ctx, "UpdateCertificate")
+	return end(db.Save(cert).Error)
 }
 
-func (d *Database) DeleteCertificate(id string) error {
-	return d.DB.Where("id = ?", id).Delete(&Certificate{}).Error
+func (d *Database) DeleteCertificate(ctx context.Context, id string) error {
+	db, end := d.trace(ctx, "DeleteCertificate")
+	return end(db.Where("id = ?", id).Delete(&Certificate{}).Error)
 }
 
-func (d *Database) LogAuditEvent(event *AuditEvent) error {
-	return d.DB.Create(event).Error
+func (d *Database) LogAuditEvent(ctx context.Context, event *AuditEvent) error {
+	db, end := d.trace(ctx, "LogAuditEvent")
+	return end(db.Create(event).Error)
 }
 
-func (d *Database) GetAuditEvents(certID string, limit int) ([]AuditEvent, error) {
+func (d *Database) GetAuditEvents(ctx context.Context, certID string, limit int) ([]AuditEvent, error) {
+	db, end := d.trace(ctx, "GetAuditEvents")
 	var events []AuditEvent
-	query := d.DB.Order("timestamp DESC")
+	query := db.Order("timestamp DESC")
 	
 	if certID != "" {
 		query = query.Where("cert_id = ?", certID)
@@ -87,5 +160,225 @@ func (d *Database) GetAuditEvents(certID string, limit int) ([]AuditEvent, error
 	}
 	
 	err := query.Find(&events).Error
-	return events, err
+	return events, end(err)
+}
+
+func (d *Database) CreateIssuanceRequest(ctx context.Context, req *IssuanceRequest) error {
+	db, end := d.trace(ctx, "CreateIssuanceRequest")
+	return end(db.Create(req).Error)
+}
+
+func (d *Database) GetIssuanceRequest(ctx context.Context, id string) (*IssuanceRequest, error) {
+	db, end := d.trace(ctx, "GetIssuanceRequest")
+	var req IssuanceRequest
+	err := db.Where("id = ?", id).First(&req).Error
+	return &req, end(err)
+}
+
+func (d *Database) ListIssuanceRequests(ctx context.Context, limit, offset int, status string) ([]IssuanceRequest, error) {
+	db, end := d.trace(ctx, "ListIssuanceRequests")
+	var reqs []IssuanceRequest
+	query := db.Order("created_at DESC")
+
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+
+	if offset > 0 {
+		query = query.Offset(offset)
+	}
+
+	err := query.Find(&reqs).Error
+	return reqs, end(err)
+}
+
+// DecideIssuanceRequest moves a pending request to a new status. It fails
+// with gorm.ErrRecordNotFound if the request is no longer pending, so
+// concurrent approvals cannot both succeed.
+func (d *Database) DecideIssuanceRequest(ctx context.Context, req *IssuanceRequest, status, decidedBy, reason string) error {
+	db, end := d.trace(ctx, "DecideIssuanceRequest")
+	now := time.Now()
+	result := db.Model(&IssuanceRequest{}).
+		Where("id = ? AND status = ?", req.ID, "pending").
+		Updates(map[string]interface{}{
+			"status":     status,
+			"decided_by": decidedBy,
+			"reason":     reason,
+			"decided_at": now,
+			"updated_at": now,
+		})
+	if result.Error != nil {
+		return end(result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return end(gorm.ErrRecordNotFound)
+	}
+
+	req.Status = status
+	req.DecidedBy = decidedBy
+	req.Reason = reason
+	req.DecidedAt = &now
+	req.UpdatedAt = now
+	return end(nil)
+}
+
+func (d *Database) UpdateIssuanceRequest(ctx context.Context, req *IssuanceRequest) error {
+	db, end := d.trace(ctx, "UpdateIssuanceRequest")
+	return end(db.Save(req).Error)
+}
+
+// ListExpiredIssuanceRequests returns pending requests past their expiry
+func (d *Database) ListExpiredIssuanceRequests(ctx context.Context, now time.Time) ([]IssuanceRequest, error) {
+	db, end := d.trace(ctx, "ListExpiredIssuanceRequests")
+	var reqs []IssuanceRequest
+	err := db.Where("status = ? AND expires_at <= ?", "pending", now).Find(&reqs).Error
+	return reqs, end(err)
+}
+
+// GetCASettings returns the stored CA settings row
+func (d *Database) GetCASettings(ctx context.Context) (*CASettings, error) {
+	db, end := d.trace(ctx, "GetCASettings")
+	var settings CASettings
+	err := db.Order("id").First(&settings).Error
+	if err != nil {
+		return nil, end(err)
+	}
+	return &settings, end(nil)
+}
+
+func (d *Database) SaveCASettings(ctx context.Context, settings *CASettings) error {
+	db, end := d.trace(ctx, "SaveCASettings")
+	return end(db.Save(settings).Error)
+}
+
+func (d *Database) CreateDeployTarget(ctx context.Context, target *DeployTarget) error {
+	db, end := d.trace(ctx, "CreateDeployTarget")
+	return end(db.Create(target).Error)
+}
+
+func (d *Database) GetDeployTarget(ctx context.Context, certID, id string) (*DeployTarget, error) {
+	db, end := d.trace(ctx, "GetDeployTarget")
+	var target DeployTarget
+	err := db.Where("id = ? AND cert_id = ?", id, certID).First(&target).Error
+	return &target, end(err)
+}
+
+// ListDeployTargets returns the targets of a certificate, optionally only
+// the enabled ones
+func (d *Database) ListDeployTargets(ctx context.Context, certID string, enabledOnly bool) ([]DeployTarget, error) {
+	db, end := d.trace(ctx, "ListDeployTargets")
+	var targets []DeployTarget
+	query := db.Where("cert_id = ?", certID).Order("created_at")
+	if enabledOnly {
+		query = query.Where("enabled = ?", true)
+	}
+	err := query.Find(&targets).Error
+	return targets, end(err)
+}
+
+// ListDeployTargetsByType returns the targets of a type across all
+// certificates
+func (d *Database) ListDeployTargetsByType(ctx context.Context, targetType string) ([]DeployTarget, error) {
+	db, end := d.trace(ctx, "ListDeployTargetsByType")
+	var targets []DeployTarget
+	err := db.Where("type = ?", targetType).Order("created_at").Find(&targets).Error
+	return targets, end(err)
+}
+
+func (d *Database) UpdateDeployTarget(ctx context.Context, target *DeployTarget) error {
+	db, end := d.trace(ctx, "UpdateDeployTarget")
+	return end(db.Save(target).Error)
+}
+
+func (d *Database) DeleteDeployTarget(ctx context.Context, target *DeployTarget) error {
+	db, end := d.trace(ctx, "DeleteDeployTarget")
+	return end(db.Delete(target).Error)
+}
+
+func (d *Database) CreateDeployment(ctx context.Context, deployment *Deployment) error {
+	db, end := d.trace(ctx, "CreateDeployment")
+	return end(db.Create(deployment).Error)
+}
+
+func (d *Database) UpdateDeployment(ctx context.Context, deployment *Deployment) error {
+	db, end := d.trace(ctx, "UpdateDeployment")
+	return end(db.Save(deployment).Error)
+}
+
+func (d *Database) ListDeployments(ctx context.Context, certID string, limit int) ([]Deployment, error) {
+	db, end := d.trace(ctx, "ListDeployments")
+	var deployments []Deployment
+	query := db.Where("cert_id = ?", certID).Order("created_at DESC")
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+	err := query.Find(&deployments).Error
+	return deployments, end(err)
+}
+
+// FailInterruptedDeployments marks deployments that were still pending or
+// running when the backend stopped as failed
+func (d *Database) FailInterruptedDeployments(ctx context.Context) error {
+	db, end := d.trace(ctx, "FailInterruptedDeployments")
+	now := time.Now()
+	return end(db.Model(&Deployment{}).
+		Where("status IN ?", []string{"pending", "running"}).
+		Updates(map[string]interface{}{
+			"status":      "failed",
+			"error":       "interrupted by a backend restart",
+			"finished_at": now,
+		}).Error)
+}
+
+// CreateRevocation records a revocation. A serial revoked again keeps its
+// first revocation time.
+func (d *Database) CreateRevocation(ctx context.Context, revocation *Revocation) error {
+	db, end := d.trace(ctx, "CreateRevocation")
+	return end(db.Where("serial = ?", revocation.Serial).FirstOrCreate(revocation).Error)
+}
+
+// ListRevocations returns the revocations of certificates still valid at t
+func (d *Database) ListRevocations(ctx context.Context, t time.Time) ([]Revocation, error) {
+	db, end := d.trace(ctx, "ListRevocations")
+	var revocations []Revocation
+	err := db.Where("not_after > ?", t).Order("revoked_at").Find(&revocations).Error
+	return revocations, end(err)
+}
+
+// CountCertificatesByStatus counts the certificates per status. Active
+// certificates past their expiry at now count as expired.
+func (d *Database) CountCertificatesByStatus(ctx context.Context, now time.Time) (map[string]int64, error) {
+	db, end := d.trace(ctx, "CountCertificatesByStatus")
+	var rows []struct {
+		CertStatus string
+		Count      int64
+	}
+	err := db.Model(&Certificate{}).
+		Select("CASE WHEN status = ? AND not_after <= ? THEN ? ELSE status END AS cert_status, COUNT(*) AS count", "active", now, "expired").
+		Group("cert_status").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, end(err)
+	}
+	counts := make(map[string]int64, len(rows))
+	for _, row := range rows {
+		counts[row.CertStatus] = row.Count
+	}
+	return counts, end(nil)
+}
+
+// ListExpiringCertificates returns up to limit active certificates that are
+// still valid at now, soonest to expire first
+func (d *Database) ListExpiringCertificates(ctx context.Context, now time.Time, limit int) ([]Certificate, error) {
+	db, end := d.trace(ctx, "ListExpiringCertificates")
+	var certs []Certificate
+	err := db.Where("status = ? AND not_after > ?", "active", now).
+		Order("not_after").
+		Limit(limit).
+		Find(&certs).Error
+	return certs, end(err)
 }
@@ -6,12 +6,18 @@ import (
 
 type Certificate struct {
 	ID          string    `gorm:"primaryKey" json:"id"`
-	CN          string    `gorm:"index" json:"cn"`
-	SANs        string    `json:"sans"` // JSON array
+	CertType    string    `gorm:"index;default:x509" json:"cert_type"` // x509, ssh-user, ssh-host
+	CN          string    `gorm:"index" json:"cn"`                     // key ID for SSH certificates
+	SANs        string    `json:"sans"`                                // JSON array, principals for SSH certificates
+	Serial      string    `json:"serial"`
 	NotAfter    time.Time `gorm:"index" json:"not_after"`
 	Status      string    `json:"status"` // active, revoked, expired
-	KeyStrategy string    `json:"key_strategy"` // server, csr
-	StorageRef  string    `json:"storage_ref"` // ephemeral, or file path
+	KeyStrategy string    `json:"key_strategy"` // server, csr, public_key, acme
+	KeyType     string    `json:"key_type"`     // server-generated key type
+	Profile     string    `json:"profile"`
+	StorageRef  string    `json:"storage_ref"` // ephemeral, or vault:<mount>/<path>
+	ACMEAccount string    `gorm:"index" json:"acme_account"` // CA account ID of certificates issued through ACME
+	ACMEOrder   string    `json:"acme_order"`
 	OwnerUser   string    `json:"owner_user"`
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
@@ -26,11 +32,76 @@ type AuditEvent struct {
 	Timestamp time.Time `json:"timestamp"`
 }
 
+type IssuanceRequest struct {
+	ID           string     `gorm:"primaryKey" json:"id"`
+	Kind         string     `json:"kind"` // issue, sign_csr, renew
+	CN           string     `json:"cn"`
+	SANs         string     `json:"sans"` // JSON array
+	Profile      string     `json:"profile"`
+	Payload      string     `json:"-"`      // JSON of the original request without secrets
+	Status       string     `gorm:"index" json:"status"` // pending, approved, rejected, expired, failed
+	MatchedRules string     `json:"matched_rules"` // JSON array
+	RequestedBy  string     `json:"requested_by"`
+	RequesterRoles string   `json:"-"` // JSON array, the naming policy is checked again on approval
+	RequesterKey string     `json:"-"` // name of the requester's API key
+	DecidedBy    string     `json:"decided_by"`
+	Reason       string     `json:"reason"`
+	CertID       string     `json:"cert_id"`
+	ExpiresAt    time.Time  `gorm:"index" json:"expires_at"`
+	DecidedAt    *time.Time `json:"decided_at"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+}
+
 type CASettings struct {
-	ID           uint   `gorm:"primaryKey" json:"id"`
-	CAURL        string `json:"ca_url"`
-	RootFingerprint string `json:"root_fingerprint"`
-	ACMEDirectories []string `gorm:"type:text" json:"acme_directories"` // JSON array
-	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at"`
+	ID              uint      `gorm:"primaryKey" json:"id"`
+	CAURL           string    `json:"ca_url"`
+	RootFingerprint string    `json:"root_fingerprint"` // pinned root, SHA-256 hex
+	RootSubject     string    `json:"root_subject"`
+	RootNotBefore   time.Time `json:"root_not_before"`
+	RootNotAfter    time.Time `json:"root_not_after"`
+	RootPEM         string    `json:"-"`
+	RootCheckedAt   time.Time `json:"root_checked_at"`
+	ACMEDirectories []string  `gorm:"type:text;serializer:json" json:"acme_directories"` // JSON array
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+// DeployTarget is a place an issued certificate is delivered to whenever it
+// is issued or renewed
+type DeployTarget struct {
+	ID        string    `gorm:"primaryKey" json:"id"`
+	CertID    string    `gorm:"index" json:"cert_id"`
+	Name      string    `json:"name"`
+	Type      string    `json:"type"`   // sftp, kubernetes, filesystem
+	Config    string    `json:"config"` // JSON of the type specific settings, no secrets
+	Enabled   bool      `json:"enabled"`
+	CreatedBy string    `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Deployment records one delivery of a certificate to a target
+type Deployment struct {
+	ID         string     `gorm:"primaryKey" json:"id"`
+	TargetID   string     `gorm:"index" json:"target_id"`
+	CertID     string     `gorm:"index" json:"cert_id"`
+	Trigger    string     `json:"trigger"`             // issued, renewed
+	Status     string     `gorm:"index" json:"status"` // pending, running, succeeded, failed
+	Serial     string     `json:"serial"`              // serial of the delivered certificate
+	Output     string     `json:"output"`              // progress and command output
+	Error      string     `json:"error"`
+	StartedAt  *time.Time `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// Revocation records a certificate revoked through the backend, listed on
+// the CRL it publishes until the certificate expires
+type Revocation struct {
+	Serial    string    `gorm:"primaryKey" json:"serial"` // upper case hex
+	CertID    string    `gorm:"index" json:"cert_id"`
+	NotAfter  time.Time `gorm:"index" json:"not_after"`
+	RevokedBy string    `json:"revoked_by"`
+	RevokedAt time.Time `json:"revoked_at"`
 }
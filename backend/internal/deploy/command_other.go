@@ -0,0 +1,9 @@
+//go:build !unix
+
+package deploy
+
+import "os/exec"
+
+// killProcessGroup is a no-op where process groups are not available; only
+// the shell itself is killed on timeout
+func killProcessGroup(cmd *exec.Cmd) {}
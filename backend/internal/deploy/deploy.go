@@ -0,0 +1,149 @@
+package deploy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Target types
+const (
+	TypeSFTP       = "sftp"
+	TypeKubernetes = "kubernetes"
+	TypeFilesystem = "filesystem"
+)
+
+// Statuses of a deployment
+const (
+	StatusPending   = "pending"
+	StatusRunning   = "running"
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+)
+
+// maxOutput caps the command output kept on a deployment record
+const maxOutput = 64 * 1024
+
+// Files are the PEM files of an issued certificate. Key is empty for
+// certificates signed from a CSR.
+type Files struct {
+	Cert      []byte
+	Chain     []byte
+	FullChain []byte
+	Key       []byte
+	Root      []byte
+	Serial    string
+	NotAfter  time.Time
+}
+
+// Deployer delivers certificate files to one target. It returns the output
+// of any commands it ran, also when it fails.
+type Deployer interface {
+	Deploy(ctx context.Context, files *Files) (string, error)
+}
+
+// Options are the backend-wide settings shared by all targets of a type
+type Options struct {
+	SSHKeyFile    string        // private key used for every SFTP target
+	SSHKnownHosts string        // known_hosts file checked when a target pins no host key
+	Kubeconfig    string        // kubeconfig for Kubernetes targets, in-cluster credentials when empty
+	FileRoots     []string      // directories filesystem targets may write below
+	LocalCommands []string      // post commands filesystem targets may run
+	SSHCommands   []string      // post commands SFTP targets may run on their hosts
+	Timeout       time.Duration // default limit for one deployment
+}
+
+// New parses the JSON settings of a target and returns its deployer. It is
+// also used to validate targets before they are stored.
+func New(targetType string, config []byte, opts Options) (Deployer, error) {
+	switch targetType {
+	case TypeSFTP:
+		var cfg SFTPConfig
+		if err := decodeConfig(config, &cfg); err != nil {
+			return nil, err
+		}
+		return newSFTPDeployer(cfg, opts)
+	case TypeKubernetes:
+		var cfg KubernetesConfig
+		if err := decodeConfig(config, &cfg); err != nil {
+			return nil, err
+		}
+		return newKubernetesDeployer(cfg, opts)
+	case TypeFilesystem:
+		var cfg FilesystemConfig
+		if err := decodeConfig(config, &cfg); err != nil {
+			return nil, err
+		}
+		return newFilesystemDeployer(cfg, opts)
+	default:
+		return nil, fmt.Errorf("unsupported target type %q", targetType)
+	}
+}
+
+// Paths returns the files a filesystem target writes on the backend's host,
+// or nil for other target types and invalid settings
+func Paths(targetType string, config []byte) []string {
+	if targetType != TypeFilesystem {
+		return nil
+	}
+	var cfg FilesystemConfig
+	if err := decodeConfig(config, &cfg); err != nil {
+		return nil
+	}
+	var paths []string
+	for _, p := range []string{cfg.CertPath, cfg.ChainPath, cfg.FullChainPath, cfg.KeyPath} {
+		if p != "" {
+			paths = append(paths, p)
+		}
+	}
+	return paths
+}
+
+// decodeConfig rejects unknown fields so typos in paths or options surface
+// when the target is created rather than on the next renewal
+func decodeConfig(config []byte, v interface{}) error {
+	dec := json.NewDecoder(strings.NewReader(string(config)))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("invalid target config: %w", err)
+	}
+	return nil
+}
+
+// parseTimeout reads an optional per-target timeout
+func parseTimeout(value string, fallback time.Duration) (time.Duration, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid timeout %q", value)
+	}
+	return d, nil
+}
+
+// outputBuffer collects command output up to maxOutput bytes
+type outputBuffer struct {
+	strings.Builder
+	truncated bool
+}
+
+func (b *outputBuffer) Write(p []byte) (int, error) {
+	if b.truncated {
+		return len(p), nil
+	}
+	if room := maxOutput - b.Len(); len(p) > room {
+		b.Builder.Write(p[:room])
+		b.Builder.WriteString("\n[output truncated]\n")
+		b.truncated = true
+		return len(p), nil
+	}
+	return b.Builder.Write(p)
+}
+
+// Logf appends a progress line
+func (b *outputBuffer) Logf(format string, args ...interface{}) {
+	fmt.Fprintf(b, format+"\n", args...)
+}
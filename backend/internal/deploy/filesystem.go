@@ -0,0 +1,346 @@
+package deploy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FilesystemConfig describes files on the host the backend runs on. Paths
+// must lie below one of DEPLOY_FILE_ROOTS and the post command must be one
+// of DEPLOY_LOCAL_COMMANDS, since both run with the rights of the backend.
+type FilesystemConfig struct {
+	CertPath      string `json:"cert_path,omitempty"`
+	ChainPath     string `json:"chain_path,omitempty"`
+	FullChainPath string `json:"fullchain_path,omitempty"`
+	KeyPath       string `json:"key_path,omitempty"`
+	Owner         string `json:"owner,omitempty"`    // user[:group], names or numeric IDs
+	Mode          string `json:"mode,omitempty"`     // octal, default 0644
+	KeyMode       string `json:"key_mode,omitempty"` // octal, default 0600
+	Keep          int    `json:"keep,omitempty"`     // previous versions kept next to each file
+	PostCommand   string `json:"post_command,omitempty"`
+	Timeout       string `json:"timeout,omitempty"` // Go duration, defaults to DEPLOY_TIMEOUT
+}
+
+// maxKeep bounds the number of previous versions kept per file
+const maxKeep = 100
+
+// versionLayout is appended to a file name to name a previous version
+const versionLayout = "20060102T150405.000Z"
+
+// commandWaitDelay is how long a timed out command may keep its output open
+const commandWaitDelay = 5 * time.Second
+
+type filesystemDeployer struct {
+	cfg     FilesystemConfig
+	roots   []string
+	mode    os.FileMode
+	keyMode os.FileMode
+	timeout time.Duration
+}
+
+func newFilesystemDeployer(cfg FilesystemConfig, opts Options) (*filesystemDeployer, error) {
+	if len(opts.FileRoots) == 0 {
+		return nil, errors.New("filesystem targets need DEPLOY_FILE_ROOTS to be set")
+	}
+
+	paths := []string{cfg.CertPath, cfg.ChainPath, cfg.FullChainPath, cfg.KeyPath}
+	configured := 0
+	for _, p := range paths {
+		if p == "" {
+			continue
+		}
+		if !filepath.IsAbs(p) || filepath.Clean(p) != p {
+			return nil, fmt.Errorf("path %q must be absolute and clean", p)
+		}
+		if !withinRoots(p, opts.FileRoots) {
+			return nil, fmt.Errorf("path %q is outside DEPLOY_FILE_ROOTS", p)
+		}
+		configured++
+	}
+	if configured == 0 {
+		return nil, errors.New("at least one of cert_path, chain_path, fullchain_path or key_path is required")
+	}
+
+	if cfg.Owner != "" {
+		if !validOwner.MatchString(cfg.Owner) {
+			return nil, fmt.Errorf("invalid owner %q, expected user or user:group", cfg.Owner)
+		}
+		if _, _, err := lookupOwner(cfg.Owner); err != nil {
+			return nil, err
+		}
+	}
+	mode, err := parseMode(cfg.Mode, 0644)
+	if err != nil {
+		return nil, err
+	}
+	keyMode, err := parseMode(cfg.KeyMode, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Keep < 0 || cfg.Keep > maxKeep {
+		return nil, fmt.Errorf("keep must be between 0 and %d", maxKeep)
+	}
+	if cfg.PostCommand != "" && !slices.Contains(opts.LocalCommands, cfg.PostCommand) {
+		return nil, fmt.Errorf("post_command %q is not listed in DEPLOY_LOCAL_COMMANDS", cfg.PostCommand)
+	}
+	timeout, err := parseTimeout(cfg.Timeout, opts.Timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	return &filesystemDeployer{
+		cfg:     cfg,
+		roots:   opts.FileRoots,
+		mode:    mode,
+		keyMode: keyMode,
+		timeout: timeout,
+	}, nil
+}
+
+func (d *filesystemDeployer) Deploy(ctx context.Context, files *Files) (string, error) {
+	var out outputBuffer
+	ctx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+
+	uid, gid := -1, -1
+	if d.cfg.Owner != "" {
+		var err error
+		if uid, gid, err = lookupOwner(d.cfg.Owner); err != nil {
+			return "", err
+		}
+	}
+
+	entries := []struct {
+		dest string
+		data []byte
+		mode os.FileMode
+	}{
+		{d.cfg.CertPath, files.Cert, d.mode},
+		{d.cfg.ChainPath, files.Chain, d.mode},
+		{d.cfg.FullChainPath, files.FullChain, d.mode},
+		{d.cfg.KeyPath, files.Key, d.keyMode},
+	}
+
+	var uploads []upload
+	defer func() {
+		// Leftover temp files mean the deployment failed before renaming
+		for _, u := range uploads {
+			os.Remove(u.tmp)
+		}
+	}()
+	for _, e := range entries {
+		if e.dest == "" {
+			continue
+		}
+		if len(e.data) == 0 {
+			out.Logf("skipped %s: certificate has no private key", e.dest)
+			continue
+		}
+		if err := d.checkDir(e.dest); err != nil {
+			return out.String(), err
+		}
+		tmp, err := writeTemp(e.dest, e.data, e.mode, uid, gid)
+		if err != nil {
+			return out.String(), err
+		}
+		uploads = append(uploads, upload{tmp: tmp, dest: e.dest})
+		out.Logf("wrote %s (%d bytes, mode %04o)", e.dest, len(e.data), e.mode)
+	}
+
+	now := time.Now().UTC()
+	for len(uploads) > 0 {
+		u := uploads[0]
+		if d.cfg.Keep > 0 {
+			if err := d.keepVersion(u.dest, now, &out); err != nil {
+				return out.String(), err
+			}
+		}
+		if err := os.Rename(u.tmp, u.dest); err != nil {
+			return out.String(), fmt.Errorf("failed to move %s into place: %w", u.dest, err)
+		}
+		syncDir(filepath.Dir(u.dest))
+		uploads = uploads[1:]
+	}
+
+	if d.cfg.PostCommand != "" {
+		out.Logf("$ %s", d.cfg.PostCommand)
+		if err := d.run(ctx, &out); err != nil {
+			return out.String(), fmt.Errorf("post command failed: %w", err)
+		}
+	}
+
+	return out.String(), nil
+}
+
+// checkDir rejects destinations whose directory leaves the allowed roots
+// through a symlink
+func (d *filesystemDeployer) checkDir(dest string) error {
+	dir, err := filepath.EvalSymlinks(filepath.Dir(dest))
+	if err != nil {
+		return fmt.Errorf("directory of %s: %w", dest, err)
+	}
+	var roots []string
+	for _, root := range d.roots {
+		if resolved, err := filepath.EvalSymlinks(root); err == nil {
+			roots = append(roots, resolved)
+		}
+	}
+	if !withinRoots(filepath.Join(dir, filepath.Base(dest)), roots) {
+		return fmt.Errorf("%s resolves outside DEPLOY_FILE_ROOTS", dest)
+	}
+	if info, err := os.Lstat(dest); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		return fmt.Errorf("%s is a symlink; point the target at the real file", dest)
+	}
+	return nil
+}
+
+// writeTemp writes data to a temporary file next to dest. Mode and owner are
+// set before the file is renamed into place.
+func writeTemp(dest string, data []byte, mode os.FileMode, uid, gid int) (string, error) {
+	f, err := os.CreateTemp(filepath.Dir(dest), "."+filepath.Base(dest)+".tmp-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temporary file for %s: %w", dest, err)
+	}
+	tmp := f.Name()
+	fail := func(err error) (string, error) {
+		f.Close()
+		os.Remove(tmp)
+		return "", fmt.Errorf("failed to write %s: %w", tmp, err)
+	}
+	if err := f.Chmod(mode); err != nil {
+		return fail(err)
+	}
+	if uid >= 0 || gid >= 0 {
+		if err := f.Chown(uid, gid); err != nil {
+			return fail(err)
+		}
+	}
+	if _, err := f.Write(data); err != nil {
+		return fail(err)
+	}
+	if err := f.Sync(); err != nil {
+		return fail(err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return "", fmt.Errorf("failed to write %s: %w", tmp, err)
+	}
+	return tmp, nil
+}
+
+// keepVersion links the current file to <dest>.<timestamp> and removes the
+// oldest versions beyond Keep
+func (d *filesystemDeployer) keepVersion(dest string, now time.Time, out *outputBuffer) error {
+	if _, err := os.Stat(dest); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	version := dest + "." + now.Format(versionLayout)
+	if err := os.Link(dest, version); err != nil {
+		return fmt.Errorf("failed to keep previous version of %s: %w", dest, err)
+	}
+	out.Logf("kept previous version as %s", version)
+
+	versions, err := previousVersions(dest)
+	if err != nil {
+		return err
+	}
+	for len(versions) > d.cfg.Keep {
+		if err := os.Remove(versions[0]); err != nil {
+			return fmt.Errorf("failed to remove old version %s: %w", versions[0], err)
+		}
+		out.Logf("removed old version %s", versions[0])
+		versions = versions[1:]
+	}
+	return nil
+}
+
+// previousVersions lists the kept versions of dest, oldest first
+func previousVersions(dest string) ([]string, error) {
+	matches, err := filepath.Glob(dest + ".*")
+	if err != nil {
+		return nil, err
+	}
+	var versions []string
+	for _, m := range matches {
+		suffix := strings.TrimPrefix(m, dest+".")
+		if _, err := time.Parse(versionLayout, suffix); err == nil {
+			versions = append(versions, m)
+		}
+	}
+	// The timestamp layout sorts chronologically
+	sort.Strings(versions)
+	return versions, nil
+}
+
+// run executes the post command with the shell and appends its combined
+// output
+func (d *filesystemDeployer) run(ctx context.Context, out *outputBuffer) error {
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", d.cfg.PostCommand)
+	cmd.Stdout = out
+	cmd.Stderr = out
+	cmd.WaitDelay = commandWaitDelay
+	killProcessGroup(cmd)
+	err := cmd.Run()
+	if err != nil && ctx.Err() != nil {
+		return fmt.Errorf("timed out after %s: %w", d.timeout, err)
+	}
+	return err
+}
+
+// lookupOwner resolves user[:group] to numeric IDs; -1 leaves one unchanged
+func lookupOwner(owner string) (int, int, error) {
+	name, group, _ := strings.Cut(owner, ":")
+	uid, gid := -1, -1
+
+	if id, err := strconv.Atoi(name); err == nil {
+		uid = id
+	} else {
+		u, err := user.Lookup(name)
+		if err != nil {
+			return 0, 0, fmt.Errorf("unknown owner %q", name)
+		}
+		uid, _ = strconv.Atoi(u.Uid)
+	}
+
+	if group != "" {
+		if id, err := strconv.Atoi(group); err == nil {
+			gid = id
+		} else {
+			g, err := user.LookupGroup(group)
+			if err != nil {
+				return 0, 0, fmt.Errorf("unknown group %q", group)
+			}
+			gid, _ = strconv.Atoi(g.Gid)
+		}
+	}
+	return uid, gid, nil
+}
+
+// withinRoots reports whether p lies below one of the roots
+func withinRoots(p string, roots []string) bool {
+	for _, root := range roots {
+		root = filepath.Clean(root)
+		if strings.HasPrefix(p, strings.TrimSuffix(root, string(filepath.Separator))+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// syncDir flushes a rename to disk; errors only cost durability
+func syncDir(dir string) {
+	if f, err := os.Open(dir); err == nil {
+		f.Sync()
+		f.Close()
+	}
+}
@@ -0,0 +1,239 @@
+package deploy
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fileOptions returns options with a new file root
+func fileOptions(t *testing.T, commands ...string) (Options, string) {
+	t.Helper()
+	root := t.TempDir()
+	return Options{FileRoots: []string{root}, LocalCommands: commands, Timeout: 10 * time.Second}, root
+}
+
+// newFileDeployer creates a filesystem deployer from its JSON settings
+func newFileDeployer(t *testing.T, config string, opts Options) Deployer {
+	t.Helper()
+	d, err := New(TypeFilesystem, []byte(config), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestFilesystemDeploy(t *testing.T) {
+	opts, root := fileOptions(t)
+	cert, fullchain, key := filepath.Join(root, "site.crt"), filepath.Join(root, "site.pem"), filepath.Join(root, "site.key")
+	d := newFileDeployer(t, `{"cert_path": `+strconv.Quote(cert)+`, "fullchain_path": `+strconv.Quote(fullchain)+
+		`, "key_path": `+strconv.Quote(key)+`, "mode": "0640", "key_mode": "0400"}`, opts)
+
+	// Replaced files are swapped in whole
+	if err := os.WriteFile(cert, []byte("old\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	old, err := os.Stat(cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := d.Deploy(context.Background(), testFiles)
+	if err != nil {
+		t.Fatalf("Deploy failed: %v\n%s", err, out)
+	}
+	for p, want := range map[string]struct {
+		data string
+		mode os.FileMode
+	}{
+		cert:      {string(testFiles.Cert), 0o640},
+		fullchain: {string(testFiles.FullChain), 0o640},
+		key:       {string(testFiles.Key), 0o400},
+	} {
+		if got := readFile(t, p); got != want.data {
+			t.Errorf("%s holds %q, want %q", p, got, want.data)
+		}
+		info, err := os.Stat(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != want.mode {
+			t.Errorf("%s has mode %04o, want %04o", p, info.Mode().Perm(), want.mode)
+		}
+	}
+	if info, _ := os.Stat(cert); os.SameFile(old, info) {
+		t.Error("certificate was rewritten in place instead of renamed over")
+	}
+
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		var names []string
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+		t.Errorf("root holds %v, want no temporary files left", names)
+	}
+}
+
+func TestFilesystemSkipsMissingKey(t *testing.T) {
+	opts, root := fileOptions(t)
+	cert, key := filepath.Join(root, "site.crt"), filepath.Join(root, "site.key")
+	d := newFileDeployer(t, `{"cert_path": `+strconv.Quote(cert)+`, "key_path": `+strconv.Quote(key)+`}`, opts)
+
+	files := *testFiles
+	files.Key = nil
+	out, err := d.Deploy(context.Background(), &files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "skipped "+key) {
+		t.Errorf("output does not mention the skipped key:\n%s", out)
+	}
+	if _, err := os.Stat(key); !os.IsNotExist(err) {
+		t.Errorf("key file was written: %v", err)
+	}
+}
+
+func TestFilesystemKeepVersions(t *testing.T) {
+	opts, root := fileOptions(t)
+	cert := filepath.Join(root, "site.crt")
+	d := newFileDeployer(t, `{"cert_path": `+strconv.Quote(cert)+`, "keep": 2}`, opts)
+
+	for i := 1; i <= 4; i++ {
+		files := *testFiles
+		files.Cert = []byte("version " + strconv.Itoa(i) + "\n")
+		if out, err := d.Deploy(context.Background(), &files); err != nil {
+			t.Fatalf("deployment %d failed: %v\n%s", i, err, out)
+		}
+		// Versions are named by the millisecond
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if got := readFile(t, cert); got != "version 4\n" {
+		t.Errorf("certificate holds %q", got)
+	}
+	versions, err := previousVersions(cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 2 {
+		t.Fatalf("kept %d versions, want 2: %v", len(versions), versions)
+	}
+	for i, want := range []string{"version 2\n", "version 3\n"} {
+		if got := readFile(t, versions[i]); got != want {
+			t.Errorf("version %s holds %q, want %q", versions[i], got, want)
+		}
+	}
+}
+
+func TestFilesystemRefusesSymlinks(t *testing.T) {
+	opts, root := fileOptions(t)
+	outside := t.TempDir()
+
+	// A symlinked destination is not followed
+	target := filepath.Join(outside, "victim.crt")
+	if err := os.WriteFile(target, []byte("victim\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(root, "link.crt")
+	if err := os.Symlink(target, link); err != nil {
+		t.Fatal(err)
+	}
+	d := newFileDeployer(t, `{"cert_path": `+strconv.Quote(link)+`}`, opts)
+	if _, err := d.Deploy(context.Background(), testFiles); err == nil || !strings.Contains(err.Error(), "symlink") {
+		t.Errorf("Deploy to a symlink returned %v", err)
+	}
+
+	// Nor is a symlinked directory leading out of the roots
+	if err := os.Symlink(outside, filepath.Join(root, "escape")); err != nil {
+		t.Fatal(err)
+	}
+	escaped := filepath.Join(root, "escape", "site.crt")
+	d = newFileDeployer(t, `{"cert_path": `+strconv.Quote(escaped)+`}`, opts)
+	if _, err := d.Deploy(context.Background(), testFiles); err == nil || !strings.Contains(err.Error(), "outside DEPLOY_FILE_ROOTS") {
+		t.Errorf("Deploy through a symlinked directory returned %v", err)
+	}
+
+	if got := readFile(t, target); got != "victim\n" {
+		t.Errorf("file outside the roots holds %q", got)
+	}
+	if _, err := os.Stat(filepath.Join(outside, "site.crt")); !os.IsNotExist(err) {
+		t.Errorf("file written outside the roots: %v", err)
+	}
+}
+
+func TestFilesystemPostCommand(t *testing.T) {
+	opts, root := fileOptions(t, "echo reloaded", "exit 3")
+	cert := filepath.Join(root, "site.crt")
+
+	d := newFileDeployer(t, `{"cert_path": `+strconv.Quote(cert)+`, "post_command": "echo reloaded"}`, opts)
+	out, err := d.Deploy(context.Background(), testFiles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "$ echo reloaded\nreloaded\n") {
+		t.Errorf("output lacks the command and its output:\n%s", out)
+	}
+
+	d = newFileDeployer(t, `{"cert_path": `+strconv.Quote(cert)+`, "post_command": "exit 3"}`, opts)
+	if _, err := d.Deploy(context.Background(), testFiles); err == nil || !strings.Contains(err.Error(), "post command failed") {
+		t.Errorf("Deploy returned %v, want a post command failure", err)
+	}
+}
+
+func TestNewFilesystemDeployer(t *testing.T) {
+	opts, root := fileOptions(t, "systemctl reload haproxy")
+	cert := strconv.Quote(filepath.Join(root, "site.crt"))
+
+	for _, config := range []string{
+		`{}`,
+		`{"cert_path": "relative/site.crt"}`,
+		`{"cert_path": ` + strconv.Quote(root+"/../site.crt") + `}`,
+		`{"cert_path": "/etc/passwd"}`,
+		`{"cert_path": ` + strconv.Quote(root+"-sibling/site.crt") + `}`,
+		`{"cert_path": ` + cert + `, "mode": "0888"}`,
+		`{"cert_path": ` + cert + `, "owner": "root;id"}`,
+		`{"cert_path": ` + cert + `, "keep": -1}`,
+		`{"cert_path": ` + cert + `, "keep": 101}`,
+		`{"cert_path": ` + cert + `, "post_command": "rm -rf /"}`,
+		`{"cert_path": ` + cert + `, "timeout": "soon"}`,
+		`{"cert_path": ` + cert + `, "certpath": "typo"}`,
+	} {
+		if _, err := New(TypeFilesystem, []byte(config), opts); err == nil {
+			t.Errorf("config %s was accepted", config)
+		}
+	}
+
+	if _, err := New(TypeFilesystem, []byte(`{"cert_path": `+cert+`}`), Options{}); err == nil {
+		t.Error("filesystem target accepted without DEPLOY_FILE_ROOTS")
+	}
+	if _, err := New(TypeFilesystem, []byte(`{"cert_path": `+cert+`, "post_command": "systemctl reload haproxy"}`), opts); err != nil {
+		t.Errorf("valid config rejected: %v", err)
+	}
+}
+
+func TestPaths(t *testing.T) {
+	got := Paths(TypeFilesystem, []byte(`{"cert_path": "/srv/a.crt", "key_path": "/srv/a.key"}`))
+	if strings.Join(got, " ") != "/srv/a.crt /srv/a.key" {
+		t.Errorf("Paths returned %v", got)
+	}
+	if got := Paths(TypeSFTP, []byte(`{"cert_path": "/srv/a.crt"}`)); got != nil {
+		t.Errorf("Paths of an SFTP target returned %v", got)
+	}
+}
@@ -0,0 +1,229 @@
+package deploy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Service account files mounted into every pod
+const (
+	inClusterTokenFile     = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	inClusterCAFile        = "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
+	inClusterNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+)
+
+// kubeconfig is the subset of the kubeconfig format the Kubernetes target
+// understands. Exec and auth-provider plugins are not supported.
+type kubeconfig struct {
+	CurrentContext string `yaml:"current-context"`
+	Clusters       []struct {
+		Name    string `yaml:"name"`
+		Cluster struct {
+			Server                   string `yaml:"server"`
+			CertificateAuthority     string `yaml:"certificate-authority"`
+			CertificateAuthorityData string `yaml:"certificate-authority-data"`
+			InsecureSkipTLSVerify    bool   `yaml:"insecure-skip-tls-verify"`
+			TLSServerName            string `yaml:"tls-server-name"`
+		} `yaml:"cluster"`
+	} `yaml:"clusters"`
+	Users []struct {
+		Name string `yaml:"name"`
+		User struct {
+			Token                 string                 `yaml:"token"`
+			TokenFile             string                 `yaml:"tokenFile"`
+			ClientCertificate     string                 `yaml:"client-certificate"`
+			ClientCertificateData string                 `yaml:"client-certificate-data"`
+			ClientKey             string                 `yaml:"client-key"`
+			ClientKeyData         string                 `yaml:"client-key-data"`
+			Username              string                 `yaml:"username"`
+			Password              string                 `yaml:"password"`
+			Exec                  map[string]interface{} `yaml:"exec"`
+			AuthProvider          map[string]interface{} `yaml:"auth-provider"`
+		} `yaml:"user"`
+	} `yaml:"users"`
+	Contexts []struct {
+		Name    string `yaml:"name"`
+		Context struct {
+			Cluster   string `yaml:"cluster"`
+			User      string `yaml:"user"`
+			Namespace string `yaml:"namespace"`
+		} `yaml:"context"`
+	} `yaml:"contexts"`
+}
+
+// kubeCluster is a resolved connection to one API server
+type kubeCluster struct {
+	server    string
+	namespace string // default namespace of the context
+	client    *http.Client
+	token     string
+	tokenFile string // re-read on every request, service account tokens rotate
+	username  string
+	password  string
+}
+
+// authorize adds the credentials of the cluster to a request
+func (k *kubeCluster) authorize(req *http.Request) error {
+	token := k.token
+	if k.tokenFile != "" {
+		data, err := os.ReadFile(k.tokenFile)
+		if err != nil {
+			return fmt.Errorf("failed to read token: %w", err)
+		}
+		token = strings.TrimSpace(string(data))
+	}
+	switch {
+	case token != "":
+		req.Header.Set("Authorization", "Bearer "+token)
+	case k.username != "":
+		req.SetBasicAuth(k.username, k.password)
+	}
+	return nil
+}
+
+// loadKubeconfig resolves a context of a kubeconfig file, or the current
+// context when name is empty
+func loadKubeconfig(path, name string) (*kubeCluster, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read kubeconfig: %w", err)
+	}
+	var cfg kubeconfig
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse kubeconfig: %w", err)
+	}
+	// Relative file references are relative to the kubeconfig itself
+	dir := filepath.Dir(path)
+	resolve := func(p string) string {
+		if p == "" || filepath.IsAbs(p) {
+			return p
+		}
+		return filepath.Join(dir, p)
+	}
+
+	if name == "" {
+		name = cfg.CurrentContext
+	}
+	var clusterName, userName, namespace string
+	found := false
+	for _, c := range cfg.Contexts {
+		if c.Name == name {
+			clusterName, userName, namespace = c.Context.Cluster, c.Context.User, c.Context.Namespace
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("kubeconfig has no context %q", name)
+	}
+
+	k := &kubeCluster{namespace: namespace}
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	found = false
+	for _, c := range cfg.Clusters {
+		if c.Name != clusterName {
+			continue
+		}
+		found = true
+		k.server = strings.TrimSuffix(c.Cluster.Server, "/")
+		tlsConfig.InsecureSkipVerify = c.Cluster.InsecureSkipTLSVerify
+		tlsConfig.ServerName = c.Cluster.TLSServerName
+		caPEM, err := fileOrData(resolve(c.Cluster.CertificateAuthority), c.Cluster.CertificateAuthorityData)
+		if err != nil {
+			return nil, fmt.Errorf("cluster %s: %w", clusterName, err)
+		}
+		if caPEM != nil {
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(caPEM) {
+				return nil, fmt.Errorf("cluster %s: no certificates in certificate authority", clusterName)
+			}
+			tlsConfig.RootCAs = pool
+		}
+	}
+	if !found || k.server == "" {
+		return nil, fmt.Errorf("kubeconfig has no server for cluster %q", clusterName)
+	}
+
+	for _, u := range cfg.Users {
+		if u.Name != userName {
+			continue
+		}
+		if u.User.Exec != nil || u.User.AuthProvider != nil {
+			return nil, fmt.Errorf("user %s: exec and auth-provider credentials are not supported, use a token or client certificate", userName)
+		}
+		k.token = u.User.Token
+		k.tokenFile = resolve(u.User.TokenFile)
+		k.username, k.password = u.User.Username, u.User.Password
+
+		certPEM, err := fileOrData(resolve(u.User.ClientCertificate), u.User.ClientCertificateData)
+		if err != nil {
+			return nil, fmt.Errorf("user %s: %w", userName, err)
+		}
+		keyPEM, err := fileOrData(resolve(u.User.ClientKey), u.User.ClientKeyData)
+		if err != nil {
+			return nil, fmt.Errorf("user %s: %w", userName, err)
+		}
+		if certPEM != nil || keyPEM != nil {
+			pair, err := tls.X509KeyPair(certPEM, keyPEM)
+			if err != nil {
+				return nil, fmt.Errorf("user %s: invalid client certificate: %w", userName, err)
+			}
+			tlsConfig.Certificates = []tls.Certificate{pair}
+		}
+	}
+
+	k.client = &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig, Proxy: http.ProxyFromEnvironment}}
+	return k, nil
+}
+
+// inCluster uses the service account of the pod the backend runs in
+func inCluster() (*kubeCluster, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, errors.New("not running in a cluster; set DEPLOY_KUBECONFIG")
+	}
+	caPEM, err := os.ReadFile(inClusterCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read service account CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return nil, errors.New("no certificates in service account CA")
+	}
+	namespace, _ := os.ReadFile(inClusterNamespaceFile)
+
+	return &kubeCluster{
+		server:    "https://" + net.JoinHostPort(host, port),
+		namespace: strings.TrimSpace(string(namespace)),
+		tokenFile: inClusterTokenFile,
+		client: &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12},
+		}},
+	}, nil
+}
+
+// fileOrData returns inline base64 data or the contents of a file
+func fileOrData(file, data string) ([]byte, error) {
+	if data != "" {
+		decoded, err := base64.StdEncoding.DecodeString(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid base64 data: %w", err)
+		}
+		return decoded, nil
+	}
+	if file != "" {
+		return os.ReadFile(file)
+	}
+	return nil, nil
+}
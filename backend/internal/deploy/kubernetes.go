@@ -0,0 +1,239 @@
+package deploy
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"time"
+)
+
+// KubernetesConfig binds a certificate to a kubernetes.io/tls Secret. The
+// cluster comes from a context of DEPLOY_KUBECONFIG, or from the service
+// account of the pod when no kubeconfig is set.
+type KubernetesConfig struct {
+	Namespace string            `json:"namespace,omitempty"` // defaults to the namespace of the context
+	Name      string            `json:"name"`
+	Context   string            `json:"context,omitempty"` // kubeconfig context, defaults to the current one
+	Labels    map[string]string `json:"labels,omitempty"`
+	Timeout   string            `json:"timeout,omitempty"`
+}
+
+// Keys and annotations written to the Secret
+const (
+	secretTypeTLS      = "kubernetes.io/tls"
+	managedByLabel     = "app.kubernetes.io/managed-by"
+	managedByValue     = "step-ca-webui"
+	serialAnnotation   = "step-ca-webui/serial"
+	notAfterAnnotation = "step-ca-webui/not-after"
+	kubeMaxAttempts    = 3
+)
+
+var (
+	dns1123Subdomain = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*$`)
+	dns1123Label     = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+)
+
+type kubernetesDeployer struct {
+	cfg        KubernetesConfig
+	kubeconfig string
+	timeout    time.Duration
+}
+
+func newKubernetesDeployer(cfg KubernetesConfig, opts Options) (*kubernetesDeployer, error) {
+	if cfg.Name == "" || len(cfg.Name) > 253 || !dns1123Subdomain.MatchString(cfg.Name) {
+		return nil, fmt.Errorf("name %q must be a lowercase DNS subdomain", cfg.Name)
+	}
+	if cfg.Namespace != "" && (len(cfg.Namespace) > 63 || !dns1123Label.MatchString(cfg.Namespace)) {
+		return nil, fmt.Errorf("namespace %q must be a lowercase DNS label", cfg.Namespace)
+	}
+	if cfg.Context != "" && opts.Kubeconfig == "" {
+		return nil, errors.New("context needs DEPLOY_KUBECONFIG to be set")
+	}
+	timeout, err := parseTimeout(cfg.Timeout, opts.Timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &kubernetesDeployer{cfg: cfg, kubeconfig: opts.Kubeconfig, timeout: timeout}
+	// Resolve the cluster now so a missing context is reported up front
+	if _, err := d.cluster(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func (d *kubernetesDeployer) cluster() (*kubeCluster, error) {
+	if d.kubeconfig != "" {
+		return loadKubeconfig(d.kubeconfig, d.cfg.Context)
+	}
+	return inCluster()
+}
+
+// kubeStatus is the error body returned by the API server
+type kubeStatus struct {
+	Message string `json:"message"`
+	Reason  string `json:"reason"`
+}
+
+type kubeError struct {
+	code    int
+	message string
+}
+
+func (e *kubeError) Error() string {
+	return fmt.Sprintf("API server returned %d: %s", e.code, e.message)
+}
+
+// Deploy creates the Secret or updates its certificate keys. Updates work
+// on the full object, so other keys, labels, annotations and owner
+// references survive.
+func (d *kubernetesDeployer) Deploy(ctx context.Context, files *Files) (string, error) {
+	var out outputBuffer
+	if len(files.Key) == 0 {
+		return "", errors.New("kubernetes.io/tls Secrets need a private key; certificates signed from a CSR cannot be synced")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+
+	k, err := d.cluster()
+	if err != nil {
+		return "", err
+	}
+	namespace := d.cfg.Namespace
+	if namespace == "" {
+		namespace = k.namespace
+	}
+	if namespace == "" {
+		namespace = "default"
+	}
+	collection := fmt.Sprintf("%s/api/v1/namespaces/%s/secrets", k.server, url.PathEscape(namespace))
+	item := collection + "/" + url.PathEscape(d.cfg.Name)
+
+	for attempt := 1; ; attempt++ {
+		var current map[string]interface{}
+		err := k.do(ctx, http.MethodGet, item, nil, &current)
+		var kerr *kubeError
+		switch {
+		case errors.As(err, &kerr) && kerr.code == http.StatusNotFound:
+			created := d.apply(map[string]interface{}{
+				"apiVersion": "v1",
+				"kind":       "Secret",
+				"metadata":   map[string]interface{}{"name": d.cfg.Name, "namespace": namespace},
+				"type":       secretTypeTLS,
+			}, files)
+			if err := k.do(ctx, http.MethodPost, collection, created, nil); err != nil {
+				// Someone else created it first; update theirs instead
+				if errors.As(err, &kerr) && kerr.code == http.StatusConflict && attempt < kubeMaxAttempts {
+					continue
+				}
+				return out.String(), err
+			}
+			out.Logf("created secret %s/%s on %s", namespace, d.cfg.Name, k.server)
+			return out.String(), nil
+
+		case err != nil:
+			return out.String(), err
+		}
+
+		if current["type"] != secretTypeTLS {
+			return out.String(), fmt.Errorf("secret %s/%s has type %v, not %s; delete it or choose another name", namespace, d.cfg.Name, current["type"], secretTypeTLS)
+		}
+		// The PUT carries the resourceVersion read above and fails with 409
+		// if the Secret changed in between; read it again and retry
+		if err := k.do(ctx, http.MethodPut, item, d.apply(current, files), nil); err != nil {
+			if errors.As(err, &kerr) && kerr.code == http.StatusConflict && attempt < kubeMaxAttempts {
+				out.Logf("secret changed concurrently, retrying")
+				continue
+			}
+			return out.String(), err
+		}
+		out.Logf("updated secret %s/%s on %s", namespace, d.cfg.Name, k.server)
+		return out.String(), nil
+	}
+}
+
+// apply sets the certificate keys, labels and annotations on a Secret object
+func (d *kubernetesDeployer) apply(obj map[string]interface{}, files *Files) map[string]interface{} {
+	data := childMap(obj, "data")
+	data["tls.crt"] = base64.StdEncoding.EncodeToString(files.FullChain)
+	data["tls.key"] = base64.StdEncoding.EncodeToString(files.Key)
+	data["ca.crt"] = base64.StdEncoding.EncodeToString(files.Root)
+
+	metadata := childMap(obj, "metadata")
+	labels := childMap(metadata, "labels")
+	for key, value := range d.cfg.Labels {
+		labels[key] = value
+	}
+	labels[managedByLabel] = managedByValue
+
+	annotations := childMap(metadata, "annotations")
+	annotations[serialAnnotation] = files.Serial
+	if !files.NotAfter.IsZero() {
+		annotations[notAfterAnnotation] = files.NotAfter.UTC().Format(time.RFC3339)
+	}
+	return obj
+}
+
+// childMap returns obj[key] as an object, creating it when missing
+func childMap(obj map[string]interface{}, key string) map[string]interface{} {
+	if child, ok := obj[key].(map[string]interface{}); ok {
+		return child
+	}
+	child := make(map[string]interface{})
+	obj[key] = child
+	return child
+}
+
+// do sends a JSON request to the API server and decodes the response into out
+func (k *kubeCluster) do(ctx context.Context, method, url string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if err := k.authorize(req); err != nil {
+		return err
+	}
+
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach API server: %w", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var status kubeStatus
+		message := string(data)
+		if json.Unmarshal(data, &status) == nil && status.Message != "" {
+			message = status.Message
+		}
+		return &kubeError{code: resp.StatusCode, message: message}
+	}
+	if out != nil {
+		return json.Unmarshal(data, out)
+	}
+	return nil
+}
@@ -0,0 +1,445 @@
+package deploy
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testToken = "kube-token"
+
+// fakeAPIServer serves the Secret endpoints of the Kubernetes API from memory
+type fakeAPIServer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	secrets  map[string]map[string]interface{} // by namespace/name
+	version  int
+	requests []string
+
+	// beforeWrite runs before a POST or PUT is applied, with the lock held,
+	// and can change the stored Secrets to simulate concurrent writers
+	beforeWrite func(method, key string)
+}
+
+func newFakeAPIServer(t *testing.T) *fakeAPIServer {
+	s := &fakeAPIServer{secrets: make(map[string]map[string]interface{})}
+	s.Server = httptest.NewTLSServer(http.HandlerFunc(s.serve))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// put stores a Secret with a new resourceVersion
+func (s *fakeAPIServer) put(key string, obj map[string]interface{}) {
+	s.version++
+	childMap(obj, "metadata")["resourceVersion"] = strconv.Itoa(s.version)
+	s.secrets[key] = obj
+}
+
+// secret returns a copy of a stored Secret
+func (s *fakeAPIServer) secret(t *testing.T, key string) map[string]interface{} {
+	t.Helper()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	obj, ok := s.secrets[key]
+	if !ok {
+		t.Fatalf("secret %s does not exist", key)
+	}
+	data, _ := json.Marshal(obj)
+	var copied map[string]interface{}
+	json.Unmarshal(data, &copied)
+	return copied
+}
+
+func (s *fakeAPIServer) serve(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, r.Method)
+
+	status := func(code int, message string) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(map[string]interface{}{"kind": "Status", "message": message})
+	}
+	if r.Header.Get("Authorization") != "Bearer "+testToken {
+		status(http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	// /api/v1/namespaces/{namespace}/secrets[/{name}]
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/v1/namespaces/"), "/")
+	if len(parts) < 2 || parts[1] != "secrets" {
+		status(http.StatusNotFound, "not found")
+		return
+	}
+	namespace := parts[0]
+
+	var body map[string]interface{}
+	if r.Method == http.MethodPost || r.Method == http.MethodPut {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			status(http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	switch {
+	case r.Method == http.MethodPost && len(parts) == 2:
+		key := namespace + "/" + childMap(body, "metadata")["name"].(string)
+		if s.beforeWrite != nil {
+			s.beforeWrite(r.Method, key)
+		}
+		if _, exists := s.secrets[key]; exists {
+			status(http.StatusConflict, "secrets already exists")
+			return
+		}
+		s.put(key, body)
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(body)
+
+	case len(parts) == 3:
+		key := namespace + "/" + parts[2]
+		current, exists := s.secrets[key]
+		switch r.Method {
+		case http.MethodGet:
+			if !exists {
+				status(http.StatusNotFound, fmt.Sprintf("secrets %q not found", parts[2]))
+				return
+			}
+			json.NewEncoder(w).Encode(current)
+		case http.MethodPut:
+			if s.beforeWrite != nil {
+				s.beforeWrite(r.Method, key)
+				current = s.secrets[key]
+			}
+			if childMap(body, "metadata")["resourceVersion"] != childMap(current, "metadata")["resourceVersion"] {
+				status(http.StatusConflict, "the object has been modified")
+				return
+			}
+			s.put(key, body)
+			json.NewEncoder(w).Encode(body)
+		default:
+			status(http.StatusMethodNotAllowed, "method not allowed")
+		}
+
+	default:
+		status(http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// writeKubeconfig writes a kubeconfig for the server to a new directory.
+// The CA certificate and token live next to it and are referenced by
+// relative paths.
+func writeKubeconfig(t *testing.T, s *fakeAPIServer) string {
+	t.Helper()
+
+	dir := filepath.Join(t.TempDir(), "kube")
+	if err := os.MkdirAll(filepath.Join(dir, "certs"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.Certificate().Raw})
+	if err := os.WriteFile(filepath.Join(dir, "certs", "ca.crt"), caPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "token"), []byte(testToken+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	config := fmt.Sprintf(`apiVersion: v1
+kind: Config
+current-context: test
+clusters:
+- name: test
+  cluster:
+    server: %s/
+    certificate-authority: certs/ca.crt
+- name: other
+  cluster:
+    server: https://other.invalid
+users:
+- name: test
+  user:
+    tokenFile: token
+contexts:
+- name: test
+  context:
+    cluster: test
+    user: test
+    namespace: apps
+- name: other
+  context:
+    cluster: other
+    user: test
+`, s.URL)
+	path := filepath.Join(dir, "config")
+	if err := os.WriteFile(path, []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func newTestKubernetesDeployer(t *testing.T, s *fakeAPIServer, config string) Deployer {
+	t.Helper()
+	d, err := New(TypeKubernetes, []byte(config), Options{Kubeconfig: writeKubeconfig(t, s), Timeout: 10 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+var testFiles = &Files{
+	Cert:      []byte("leaf\n"),
+	FullChain: []byte("leaf\nintermediate\n"),
+	Key:       []byte("key\n"),
+	Root:      []byte("root\n"),
+	Serial:    "0A1B",
+	NotAfter:  time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC),
+}
+
+// checkTLSSecret checks the certificate keys, labels and annotations
+// written for testFiles
+func checkTLSSecret(t *testing.T, obj map[string]interface{}) {
+	t.Helper()
+
+	if obj["type"] != secretTypeTLS {
+		t.Errorf("type is %v", obj["type"])
+	}
+	data := childMap(obj, "data")
+	for key, want := range map[string][]byte{"tls.crt": testFiles.FullChain, "tls.key": testFiles.Key, "ca.crt": testFiles.Root} {
+		got, _ := base64.StdEncoding.DecodeString(fmt.Sprint(data[key]))
+		if string(got) != string(want) {
+			t.Errorf("%s is %q, want %q", key, got, want)
+		}
+	}
+	metadata := childMap(obj, "metadata")
+	if got := childMap(metadata, "labels")[managedByLabel]; got != managedByValue {
+		t.Errorf("%s label is %v", managedByLabel, got)
+	}
+	annotations := childMap(metadata, "annotations")
+	if annotations[serialAnnotation] != "0A1B" || annotations[notAfterAnnotation] != "2030-01-02T03:04:05Z" {
+		t.Errorf("annotations are %v", annotations)
+	}
+}
+
+func TestKubernetesCreate(t *testing.T) {
+	s := newFakeAPIServer(t)
+	d := newTestKubernetesDeployer(t, s, `{"name": "web-tls", "labels": {"app": "web"}}`)
+
+	out, err := d.Deploy(context.Background(), testFiles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "created secret apps/web-tls") {
+		t.Errorf("output is %q", out)
+	}
+
+	// The namespace comes from the kubeconfig context
+	obj := s.secret(t, "apps/web-tls")
+	checkTLSSecret(t, obj)
+	if got := childMap(childMap(obj, "metadata"), "labels")["app"]; got != "web" {
+		t.Errorf("app label is %v", got)
+	}
+}
+
+func TestKubernetesUpdatePreservesForeignData(t *testing.T) {
+	s := newFakeAPIServer(t)
+	s.put("certs/web-tls", map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Secret",
+		"type":       secretTypeTLS,
+		"metadata": map[string]interface{}{
+			"name":            "web-tls",
+			"namespace":       "certs",
+			"labels":          map[string]interface{}{"team": "payments", "app": "old"},
+			"annotations":     map[string]interface{}{"reloader.stakater.com/match": "true"},
+			"ownerReferences": []interface{}{map[string]interface{}{"kind": "Deployment", "name": "web"}},
+		},
+		"data": map[string]interface{}{
+			"tls.crt":  base64.StdEncoding.EncodeToString([]byte("old")),
+			"dhparams": base64.StdEncoding.EncodeToString([]byte("keep me")),
+		},
+	})
+	d := newTestKubernetesDeployer(t, s, `{"name": "web-tls", "namespace": "certs", "labels": {"app": "web"}}`)
+
+	out, err := d.Deploy(context.Background(), testFiles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "updated secret certs/web-tls") {
+		t.Errorf("output is %q", out)
+	}
+
+	obj := s.secret(t, "certs/web-tls")
+	checkTLSSecret(t, obj)
+	if got := childMap(obj, "data")["dhparams"]; got != base64.StdEncoding.EncodeToString([]byte("keep me")) {
+		t.Errorf("foreign data key is %v", got)
+	}
+	metadata := childMap(obj, "metadata")
+	labels := childMap(metadata, "labels")
+	if labels["team"] != "payments" || labels["app"] != "web" {
+		t.Errorf("labels are %v", labels)
+	}
+	if got := childMap(metadata, "annotations")["reloader.stakater.com/match"]; got != "true" {
+		t.Errorf("foreign annotation is %v", got)
+	}
+	if refs, _ := metadata["ownerReferences"].([]interface{}); len(refs) != 1 {
+		t.Errorf("owner references are %v", metadata["ownerReferences"])
+	}
+}
+
+func TestKubernetesWrongType(t *testing.T) {
+	s := newFakeAPIServer(t)
+	s.put("apps/web-tls", map[string]interface{}{
+		"type":     "Opaque",
+		"metadata": map[string]interface{}{"name": "web-tls"},
+		"data":     map[string]interface{}{"password": "c2VjcmV0"},
+	})
+	d := newTestKubernetesDeployer(t, s, `{"name": "web-tls"}`)
+
+	_, err := d.Deploy(context.Background(), testFiles)
+	if err == nil || !strings.Contains(err.Error(), "has type Opaque") {
+		t.Fatalf("Deploy returned %v, want a type error", err)
+	}
+	if got := childMap(s.secret(t, "apps/web-tls"), "data"); len(got) != 1 {
+		t.Errorf("Opaque secret was modified: %v", got)
+	}
+}
+
+func TestKubernetesCreateConflict(t *testing.T) {
+	s := newFakeAPIServer(t)
+	// Another writer creates the Secret between our GET and POST
+	s.beforeWrite = func(method, key string) {
+		if method == http.MethodPost {
+			s.put(key, map[string]interface{}{
+				"type":     secretTypeTLS,
+				"metadata": map[string]interface{}{"name": "web-tls", "labels": map[string]interface{}{"owner": "other"}},
+			})
+			s.beforeWrite = nil
+		}
+	}
+	d := newTestKubernetesDeployer(t, s, `{"name": "web-tls"}`)
+
+	out, err := d.Deploy(context.Background(), testFiles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "updated secret apps/web-tls") {
+		t.Errorf("output is %q", out)
+	}
+	if want := "GET POST GET PUT"; strings.Join(s.requests, " ") != want {
+		t.Errorf("requests were %v, want %s", s.requests, want)
+	}
+	obj := s.secret(t, "apps/web-tls")
+	checkTLSSecret(t, obj)
+	if got := childMap(childMap(obj, "metadata"), "labels")["owner"]; got != "other" {
+		t.Errorf("label of the other writer is %v", got)
+	}
+}
+
+func TestKubernetesUpdateConflict(t *testing.T) {
+	existing := func() map[string]interface{} {
+		return map[string]interface{}{
+			"type":     secretTypeTLS,
+			"metadata": map[string]interface{}{"name": "web-tls"},
+		}
+	}
+
+	t.Run("retried", func(t *testing.T) {
+		s := newFakeAPIServer(t)
+		s.put("apps/web-tls", existing())
+		// The Secret changes once between our GET and PUT
+		s.beforeWrite = func(method, key string) {
+			s.put(key, existing())
+			s.beforeWrite = nil
+		}
+		d := newTestKubernetesDeployer(t, s, `{"name": "web-tls"}`)
+
+		out, err := d.Deploy(context.Background(), testFiles)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(out, "retrying") || !strings.Contains(out, "updated secret") {
+			t.Errorf("output is %q", out)
+		}
+		if want := "GET PUT GET PUT"; strings.Join(s.requests, " ") != want {
+			t.Errorf("requests were %v, want %s", s.requests, want)
+		}
+		checkTLSSecret(t, s.secret(t, "apps/web-tls"))
+	})
+
+	t.Run("gives up", func(t *testing.T) {
+		s := newFakeAPIServer(t)
+		s.put("apps/web-tls", existing())
+		s.beforeWrite = func(method, key string) { s.put(key, existing()) }
+		d := newTestKubernetesDeployer(t, s, `{"name": "web-tls"}`)
+
+		_, err := d.Deploy(context.Background(), testFiles)
+		if err == nil || !strings.Contains(err.Error(), "409") {
+			t.Fatalf("Deploy returned %v, want a conflict", err)
+		}
+		if got := strings.Count(strings.Join(s.requests, " "), "PUT"); got != kubeMaxAttempts {
+			t.Errorf("sent %d updates, want %d", got, kubeMaxAttempts)
+		}
+	})
+}
+
+func TestKubernetesNeedsKey(t *testing.T) {
+	s := newFakeAPIServer(t)
+	d := newTestKubernetesDeployer(t, s, `{"name": "web-tls"}`)
+
+	files := *testFiles
+	files.Key = nil
+	if _, err := d.Deploy(context.Background(), &files); err == nil {
+		t.Fatal("Deploy without a key succeeded")
+	}
+	if len(s.requests) != 0 {
+		t.Errorf("sent requests %v", s.requests)
+	}
+}
+
+func TestLoadKubeconfig(t *testing.T) {
+	s := newFakeAPIServer(t)
+	path := writeKubeconfig(t, s)
+
+	k, err := loadKubeconfig(path, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k.server != s.URL || k.namespace != "apps" {
+		t.Errorf("current context resolved to %s, namespace %q", k.server, k.namespace)
+	}
+	if want := filepath.Join(filepath.Dir(path), "token"); k.tokenFile != want {
+		t.Errorf("token file is %s, want %s", k.tokenFile, want)
+	}
+
+	// The CA is trusted although the working directory has no certs/ca.crt
+	var list map[string]interface{}
+	if err := k.do(context.Background(), http.MethodGet, k.server+"/api/v1/namespaces/apps/secrets/missing", nil, &list); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("request through the kubeconfig returned %v, want a 404 from the server", err)
+	}
+
+	k, err = loadKubeconfig(path, "other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k.server != "https://other.invalid" || k.namespace != "" {
+		t.Errorf("context other resolved to %s, namespace %q", k.server, k.namespace)
+	}
+
+	if _, err := loadKubeconfig(path, "missing"); err == nil {
+		t.Error("unknown context was accepted")
+	}
+	if _, err := New(TypeKubernetes, []byte(`{"name": "web-tls", "context": "missing"}`), Options{Kubeconfig: path}); err == nil {
+		t.Error("target with an unknown context was accepted")
+	}
+}
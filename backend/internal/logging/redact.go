@@ -0,0 +1,105 @@
+package logging
+
+import (
+	"log/slog"
+	"regexp"
+	"strings"
+)
+
+// Redacted replaces secrets in log records
+const Redacted = "[REDACTED]"
+
+// secretPatterns match secrets that can turn up in messages, errors and
+// command output
+var secretPatterns = []struct {
+	re   *regexp.Regexp
+	repl string
+}{
+	// PEM private keys, encrypted or not
+	{regexp.MustCompile(`-----BEGIN [A-Z0-9 ]*PRIVATE KEY-----[\s\S]*?(-----END [A-Z0-9 ]*PRIVATE KEY-----|$)`), Redacted},
+	// JWTs, JWS and JWE in compact form, such as one-time tokens
+	{regexp.MustCompile(`eyJ[A-Za-z0-9_-]*\.[A-Za-z0-9_.-]+`), Redacted},
+	// Vault tokens
+	{regexp.MustCompile(`\bhv[sbr]\.[A-Za-z0-9_-]{20,}`), Redacted},
+	// Authorization headers
+	{regexp.MustCompile(`(?i)\b(bearer|basic)\s+[A-Za-z0-9._~+/=-]+`), "$1 " + Redacted},
+	// password=..., "secret": "...", token: ...
+	{regexp.MustCompile(`(?i)((?:password|passwd|secret|token|api[_-]?key)["']?\s*[:=]\s*["']?)[^\s"',&}]+`), "${1}" + Redacted},
+}
+
+// Redact removes private keys, tokens and passwords from s
+func Redact(s string) string {
+	for _, p := range secretPatterns {
+		s = p.re.ReplaceAllString(s, p.repl)
+	}
+	return s
+}
+
+// RedactArgs redacts command line arguments: the values of flags naming a
+// secret, and anything Redact finds
+func RedactArgs(args []string) []string {
+	redacted := make([]string, len(args))
+	secretValue := false
+	for i, arg := range args {
+		switch {
+		case secretValue:
+			redacted[i] = Redacted
+			secretValue = false
+		case strings.HasPrefix(arg, "-"):
+			name, _, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+			if SensitiveKey(name) {
+				if hasValue {
+					redacted[i] = arg[:strings.Index(arg, "=")+1] + Redacted
+					continue
+				}
+				secretValue = true
+			}
+			redacted[i] = Redact(arg)
+		default:
+			redacted[i] = Redact(arg)
+		}
+	}
+	return redacted
+}
+
+// SensitiveKey reports whether a field or flag with this name holds a secret.
+// Paths to files holding secrets are not secret themselves.
+func SensitiveKey(name string) bool {
+	k := strings.ToLower(strings.ReplaceAll(name, "-", "_"))
+	if strings.HasSuffix(k, "_file") || strings.HasSuffix(k, "_path") || strings.Contains(k, "public") {
+		return false
+	}
+	for _, s := range []string{"password", "passwd", "secret", "token", "authorization", "cookie", "private", "credential"} {
+		if strings.Contains(k, s) {
+			return true
+		}
+	}
+	if strings.Contains(k, "key") && strings.HasSuffix(k, "_pem") {
+		return true
+	}
+	return k == "key" || k == "ott" || strings.HasSuffix(k, "_key") || strings.HasSuffix(k, "apikey")
+}
+
+// redactAttr is the ReplaceAttr hook of the handler
+func redactAttr(_ []string, a slog.Attr) slog.Attr {
+	if a.Value.Kind() == slog.KindGroup {
+		return a
+	}
+	if SensitiveKey(a.Key) {
+		return slog.String(a.Key, Redacted)
+	}
+	switch a.Value.Kind() {
+	case slog.KindString:
+		a.Value = slog.StringValue(Redact(a.Value.String()))
+	case slog.KindAny:
+		switch v := a.Value.Any().(type) {
+		case error:
+			a.Value = slog.StringValue(Redact(v.Error()))
+		case []string:
+			a.Value = slog.AnyValue(RedactArgs(v))
+		case []byte:
+			a.Value = slog.StringValue(Redact(string(v)))
+		}
+	}
+	return a
+}
@@ -0,0 +1,75 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"step-ca-webui/internal/db"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ExpiringCertificates is how many of the soonest-expiring active
+// certificates get their own expiry series
+const ExpiringCertificates = 10
+
+// certificateStatuses are always reported, so alerts on them have a series
+// to evaluate even before the first certificate is issued
+var certificateStatuses = []string{"active", "revoked", "expired"}
+
+var (
+	certificatesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "certificates"),
+		"Certificates in the inventory, by status. Active certificates past their expiry count as expired.",
+		[]string{"status"}, nil)
+
+	expiryDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "certificate_expiry_seconds"),
+		"Seconds until the soonest-expiring active certificates expire.",
+		[]string{"id", "cn", "cert_type"}, nil)
+)
+
+// certificateCollector reads the inventory when metrics are scraped
+type certificateCollector struct {
+	db *db.Database
+}
+
+// RegisterCertificates reports the certificates in database with the
+// default registry
+func RegisterCertificates(database *db.Database) error {
+	return prometheus.Register(certificateCollector{db: database})
+}
+
+func (c certificateCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- certificatesDesc
+	ch <- expiryDesc
+}
+
+func (c certificateCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx := context.Background()
+	now := time.Now()
+
+	counts, err := c.db.CountCertificatesByStatus(ctx, now)
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(certificatesDesc, err)
+	} else {
+		for _, status := range certificateStatuses {
+			if _, ok := counts[status]; !ok {
+				counts[status] = 0
+			}
+		}
+		for status, count := range counts {
+			ch <- prometheus.MustNewConstMetric(certificatesDesc, prometheus.GaugeValue, float64(count), status)
+		}
+	}
+
+	certs, err := c.db.ListExpiringCertificates(ctx, now, ExpiringCertificates)
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(expiryDesc, err)
+		return
+	}
+	for _, cert := range certs {
+		ch <- prometheus.MustNewConstMetric(expiryDesc, prometheus.GaugeValue,
+			cert.NotAfter.Sub(now).Seconds(), cert.ID, cert.CN, cert.CertType)
+	}
+}
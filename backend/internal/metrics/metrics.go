@@ -0,0 +1,103 @@
+package metrics
+
+import (
+	"crypto/subtle"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// namespace prefixes every metric name
+const namespace = "stepca_webui"
+
+// Outcomes of operations and CA calls
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+)
+
+// Certificate operations. Issuance, CSR signing and renewal are counted once
+// the request reaches the CA; requests rejected by validation, policy or the
+// approval workflow are not.
+const (
+	OpIssue   = "issue"
+	OpSign    = "sign"
+	OpRenew   = "renew"
+	OpRevoke  = "revoke"
+	OpSignSSH = "ssh_sign"
+)
+
+// operationBuckets cover a CA call up to the CA client timeout
+var operationBuckets = []float64{.05, .1, .25, .5, 1, 2.5, 5, 10, 30}
+
+var (
+	operations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "operations_total",
+		Help:      "Certificate operations sent to the CA, by operation and outcome.",
+	}, []string{"operation", "outcome"})
+
+	operationDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "operation_duration_seconds",
+		Help:      "Time the CA took to complete certificate operations, by operation and outcome.",
+		Buckets:   operationBuckets,
+	}, []string{"operation", "outcome"})
+
+	caCallDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "ca_call_duration_seconds",
+		Help:      "Latency of step CLI subcommands and CA API requests, by call and outcome.",
+		Buckets:   operationBuckets,
+	}, []string{"call", "outcome"})
+)
+
+// outcome maps an error to an outcome label
+func outcome(err error) string {
+	if err != nil {
+		return OutcomeFailure
+	}
+	return OutcomeSuccess
+}
+
+// ObserveOperation records a certificate operation started at start that
+// ended with err
+func ObserveOperation(operation string, start time.Time, err error) {
+	o := outcome(err)
+	operations.WithLabelValues(operation, o).Inc()
+	operationDuration.WithLabelValues(operation, o).Observe(time.Since(start).Seconds())
+}
+
+// ObserveCACall records a call to the CA started at start that ended with
+// err. call names a step subcommand, such as "step ca token", or a CA
+// endpoint without variable parts, such as "GET /root/:sha".
+func ObserveCACall(call string, start time.Time, err error) {
+	caCallDuration.WithLabelValues(call, outcome(err)).Observe(time.Since(start).Seconds())
+}
+
+// Handler serves the metrics of the default registry. With a token, scrapes
+// must send it as a bearer token, since the expiry metrics name certificates.
+func Handler(token string) gin.HandlerFunc {
+	h := promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer,
+		promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{
+			ErrorLog:      slog.NewLogLogger(slog.Default().Handler(), slog.LevelError),
+			ErrorHandling: promhttp.ContinueOnError,
+		}))
+	return func(c *gin.Context) {
+		if token != "" {
+			bearer, _ := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(bearer), []byte(token)) != 1 {
+				c.Header("WWW-Authenticate", `Bearer realm="metrics"`)
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid metrics token"})
+				return
+			}
+		}
+		h.ServeHTTP(c.Writer, c.Request)
+	}
+}
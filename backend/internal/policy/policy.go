@@ -0,0 +1,354 @@
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+)
+
+const (
+	EffectAllow = "allow"
+	EffectDeny  = "deny"
+)
+
+// Name types a requested CN or SAN is classified as
+const (
+	NameDNS   = "dns"
+	NameIP    = "ip"
+	NameEmail = "email"
+	NameURI   = "uri"
+)
+
+// Scope limits a rule to particular callers. An empty scope applies to everyone.
+type Scope struct {
+	Users   []string `json:"users,omitempty"`
+	Roles   []string `json:"roles,omitempty"`
+	APIKeys []string `json:"api_keys,omitempty"`
+}
+
+type DNSRules struct {
+	Suffixes  []string `json:"suffixes,omitempty"`  // "example.com" matches example.com and any subdomain
+	Wildcards []string `json:"wildcards,omitempty"` // "*.example.com" matches exactly one label
+	Regex     []string `json:"regex,omitempty"`     // matched against the whole name
+}
+
+type Rule struct {
+	Name         string   `json:"name"`
+	Effect       string   `json:"effect"` // allow, deny
+	Scope        Scope    `json:"scope"`
+	DNS          DNSRules `json:"dns"`
+	IPCIDRs      []string `json:"ip_cidrs,omitempty"`
+	EmailDomains []string `json:"email_domains,omitempty"`
+	URIs         []string `json:"uris,omitempty"` // scheme and host such as "spiffe://example.org", optionally with a path prefix
+}
+
+type Config struct {
+	Rules []Rule `json:"rules"`
+}
+
+// Subject is the caller a policy is evaluated for
+type Subject struct {
+	User   string   `json:"user"`
+	Roles  []string `json:"roles"`
+	APIKey string   `json:"api_key"`
+}
+
+type Decision struct {
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Allowed bool   `json:"allowed"`
+	Rule    string `json:"rule,omitempty"`
+	Reason  string `json:"reason"`
+}
+
+type Result struct {
+	Allowed   bool       `json:"allowed"`
+	Decisions []Decision `json:"decisions"`
+}
+
+// Denied returns the decisions that rejected a name
+func (r *Result) Denied() []Decision {
+	var denied []Decision
+	for _, d := range r.Decisions {
+		if !d.Allowed {
+			denied = append(denied, d)
+		}
+	}
+	return denied
+}
+
+// Error summarises the denied names, or returns nil if everything was allowed
+func (r *Result) Error() error {
+	denied := r.Denied()
+	if len(denied) == 0 {
+		return nil
+	}
+	reasons := make([]string, 0, len(denied))
+	for _, d := range denied {
+		reasons = append(reasons, fmt.Sprintf("%s (%s)", d.Name, d.Reason))
+	}
+	return fmt.Errorf("denied by naming policy: %s", strings.Join(reasons, "; "))
+}
+
+type compiledRule struct {
+	Rule
+	regex    []*regexp.Regexp
+	networks []*net.IPNet
+	uris     []*url.URL
+}
+
+type Engine struct {
+	rules []*compiledRule
+}
+
+// Load reads a policy from a JSON file. An empty path yields an engine with
+// no rules, which allows every name.
+func Load(path string) (*Engine, error) {
+	if path == "" {
+		return New(Config{})
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read policy file: %w", err)
+	}
+
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse policy file: %w", err)
+	}
+
+	return New(cfg)
+}
+
+func New(cfg Config) (*Engine, error) {
+	engine := &Engine{}
+	for i, rule := range cfg.Rules {
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule-%d", i+1)
+		}
+		if rule.Effect != EffectAllow && rule.Effect != EffectDeny {
+			return nil, fmt.Errorf("policy rule %q: effect must be %q or %q", rule.Name, EffectAllow, EffectDeny)
+		}
+
+		compiled := &compiledRule{Rule: rule}
+		for _, expr := range rule.DNS.Regex {
+			re, err := regexp.Compile("^(?:" + expr + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("policy rule %q: invalid regex %q: %w", rule.Name, expr, err)
+			}
+			compiled.regex = append(compiled.regex, re)
+		}
+		for _, cidr := range rule.IPCIDRs {
+			_, network, err := net.ParseCIDR(cidr)
+			if err != nil {
+				return nil, fmt.Errorf("policy rule %q: invalid CIDR %q: %w", rule.Name, cidr, err)
+			}
+			compiled.networks = append(compiled.networks, network)
+		}
+		for _, prefix := range rule.URIs {
+			u, err := url.Parse(prefix)
+			if err != nil || u.Scheme == "" || u.Host == "" || u.User != nil || u.RawQuery != "" || u.Fragment != "" {
+				return nil, fmt.Errorf("policy rule %q: invalid URI %q: must be a scheme and host with an optional path", rule.Name, prefix)
+			}
+			compiled.uris = append(compiled.uris, u)
+		}
+
+		engine.rules = append(engine.rules, compiled)
+	}
+	return engine, nil
+}
+
+// Enabled reports whether any rules are configured
+func (e *Engine) Enabled() bool {
+	return len(e.rules) > 0
+}
+
+// Evaluate checks each name against the rules that apply to the subject.
+// A name is allowed when an applicable allow rule matches it and no
+// applicable deny rule does. Without any rules every name is allowed.
+func (e *Engine) Evaluate(subject Subject, names []string) *Result {
+	result := &Result{Allowed: true}
+
+	seen := make(map[string]bool)
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" || seen[strings.ToLower(name)] {
+			continue
+		}
+		seen[strings.ToLower(name)] = true
+
+		decision := e.evaluateName(subject, name)
+		if !decision.Allowed {
+			result.Allowed = false
+		}
+		result.Decisions = append(result.Decisions, decision)
+	}
+
+	return result
+}
+
+func (e *Engine) evaluateName(subject Subject, name string) Decision {
+	nameType := Classify(name)
+	decision := Decision{Name: name, Type: nameType}
+
+	if !e.Enabled() {
+		decision.Allowed = true
+		decision.Reason = "no policy configured"
+		return decision
+	}
+
+	var allowedBy string
+	for _, rule := range e.rules {
+		if !rule.appliesTo(subject) || !rule.matches(nameType, name) {
+			continue
+		}
+		if rule.Effect == EffectDeny {
+			decision.Rule = rule.Name
+			decision.Reason = "matched deny rule"
+			return decision
+		}
+		if allowedBy == "" {
+			allowedBy = rule.Name
+		}
+	}
+
+	if allowedBy == "" {
+		decision.Reason = "no allow rule matches"
+		return decision
+	}
+
+	decision.Allowed = true
+	decision.Rule = allowedBy
+	decision.Reason = "matched allow rule"
+	return decision
+}
+
+// Classify determines whether a name is an IP, email, URI or DNS name
+func Classify(name string) string {
+	switch {
+	case net.ParseIP(name) != nil:
+		return NameIP
+	case strings.Contains(name, "://"):
+		return NameURI
+	case strings.Contains(name, "@"):
+		return NameEmail
+	default:
+		return NameDNS
+	}
+}
+
+func (r *compiledRule) appliesTo(subject Subject) bool {
+	if len(r.Scope.Users) == 0 && len(r.Scope.Roles) == 0 && len(r.Scope.APIKeys) == 0 {
+		return true
+	}
+	if contains(r.Scope.Users, subject.User) {
+		return true
+	}
+	if subject.APIKey != "" && contains(r.Scope.APIKeys, subject.APIKey) {
+		return true
+	}
+	for _, role := range subject.Roles {
+		if contains(r.Scope.Roles, role) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *compiledRule) matches(nameType, name string) bool {
+	lower := strings.ToLower(name)
+
+	switch nameType {
+	case NameDNS:
+		for _, suffix := range r.DNS.Suffixes {
+			suffix = strings.ToLower(strings.TrimPrefix(suffix, "."))
+			if lower == suffix || strings.HasSuffix(lower, "."+suffix) {
+				return true
+			}
+		}
+		for _, wildcard := range r.DNS.Wildcards {
+			if matchWildcard(strings.ToLower(wildcard), lower) {
+				return true
+			}
+		}
+		for _, re := range r.regex {
+			if re.MatchString(lower) {
+				return true
+			}
+		}
+	case NameIP:
+		ip := net.ParseIP(name)
+		for _, network := range r.networks {
+			if network.Contains(ip) {
+				return true
+			}
+		}
+	case NameEmail:
+		domain := lower[strings.LastIndex(lower, "@")+1:]
+		for _, allowed := range r.EmailDomains {
+			allowed = strings.ToLower(allowed)
+			if domain == allowed || (strings.HasPrefix(allowed, ".") && strings.HasSuffix(domain, allowed)) {
+				return true
+			}
+		}
+	case NameURI:
+		u, err := url.Parse(name)
+		if err != nil || u.User != nil {
+			return false
+		}
+		for _, prefix := range r.uris {
+			if matchURI(prefix, u) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// matchWildcard matches "*.example.com" against a name with exactly one
+// additional label; the requested name may itself be a wildcard
+func matchWildcard(pattern, name string) bool {
+	if !strings.HasPrefix(pattern, "*.") {
+		return pattern == name
+	}
+	suffix := pattern[1:]
+	if !strings.HasSuffix(name, suffix) {
+		return false
+	}
+	label := strings.TrimSuffix(name, suffix)
+	return label != "" && !strings.Contains(label, ".")
+}
+
+// matchURI matches the scheme and host of a URI exactly and its path
+// against the prefix's path, which ends at a segment boundary. Paths with
+// dot segments never match, as they may resolve outside the prefix.
+func matchURI(prefix, u *url.URL) bool {
+	if u.Scheme != prefix.Scheme || !strings.EqualFold(u.Host, prefix.Host) {
+		return false
+	}
+	for _, segment := range strings.Split(u.Path, "/") {
+		if segment == "." || segment == ".." {
+			return false
+		}
+	}
+	if !strings.HasPrefix(u.Path, prefix.Path) {
+		return false
+	}
+	rest := u.Path[len(prefix.Path):]
+	return rest == "" || strings.HasSuffix(prefix.Path, "/") || strings.HasPrefix(rest, "/")
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
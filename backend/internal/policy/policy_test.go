@@ -0,0 +1,52 @@
+package policy
+
+import "testing"
+
+func TestURIRules(t *testing.T) {
+	engine, err := New(Config{Rules: []Rule{
+		{Name: "mesh", Effect: EffectAllow, URIs: []string{"spiffe://example.org/ns/prod", "https://Example.com/"}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, want := range map[string]bool{
+		"spiffe://example.org/ns/prod":                   true,
+		"spiffe://example.org/ns/prod/sa/web":            true,
+		"SPIFFE://EXAMPLE.ORG/ns/prod/sa/web":            true,
+		"spiffe://example.org/ns/production":             false,
+		"spiffe://example.org/NS/prod":                   false,
+		"spiffe://example.org/ns/prod/../staging":        false,
+		"spiffe://example.org/ns/prod/%2E%2E/staging":    false,
+		"spiffe://example.org.evil.com/ns/prod":          false,
+		"spiffe://example.org:8443/ns/prod":              false,
+		"spiffe://example.org@evil.com/ns/prod":          false,
+		"spiffe://evil.com/spiffe://example.org/ns/prod": false,
+		"https://example.org/ns/prod":                    false,
+		"https://example.com/":                           true,
+		"https://example.com/anything?q=1":               true,
+		"https://example.com":                            false,
+		"https://example.com.evil.com/":                  false,
+		"http://example.com/":                            false,
+	} {
+		result := engine.Evaluate(Subject{}, []string{name})
+		if result.Allowed != want {
+			t.Errorf("%s: allowed is %v, want %v", name, result.Allowed, want)
+		}
+	}
+}
+
+func TestInvalidURIRules(t *testing.T) {
+	for _, uri := range []string{
+		"example.org/ns/prod",
+		"spiffe:///ns/prod",
+		"spiffe://user@example.org/",
+		"https://example.com/?q=1",
+		"https://example.com/#top",
+		"https://exa mple.com/",
+	} {
+		if _, err := New(Config{Rules: []Rule{{Effect: EffectAllow, URIs: []string{uri}}}}); err == nil {
+			t.Errorf("URI rule %q was accepted", uri)
+		}
+	}
+}
@@ -0,0 +1,345 @@
+package profile
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"step-ca-webui/internal/step"
+)
+
+// Names follow the step-ca certificate template keyUsage/extKeyUsage values
+var keyUsages = map[string]x509.KeyUsage{
+	"digitalSignature":  x509.KeyUsageDigitalSignature,
+	"contentCommitment": x509.KeyUsageContentCommitment,
+	"keyEncipherment":   x509.KeyUsageKeyEncipherment,
+	"dataEncipherment":  x509.KeyUsageDataEncipherment,
+	"keyAgreement":      x509.KeyUsageKeyAgreement,
+	"certSign":          x509.KeyUsageCertSign,
+	"crlSign":           x509.KeyUsageCRLSign,
+	"encipherOnly":      x509.KeyUsageEncipherOnly,
+	"decipherOnly":      x509.KeyUsageDecipherOnly,
+}
+
+var extKeyUsages = map[string]x509.ExtKeyUsage{
+	"any":             x509.ExtKeyUsageAny,
+	"serverAuth":      x509.ExtKeyUsageServerAuth,
+	"clientAuth":      x509.ExtKeyUsageClientAuth,
+	"codeSigning":     x509.ExtKeyUsageCodeSigning,
+	"emailProtection": x509.ExtKeyUsageEmailProtection,
+	"timeStamping":    x509.ExtKeyUsageTimeStamping,
+	"ocspSigning":     x509.ExtKeyUsageOCSPSigning,
+}
+
+type Subject struct {
+	Organization       []string `json:"organization,omitempty"`
+	OrganizationalUnit []string `json:"organizational_unit,omitempty"`
+	Country            []string `json:"country,omitempty"`
+	Province           []string `json:"province,omitempty"`
+	Locality           []string `json:"locality,omitempty"`
+}
+
+type Profile struct {
+	Name         string   `json:"name"`
+	Description  string   `json:"description"`
+	DefaultDays  int      `json:"default_days"`
+	MinDays      int      `json:"min_days"`
+	MaxDays      int      `json:"max_days"`
+	AllowedSANs  []string `json:"allowed_sans"` // exact, "*.example.com", ".example.com" or CIDR
+	KeyType      string   `json:"key_type"`
+	KeyUsages    []string `json:"key_usages"`
+	ExtKeyUsages []string `json:"ext_key_usages"`
+	Subject      Subject  `json:"subject"`
+	Format       string   `json:"format"` // see step.Format constants
+	// Provisioner issues this profile's certificates instead of the default one
+	Provisioner *step.Credentials `json:"provisioner,omitempty"`
+}
+
+// Limits bound the lifetime of issued certificates. Zero values are unbounded.
+type Limits struct {
+	Min         time.Duration `json:"min"`
+	Max         time.Duration `json:"max"`
+	MaxBackdate time.Duration `json:"max_backdate"`
+}
+
+type Registry struct {
+	profiles map[string]*Profile
+	limits   Limits
+}
+
+// Load reads profile definitions from a JSON file containing an array of
+// profiles. An empty path yields an empty registry. The global limits apply
+// to every request and are narrowed further by each profile.
+func Load(path string, limits Limits) (*Registry, error) {
+	registry := &Registry{profiles: make(map[string]*Profile), limits: limits}
+	if path == "" {
+		return registry, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read profiles file: %w", err)
+	}
+
+	var profiles []*Profile
+	if err := json.Unmarshal(data, &profiles); err != nil {
+		return nil, fmt.Errorf("failed to parse profiles file: %w", err)
+	}
+
+	for _, p := range profiles {
+		if err := p.Validate(); err != nil {
+			return nil, err
+		}
+		if _, exists := registry.profiles[p.Name]; exists {
+			return nil, fmt.Errorf("duplicate profile %q", p.Name)
+		}
+		registry.profiles[p.Name] = p
+	}
+
+	return registry, nil
+}
+
+func (r *Registry) Get(name string) (*Profile, bool) {
+	p, ok := r.profiles[name]
+	return p, ok
+}
+
+// Limits returns the lifetime limits for a request using the profile and its
+// provisioner, or the global limits when p is nil
+func (r *Registry) Limits(p *Profile) Limits {
+	limits := r.limits
+	if p == nil {
+		return limits
+	}
+	if min := time.Duration(p.MinDays) * 24 * time.Hour; min > limits.Min {
+		limits.Min = min
+	}
+	if max := time.Duration(p.MaxDays) * 24 * time.Hour; max > 0 && (limits.Max == 0 || max < limits.Max) {
+		limits.Max = max
+	}
+	if p.Provisioner != nil {
+		min, max := p.Provisioner.Lifetimes()
+		if min > limits.Min {
+			limits.Min = min
+		}
+		if max > 0 && (limits.Max == 0 || max < limits.Max) {
+			limits.Max = max
+		}
+	}
+	return limits
+}
+
+func (r *Registry) List() []*Profile {
+	profiles := make([]*Profile, 0, len(r.profiles))
+	for _, p := range r.profiles {
+		profiles = append(profiles, p)
+	}
+	sort.Slice(profiles, func(i, j int) bool { return profiles[i].Name < profiles[j].Name })
+	return profiles
+}
+
+func (p *Profile) Validate() error {
+	if p.Name == "" {
+		return fmt.Errorf("profile name is required")
+	}
+	if p.DefaultDays < 0 || p.MinDays < 0 || p.MaxDays < 0 {
+		return fmt.Errorf("profile %q: lifetimes must not be negative", p.Name)
+	}
+	if p.MaxDays > 0 && p.DefaultDays > p.MaxDays {
+		return fmt.Errorf("profile %q: default_days exceeds max_days", p.Name)
+	}
+	if p.MaxDays > 0 && p.MinDays > p.MaxDays {
+		return fmt.Errorf("profile %q: min_days exceeds max_days", p.Name)
+	}
+	if p.DefaultDays > 0 && p.DefaultDays < p.MinDays {
+		return fmt.Errorf("profile %q: default_days is below min_days", p.Name)
+	}
+	if p.KeyType != "" && !step.IsValidKeyType(p.KeyType) {
+		return fmt.Errorf("profile %q: unsupported key type %q", p.Name, p.KeyType)
+	}
+	for _, ku := range p.KeyUsages {
+		if _, ok := keyUsages[ku]; !ok {
+			return fmt.Errorf("profile %q: unknown key usage %q", p.Name, ku)
+		}
+	}
+	for _, eku := range p.ExtKeyUsages {
+		if _, ok := extKeyUsages[eku]; !ok {
+			return fmt.Errorf("profile %q: unknown extended key usage %q", p.Name, eku)
+		}
+	}
+	if p.Format != "" && !step.IsValidFormat(p.Format) {
+		return fmt.Errorf("profile %q: unsupported format %q", p.Name, p.Format)
+	}
+	for _, pattern := range p.AllowedSANs {
+		if strings.Contains(pattern, "/") {
+			if _, _, err := net.ParseCIDR(pattern); err != nil {
+				return fmt.Errorf("profile %q: invalid CIDR %q", p.Name, pattern)
+			}
+		}
+	}
+	if p.Provisioner != nil {
+		if err := p.Provisioner.Validate(); err != nil {
+			return fmt.Errorf("profile %q: %w", p.Name, err)
+		}
+		if _, max := p.Provisioner.Lifetimes(); max > 0 && time.Duration(p.DefaultDays)*24*time.Hour > max {
+			return fmt.Errorf("profile %q: default_days exceeds the provisioner's max_lifetime", p.Name)
+		}
+	}
+	return nil
+}
+
+// AllowsName reports whether a CN or SAN is permitted by the profile's
+// allowed SAN patterns. Profiles without patterns allow every name.
+func (p *Profile) AllowsName(name string) bool {
+	if len(p.AllowedSANs) == 0 {
+		return true
+	}
+	for _, pattern := range p.AllowedSANs {
+		if matchPattern(pattern, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// TemplateData returns the values passed to the provisioner's certificate
+// template so it can set the subject and key usages for this profile.
+func (p *Profile) TemplateData() map[string]interface{} {
+	data := map[string]interface{}{
+		"profile": p.Name,
+	}
+	if len(p.KeyUsages) > 0 {
+		data["keyUsage"] = p.KeyUsages
+	}
+	if len(p.ExtKeyUsages) > 0 {
+		data["extKeyUsage"] = p.ExtKeyUsages
+	}
+
+	subject := map[string]interface{}{}
+	if len(p.Subject.Organization) > 0 {
+		subject["organization"] = p.Subject.Organization
+	}
+	if len(p.Subject.OrganizationalUnit) > 0 {
+		subject["organizationalUnit"] = p.Subject.OrganizationalUnit
+	}
+	if len(p.Subject.Country) > 0 {
+		subject["country"] = p.Subject.Country
+	}
+	if len(p.Subject.Province) > 0 {
+		subject["province"] = p.Subject.Province
+	}
+	if len(p.Subject.Locality) > 0 {
+		subject["locality"] = p.Subject.Locality
+	}
+	if len(subject) > 0 {
+		data["subject"] = subject
+	}
+
+	return data
+}
+
+// CheckCertificate reports an error when a certificate issued for the
+// profile lacks its key usages or extended key usages, or carries others.
+// The usages only reach the CA as template data, so a provisioner template
+// that ignores them would otherwise go unnoticed.
+func (p *Profile) CheckCertificate(cert *x509.Certificate) error {
+	if len(p.KeyUsages) > 0 {
+		var want x509.KeyUsage
+		for _, ku := range p.KeyUsages {
+			want |= keyUsages[ku]
+		}
+		if cert.KeyUsage != want {
+			return fmt.Errorf("CA issued key usages %s instead of %s; its provisioner template must use the profile's keyUsage",
+				keyUsageNames(cert.KeyUsage), keyUsageNames(want))
+		}
+	}
+	if len(p.ExtKeyUsages) > 0 {
+		want := make([]x509.ExtKeyUsage, 0, len(p.ExtKeyUsages))
+		for _, eku := range p.ExtKeyUsages {
+			want = append(want, extKeyUsages[eku])
+		}
+		if !sameExtKeyUsages(cert.ExtKeyUsage, want) || len(cert.UnknownExtKeyUsage) > 0 {
+			return fmt.Errorf("CA issued extended key usages %s instead of %s; its provisioner template must use the profile's extKeyUsage",
+				extKeyUsageNames(cert.ExtKeyUsage, len(cert.UnknownExtKeyUsage)), extKeyUsageNames(want, 0))
+		}
+	}
+	return nil
+}
+
+func sameExtKeyUsages(a, b []x509.ExtKeyUsage) bool {
+	for _, eku := range a {
+		if !slices.Contains(b, eku) {
+			return false
+		}
+	}
+	for _, eku := range b {
+		if !slices.Contains(a, eku) {
+			return false
+		}
+	}
+	return true
+}
+
+func keyUsageNames(usage x509.KeyUsage) string {
+	var names []string
+	for name, ku := range keyUsages {
+		if usage&ku != 0 {
+			names = append(names, name)
+		}
+	}
+	return formatNames(names)
+}
+
+func extKeyUsageNames(usages []x509.ExtKeyUsage, unknown int) string {
+	var names []string
+	for name, eku := range extKeyUsages {
+		if slices.Contains(usages, eku) {
+			names = append(names, name)
+		}
+	}
+	for i := 0; i < unknown; i++ {
+		names = append(names, "unknown")
+	}
+	return formatNames(names)
+}
+
+func formatNames(names []string) string {
+	sort.Strings(names)
+	return "[" + strings.Join(names, " ") + "]"
+}
+
+func matchPattern(pattern, name string) bool {
+	pattern = strings.ToLower(strings.TrimSpace(pattern))
+	name = strings.ToLower(strings.TrimSpace(name))
+
+	if strings.Contains(pattern, "/") {
+		_, network, err := net.ParseCIDR(pattern)
+		if err != nil {
+			return false
+		}
+		ip := net.ParseIP(name)
+		return ip != nil && network.Contains(ip)
+	}
+
+	switch {
+	case strings.HasPrefix(pattern, "*."):
+		// Wildcard matches exactly one label
+		suffix := pattern[1:]
+		if !strings.HasSuffix(name, suffix) {
+			return false
+		}
+		label := strings.TrimSuffix(name, suffix)
+		return label != "" && !strings.Contains(label, ".")
+	case strings.HasPrefix(pattern, "."):
+		// Leading dot matches any subdomain depth
+		return strings.HasSuffix(name, pattern)
+	default:
+		return name == pattern
+	}
+}
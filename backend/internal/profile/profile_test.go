@@ -0,0 +1,155 @@
+package profile
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"step-ca-webui/internal/step"
+)
+
+func TestCheckCertificate(t *testing.T) {
+	p := &Profile{
+		Name:         "kafka-broker",
+		KeyUsages:    []string{"digitalSignature", "keyEncipherment"},
+		ExtKeyUsages: []string{"serverAuth", "clientAuth"},
+	}
+	if err := p.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name string
+		cert *x509.Certificate
+		err  string
+	}{
+		{
+			name: "matching usages",
+			cert: &x509.Certificate{
+				KeyUsage:    x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+				ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+			},
+		},
+		{
+			name: "missing key usage",
+			cert: &x509.Certificate{
+				KeyUsage:    x509.KeyUsageDigitalSignature,
+				ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+			},
+			err: "key usages [digitalSignature] instead of [digitalSignature keyEncipherment]",
+		},
+		{
+			name: "extra key usage",
+			cert: &x509.Certificate{
+				KeyUsage:    x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment | x509.KeyUsageCertSign,
+				ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+			},
+			err: "key usages [certSign digitalSignature keyEncipherment]",
+		},
+		{
+			name: "missing extended key usage",
+			cert: &x509.Certificate{
+				KeyUsage:    x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+				ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+			},
+			err: "extended key usages [serverAuth] instead of [clientAuth serverAuth]",
+		},
+		{
+			name: "extra extended key usage",
+			cert: &x509.Certificate{
+				KeyUsage:    x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+				ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageCodeSigning},
+			},
+			err: "extended key usages [clientAuth codeSigning serverAuth]",
+		},
+		{
+			name: "unknown extended key usage",
+			cert: &x509.Certificate{
+				KeyUsage:           x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+				ExtKeyUsage:        []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+				UnknownExtKeyUsage: []asn1.ObjectIdentifier{{1, 3, 6, 1, 4, 1, 311, 10, 3, 4}},
+			},
+			err: "extended key usages [clientAuth serverAuth unknown]",
+		},
+	} {
+		err := p.CheckCertificate(tt.cert)
+		switch {
+		case tt.err == "" && err != nil:
+			t.Errorf("%s: %v", tt.name, err)
+		case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+			t.Errorf("%s: got %v, want an error about %s", tt.name, err, tt.err)
+		}
+	}
+
+	// Profiles without usages accept whatever the CA issued
+	unconstrained := &Profile{Name: "default"}
+	if err := unconstrained.CheckCertificate(&x509.Certificate{KeyUsage: x509.KeyUsageCertSign}); err != nil {
+		t.Errorf("profile without usages rejected a certificate: %v", err)
+	}
+}
+
+func TestValidateUsages(t *testing.T) {
+	for _, p := range []*Profile{
+		{Name: "typo", KeyUsages: []string{"digitalsignature"}},
+		{Name: "openssl", ExtKeyUsages: []string{"TLS Web Server Authentication"}},
+	} {
+		if err := p.Validate(); err == nil {
+			t.Errorf("profile %s was accepted", p.Name)
+		}
+	}
+
+	p := &Profile{Name: "web", KeyUsages: []string{"digitalSignature"}, ExtKeyUsages: []string{"serverAuth"}}
+	if err := p.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	data := p.TemplateData()
+	if ku, _ := data["keyUsage"].([]string); strings.Join(ku, " ") != "digitalSignature" {
+		t.Errorf("template keyUsage is %v", data["keyUsage"])
+	}
+	if eku, _ := data["extKeyUsage"].([]string); strings.Join(eku, " ") != "serverAuth" {
+		t.Errorf("template extKeyUsage is %v", data["extKeyUsage"])
+	}
+}
+
+func TestLimits(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "profiles.json")
+	if err := os.WriteFile(path, []byte(`[
+		{"name": "web", "min_days": 1, "max_days": 90},
+		{"name": "short", "max_days": 90, "provisioner": {"type": "oidc", "name": "short", "min_lifetime": "1h", "max_lifetime": "7d"}},
+		{"name": "open", "provisioner": {"type": "oidc", "name": "open"}}
+	]`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	global := Limits{Min: 5 * time.Minute, Max: 397 * 24 * time.Hour, MaxBackdate: time.Hour}
+	registry, err := Load(path, global)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, want := range map[string]Limits{
+		"":      global,
+		"web":   {Min: 24 * time.Hour, Max: 90 * 24 * time.Hour, MaxBackdate: time.Hour},
+		"short": {Min: time.Hour, Max: 7 * 24 * time.Hour, MaxBackdate: time.Hour},
+		"open":  global,
+	} {
+		p, _ := registry.Get(name)
+		if got := registry.Limits(p); got != want {
+			t.Errorf("%q has limits %+v, want %+v", name, got, want)
+		}
+	}
+
+	for _, p := range []*Profile{
+		{Name: "bad-duration", Provisioner: &step.Credentials{Type: "oidc", Provisioner: "p", MaxLifetime: "a week"}},
+		{Name: "negative", Provisioner: &step.Credentials{Type: "oidc", Provisioner: "p", MinLifetime: "-1h"}},
+		{Name: "inverted", Provisioner: &step.Credentials{Type: "oidc", Provisioner: "p", MinLifetime: "2d", MaxLifetime: "1d"}},
+		{Name: "long-default", DefaultDays: 30, Provisioner: &step.Credentials{Type: "oidc", Provisioner: "p", MaxLifetime: "7d"}},
+	} {
+		if err := p.Validate(); err == nil {
+			t.Errorf("profile %s was accepted", p.Name)
+		}
+	}
+}
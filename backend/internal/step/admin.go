@@ -0,0 +1,311 @@
+package step
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// adminIssuer is the issuer step-ca expects in tokens for its admin API
+const adminIssuer = "step-admin-client/1.0"
+
+// adminTokenLifetime bounds how long an admin token is accepted; each token
+// is used for a single request
+const adminTokenLifetime = 5 * time.Minute
+
+// AdminConfig locates the certificate of a step-ca admin, as issued with
+// `step ca certificate <admin subject> admin.crt admin.key`. The files are
+// read on every request so a renewed certificate is picked up.
+type AdminConfig struct {
+	CertFile string // leaf followed by the intermediates
+	KeyFile  string // unencrypted PEM private key
+}
+
+// AdminClient talks to the remote administration API of step-ca, which
+// must run with provisioners stored in its database ("enableAdmin": true)
+type AdminClient struct {
+	step *StepClient
+	cfg  AdminConfig
+}
+
+// NewAdminClient checks that the admin credentials can be read
+func NewAdminClient(s *StepClient, cfg AdminConfig) (*AdminClient, error) {
+	a := &AdminClient{step: s, cfg: cfg}
+	if _, _, err := a.credentials(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// credentials reads the admin certificate chain and its private key
+func (a *AdminClient) credentials() ([]*x509.Certificate, crypto.Signer, error) {
+	return loadKeyPair(a.cfg.CertFile, a.cfg.KeyFile, "admin")
+}
+
+// loadKeyPair reads a PEM certificate chain and the unencrypted private key
+// of its leaf. what names the pair in errors.
+func loadKeyPair(certFile, keyFile, what string) ([]*x509.Certificate, crypto.Signer, error) {
+	certPEM, err := os.ReadFile(certFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read %s certificate: %w", what, err)
+	}
+	chain, err := parseCertificates(certPEM)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid %s certificate: %w", what, err)
+	}
+
+	keyPEM, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read %s key: %w", what, err)
+	}
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, nil, fmt.Errorf("%s key is not PEM encoded", what)
+	}
+	if block.Type == "ENCRYPTED PRIVATE KEY" || block.Headers["Proc-Type"] != "" {
+		return nil, nil, fmt.Errorf("%s key is encrypted; store it without a password", what)
+	}
+	var key interface{}
+	switch block.Type {
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid %s key: %w", what, err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, nil, fmt.Errorf("unsupported %s key type %T", what, key)
+	}
+	if !publicKeysEqual(chain[0].PublicKey, signer.Public()) {
+		return nil, nil, fmt.Errorf("%s key does not match the %s certificate", what, what)
+	}
+	return chain, signer, nil
+}
+
+func publicKeysEqual(a, b crypto.PublicKey) bool {
+	key, ok := a.(interface{ Equal(crypto.PublicKey) bool })
+	return ok && key.Equal(b)
+}
+
+// token signs a single use token for the admin API endpoint at path. The
+// certificate chain travels in the x5c header; step-ca finds the admin by
+// the certificate's subject.
+func (a *AdminClient) token(path string) (string, error) {
+	chain, signer, err := a.credentials()
+	if err != nil {
+		return "", err
+	}
+
+	alg, err := jwsAlgorithm(signer)
+	if err != nil {
+		return "", err
+	}
+	x5c := make([]string, 0, len(chain))
+	for _, cert := range chain {
+		x5c = append(x5c, base64.StdEncoding.EncodeToString(cert.Raw))
+	}
+	jti := make([]byte, 32)
+	if _, err := rand.Read(jti); err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	header := map[string]interface{}{"alg": alg, "typ": "JWT", "x5c": x5c}
+	claims := map[string]interface{}{
+		"iss": adminIssuer,
+		"sub": chain[0].Subject.CommonName,
+		"aud": strings.TrimSuffix(a.step.CAURL, "/") + path,
+		"jti": hex.EncodeToString(jti),
+		"iat": now.Unix(),
+		"nbf": now.Unix(),
+		"exp": now.Add(adminTokenLifetime).Unix(),
+	}
+	return signJWS(signer, alg, header, claims)
+}
+
+// jwsAlgorithm picks the JWS algorithm matching a signing key
+func jwsAlgorithm(signer crypto.Signer) (string, error) {
+	switch key := signer.Public().(type) {
+	case *ecdsa.PublicKey:
+		switch key.Curve {
+		case elliptic.P256():
+			return "ES256", nil
+		case elliptic.P384():
+			return "ES384", nil
+		case elliptic.P521():
+			return "ES512", nil
+		}
+	case *rsa.PublicKey:
+		return "RS256", nil
+	case ed25519.PublicKey:
+		return "EdDSA", nil
+	}
+	return "", fmt.Errorf("unsupported admin key type %T", signer.Public())
+}
+
+// signJWS returns the compact serialization of a signed JWT
+func signJWS(signer crypto.Signer, alg string, header, claims map[string]interface{}) (string, error) {
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+	claimsJSON, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	input := base64.RawURLEncoding.EncodeToString(headerJSON) + "." + base64.RawURLEncoding.EncodeToString(claimsJSON)
+
+	var sig []byte
+	switch alg {
+	case "EdDSA":
+		sig, err = signer.Sign(rand.Reader, []byte(input), crypto.Hash(0))
+	case "RS256":
+		digest := sha256.Sum256([]byte(input))
+		sig, err = signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	default:
+		sig, err = signECDSA(signer, alg, []byte(input))
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to sign admin token: %w", err)
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// signECDSA signs with a JWS ECDSA algorithm, which encodes the signature
+// as the fixed size concatenation of r and s rather than ASN.1
+func signECDSA(signer crypto.Signer, alg string, input []byte) ([]byte, error) {
+	var digest []byte
+	var hash crypto.Hash
+	switch alg {
+	case "ES256":
+		sum := sha256.Sum256(input)
+		digest, hash = sum[:], crypto.SHA256
+	case "ES384":
+		sum := sha512.Sum384(input)
+		digest, hash = sum[:], crypto.SHA384
+	case "ES512":
+		sum := sha512.Sum512(input)
+		digest, hash = sum[:], crypto.SHA512
+	}
+	der, err := signer.Sign(rand.Reader, digest, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	var sig struct{ R, S *big.Int }
+	if _, err := asn1.Unmarshal(der, &sig); err != nil {
+		return nil, err
+	}
+	size := (signer.Public().(*ecdsa.PublicKey).Curve.Params().BitSize + 7) / 8
+	out := make([]byte, 2*size)
+	sig.R.FillBytes(out[:size])
+	sig.S.FillBytes(out[size:])
+	return out, nil
+}
+
+// adminDo calls an admin API endpoint with a fresh token
+func (a *AdminClient) adminDo(ctx context.Context, method, path string, query url.Values, body, out interface{}) error {
+	root, err := a.step.Root(ctx)
+	if err != nil {
+		return err
+	}
+	token, err := a.token(path)
+	if err != nil {
+		return err
+	}
+	target := path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	header := http.Header{"Authorization": {token}}
+	return a.step.caDoHeader(ctx, caHTTPClient(root), method, target, header, body, out)
+}
+
+type adminProvisionersResponse struct {
+	Provisioners []map[string]interface{} `json:"provisioners"`
+	NextCursor   string                   `json:"nextCursor"`
+}
+
+// ListProvisioners returns every provisioner of the CA
+func (a *AdminClient) ListProvisioners(ctx context.Context) ([]ProvisionerInfo, error) {
+	var infos []ProvisionerInfo
+	seen := map[string]bool{}
+	cursor := ""
+	for {
+		query := url.Values{"limit": {strconv.Itoa(provisionersPageSize)}}
+		if cursor != "" {
+			query.Set("cursor", cursor)
+		}
+		var resp adminProvisionersResponse
+		if err := a.adminDo(ctx, http.MethodGet, "/admin/provisioners", query, nil, &resp); err != nil {
+			return nil, err
+		}
+		for _, raw := range resp.Provisioners {
+			infos = append(infos, provisionerFromConfig(raw))
+		}
+		if resp.NextCursor == "" || len(resp.Provisioners) < provisionersPageSize || seen[resp.NextCursor] {
+			return infos, nil
+		}
+		seen[resp.NextCursor] = true
+		cursor = resp.NextCursor
+	}
+}
+
+// GetProvisioner returns a provisioner in step-ca's linkedca JSON form, which
+// is what UpdateProvisioner expects back
+func (a *AdminClient) GetProvisioner(ctx context.Context, name string) (LinkedProvisioner, error) {
+	var prov LinkedProvisioner
+	if err := a.adminDo(ctx, http.MethodGet, "/admin/provisioners/"+url.PathEscape(name), nil, nil, &prov); err != nil {
+		return nil, err
+	}
+	return prov, nil
+}
+
+// CreateProvisioner adds a provisioner to the CA
+func (a *AdminClient) CreateProvisioner(ctx context.Context, prov LinkedProvisioner) (LinkedProvisioner, error) {
+	var created LinkedProvisioner
+	if err := a.adminDo(ctx, http.MethodPost, "/admin/provisioners", nil, prov, &created); err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+// UpdateProvisioner replaces a provisioner with prov, which must keep the
+// ID, type and timestamps returned by GetProvisioner
+func (a *AdminClient) UpdateProvisioner(ctx context.Context, name string, prov LinkedProvisioner) (LinkedProvisioner, error) {
+	var updated LinkedProvisioner
+	if err := a.adminDo(ctx, http.MethodPut, "/admin/provisioners/"+url.PathEscape(name), nil, prov, &updated); err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
+
+// DeleteProvisioner removes a provisioner from the CA
+func (a *AdminClient) DeleteProvisioner(ctx context.Context, name string) error {
+	return a.adminDo(ctx, http.MethodDelete, "/admin/provisioners/"+url.PathEscape(name), nil, nil, nil)
+}
@@ -0,0 +1,269 @@
+package step
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"step-ca-webui/internal/logging"
+	"step-ca-webui/internal/metrics"
+	"step-ca-webui/internal/tracing"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// signRequest mirrors the body of step-ca's POST /1.0/sign
+type signRequest struct {
+	CSR          string                 `json:"csr"`
+	OTT          string                 `json:"ott"`
+	NotBefore    string                 `json:"notBefore,omitempty"`
+	NotAfter     string                 `json:"notAfter,omitempty"`
+	TemplateData map[string]interface{} `json:"templateData,omitempty"`
+}
+
+type signResponse struct {
+	ServerPEM    string   `json:"crt"`
+	CaPEM        string   `json:"ca"`
+	CertChainPEM []string `json:"certChain"`
+}
+
+type intermediatesResponse struct {
+	Certificates []string `json:"crts"`
+}
+
+type caError struct {
+	Status  int    `json:"status"`
+	Message string `json:"message"`
+}
+
+// CAError is an error response of the CA
+type CAError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *CAError) Error() string {
+	return fmt.Sprintf("CA returned %d: %s", e.StatusCode, e.Message)
+}
+
+// caHTTPClient returns an HTTP client that trusts only the CA root
+func caHTTPClient(root *x509.Certificate) *http.Client {
+	pool := x509.NewCertPool()
+	pool.AddCert(root)
+	return &http.Client{
+		Timeout: 30 * time.Second,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12},
+		},
+	}
+}
+
+// caDo sends a JSON request to the CA and decodes the JSON response into out
+func (s *StepClient) caDo(ctx context.Context, client *http.Client, method, path string, body, out interface{}) error {
+	return s.caDoHeader(ctx, client, method, path, nil, body, out)
+}
+
+// caDoHeader is caDo with additional request headers
+func (s *StepClient) caDoHeader(ctx context.Context, client *http.Client, method, path string, header http.Header, body, out interface{}) (err error) {
+	ctx, done := caCall(ctx, caCallName(method, path))
+	defer func() { done(err) }()
+
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to encode request: %w", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(s.CAURL, "/")+path, reader)
+	if err != nil {
+		return err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if id := logging.RequestID(ctx); id != "" {
+		req.Header.Set(logging.RequestIDHeader, id)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("CA request %s %s failed: %w", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read CA response: %w", err)
+	}
+
+	if resp.StatusCode >= 300 {
+		var caErr caError
+		if json.Unmarshal(data, &caErr) == nil && caErr.Message != "" {
+			return &CAError{StatusCode: resp.StatusCode, Message: caErr.Message}
+		}
+		return &CAError{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(data))}
+	}
+
+	if out != nil {
+		if err := json.Unmarshal(data, out); err != nil {
+			return fmt.Errorf("failed to decode CA response: %w", err)
+		}
+	}
+	return nil
+}
+
+// caCall starts a client span for a call to the CA, named after a step
+// subcommand or CA endpoint as by caCallName. The returned function ends the
+// span and records the latency of the call.
+func caCall(ctx context.Context, name string) (context.Context, func(error)) {
+	start := time.Now()
+	ctx, span := tracing.Start(ctx, name, trace.WithSpanKind(trace.SpanKindClient))
+	return ctx, func(err error) {
+		metrics.ObserveCACall(name, start, err)
+		tracing.End(span, err)
+	}
+}
+
+// caPathParams are the CA endpoints ending in a fingerprint or name, which
+// are left out of metric labels
+var caPathParams = map[string]string{
+	"/root/":               "/root/:sha",
+	"/admin/provisioners/": "/admin/provisioners/:name",
+}
+
+// caCallName names a CA request for metrics by its method and endpoint,
+// without query or path parameters
+func caCallName(method, path string) string {
+	path, _, _ = strings.Cut(path, "?")
+	for prefix, pattern := range caPathParams {
+		if strings.HasPrefix(path, prefix) {
+			path = pattern
+			break
+		}
+	}
+	return method + " " + path
+}
+
+// fetchIntermediates downloads the CA's intermediate certificates
+func (s *StepClient) fetchIntermediates(ctx context.Context, client *http.Client) ([]*x509.Certificate, error) {
+	var resp intermediatesResponse
+	if err := s.caDo(ctx, client, http.MethodGet, "/intermediates", nil, &resp); err != nil {
+		return nil, err
+	}
+
+	var certs []*x509.Certificate
+	for _, certPEM := range resp.Certificates {
+		parsed, err := parseCertificates([]byte(certPEM))
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, parsed...)
+	}
+	return certs, nil
+}
+
+// parseCertificates decodes every CERTIFICATE block in PEM data
+func parseCertificates(data []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse certificate: %w", err)
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificates found in PEM data")
+	}
+	return certs, nil
+}
+
+// EncodeCertificates encodes certs as concatenated PEM blocks
+func EncodeCertificates(certs ...*x509.Certificate) []byte {
+	var buf bytes.Buffer
+	for _, cert := range certs {
+		pem.Encode(&buf, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	}
+	return buf.Bytes()
+}
+
+// orderChain returns the intermediates needed to link leaf to root, ordered
+// from the leaf's issuer upwards. Certificates that are not part of the path
+// are dropped.
+func orderChain(leaf *x509.Certificate, candidates []*x509.Certificate, root *x509.Certificate) ([]*x509.Certificate, error) {
+	var chain []*x509.Certificate
+	used := make(map[int]bool)
+	current := leaf
+
+	for len(chain) <= len(candidates) {
+		if root != nil && current.CheckSignatureFrom(root) == nil {
+			return chain, nil
+		}
+
+		next := -1
+		for i, candidate := range candidates {
+			if used[i] || candidate.Equal(current) || (root != nil && candidate.Equal(root)) {
+				continue
+			}
+			if bytes.Equal(candidate.RawSubject, current.RawIssuer) && current.CheckSignatureFrom(candidate) == nil {
+				next = i
+				break
+			}
+		}
+		if next < 0 {
+			break
+		}
+
+		used[next] = true
+		chain = append(chain, candidates[next])
+		current = candidates[next]
+	}
+
+	if root == nil {
+		return chain, nil
+	}
+	return nil, fmt.Errorf("could not build a chain from %s to the root", leaf.Subject.CommonName)
+}
+
+// IssuingIntermediate returns the intermediate that signs leaf certificates:
+// the one furthest from root among those that chain to it. Intermediates
+// are not served in any particular order.
+func IssuingIntermediate(intermediates []*x509.Certificate, root *x509.Certificate) (*x509.Certificate, error) {
+	var issuing *x509.Certificate
+	depth := -1
+	for _, candidate := range intermediates {
+		chain, err := orderChain(candidate, intermediates, root)
+		if err != nil {
+			continue
+		}
+		if len(chain) > depth {
+			issuing, depth = candidate, len(chain)
+		}
+	}
+	if issuing == nil {
+		return nil, fmt.Errorf("no intermediate chains to the root %s", root.Subject.CommonName)
+	}
+	return issuing, nil
+}
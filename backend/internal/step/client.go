@@ -3,46 +3,102 @@ package step
 import (
 	"archive/zip"
 	"bytes"
+	"context"
+	"crypto/x509"
 	"encoding/pem"
 	"fmt"
-	"log"
+	"log/slog"
+	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
+
+	"step-ca-webui/internal/certfmt"
+	"step-ca-webui/internal/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type CertBundle struct {
-	CertPEM     []byte
-	KeyPEM      []byte
-	ChainPEM    []byte
-	FullChainPEM []byte
-	PFXData     []byte
-	Serial      string
-	NotAfter    time.Time
+	Leaf             *x509.Certificate
+	CertPEM          []byte
+	KeyPEM           []byte
+	ChainPEM         []byte // intermediates, then the root unless excluded
+	FullChainPEM     []byte // leaf followed by ChainPEM
+	IntermediatesPEM []byte
+	RootPEM          []byte
+	PFXData          []byte
+	Serial           string
+	NotAfter         time.Time
+}
+
+// Validity is the requested certificate lifetime. A zero NotBefore lets the
+// CA pick the current time.
+type Validity struct {
+	NotBefore time.Time
+	NotAfter  time.Time
+}
+
+type IssueOptions struct {
+	Auth         Auth
+	CN           string
+	SANs         []string
+	Validity     Validity
+	KeyType      string                 // see KeyType constants, defaults to DefaultKeyType
+	TemplateData map[string]interface{} // passed to the provisioner template
+	ExcludeRoot  bool                   // leave the root out of chain.pem and fullchain.pem
 }
 
 type StepClient struct {
-	CAURL               string
-	CARootFingerprint   string
-	ProvisionerName     string
-	ProvisionerPassword string
+	CAURL             string
+	CARootFingerprint string
+	Credentials       Credentials // default provisioner, see Auth
+	OCSPURL           string      // OCSP responder for revocation checks, optional
+
+	rootMu        sync.RWMutex
+	root          *x509.Certificate // pinned root, see LoadRoot
+	rootErr       error
+	rootChecked   time.Time
+	intermediates []*x509.Certificate
+	pinned        string // fingerprint persisted from an earlier run
 }
 
-func NewStepClient(caURL, caRootFingerprint, provisionerName, provisionerPassword string) *StepClient {
+func NewStepClient(caURL, caRootFingerprint string, credentials Credentials) *StepClient {
 	return &StepClient{
-		CAURL:               caURL,
-		CARootFingerprint:   caRootFingerprint,
-		ProvisionerName:     provisionerName,
-		ProvisionerPassword: provisionerPassword,
+		CAURL:             caURL,
+		CARootFingerprint: caRootFingerprint,
+		Credentials:       credentials,
 	}
 }
 
-func (s *StepClient) IssueCertificate(cn string, sans []string, notAfterDays int) (*CertBundle, error) {
-	log.Printf("DEBUG: IssueCertificate called with cn=%s, sans=%v, notAfterDays=%d\n", cn, sans, notAfterDays)
-	log.Printf("DEBUG: StepClient.CARootFingerprint='%s'\n", s.CARootFingerprint)
-	
+func (s *StepClient) IssueCertificate(ctx context.Context, opts IssueOptions) (_ *CertBundle, err error) {
+	ctx, span := tracing.Start(ctx, "StepClient.IssueCertificate")
+	defer func() { tracing.End(span, err) }()
+
+	cn, sans := opts.CN, opts.SANs
+	slog.DebugContext(ctx, "Issuing certificate", "cn", cn, "sans", sans, "not_after", opts.Validity.NotAfter, "key_type", opts.KeyType)
+
+	// Generate the private key locally so the key type is under our control.
+	// Large RSA keys take long enough to get their own span.
+	_, keySpan := tracing.Start(ctx, "GenerateKey", trace.WithAttributes(attribute.String("key_type", opts.KeyType)))
+	key, err := GenerateKey(opts.KeyType)
+	tracing.End(keySpan, err)
+	if err != nil {
+		return nil, err
+	}
+	keyPEM, err := EncodePrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	csrPEM, err := CreateCSR(key, cn, sans)
+	if err != nil {
+		return nil, err
+	}
+
 	// Create temporary directory for certificate files
 	tempDir, err := os.MkdirTemp("", "step-cert-*")
 	if err != nil {
@@ -50,424 +106,392 @@ func (s *StepClient) IssueCertificate(cn string, sans []string, notAfterDays int
 	}
 	defer os.RemoveAll(tempDir)
 
-	certPath := filepath.Join(tempDir, "cert.crt")
-	keyPath := filepath.Join(tempDir, "cert.key")
-	passwordFile := filepath.Join(tempDir, "password.txt")
-	rootPath := filepath.Join(tempDir, "root.crt")
+	leaf, intermediates, root, err := s.signCSR(ctx, tempDir, opts.Auth, csrPEM, cn, sans, opts.Validity, opts.TemplateData)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.buildBundle(leaf, intermediates, root, keyPEM, opts.ExcludeRoot), nil
+}
 
-	// Write password to file
-	if err := os.WriteFile(passwordFile, []byte(s.ProvisionerPassword), 0644); err != nil {
-		return nil, fmt.Errorf("failed to write password file: %w", err)
+func (s *StepClient) SignCSR(ctx context.Context, auth Auth, csrPEM string, validity Validity, excludeRoot bool) (_ *CertBundle, err error) {
+	ctx, span := tracing.Start(ctx, "StepClient.SignCSR")
+	defer func() { tracing.End(span, err) }()
+
+	// Create temporary directory
+	tempDir, err := os.MkdirTemp("", "step-csr-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp dir: %w", err)
 	}
+	defer os.RemoveAll(tempDir)
 
-	// Download root certificate
-	rootArgs := []string{
-		"ca", "root",
-		rootPath,
-		"--ca-url", s.CAURL,
+	// The token must carry the same subject and SANs as the CSR
+	csr, err := ParseCSR([]byte(csrPEM))
+	if err != nil {
+		return nil, err
 	}
-	if s.CARootFingerprint != "" {
-		rootArgs = append(rootArgs, "--fingerprint", s.CARootFingerprint)
+	subject := csr.Subject.CommonName
+	sans := CSRSANs(csr)
+	if subject == "" && len(sans) > 0 {
+		subject = sans[0]
 	}
-	// DEBUG: log the command and fingerprint
-	log.Printf("DEBUG [IssueCertificate]: Executing root command: step %v\n", rootArgs)
-	log.Printf("DEBUG [IssueCertificate]: CARootFingerprint value: '%s'\n", s.CARootFingerprint)
-	rootCmd := exec.Command("step", rootArgs...)
-	rootOutput, err := rootCmd.CombinedOutput()
+
+	slog.DebugContext(ctx, "Signing CSR", "subject", subject, "sans", sans, "not_after", validity.NotAfter)
+	leaf, intermediates, root, err := s.signCSR(ctx, tempDir, auth, []byte(csrPEM), subject, sans, validity, nil)
 	if err != nil {
-		log.Printf("DEBUG [IssueCertificate]: Root command FAILED: %s\n", string(rootOutput))
-		return nil, fmt.Errorf("step root command failed: %s, error: %w", string(rootOutput), err)
+		return nil, err
 	}
-	log.Printf("DEBUG [IssueCertificate]: Root command succeeded\n")
 
-	// First, generate a token
-	// Note: --not-after for token is token validity (default 5m), not certificate validity
-	tokenArgs := []string{
-		"ca", "token",
-		cn,
-		"--ca-url", s.CAURL,
-		"--root", rootPath,
-		"--provisioner", s.ProvisionerName,
-		"--provisioner-password-file", passwordFile,
+	return s.buildBundle(leaf, intermediates, root, nil, excludeRoot), nil
+}
+
+// signCSR obtains a provisioner token for the subject and SANs and has the
+// CA sign the CSR with it, returning the issued certificate, the
+// intermediates linking it to the root, and the root itself
+func (s *StepClient) signCSR(ctx context.Context, tempDir string, auth Auth, csrPEM []byte, subject string, sans []string, validity Validity, templateData map[string]interface{}) (*x509.Certificate, []*x509.Certificate, *x509.Certificate, error) {
+	// Use the pinned root; issuance stops if the CA root changed
+	root, err := s.Root(ctx)
+	if err != nil {
+		return nil, nil, nil, err
 	}
 
 	// Add SANs to token command if provided
+	var sanArgs []string
 	for _, san := range sans {
-		tokenArgs = append(tokenArgs, "--san", san)
+		sanArgs = append(sanArgs, "--san", san)
 	}
-
-	// Execute token command
-	tokenCmd := exec.Command("step", tokenArgs...)
-	// DEBUG: log the command being executed
-	log.Printf("DEBUG: Executing token command: step %v\n", tokenArgs)
-	tokenOutput, err := tokenCmd.CombinedOutput()
+	token, err := s.signToken(ctx, tempDir, root, auth, subject, sanArgs...)
 	if err != nil {
-		log.Printf("DEBUG: Token command FAILED: %s\n", string(tokenOutput))
-		return nil, fmt.Errorf("step token command failed: %s, error: %w", string(tokenOutput), err)
+		return nil, nil, nil, err
 	}
-	log.Printf("DEBUG: Token command succeeded, output length: %d\n", len(tokenOutput))
 
-	// Extract JWT token from output (it's the line starting with "ey")
-	// The step CLI outputs colored/formatted text before the actual token
-	token := ""
-	for _, line := range strings.Split(string(tokenOutput), "\n") {
-		trimmed := strings.TrimSpace(line)
-		if strings.HasPrefix(trimmed, "ey") {
-			token = trimmed
-			break
-		}
+	// Now use the token to sign the CSR. The sign API is called directly
+	// because its response carries the intermediates, which step ca sign
+	// does not write out.
+	signReq := signRequest{
+		CSR:          string(csrPEM),
+		OTT:          token,
+		NotAfter:     validity.NotAfter.UTC().Format(time.RFC3339),
+		TemplateData: templateData,
 	}
-	
-	if token == "" {
-		log.Printf("DEBUG: Could not extract token from output: %s\n", string(tokenOutput))
-		return nil, fmt.Errorf("failed to extract JWT token from step ca token output")
-	}
-	
-	log.Printf("DEBUG: Extracted token (first 20 chars): %s...\n", token[:min(20, len(token))])
-
-	// Now use the token to issue certificate
-	certArgs := []string{
-		"ca", "certificate",
-		cn,
-		certPath,
-		keyPath,
-		"--token", token,
-		"--ca-url", s.CAURL,
-		"--root", rootPath,
-		"--not-after", fmt.Sprintf("%dh", notAfterDays*24),
+	if !validity.NotBefore.IsZero() {
+		signReq.NotBefore = validity.NotBefore.UTC().Format(time.RFC3339)
 	}
 
-	// Execute certificate command
-	cmd := exec.Command("step", certArgs...)
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return nil, fmt.Errorf("step command failed: %s, error: %w", string(output), err)
+	client := caHTTPClient(root)
+	var signResp signResponse
+	if err := s.caDo(ctx, client, http.MethodPost, "/1.0/sign", signReq, &signResp); err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to sign certificate: %w", err)
 	}
 
-	// Read certificate and key files
-	certPEM, err := os.ReadFile(certPath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read cert file: %w", err)
+	// certChain holds the leaf followed by the intermediates; older CAs only
+	// return the leaf in crt and the issuer in ca
+	chainPEMs := signResp.CertChainPEM
+	if len(chainPEMs) == 0 {
+		chainPEMs = []string{signResp.ServerPEM, signResp.CaPEM}
 	}
-
-	keyPEM, err := os.ReadFile(keyPath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read key file: %w", err)
+	var issued []*x509.Certificate
+	for _, certPEM := range chainPEMs {
+		if certPEM == "" {
+			continue
+		}
+		certs, err := parseCertificates([]byte(certPEM))
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		issued = append(issued, certs...)
 	}
-
-	// Get certificate chain
-	chainPEM, err := s.getChain(certPath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get chain: %w", err)
+	if len(issued) == 0 {
+		return nil, nil, nil, fmt.Errorf("CA returned no certificate")
 	}
+	leaf := issued[0]
 
-	// Create full chain
-	fullChainPEM := append(certPEM, chainPEM...)
-
-	// Extract serial number and expiry from certificate
-	serial, notAfter, err := s.parseCertificate(certPEM)
+	intermediates, err := orderChain(leaf, issued[1:], root)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse certificate: %w", err)
+		// Fall back to the CA's published intermediates
+		published, fetchErr := s.fetchIntermediates(ctx, client)
+		if fetchErr != nil {
+			return nil, nil, nil, fmt.Errorf("%v; fetching intermediates failed: %w", err, fetchErr)
+		}
+		intermediates, err = orderChain(leaf, append(issued[1:], published...), root)
+		if err != nil {
+			return nil, nil, nil, err
+		}
 	}
 
-	return &CertBundle{
-		CertPEM:      certPEM,
-		KeyPEM:       keyPEM,
-		ChainPEM:     chainPEM,
-		FullChainPEM: fullChainPEM,
-		Serial:       serial,
-		NotAfter:     notAfter,
-	}, nil
+	return leaf, intermediates, root, nil
 }
 
-func (s *StepClient) SignCSR(csrPEM string, notAfterDays int) (*CertBundle, error) {
-	// Create temporary directory
-	tempDir, err := os.MkdirTemp("", "step-csr-*")
-	if err != nil {
-		return nil, fmt.Errorf("failed to create temp dir: %w", err)
-	}
-	defer os.RemoveAll(tempDir)
-
-	csrPath := filepath.Join(tempDir, "csr.pem")
-	certPath := filepath.Join(tempDir, "cert.crt")
-	passwordFile := filepath.Join(tempDir, "password.txt")
+// provisionerToken has the step CLI create a one-time token for subject,
+// signed for the named provisioner. extraArgs are passed to step ca token
+// and carry the key to sign with.
+func (s *StepClient) provisionerToken(ctx context.Context, tempDir string, root *x509.Certificate, provisioner, subject string, extraArgs ...string) (string, error) {
 	rootPath := filepath.Join(tempDir, "root.crt")
 
-	// Write CSR to file
-	if err := os.WriteFile(csrPath, []byte(csrPEM), 0644); err != nil {
-		return nil, fmt.Errorf("failed to write CSR file: %w", err)
+	if err := os.WriteFile(rootPath, EncodeCertificates(root), 0644); err != nil {
+		return "", fmt.Errorf("failed to write root certificate: %w", err)
 	}
 
-	// Write password to file
-	if err := os.WriteFile(passwordFile, []byte(s.ProvisionerPassword), 0644); err != nil {
-		return nil, fmt.Errorf("failed to write password file: %w", err)
-	}
-
-	// Download root certificate
-	rootArgs := []string{
-		"ca", "root",
-		rootPath,
-		"--ca-url", s.CAURL,
-	}
-	if s.CARootFingerprint != "" {
-		rootArgs = append(rootArgs, "--fingerprint", s.CARootFingerprint)
-	}
-	rootCmd := exec.Command("step", rootArgs...)
-	rootOutput, err := rootCmd.CombinedOutput()
-	if err != nil {
-		return nil, fmt.Errorf("step root command failed: %s, error: %w", string(rootOutput), err)
-	}
-
-	// First, generate a token (we need to extract CN from CSR)
-	// For now, use a generic subject - this could be improved by parsing the CSR
 	// Note: --not-after for token is token validity (default 5m), not certificate validity
 	tokenArgs := []string{
 		"ca", "token",
-		"csr-signing", // Generic subject for CSR signing
+		subject,
 		"--ca-url", s.CAURL,
 		"--root", rootPath,
-		"--provisioner", s.ProvisionerName,
-		"--provisioner-password-file", passwordFile,
+		"--provisioner", provisioner,
 	}
+	tokenArgs = append(tokenArgs, extraArgs...)
 
 	// Execute token command
-	tokenCmd := exec.Command("step", tokenArgs...)
+	slog.DebugContext(ctx, "Requesting provisioner token", "provisioner", provisioner, "subject", subject, "args", tokenArgs)
+	_, done := caCall(ctx, "step ca token")
+	tokenCmd := exec.CommandContext(ctx, "step", tokenArgs...)
 	tokenOutput, err := tokenCmd.CombinedOutput()
+	done(err)
 	if err != nil {
-		return nil, fmt.Errorf("step token command failed: %s, error: %w", string(tokenOutput), err)
+		return "", fmt.Errorf("step token command failed: %s, error: %w", string(tokenOutput), err)
 	}
 
-	token := strings.TrimSpace(string(tokenOutput))
-
-	// Now use the token to sign CSR
-	args := []string{
-		"ca", "sign",
-		csrPath,
-		certPath,
-		"--token", token,
-		"--ca-url", s.CAURL,
-		"--root", rootPath,
-		"--not-after", fmt.Sprintf("%dh", notAfterDays*24),
+	// Extract JWT token from output (it's the line starting with "ey")
+	// The step CLI outputs colored/formatted text before the actual token
+	token := ""
+	for _, line := range strings.Split(string(tokenOutput), "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "ey") {
+			token = trimmed
+			break
+		}
 	}
 
-	// Execute step command
-	cmd := exec.Command("step", args...)
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return nil, fmt.Errorf("step command failed: %s, error: %w", string(output), err)
+	if token == "" {
+		return "", fmt.Errorf("failed to extract JWT token from step ca token output")
 	}
+	return token, nil
+}
 
-	// Read certificate file
-	certPEM, err := os.ReadFile(certPath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read cert file: %w", err)
+// buildBundle assembles the PEM files for an issued certificate. The chain is
+// ordered from the leaf's issuer up to the root; excludeRoot leaves the root
+// out, as most TLS servers expect.
+func (s *StepClient) buildBundle(leaf *x509.Certificate, intermediates []*x509.Certificate, root *x509.Certificate, keyPEM []byte, excludeRoot bool) *CertBundle {
+	chain := intermediates
+	if !excludeRoot {
+		chain = append(append([]*x509.Certificate{}, intermediates...), root)
 	}
 
-	// Get certificate chain
-	chainPEM, err := s.getChain(certPath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get chain: %w", err)
+	certPEM := EncodeCertificates(leaf)
+	chainPEM := EncodeCertificates(chain...)
+
+	return &CertBundle{
+		Leaf:             leaf,
+		CertPEM:          certPEM,
+		KeyPEM:           keyPEM,
+		ChainPEM:         chainPEM,
+		FullChainPEM:     append(append([]byte{}, certPEM...), chainPEM...),
+		IntermediatesPEM: EncodeCertificates(intermediates...),
+		RootPEM:          EncodeCertificates(root),
+		Serial:           fmt.Sprintf("%X", leaf.SerialNumber),
+		NotAfter:         leaf.NotAfter,
 	}
+}
 
-	// Create full chain
-	fullChainPEM := append(certPEM, chainPEM...)
+// RevokeCertificate revokes the certificate with the decimal serial at the CA
+func (s *StepClient) RevokeCertificate(ctx context.Context, auth Auth, serial string) (err error) {
+	ctx, span := tracing.Start(ctx, "StepClient.RevokeCertificate")
+	defer func() { tracing.End(span, err) }()
 
-	// Extract serial number and expiry from certificate
-	serial, notAfter, err := s.parseCertificate(certPEM)
+	tempDir, err := os.MkdirTemp("", "step-revoke-*")
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse certificate: %w", err)
+		return fmt.Errorf("failed to create temp dir: %w", err)
 	}
+	defer os.RemoveAll(tempDir)
 
-	return &CertBundle{
-		CertPEM:      certPEM,
-		ChainPEM:     chainPEM,
-		FullChainPEM: fullChainPEM,
-		Serial:       serial,
-		NotAfter:     notAfter,
-	}, nil
-}
-
-func (s *StepClient) RevokeCertificate(serial string) error {
+	root, err := s.Root(ctx)
+	if err != nil {
+		return err
+	}
+	token, err := s.signToken(ctx, tempDir, root, auth, serial, "--revoke")
+	if err != nil {
+		return err
+	}
 	args := []string{
 		"ca", "revoke",
 		serial,
 		"--ca-url", s.CAURL,
-		"--provisioner", s.ProvisionerName,
-		"--password", s.ProvisionerPassword,
+		"--root", filepath.Join(tempDir, "root.crt"),
+		"--token", token,
 	}
 
-	cmd := exec.Command("step", args...)
+	slog.DebugContext(ctx, "Revoking certificate", "serial", serial, "args", args)
+	_, done := caCall(ctx, "step ca revoke")
+	cmd := exec.CommandContext(ctx, "step", args...)
 	output, err := cmd.CombinedOutput()
+	done(err)
 	if err != nil {
+		if strings.Contains(string(output), "is already revoked") {
+			return ErrAlreadyRevoked
+		}
 		return fmt.Errorf("step revoke failed: %s, error: %w", string(output), err)
 	}
 
 	return nil
 }
 
-func (s *StepClient) CreatePFX(certPEM, keyPEM, password string) ([]byte, error) {
-	// Create temporary directory
-	tempDir, err := os.MkdirTemp("", "step-pfx-*")
-	if err != nil {
-		return nil, fmt.Errorf("failed to create temp dir: %w", err)
-	}
-	defer os.RemoveAll(tempDir)
-
-	certPath := filepath.Join(tempDir, "cert.crt")
-	keyPath := filepath.Join(tempDir, "cert.key")
-	pfxPath := filepath.Join(tempDir, "cert.p12")
-	passwordPath := filepath.Join(tempDir, "password.txt")
-
-	// Write certificate and key files
-	if err := os.WriteFile(certPath, []byte(certPEM), 0644); err != nil {
-		return nil, fmt.Errorf("failed to write cert file: %w", err)
-	}
-	if err := os.WriteFile(keyPath, []byte(keyPEM), 0644); err != nil {
-		return nil, fmt.Errorf("failed to write key file: %w", err)
-	}
-	if err := os.WriteFile(passwordPath, []byte(password), 0644); err != nil {
-		return nil, fmt.Errorf("failed to write password file: %w", err)
-	}
-
-	// Build step command
-	args := []string{
-		"certificate", "p12",
-		pfxPath,
-		certPath,
-		keyPath,
-		"--password-file", passwordPath,
-	}
-
-	// Execute step command
-	cmd := exec.Command("step", args...)
-	output, err := cmd.CombinedOutput()
+// CreatePFX builds a PKCS#12 file with the key and full chain in memory
+func (s *StepClient) CreatePFX(bundle *CertBundle, password, encryption, friendlyName string) ([]byte, error) {
+	key, chain, err := bundleKeyAndChain(bundle)
 	if err != nil {
-		return nil, fmt.Errorf("step p12 command failed: %s, error: %w", string(output), err)
+		return nil, err
 	}
-
-	// Read PFX file
-	pfxData, err := os.ReadFile(pfxPath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read PFX file: %w", err)
+	if friendlyName == "" {
+		friendlyName = chain[0].Subject.CommonName
 	}
-
-	return pfxData, nil
+	return certfmt.PFX(key, chain, password, encryption, friendlyName)
 }
 
-func (s *StepClient) getChain(certPath string) ([]byte, error) {
-	// The step ca certificate command already provides the certificate
-	// We need to get the CA chain (intermediate + root)
-	// Download the root certificate using step ca root
-	
-	tempDir, err := os.MkdirTemp("", "step-chain-*")
+// bundleKeyAndChain parses the private key and the leaf-first chain of a bundle
+func bundleKeyAndChain(bundle *CertBundle) (interface{}, []*x509.Certificate, error) {
+	chain, err := parseCertificates(bundle.FullChainPEM)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create temp dir: %w", err)
+		return nil, nil, err
 	}
-	defer os.RemoveAll(tempDir)
-
-	rootPath := filepath.Join(tempDir, "root.crt")
-
-	// Download root certificate
-	rootArgs := []string{
-		"ca", "root",
-		rootPath,
-		"--ca-url", s.CAURL,
-	}
-	if s.CARootFingerprint != "" {
-		rootArgs = append(rootArgs, "--fingerprint", s.CARootFingerprint)
+	block, _ := pem.Decode(bundle.KeyPEM)
+	if block == nil {
+		return nil, nil, fmt.Errorf("failed to decode private key")
 	}
-	rootCmd := exec.Command("step", rootArgs...)
-	rootOutput, err := rootCmd.CombinedOutput()
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
 	if err != nil {
-		return nil, fmt.Errorf("step root command failed: %s, error: %w", string(rootOutput), err)
+		return nil, nil, fmt.Errorf("failed to parse private key: %w", err)
 	}
+	return key, chain, nil
+}
 
-	// Read the root certificate
-	chainPEM, err := os.ReadFile(rootPath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read root certificate: %w", err)
-	}
+// DownloadOptions selects the download format and the secrets protecting it
+type DownloadOptions struct {
+	Format           string // see the Format constants, defaults to FormatPEM
+	PFXPassword      string
+	PFXEncryption    string // certfmt.PFXModern (default) or certfmt.PFXLegacy
+	FriendlyName     string // name shown for the PFX entry, defaults to the CN
+	KeystoreAlias    string // alias of the key entry in keystore.jks, defaults to the CN
+	KeystorePassword string // protects keystore.jks and truststore.p12
+	KeyPassword      string // encrypts privkey.pem and the FormatPKCS8 key as PKCS#8
+	ZipPassword      string // encrypts every ZIP entry with AES-256
+	SecretName       string // name of the FormatK8s Secret, defaults to <cn>-tls
+	Namespace        string // namespace of the FormatK8s Secret
+}
 
-	return chainPEM, nil
+// bundleFile is one file of a download archive
+type bundleFile struct {
+	Name string
+	Data []byte
 }
 
-func (s *StepClient) parseCertificate(certPEM []byte) (string, time.Time, error) {
-	block, _ := pem.Decode(certPEM)
-	if block == nil {
-		return "", time.Time{}, fmt.Errorf("failed to decode PEM block")
+// bundleFiles lists the files of a download archive for the requested format
+func (s *StepClient) bundleFiles(bundle *CertBundle, opts DownloadOptions) ([]bundleFile, error) {
+	// Add certificate files
+	files := []bundleFile{
+		{"cert.pem", bundle.CertPEM},
+		{"chain.pem", bundle.ChainPEM},
+		{"fullchain.pem", bundle.FullChainPEM},
+		{"root.pem", bundle.RootPEM},
 	}
 
-	// Use openssl to get certificate details
-	cmd := exec.Command("openssl", "x509", "-noout", "-serial", "-enddate")
-	cmd.Stdin = bytes.NewReader(certPEM)
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return "", time.Time{}, fmt.Errorf("openssl command failed: %s, error: %w", string(output), err)
+	// Add private key if available, encrypted when a key password is given
+	if len(bundle.KeyPEM) > 0 {
+		keyPEM := bundle.KeyPEM
+		if opts.KeyPassword != "" {
+			key, _, err := bundleKeyAndChain(bundle)
+			if err != nil {
+				return nil, err
+			}
+			if keyPEM, err = certfmt.EncryptedPKCS8(key, opts.KeyPassword); err != nil {
+				return nil, err
+			}
+		}
+		files = append(files, bundleFile{"privkey.pem", keyPEM})
 	}
 
-	lines := strings.Split(string(output), "\n")
-	var serial string
-	var notAfter time.Time
+	// Add PFX if requested
+	if opts.Format == FormatPFX && len(bundle.KeyPEM) > 0 {
+		pfxData, err := s.CreatePFX(bundle, opts.PFXPassword, opts.PFXEncryption, opts.FriendlyName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create PFX: %w", err)
+		}
+		files = append(files, bundleFile{"cert.p12", pfxData})
+	}
 
-	for _, line := range lines {
-		if strings.HasPrefix(line, "serial=") {
-			serial = strings.TrimPrefix(line, "serial=")
-		} else if strings.HasPrefix(line, "notAfter=") {
-			dateStr := strings.TrimPrefix(line, "notAfter=")
-			notAfter, err = time.Parse("Jan 2 15:04:05 2006 MST", dateStr)
-			if err != nil {
-				return "", time.Time{}, fmt.Errorf("failed to parse date: %w", err)
-			}
+	// Add Java keystore and truststore if requested
+	if opts.Format == FormatJKS {
+		stores, err := s.javaStores(bundle, opts)
+		if err != nil {
+			return nil, err
 		}
+		files = append(files, stores...)
 	}
 
-	return serial, notAfter, nil
-}
+	// Add README with installation instructions
+	readme := s.generateReadme(opts.Format, len(bundle.KeyPEM) > 0, opts.KeyPassword != "")
+	files = append(files, bundleFile{"README.txt", []byte(readme)})
 
-func (s *StepClient) CreateDownloadBundle(bundle *CertBundle, format string, pfxPassword string) ([]byte, error) {
-	var buf bytes.Buffer
-	zipWriter := zip.NewWriter(&buf)
+	return files, nil
+}
 
-	// Add certificate files
-	if err := s.addFileToZip(zipWriter, "cert.pem", bundle.CertPEM); err != nil {
+func (s *StepClient) CreateDownloadBundle(bundle *CertBundle, opts DownloadOptions) ([]byte, error) {
+	files, err := s.bundleFiles(bundle, opts)
+	if err != nil {
 		return nil, err
 	}
-	if err := s.addFileToZip(zipWriter, "chain.pem", bundle.ChainPEM); err != nil {
-		return nil, err
+
+	var buf bytes.Buffer
+	zipWriter := zip.NewWriter(&buf)
+	for _, file := range files {
+		if opts.ZipPassword != "" {
+			err = addAESFileToZip(zipWriter, file.Name, file.Data, opts.ZipPassword)
+		} else {
+			err = s.addFileToZip(zipWriter, file.Name, file.Data)
+		}
+		if err != nil {
+			return nil, err
+		}
 	}
-	if err := s.addFileToZip(zipWriter, "fullchain.pem", bundle.FullChainPEM); err != nil {
-		return nil, err
+
+	if err := zipWriter.Close(); err != nil {
+		return nil, fmt.Errorf("failed to close zip writer: %w", err)
 	}
 
-	// Add private key if available
+	return buf.Bytes(), nil
+}
+
+// javaStores builds keystore.jks with the key and full chain, and
+// truststore.p12 with the CA certificates
+func (s *StepClient) javaStores(bundle *CertBundle, opts DownloadOptions) ([]bundleFile, error) {
+	var files []bundleFile
 	if len(bundle.KeyPEM) > 0 {
-		if err := s.addFileToZip(zipWriter, "privkey.pem", bundle.KeyPEM); err != nil {
+		key, chain, err := bundleKeyAndChain(bundle)
+		if err != nil {
 			return nil, err
 		}
-	}
 
-	// Add PFX if requested
-	if format == "pfx" && len(bundle.KeyPEM) > 0 {
-		pfxData, err := s.CreatePFX(string(bundle.CertPEM), string(bundle.KeyPEM), pfxPassword)
-		if err != nil {
-			return nil, fmt.Errorf("failed to create PFX: %w", err)
+		alias := opts.KeystoreAlias
+		if alias == "" {
+			alias = chain[0].Subject.CommonName
 		}
-		if err := s.addFileToZip(zipWriter, "cert.p12", pfxData); err != nil {
-			return nil, err
+		jks, err := certfmt.JKS(alias, opts.KeystorePassword, key, chain)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create keystore: %w", err)
 		}
+		files = append(files, bundleFile{"keystore.jks", jks})
 	}
 
-	// Add README with installation instructions
-	readme := s.generateReadme(format, pfxPassword)
-	if err := s.addFileToZip(zipWriter, "README.txt", []byte(readme)); err != nil {
+	// The truststore always carries the root, even when the chain excludes it
+	caCerts, err := parseCertificates(append(append([]byte{}, bundle.IntermediatesPEM...), bundle.RootPEM...))
+	if err != nil {
 		return nil, err
 	}
-
-	if err := zipWriter.Close(); err != nil {
-		return nil, fmt.Errorf("failed to close zip writer: %w", err)
+	truststore, err := certfmt.TrustStore(opts.KeystorePassword, caCerts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create truststore: %w", err)
 	}
-
-	return buf.Bytes(), nil
+	return append(files, bundleFile{"truststore.p12", truststore}), nil
 }
 
 func (s *StepClient) addFileToZip(zipWriter *zip.Writer, filename string, data []byte) error {
@@ -479,16 +503,29 @@ func (s *StepClient) addFileToZip(zipWriter *zip.Writer, filename string, data [
 	return err
 }
 
-func (s *StepClient) generateReadme(format, pfxPassword string) string {
+// generateReadme explains the bundle files. It never contains passwords;
+// those stay with whoever requested the bundle.
+func (s *StepClient) generateReadme(format string, hasKey, keyEncrypted bool) string {
 	readme := "# Certificate Installation Instructions\n\n"
 	readme += "## Files in this bundle:\n"
 	readme += "- cert.pem: Your certificate\n"
-	readme += "- chain.pem: Certificate chain (intermediate CAs)\n"
+	readme += "- chain.pem: Certificate chain (intermediate CAs, then the root unless excluded)\n"
 	readme += "- fullchain.pem: Certificate + chain (use this for most applications)\n"
-	readme += "- privkey.pem: Private key (keep this secure!)\n"
+	readme += "- root.pem: CA root certificate\n"
+	if keyEncrypted {
+		readme += "- privkey.pem: Private key, encrypted PKCS#8 (keep this secure!)\n"
+	} else if hasKey {
+		readme += "- privkey.pem: Private key (keep this secure!)\n"
+	}
 
 	if format == "pfx" {
-		readme += "- cert.p12: PFX/PKCS#12 bundle (password: " + pfxPassword + ")\n"
+		readme += "- cert.p12: PFX/PKCS#12 bundle with the key and full chain, protected with the PFX password\n"
+	}
+	if format == "jks" {
+		if hasKey {
+			readme += "- keystore.jks: Java keystore with the private key and full chain\n"
+		}
+		readme += "- truststore.p12: PKCS#12 truststore with the CA certificates\n"
 	}
 
 	readme += "\n## Installation Instructions\n\n"
@@ -499,32 +536,51 @@ func (s *StepClient) generateReadme(format, pfxPassword string) string {
 	readme += "sudo cp privkey.pem /etc/ssl/private/your-domain.key\n\n"
 	readme += "# For Nginx, update your server block:\n"
 	readme += "# ssl_certificate /etc/ssl/certs/your-domain.crt;\n"
-	readme += "# ssl_certificate_key /etc/ssl/private/your-domain.key;\n\n"
+	readme += "# ssl_certificate_key /etc/ssl/private/your-domain.key;\n"
+	if keyEncrypted {
+		readme += "# ssl_password_file /etc/ssl/private/your-domain.pass;\n"
+		readme += "# or decrypt the key once: openssl pkey -in privkey.pem -out your-domain.key\n"
+	}
+	readme += "\n"
 	readme += "# Reload nginx\n"
 	readme += "sudo nginx -s reload\n"
 	readme += "```\n\n"
 
+	if format == "jks" {
+		readme += "### Java (Tomcat, Spring Boot, etc.)\n"
+		readme += "```properties\n"
+		readme += "# Spring Boot application.properties\n"
+		readme += "server.ssl.key-store=keystore.jks\n"
+		readme += "server.ssl.key-store-type=JKS\n"
+		readme += "server.ssl.trust-store=truststore.p12\n"
+		readme += "server.ssl.trust-store-type=PKCS12\n"
+		readme += "```\n"
+		readme += "Both stores use the keystore password chosen when the certificate was issued.\n\n"
+	}
+
 	readme += "### Windows (IIS)\n"
 	readme += "1. Import cert.p12 into Certificate Store\n"
-	readme += "2. Use IIS Manager to bind the certificate to your site\n\n"
+	readme += "2. Use IIS Manager to bind the certificate to your site\n"
+	readme += "Windows Server 2016 and older cannot read AES-encrypted PFX files; issue with\n"
+	readme += "\"pfx_encryption\": \"legacy\" for those.\n\n"
 
 	readme += "### Trust the CA Root\n"
 	readme += "To trust this CA on client systems:\n\n"
 	readme += "**Linux/Ubuntu:**\n"
 	readme += "```bash\n"
-	readme += "sudo cp chain.pem /usr/local/share/ca-certificates/my-ca.crt\n"
+	readme += "sudo cp root.pem /usr/local/share/ca-certificates/my-ca.crt\n"
 	readme += "sudo update-ca-certificates\n"
 	readme += "```\n\n"
 
 	readme += "**Windows PowerShell:**\n"
 	readme += "```powershell\n"
-	readme += "Import-Certificate -FilePath chain.pem -CertStoreLocation Cert:\\LocalMachine\\Root\n"
+	readme += "Import-Certificate -FilePath root.pem -CertStoreLocation Cert:\\LocalMachine\\Root\n"
 	readme += "```\n\n"
 
 	readme += "## Verification\n"
 	readme += "```bash\n"
 	readme += "# Verify certificate chain\n"
-	readme += "openssl verify -CAfile chain.pem cert.pem\n\n"
+	readme += "openssl verify -CAfile root.pem -untrusted chain.pem cert.pem\n\n"
 	readme += "# Check certificate details\n"
 	readme += "openssl x509 -in cert.pem -text -noout\n\n"
 	readme += "# Test SSL connection\n"
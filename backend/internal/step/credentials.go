@@ -0,0 +1,160 @@
+package step
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"step-ca-webui/internal/config"
+)
+
+// Ways of authenticating to a provisioner when requesting a certificate
+const (
+	CredentialsJWK   = "jwk"   // token signed with the provisioner's password protected key
+	CredentialsX5C   = "x5c"   // token signed with a certificate chaining to the provisioner's roots
+	CredentialsOIDC  = "oidc"  // the caller's ID token is the sign token
+	CredentialsK8sSA = "k8ssa" // the Kubernetes service account token is the sign token
+)
+
+// DefaultK8sSATokenFile is where Kubernetes mounts the service account token
+const DefaultK8sSATokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+// Credentials authenticate sign requests with a provisioner of the CA. Files
+// are read on every request so rotated certificates and tokens are used.
+type Credentials struct {
+	Type         string `json:"type"` // see Credentials constants, defaults to jwk
+	Provisioner  string `json:"name"`
+	Password     string `json:"-"`                       // jwk, from the environment only
+	PasswordFile string `json:"password_file,omitempty"` // jwk
+	CertFile     string `json:"cert_file,omitempty"`     // x5c: leaf followed by intermediates
+	KeyFile      string `json:"key_file,omitempty"`      // x5c: unencrypted private key
+	TokenFile    string `json:"token_file,omitempty"`    // k8ssa, defaults to DefaultK8sSATokenFile
+	// Lifetime limits of certificates requested through this provisioner,
+	// Go durations or days such as "30d"
+	MinLifetime string `json:"min_lifetime,omitempty"`
+	MaxLifetime string `json:"max_lifetime,omitempty"`
+
+	minLifetime, maxLifetime time.Duration
+}
+
+// Auth selects how a single request authenticates to the CA
+type Auth struct {
+	Credentials *Credentials // nil uses the client's default provisioner
+	IDToken     string       // the caller's OIDC ID token, used by OIDC provisioners
+}
+
+// Validate fills in the default type and token file and checks that the
+// credentials name a provisioner and carry what their type needs
+func (c *Credentials) Validate() error {
+	if c.Type == "" {
+		c.Type = CredentialsJWK
+	}
+	if c.Provisioner == "" {
+		return errors.New("provisioner name is required")
+	}
+	switch c.Type {
+	case CredentialsJWK:
+		if c.Password == "" && c.PasswordFile == "" {
+			return fmt.Errorf("JWK provisioner %s needs a password", c.Provisioner)
+		}
+	case CredentialsX5C:
+		if c.CertFile == "" || c.KeyFile == "" {
+			return fmt.Errorf("X5C provisioner %s needs a certificate and key file", c.Provisioner)
+		}
+	case CredentialsOIDC:
+	case CredentialsK8sSA:
+		if c.TokenFile == "" {
+			c.TokenFile = DefaultK8sSATokenFile
+		}
+	default:
+		return fmt.Errorf("unsupported provisioner type %q", c.Type)
+	}
+
+	var err error
+	if c.minLifetime, err = parseLifetime(c.MinLifetime); err != nil {
+		return fmt.Errorf("provisioner %s: invalid min_lifetime: %w", c.Provisioner, err)
+	}
+	if c.maxLifetime, err = parseLifetime(c.MaxLifetime); err != nil {
+		return fmt.Errorf("provisioner %s: invalid max_lifetime: %w", c.Provisioner, err)
+	}
+	if c.maxLifetime > 0 && c.minLifetime > c.maxLifetime {
+		return fmt.Errorf("provisioner %s: min_lifetime exceeds max_lifetime", c.Provisioner)
+	}
+	return nil
+}
+
+// Lifetimes returns the lifetime limits checked by Validate. Zero values are
+// unbounded.
+func (c *Credentials) Lifetimes() (min, max time.Duration) {
+	return c.minLifetime, c.maxLifetime
+}
+
+func parseLifetime(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	d, err := config.ParseDuration(value)
+	if err != nil {
+		return 0, err
+	}
+	if d < 0 {
+		return 0, errors.New("must not be negative")
+	}
+	return d, nil
+}
+
+// NeedsIDToken reports whether requests must carry the caller's ID token
+func (c *Credentials) NeedsIDToken() bool {
+	return c.Type == CredentialsOIDC
+}
+
+// credentials returns the credentials a request uses
+func (s *StepClient) credentials(auth Auth) *Credentials {
+	if auth.Credentials != nil {
+		return auth.Credentials
+	}
+	return &s.Credentials
+}
+
+// signToken returns the one-time token authorizing a request for subject.
+// JWK and X5C tokens are created with the step CLI, which receives
+// extraArgs. OIDC and K8sSA tokens are issued elsewhere and sent as they are;
+// the CA takes the names they may use from their claims.
+func (s *StepClient) signToken(ctx context.Context, tempDir string, root *x509.Certificate, auth Auth, subject string, extraArgs ...string) (string, error) {
+	creds := s.credentials(auth)
+	switch creds.Type {
+	case CredentialsOIDC:
+		if auth.IDToken == "" {
+			return "", fmt.Errorf("OIDC provisioner %s needs the caller's ID token", creds.Provisioner)
+		}
+		return auth.IDToken, nil
+	case CredentialsK8sSA:
+		tokenFile := creds.TokenFile
+		if tokenFile == "" {
+			tokenFile = DefaultK8sSATokenFile
+		}
+		token, err := os.ReadFile(tokenFile)
+		if err != nil {
+			return "", fmt.Errorf("failed to read service account token: %w", err)
+		}
+		return strings.TrimSpace(string(token)), nil
+	case CredentialsX5C:
+		args := append([]string{"--x5c-cert", creds.CertFile, "--x5c-key", creds.KeyFile}, extraArgs...)
+		return s.provisionerToken(ctx, tempDir, root, creds.Provisioner, subject, args...)
+	default:
+		passwordFile := creds.PasswordFile
+		if passwordFile == "" {
+			passwordFile = filepath.Join(tempDir, "password.txt")
+			if err := os.WriteFile(passwordFile, []byte(creds.Password), 0600); err != nil {
+				return "", fmt.Errorf("failed to write password file: %w", err)
+			}
+		}
+		args := append([]string{"--provisioner-password-file", passwordFile}, extraArgs...)
+		return s.provisionerToken(ctx, tempDir, root, creds.Provisioner, subject, args...)
+	}
+}
@@ -0,0 +1,146 @@
+package step
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// crlName restricts CRL names to what can safely appear in a URL path
+var crlName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// CRLPublisherConfig locates the delegated CRL signing certificate. The files
+// are read on every publication so a renewed certificate is picked up.
+type CRLPublisherConfig struct {
+	Name     string        // file name the CRL is published under, without extension
+	CertFile string        // certificate with the cRLSign key usage, followed by its intermediates
+	KeyFile  string        // unencrypted PEM private key
+	Validity time.Duration // time from one publication to the CRL's next update
+}
+
+// CRLEntry is a revoked serial number listed on a published CRL
+type CRLEntry struct {
+	Serial    *big.Int
+	RevokedAt time.Time
+}
+
+// PublishedCRL is a signed CRL as served to clients
+type PublishedCRL struct {
+	DER        []byte
+	Number     *big.Int
+	ThisUpdate time.Time
+	NextUpdate time.Time
+	Entries    int
+}
+
+// PEM returns the CRL PEM encoded
+func (c *PublishedCRL) PEM() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: c.DER})
+}
+
+// CRLPublisher signs CRLs with a certificate the CA delegated CRL signing to,
+// for deployments where step-ca does not publish one itself
+type CRLPublisher struct {
+	cfg CRLPublisherConfig
+
+	mu     sync.RWMutex
+	number int64
+	crl    *PublishedCRL
+}
+
+// NewCRLPublisher checks the configuration and that the signing certificate
+// can be read and is allowed to sign CRLs
+func NewCRLPublisher(cfg CRLPublisherConfig) (*CRLPublisher, error) {
+	if !crlName.MatchString(cfg.Name) {
+		return nil, fmt.Errorf("invalid CRL name %q: use letters, digits, '.', '_' and '-'", cfg.Name)
+	}
+	if cfg.Validity <= 0 {
+		return nil, errors.New("CRL validity must be positive")
+	}
+	p := &CRLPublisher{cfg: cfg}
+	if _, _, err := p.signer(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Name returns the name the CRL is published under
+func (p *CRLPublisher) Name() string {
+	return p.cfg.Name
+}
+
+// Published returns the last CRL signed by Publish, or nil before the first
+func (p *CRLPublisher) Published() *PublishedCRL {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.crl
+}
+
+// Publish signs a new CRL listing entries and serves it from then on. CRL
+// numbers follow the clock so they keep increasing across restarts.
+func (p *CRLPublisher) Publish(entries []CRLEntry) (*PublishedCRL, error) {
+	cert, key, err := p.signer()
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now().UTC().Truncate(time.Second)
+	if now.After(cert.NotAfter) {
+		return nil, fmt.Errorf("CRL signing certificate expired at %s", cert.NotAfter.Format(time.RFC3339))
+	}
+	number := now.Unix()
+	if number <= p.number {
+		number = p.number + 1
+	}
+
+	template := &x509.RevocationList{
+		Number:     big.NewInt(number),
+		ThisUpdate: now,
+		NextUpdate: now.Add(p.cfg.Validity),
+	}
+	for _, entry := range entries {
+		template.RevokedCertificateEntries = append(template.RevokedCertificateEntries, x509.RevocationListEntry{
+			SerialNumber:   entry.Serial,
+			RevocationTime: entry.RevokedAt.UTC(),
+		})
+	}
+	der, err := x509.CreateRevocationList(rand.Reader, template, cert, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign CRL: %w", err)
+	}
+
+	p.number = number
+	p.crl = &PublishedCRL{
+		DER:        der,
+		Number:     template.Number,
+		ThisUpdate: template.ThisUpdate,
+		NextUpdate: template.NextUpdate,
+		Entries:    len(entries),
+	}
+	return p.crl, nil
+}
+
+// signer reads the signing certificate and key
+func (p *CRLPublisher) signer() (*x509.Certificate, crypto.Signer, error) {
+	chain, key, err := loadKeyPair(p.cfg.CertFile, p.cfg.KeyFile, "CRL signing")
+	if err != nil {
+		return nil, nil, err
+	}
+	if chain[0].KeyUsage&x509.KeyUsageCRLSign == 0 {
+		return nil, nil, errors.New("CRL signing certificate lacks the cRLSign key usage")
+	}
+	if len(chain[0].SubjectKeyId) == 0 {
+		return nil, nil, errors.New("CRL signing certificate has no subject key identifier")
+	}
+	return chain[0], key, nil
+}
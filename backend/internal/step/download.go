@@ -0,0 +1,221 @@
+package step
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"step-ca-webui/internal/certfmt"
+)
+
+// Download formats. The archive formats (pem, pfx, jks, tar.gz) hold every
+// bundle file; the others are single files. Downloads are handed out once, so
+// der, p7b and pkcs8 come in a ZIP with the part of the bundle they lack
+// whenever it would otherwise be lost.
+const (
+	FormatPEM      = "pem"      // ZIP of PEM files
+	FormatPFX      = "pfx"      // ZIP of PEM files and cert.p12
+	FormatJKS      = "jks"      // ZIP of PEM files, keystore.jks and truststore.p12
+	FormatTarGz    = "tar.gz"   // tarball of the PEM files
+	FormatCombined = "combined" // key, certificate and chain in one PEM, as HAProxy expects
+	FormatDER      = "der"      // certificate only, DER encoded
+	FormatPKCS8    = "pkcs8"    // private key as encrypted PKCS#8 PEM
+	FormatP7B      = "p7b"      // certificate and chain as PKCS#7
+	FormatK8s      = "k8s"      // kubernetes.io/tls Secret manifest
+)
+
+var formatsNeedingKey = map[string]bool{
+	FormatCombined: true,
+	FormatPKCS8:    true,
+	FormatK8s:      true,
+}
+
+var validFormats = map[string]bool{
+	FormatPEM: true, FormatPFX: true, FormatJKS: true, FormatTarGz: true,
+	FormatCombined: true, FormatDER: true, FormatPKCS8: true, FormatP7B: true, FormatK8s: true,
+}
+
+// IsValidFormat reports whether format is one of the Format constants
+func IsValidFormat(format string) bool {
+	return validFormats[format]
+}
+
+// DownloadFile is a rendered download, ready to be sent to the client
+type DownloadFile struct {
+	Filename    string
+	ContentType string
+	Data        []byte
+}
+
+var (
+	unsafeFilename = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+	unsafeK8sName  = regexp.MustCompile(`[^a-z0-9.-]+`)
+)
+
+// RenderDownload produces the file for the requested format
+func (s *StepClient) RenderDownload(bundle *CertBundle, opts DownloadOptions) (*DownloadFile, error) {
+	format := opts.Format
+	if format == "" {
+		format = FormatPEM
+	}
+	if !IsValidFormat(format) {
+		return nil, fmt.Errorf("unsupported format %q", format)
+	}
+	if formatsNeedingKey[format] && len(bundle.KeyPEM) == 0 {
+		return nil, fmt.Errorf("format %s needs a private key, which this certificate does not have", format)
+	}
+
+	chain, err := parseCertificates(bundle.FullChainPEM)
+	if err != nil {
+		return nil, err
+	}
+	cn := chain[0].Subject.CommonName
+	name := strings.Trim(unsafeFilename.ReplaceAllString(strings.ReplaceAll(cn, "*", "wildcard"), "_"), "_.")
+	if name == "" {
+		name = "certificate"
+	}
+
+	switch format {
+	case FormatPEM, FormatPFX, FormatJKS:
+		data, err := s.CreateDownloadBundle(bundle, opts)
+		if err != nil {
+			return nil, err
+		}
+		return &DownloadFile{Filename: name + "-cert-bundle.zip", ContentType: "application/zip", Data: data}, nil
+
+	case FormatTarGz:
+		data, err := s.createTarGz(bundle, opts)
+		if err != nil {
+			return nil, err
+		}
+		return &DownloadFile{Filename: name + "-cert-bundle.tar.gz", ContentType: "application/gzip", Data: data}, nil
+
+	case FormatCombined:
+		data := append(append([]byte{}, bundle.KeyPEM...), bundle.FullChainPEM...)
+		return &DownloadFile{Filename: name + ".pem", ContentType: "application/x-pem-file", Data: data}, nil
+
+	case FormatDER:
+		file := &DownloadFile{Filename: name + ".der", ContentType: "application/pkix-cert", Data: chain[0].Raw}
+		return s.withKey(name, format, file, bundle)
+
+	case FormatPKCS8:
+		key, _, err := bundleKeyAndChain(bundle)
+		if err != nil {
+			return nil, err
+		}
+		data, err := certfmt.EncryptedPKCS8(key, opts.KeyPassword)
+		if err != nil {
+			return nil, err
+		}
+		return s.zipDownload(name+"-pkcs8.zip",
+			bundleFile{name + ".key", data},
+			bundleFile{"cert.pem", bundle.CertPEM},
+			bundleFile{"chain.pem", bundle.ChainPEM},
+			bundleFile{"fullchain.pem", bundle.FullChainPEM},
+		)
+
+	case FormatP7B:
+		data, err := certfmt.PKCS7(chain)
+		if err != nil {
+			return nil, err
+		}
+		file := &DownloadFile{Filename: name + ".p7b", ContentType: "application/x-pkcs7-certificates", Data: data}
+		return s.withKey(name, format, file, bundle)
+
+	case FormatK8s:
+		secretName := opts.SecretName
+		if secretName == "" {
+			secretName = strings.Trim(unsafeK8sName.ReplaceAllString(strings.ToLower(strings.ReplaceAll(cn, "*", "wildcard")), "-"), "-.") + "-tls"
+		}
+		data := kubernetesTLSSecret(secretName, opts.Namespace, bundle)
+		return &DownloadFile{Filename: name + "-tls-secret.yaml", ContentType: "application/yaml", Data: data}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported format %q", format)
+}
+
+// withKey returns file alone for certificates signed from a CSR, and packs it
+// with privkey.pem into <name>-<format>.zip when the key was generated here
+func (s *StepClient) withKey(name, format string, file *DownloadFile, bundle *CertBundle) (*DownloadFile, error) {
+	if len(bundle.KeyPEM) == 0 {
+		return file, nil
+	}
+	return s.zipDownload(name+"-"+format+".zip", bundleFile{file.Filename, file.Data}, bundleFile{"privkey.pem", bundle.KeyPEM})
+}
+
+// zipDownload packs files into a ZIP download
+func (s *StepClient) zipDownload(filename string, files ...bundleFile) (*DownloadFile, error) {
+	var buf bytes.Buffer
+	zipWriter := zip.NewWriter(&buf)
+	for _, file := range files {
+		if err := s.addFileToZip(zipWriter, file.Name, file.Data); err != nil {
+			return nil, err
+		}
+	}
+	if err := zipWriter.Close(); err != nil {
+		return nil, fmt.Errorf("failed to close zip writer: %w", err)
+	}
+	return &DownloadFile{Filename: filename, ContentType: "application/zip", Data: buf.Bytes()}, nil
+}
+
+// createTarGz packs the bundle files into a gzip compressed tarball
+func (s *StepClient) createTarGz(bundle *CertBundle, opts DownloadOptions) ([]byte, error) {
+	files, err := s.bundleFiles(bundle, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	now := time.Now()
+	for _, file := range files {
+		// Keys are readable by the owner only once extracted
+		mode := int64(0644)
+		if file.Name == "privkey.pem" {
+			mode = 0600
+		}
+		header := &tar.Header{Name: file.Name, Mode: mode, Size: int64(len(file.Data)), ModTime: now, Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(header); err != nil {
+			return nil, err
+		}
+		if _, err := tw.Write(file.Data); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to close tar writer: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return nil, fmt.Errorf("failed to close gzip writer: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// kubernetesTLSSecret renders a kubernetes.io/tls Secret with the full
+// chain, the key and the CA root
+func kubernetesTLSSecret(name, namespace string, bundle *CertBundle) []byte {
+	var b strings.Builder
+	b.WriteString("apiVersion: v1\n")
+	b.WriteString("kind: Secret\n")
+	b.WriteString("metadata:\n")
+	fmt.Fprintf(&b, "  name: %s\n", name)
+	if namespace != "" {
+		fmt.Fprintf(&b, "  namespace: %s\n", namespace)
+	}
+	b.WriteString("  annotations:\n")
+	fmt.Fprintf(&b, "    step-ca-webui/serial: %q\n", bundle.Serial)
+	fmt.Fprintf(&b, "    step-ca-webui/not-after: %q\n", bundle.NotAfter.UTC().Format(time.RFC3339))
+	b.WriteString("type: kubernetes.io/tls\n")
+	b.WriteString("data:\n")
+	fmt.Fprintf(&b, "  tls.crt: %s\n", base64.StdEncoding.EncodeToString(bundle.FullChainPEM))
+	fmt.Fprintf(&b, "  tls.key: %s\n", base64.StdEncoding.EncodeToString(bundle.KeyPEM))
+	fmt.Fprintf(&b, "  ca.crt: %s\n", base64.StdEncoding.EncodeToString(bundle.RootPEM))
+	return []byte(b.String())
+}
@@ -0,0 +1,134 @@
+package step
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// Key types accepted for server-generated keys
+const (
+	KeyTypeECP256  = "ec-p256"
+	KeyTypeECP384  = "ec-p384"
+	KeyTypeRSA2048 = "rsa-2048"
+	KeyTypeRSA3072 = "rsa-3072"
+	KeyTypeRSA4096 = "rsa-4096"
+	KeyTypeEd25519 = "ed25519"
+
+	DefaultKeyType = KeyTypeECP256
+)
+
+func IsValidKeyType(keyType string) bool {
+	switch keyType {
+	case KeyTypeECP256, KeyTypeECP384, KeyTypeRSA2048, KeyTypeRSA3072, KeyTypeRSA4096, KeyTypeEd25519:
+		return true
+	}
+	return false
+}
+
+// GenerateKey creates a new private key of the given type
+func GenerateKey(keyType string) (crypto.Signer, error) {
+	if keyType == "" {
+		keyType = DefaultKeyType
+	}
+
+	switch keyType {
+	case KeyTypeECP256:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case KeyTypeECP384:
+		return ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case KeyTypeRSA2048:
+		return rsa.GenerateKey(rand.Reader, 2048)
+	case KeyTypeRSA3072:
+		return rsa.GenerateKey(rand.Reader, 3072)
+	case KeyTypeRSA4096:
+		return rsa.GenerateKey(rand.Reader, 4096)
+	case KeyTypeEd25519:
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		return key, err
+	default:
+		return nil, fmt.Errorf("unsupported key type: %s", keyType)
+	}
+}
+
+// EncodePrivateKey encodes a private key as an unencrypted PKCS#8 PEM block
+func EncodePrivateKey(key crypto.Signer) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal private key: %w", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// CreateCSR builds a PEM encoded certificate request for the CN and SANs,
+// sorting each SAN into DNS, IP, email or URI entries
+func CreateCSR(key crypto.Signer, cn string, sans []string) ([]byte, error) {
+	template := &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: cn},
+	}
+
+	for _, san := range sans {
+		switch {
+		case net.ParseIP(san) != nil:
+			template.IPAddresses = append(template.IPAddresses, net.ParseIP(san))
+		case strings.Contains(san, "://"):
+			u, err := url.Parse(san)
+			if err != nil {
+				return nil, fmt.Errorf("invalid URI SAN %q: %w", san, err)
+			}
+			template.URIs = append(template.URIs, u)
+		case strings.Contains(san, "@"):
+			template.EmailAddresses = append(template.EmailAddresses, san)
+		default:
+			template.DNSNames = append(template.DNSNames, san)
+		}
+	}
+
+	der, err := x509.CreateCertificateRequest(rand.Reader, template, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create CSR: %w", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}), nil
+}
+
+// ParseCSR decodes and verifies a PEM encoded certificate request
+func ParseCSR(csrPEM []byte) (*x509.CertificateRequest, error) {
+	block, _ := pem.Decode(csrPEM)
+	if block == nil || !strings.Contains(block.Type, "CERTIFICATE REQUEST") {
+		return nil, fmt.Errorf("failed to decode CSR PEM block")
+	}
+
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CSR: %w", err)
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, fmt.Errorf("invalid CSR signature: %w", err)
+	}
+
+	return csr, nil
+}
+
+// CSRSANs returns every subject alternative name in a certificate request
+func CSRSANs(csr *x509.CertificateRequest) []string {
+	var sans []string
+	sans = append(sans, csr.DNSNames...)
+	for _, ip := range csr.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	sans = append(sans, csr.EmailAddresses...)
+	for _, uri := range csr.URIs {
+		sans = append(sans, uri.String())
+	}
+	return sans
+}
@@ -0,0 +1,370 @@
+package step
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Provisioner types that can be managed, as named by step-ca's admin API
+const (
+	ProvisionerTypeJWK   = "JWK"
+	ProvisionerTypeOIDC  = "OIDC"
+	ProvisionerTypeACME  = "ACME"
+	ProvisionerTypeX5C   = "X5C"
+	ProvisionerTypeK8sSA = "K8SSA"
+)
+
+// LinkedProvisioner is a provisioner in the JSON form of step-ca's admin API
+// (linkedca.Provisioner). It is kept as a map so fields this package does
+// not know survive an update.
+type LinkedProvisioner map[string]interface{}
+
+// ProvisionerClaims are the X.509 lifetime claims of a provisioner. Durations
+// are Go durations; empty ones fall back to the CA defaults.
+type ProvisionerClaims struct {
+	DefaultTLSDuration string `json:"default_tls_duration,omitempty"`
+	MinTLSDuration     string `json:"min_tls_duration,omitempty"`
+	MaxTLSDuration     string `json:"max_tls_duration,omitempty"`
+	DisableRenewal     *bool  `json:"disable_renewal,omitempty"`
+}
+
+// ProvisionerInfo summarizes a provisioner
+type ProvisionerInfo struct {
+	Type   string            `json:"type"`
+	Name   string            `json:"name"`
+	Claims ProvisionerClaims `json:"claims"`
+}
+
+// ProvisionerSpec describes a provisioner to create, or the changes to an
+// existing one. Only the block matching Type is used.
+type ProvisionerSpec struct {
+	Type   string
+	Name   string
+	Claims *ProvisionerClaims
+	JWK    *JWKSpec
+	OIDC   *OIDCSpec
+	ACME   *ACMESpec
+	X5C    *X5CSpec
+	K8sSA  *K8sSASpec
+}
+
+// JWKSpec holds the key of a JWK provisioner. EncryptedKey is the password
+// protected private key in compact JWE form, served to `step ca token`.
+type JWKSpec struct {
+	PublicKey    json.RawMessage
+	EncryptedKey string
+}
+
+// OIDCSpec configures an OIDC provisioner
+type OIDCSpec struct {
+	ClientID              string
+	ClientSecret          string
+	ConfigurationEndpoint string
+	Admins                []string
+	Domains               []string
+	Groups                []string
+	ListenAddress         string
+	TenantID              string
+}
+
+// ACMESpec configures an ACME provisioner. Challenges use the ACME names
+// http-01, dns-01, tls-alpn-01 and device-attest-01.
+type ACMESpec struct {
+	ForceCN    *bool
+	RequireEAB *bool
+	Challenges []string
+}
+
+// X5CSpec holds the PEM roots trusted by an X5C provisioner
+type X5CSpec struct {
+	Roots []byte
+}
+
+// K8sSASpec holds the PEM public keys that sign Kubernetes service account
+// tokens
+type K8sSASpec struct {
+	PublicKeys []byte
+}
+
+// acmeChallenges maps ACME challenge names to the admin API enum
+var acmeChallenges = map[string]string{
+	"http-01":          "HTTP_01",
+	"dns-01":           "DNS_01",
+	"tls-alpn-01":      "TLS_ALPN_01",
+	"device-attest-01": "DEVICE_ATTEST_01",
+}
+
+// IsACMEChallenge reports whether name is an ACME challenge step-ca knows
+func IsACMEChallenge(name string) bool {
+	_, ok := acmeChallenges[name]
+	return ok
+}
+
+// detailsKeys are the keys of the type specific details in a
+// LinkedProvisioner
+var detailsKeys = map[string]string{
+	ProvisionerTypeJWK:   "JWK",
+	ProvisionerTypeOIDC:  "OIDC",
+	ProvisionerTypeACME:  "ACME",
+	ProvisionerTypeX5C:   "X5C",
+	ProvisionerTypeK8sSA: "K8sSA",
+}
+
+// IsManagedProvisionerType reports whether provisioners of type t can be
+// created and edited
+func IsManagedProvisionerType(t string) bool {
+	_, ok := detailsKeys[t]
+	return ok
+}
+
+// NewLinkedProvisioner builds a provisioner to create from spec
+func NewLinkedProvisioner(spec ProvisionerSpec) (LinkedProvisioner, error) {
+	prov := LinkedProvisioner{"type": spec.Type, "name": spec.Name}
+	if err := prov.Apply(spec); err != nil {
+		return nil, err
+	}
+	return prov, nil
+}
+
+// Apply writes the claims and type specific settings of spec into the
+// provisioner, leaving fields the spec does not set unchanged
+func (p LinkedProvisioner) Apply(spec ProvisionerSpec) error {
+	if spec.Claims != nil {
+		claims := childMap(p, "claims")
+		if c := spec.Claims; c.DefaultTLSDuration != "" || c.MinTLSDuration != "" || c.MaxTLSDuration != "" {
+			x509Claims := childMap(claims, "x509")
+			x509Claims["enabled"] = true
+			durations := childMap(x509Claims, "durations")
+			setIfNotEmpty(durations, "default", c.DefaultTLSDuration)
+			setIfNotEmpty(durations, "min", c.MinTLSDuration)
+			setIfNotEmpty(durations, "max", c.MaxTLSDuration)
+		}
+		if spec.Claims.DisableRenewal != nil {
+			claims["disableRenewal"] = *spec.Claims.DisableRenewal
+		}
+	}
+
+	key, ok := detailsKeys[p.Type()]
+	if !ok {
+		return fmt.Errorf("provisioners of type %s cannot be managed here", p.Type())
+	}
+	details := childMap(childMap(p, "details"), key)
+
+	switch p.Type() {
+	case ProvisionerTypeJWK:
+		if spec.JWK != nil {
+			details["publicKey"] = base64.StdEncoding.EncodeToString(spec.JWK.PublicKey)
+			if spec.JWK.EncryptedKey != "" {
+				details["encryptedPrivateKey"] = base64.StdEncoding.EncodeToString([]byte(spec.JWK.EncryptedKey))
+			} else {
+				delete(details, "encryptedPrivateKey")
+			}
+		}
+	case ProvisionerTypeOIDC:
+		if o := spec.OIDC; o != nil {
+			setIfNotEmpty(details, "clientId", o.ClientID)
+			setIfNotEmpty(details, "clientSecret", o.ClientSecret)
+			setIfNotEmpty(details, "configurationEndpoint", o.ConfigurationEndpoint)
+			setIfNotEmpty(details, "listenAddress", o.ListenAddress)
+			setIfNotEmpty(details, "tenantId", o.TenantID)
+			setListIfNotNil(details, "admins", o.Admins)
+			setListIfNotNil(details, "domains", o.Domains)
+			setListIfNotNil(details, "groups", o.Groups)
+		}
+	case ProvisionerTypeACME:
+		if a := spec.ACME; a != nil {
+			if a.ForceCN != nil {
+				details["forceCn"] = *a.ForceCN
+			}
+			if a.RequireEAB != nil {
+				details["requireEab"] = *a.RequireEAB
+			}
+			if a.Challenges != nil {
+				challenges := make([]string, 0, len(a.Challenges))
+				for _, name := range a.Challenges {
+					challenge, ok := acmeChallenges[name]
+					if !ok {
+						return fmt.Errorf("unknown ACME challenge %q", name)
+					}
+					challenges = append(challenges, challenge)
+				}
+				details["challenges"] = challenges
+			}
+		}
+	case ProvisionerTypeX5C:
+		if spec.X5C != nil {
+			details["roots"] = pemBlocks(spec.X5C.Roots)
+		}
+	case ProvisionerTypeK8sSA:
+		if spec.K8sSA != nil {
+			details["publicKeys"] = pemBlocks(spec.K8sSA.PublicKeys)
+		}
+	}
+	return nil
+}
+
+// Type returns the provisioner type
+func (p LinkedProvisioner) Type() string {
+	t, _ := p["type"].(string)
+	return t
+}
+
+// Info summarizes the provisioner
+func (p LinkedProvisioner) Info() ProvisionerInfo {
+	name, _ := p["name"].(string)
+	info := ProvisionerInfo{Type: p.Type(), Name: name}
+	claims, _ := p["claims"].(map[string]interface{})
+	x509Claims, _ := claims["x509"].(map[string]interface{})
+	durations, _ := x509Claims["durations"].(map[string]interface{})
+	info.Claims.DefaultTLSDuration, _ = durations["default"].(string)
+	info.Claims.MinTLSDuration, _ = durations["min"].(string)
+	info.Claims.MaxTLSDuration, _ = durations["max"].(string)
+	if disabled, ok := claims["disableRenewal"].(bool); ok {
+		info.Claims.DisableRenewal = &disabled
+	}
+	return info
+}
+
+// Details returns the type specific settings without secrets: the
+// encrypted JWK private key and the OIDC client secret are left out
+func (p LinkedProvisioner) Details() map[string]interface{} {
+	all, _ := p["details"].(map[string]interface{})
+	details, _ := all[detailsKeys[p.Type()]].(map[string]interface{})
+	out := make(map[string]interface{}, len(details))
+	for k, v := range details {
+		if k == "encryptedPrivateKey" || k == "clientSecret" {
+			continue
+		}
+		out[k] = v
+	}
+	return out
+}
+
+// provisionerFromConfig summarizes a provisioner as listed in ca.json form,
+// which the admin API uses for its list endpoint
+func provisionerFromConfig(raw map[string]interface{}) ProvisionerInfo {
+	info := ProvisionerInfo{}
+	info.Type, _ = raw["type"].(string)
+	info.Type = strings.ToUpper(info.Type)
+	info.Name, _ = raw["name"].(string)
+	claims, _ := raw["claims"].(map[string]interface{})
+	info.Claims.DefaultTLSDuration, _ = claims["defaultTLSCertDuration"].(string)
+	info.Claims.MinTLSDuration, _ = claims["minTLSCertDuration"].(string)
+	info.Claims.MaxTLSDuration, _ = claims["maxTLSCertDuration"].(string)
+	if disabled, ok := claims["disableRenewal"].(bool); ok {
+		info.Claims.DisableRenewal = &disabled
+	}
+	return info
+}
+
+// GenerateJWK creates a P-256 key for a JWK provisioner with the step CLI and
+// returns the public JWK and the private key encrypted with password
+func GenerateJWK(password string) (json.RawMessage, string, error) {
+	tempDir, err := os.MkdirTemp("", "step-jwk-*")
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create temp dir: %w", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	passwordFile := filepath.Join(tempDir, "password.txt")
+	publicFile := filepath.Join(tempDir, "pub.json")
+	privateFile := filepath.Join(tempDir, "priv.json")
+	if err := os.WriteFile(passwordFile, []byte(password), 0600); err != nil {
+		return nil, "", fmt.Errorf("failed to write password file: %w", err)
+	}
+
+	cmd := exec.Command("step", "crypto", "jwk", "create", publicFile, privateFile,
+		"--kty", "EC", "--crv", "P-256", "--password-file", passwordFile)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return nil, "", fmt.Errorf("step crypto jwk create failed: %s, error: %w", strings.TrimSpace(string(output)), err)
+	}
+
+	publicKey, err := os.ReadFile(publicFile)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read public key: %w", err)
+	}
+	privateJWE, err := os.ReadFile(privateFile)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read private key: %w", err)
+	}
+	encrypted, err := CompactJWE(privateJWE)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var compacted bytes.Buffer
+	if err := json.Compact(&compacted, publicKey); err != nil {
+		return nil, "", fmt.Errorf("invalid public key: %w", err)
+	}
+	return json.RawMessage(compacted.Bytes()), encrypted, nil
+}
+
+// CompactJWE converts a JWE in JSON serialization, as written by
+// `step crypto jwk create`, to the compact form step-ca stores. Compact
+// input is returned unchanged.
+func CompactJWE(data []byte) (string, error) {
+	trimmed := strings.TrimSpace(string(data))
+	if !strings.HasPrefix(trimmed, "{") {
+		if strings.Count(trimmed, ".") != 4 {
+			return "", fmt.Errorf("encrypted key is not a JWE")
+		}
+		return trimmed, nil
+	}
+
+	var jwe struct {
+		Protected    string `json:"protected"`
+		EncryptedKey string `json:"encrypted_key"`
+		IV           string `json:"iv"`
+		Ciphertext   string `json:"ciphertext"`
+		Tag          string `json:"tag"`
+	}
+	if err := json.Unmarshal([]byte(trimmed), &jwe); err != nil {
+		return "", fmt.Errorf("invalid encrypted key: %w", err)
+	}
+	if jwe.Protected == "" || jwe.Ciphertext == "" {
+		return "", fmt.Errorf("encrypted key is not a JWE")
+	}
+	return strings.Join([]string{jwe.Protected, jwe.EncryptedKey, jwe.IV, jwe.Ciphertext, jwe.Tag}, "."), nil
+}
+
+// pemBlocks splits PEM data into its blocks, base64 encoded as the admin
+// API expects for bytes fields
+func pemBlocks(data []byte) []string {
+	var blocks []string
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return blocks
+		}
+		blocks = append(blocks, base64.StdEncoding.EncodeToString(pem.EncodeToMemory(block)))
+	}
+}
+
+func childMap(parent map[string]interface{}, key string) map[string]interface{} {
+	if child, ok := parent[key].(map[string]interface{}); ok {
+		return child
+	}
+	child := map[string]interface{}{}
+	parent[key] = child
+	return child
+}
+
+func setIfNotEmpty(m map[string]interface{}, key, value string) {
+	if value != "" {
+		m[key] = value
+	}
+}
+
+func setListIfNotNil(m map[string]interface{}, key string, values []string) {
+	if values != nil {
+		m[key] = values
+	}
+}
@@ -0,0 +1,62 @@
+package step
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// ProvisionerACME is the type step-ca reports for ACME provisioners
+const ProvisionerACME = "ACME"
+
+// Provisioner is an entry of the CA's public provisioner list
+type Provisioner struct {
+	Type string `json:"type"`
+	Name string `json:"name"`
+}
+
+type provisionersResponse struct {
+	Provisioners []Provisioner `json:"provisioners"`
+	NextCursor   string        `json:"nextCursor"`
+}
+
+// provisionersPageSize is the page size requested from GET /provisioners
+const provisionersPageSize = 100
+
+// Provisioners lists every provisioner the CA has configured
+func (s *StepClient) Provisioners(ctx context.Context) ([]Provisioner, error) {
+	root, err := s.Root(ctx)
+	if err != nil {
+		return nil, err
+	}
+	client := caHTTPClient(root)
+
+	var provisioners []Provisioner
+	seen := map[string]bool{}
+	cursor := ""
+	for {
+		query := url.Values{"limit": {strconv.Itoa(provisionersPageSize)}}
+		if cursor != "" {
+			query.Set("cursor", cursor)
+		}
+		var resp provisionersResponse
+		if err := s.caDo(ctx, client, http.MethodGet, "/provisioners?"+query.Encode(), nil, &resp); err != nil {
+			return nil, err
+		}
+		provisioners = append(provisioners, resp.Provisioners...)
+
+		// Stop at the last page, and on a cursor that repeats
+		if resp.NextCursor == "" || len(resp.Provisioners) < provisionersPageSize || seen[resp.NextCursor] {
+			return provisioners, nil
+		}
+		seen[resp.NextCursor] = true
+		cursor = resp.NextCursor
+	}
+}
+
+// ACMEDirectory returns the directory URL of the named ACME provisioner
+func (s *StepClient) ACMEDirectory(provisioner string) string {
+	return strings.TrimSuffix(s.CAURL, "/") + "/acme/" + url.PathEscape(provisioner) + "/directory"
+}
@@ -0,0 +1,235 @@
+package step
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"step-ca-webui/internal/logging"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// Revocation states reported by RevocationStatus
+const (
+	RevocationGood    = "good"
+	RevocationRevoked = "revoked"
+	RevocationUnknown = "unknown"
+)
+
+// ErrCRLDisabled is returned when the CA does not publish a CRL
+var ErrCRLDisabled = errors.New(`the CA does not publish a CRL; enable "crl" in its ca.json`)
+
+// ErrAlreadyRevoked is returned by RevokeCertificate when the CA had already
+// revoked the certificate
+var ErrAlreadyRevoked = errors.New("certificate is already revoked at the CA")
+
+// maxRevocationResponse bounds the size of CRL and OCSP responses
+const maxRevocationResponse = 32 << 20
+
+// revocationReasons names the CRL reason codes of RFC 5280
+var revocationReasons = map[int]string{
+	ocsp.Unspecified:          "unspecified",
+	ocsp.KeyCompromise:        "keyCompromise",
+	ocsp.CACompromise:         "cACompromise",
+	ocsp.AffiliationChanged:   "affiliationChanged",
+	ocsp.Superseded:           "superseded",
+	ocsp.CessationOfOperation: "cessationOfOperation",
+	ocsp.CertificateHold:      "certificateHold",
+	ocsp.RemoveFromCRL:        "removeFromCRL",
+	ocsp.PrivilegeWithdrawn:   "privilegeWithdrawn",
+	ocsp.AACompromise:         "aACompromise",
+}
+
+// RevocationStatus is what one source, the CRL or an OCSP responder, says
+// about a certificate
+type RevocationStatus struct {
+	Source     string     `json:"source"` // crl or ocsp
+	URL        string     `json:"url"`
+	Status     string     `json:"status"` // good, revoked or unknown
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	Reason     string     `json:"reason,omitempty"`
+	ThisUpdate *time.Time `json:"this_update,omitempty"`
+	NextUpdate *time.Time `json:"next_update,omitempty"`
+	Error      string     `json:"error,omitempty"`
+}
+
+// ParseSerial reads a serial number in the upper case hex form the
+// inventory uses
+func ParseSerial(serial string) (*big.Int, error) {
+	n, ok := new(big.Int).SetString(serial, 16)
+	if !ok {
+		return nil, fmt.Errorf("invalid serial number %q", serial)
+	}
+	return n, nil
+}
+
+// CRLURL returns the URL the CA publishes its CRL at
+func (s *StepClient) CRLURL() string {
+	return strings.TrimSuffix(s.CAURL, "/") + "/crl"
+}
+
+// CRL fetches the CA's certificate revocation list and checks that one of
+// its intermediates or the root signed it
+func (s *StepClient) CRL(ctx context.Context) (*x509.RevocationList, error) {
+	root, err := s.Root(ctx)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.CRLURL(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if id := logging.RequestID(ctx); id != "" {
+		req.Header.Set(logging.RequestIDHeader, id)
+	}
+	_, done := caCall(ctx, "GET /crl")
+	resp, err := caHTTPClient(root).Do(req)
+	done(err)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch CRL: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusNotImplemented {
+		return nil, ErrCRLDisabled
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch CRL: CA returned %d", resp.StatusCode)
+	}
+	der, err := io.ReadAll(io.LimitReader(resp.Body, maxRevocationResponse))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CRL: %w", err)
+	}
+
+	crl, err := x509.ParseRevocationList(der)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CRL: %w", err)
+	}
+	intermediates, err := s.Intermediates(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, issuer := range append(intermediates, root) {
+		if crl.CheckSignatureFrom(issuer) == nil {
+			return crl, nil
+		}
+	}
+	return nil, errors.New("CRL is not signed by the CA")
+}
+
+// CRLStatus looks a serial number up in a CRL fetched by CRL
+func (s *StepClient) CRLStatus(crl *x509.RevocationList, serial *big.Int) RevocationStatus {
+	status := RevocationStatus{
+		Source:     "crl",
+		URL:        s.CRLURL(),
+		Status:     RevocationGood,
+		ThisUpdate: timePtr(crl.ThisUpdate),
+		NextUpdate: timePtr(crl.NextUpdate),
+	}
+	for _, entry := range crl.RevokedCertificateEntries {
+		if entry.SerialNumber.Cmp(serial) == 0 {
+			status.Status = RevocationRevoked
+			status.RevokedAt = timePtr(entry.RevocationTime)
+			status.Reason = revocationReasons[entry.ReasonCode]
+			break
+		}
+	}
+	if !crl.NextUpdate.IsZero() && time.Now().After(crl.NextUpdate) {
+		status.Error = "CRL is past its next update"
+	}
+	return status
+}
+
+// OCSPStatus asks the OCSP responder at responderURL about the certificate
+// with serial. The response must be signed by the CA's issuing intermediate
+// or a responder it delegated to.
+func (s *StepClient) OCSPStatus(ctx context.Context, responderURL string, serial *big.Int) RevocationStatus {
+	status := RevocationStatus{Source: "ocsp", URL: responderURL, Status: RevocationUnknown}
+	resp, err := s.queryOCSP(ctx, responderURL, serial)
+	if err != nil {
+		status.Error = err.Error()
+		return status
+	}
+
+	status.ThisUpdate = timePtr(resp.ThisUpdate)
+	status.NextUpdate = timePtr(resp.NextUpdate)
+	switch resp.Status {
+	case ocsp.Good:
+		status.Status = RevocationGood
+	case ocsp.Revoked:
+		status.Status = RevocationRevoked
+		status.RevokedAt = timePtr(resp.RevokedAt)
+		status.Reason = revocationReasons[resp.RevocationReason]
+	}
+	return status
+}
+
+func (s *StepClient) queryOCSP(ctx context.Context, responderURL string, serial *big.Int) (*ocsp.Response, error) {
+	root, err := s.Root(ctx)
+	if err != nil {
+		return nil, err
+	}
+	intermediates, err := s.Intermediates(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(intermediates) == 0 {
+		return nil, errors.New("CA intermediates are not available")
+	}
+	// The request names the issuer by its name and key hash, so it must be
+	// the intermediate that signed the certificate
+	issuer, err := IssuingIntermediate(intermediates, root)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := ocsp.CreateRequest(&x509.Certificate{SerialNumber: serial}, issuer, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OCSP request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, responderURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/ocsp-request")
+	req.Header.Set("Accept", "application/ocsp-response")
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	_, done := caCall(ctx, "POST ocsp")
+	httpResp, err := client.Do(req)
+	done(err)
+	if err != nil {
+		return nil, fmt.Errorf("OCSP request failed: %w", err)
+	}
+	defer httpResp.Body.Close()
+	if httpResp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("OCSP responder returned %d", httpResp.StatusCode)
+	}
+	der, err := io.ReadAll(io.LimitReader(httpResp.Body, maxRevocationResponse))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read OCSP response: %w", err)
+	}
+
+	resp, err := ocsp.ParseResponse(der, issuer)
+	if err != nil {
+		return nil, fmt.Errorf("invalid OCSP response: %w", err)
+	}
+	if resp.SerialNumber.Cmp(serial) != 0 {
+		return nil, errors.New("OCSP response is for a different certificate")
+	}
+	return resp, nil
+}
+
+func timePtr(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
@@ -0,0 +1,241 @@
+package step
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ErrRootChanged is returned once the CA stops serving the pinned root.
+// Issuance is refused until the root matches again or the pin is updated.
+var ErrRootChanged = errors.New("CA root certificate changed unexpectedly")
+
+// RootInfo describes the pinned CA root for display
+type RootInfo struct {
+	Fingerprint string
+	Subject     string
+	NotBefore   time.Time
+	NotAfter    time.Time
+	PEM         []byte
+	CheckedAt   time.Time
+	Err         error
+}
+
+type rootResponse struct {
+	RootPEM string `json:"ca"`
+}
+
+type rootsResponse struct {
+	Certificates []string `json:"crts"`
+}
+
+// Fingerprint returns the SHA-256 fingerprint of a certificate in the hex
+// format used by step
+func Fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+func normalizeFingerprint(fp string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(fp), ":", ""))
+}
+
+func fingerprintsEqual(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(normalizeFingerprint(a)), []byte(normalizeFingerprint(b))) == 1
+}
+
+// LoadRoot downloads the CA root and pins it. The root must match
+// CARootFingerprint, or pinned when no fingerprint is configured. Without
+// either, the root is trusted on first use.
+func (s *StepClient) LoadRoot(ctx context.Context, pinned string) (*x509.Certificate, error) {
+	s.rootMu.Lock()
+	s.pinned = pinned
+	s.rootMu.Unlock()
+
+	expected := s.CARootFingerprint
+	if expected == "" {
+		expected = pinned
+	}
+
+	// The root cannot be verified before it is known, so the bootstrap
+	// request skips TLS verification and checks the fingerprint instead
+	insecure := &http.Client{
+		Timeout: 30 * time.Second,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true, MinVersion: tls.VersionTLS12},
+		},
+	}
+
+	var root *x509.Certificate
+	if expected != "" {
+		var resp rootResponse
+		if err := s.caDo(ctx, insecure, http.MethodGet, "/root/"+normalizeFingerprint(expected), nil, &resp); err != nil {
+			return nil, fmt.Errorf("failed to download root: %w", err)
+		}
+		certs, err := parseCertificates([]byte(resp.RootPEM))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse root: %w", err)
+		}
+		root = certs[0]
+		if !fingerprintsEqual(Fingerprint(root), expected) {
+			return nil, fmt.Errorf("root fingerprint %s does not match expected %s", Fingerprint(root), normalizeFingerprint(expected))
+		}
+	} else {
+		var resp rootsResponse
+		if err := s.caDo(ctx, insecure, http.MethodGet, "/roots", nil, &resp); err != nil {
+			return nil, fmt.Errorf("failed to download roots: %w", err)
+		}
+		if len(resp.Certificates) == 0 {
+			return nil, fmt.Errorf("CA returned no roots")
+		}
+		certs, err := parseCertificates([]byte(resp.Certificates[0]))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse root: %w", err)
+		}
+		root = certs[0]
+		slog.WarnContext(ctx, "CA_ROOT_FINGERPRINT is not set, trusting the CA root on first use", "fingerprint", Fingerprint(root))
+	}
+
+	s.rootMu.Lock()
+	s.root = root
+	s.rootErr = nil
+	s.rootChecked = time.Now()
+	s.rootMu.Unlock()
+
+	s.refreshIntermediates(ctx, root)
+	return root, nil
+}
+
+// CheckRoot asks the CA, over TLS verified against the pinned root, which
+// roots it serves. If the pinned root is gone the client stops issuing.
+// Network failures are returned without changing the pin state.
+func (s *StepClient) CheckRoot(ctx context.Context) error {
+	s.rootMu.RLock()
+	root := s.root
+	s.rootMu.RUnlock()
+	if root == nil {
+		return fmt.Errorf("CA root is not loaded")
+	}
+
+	var resp rootsResponse
+	err := s.caDo(ctx, caHTTPClient(root), http.MethodGet, "/roots", nil, &resp)
+
+	var verifyErr *tls.CertificateVerificationError
+	var authorityErr x509.UnknownAuthorityError
+	changed := errors.As(err, &verifyErr) || errors.As(err, &authorityErr)
+	if err != nil && !changed {
+		return err
+	}
+
+	if !changed {
+		changed = true
+		for _, certPEM := range resp.Certificates {
+			certs, err := parseCertificates([]byte(certPEM))
+			if err == nil && certs[0].Equal(root) {
+				changed = false
+				break
+			}
+		}
+	}
+
+	s.rootMu.Lock()
+	s.rootChecked = time.Now()
+	s.rootErr = nil
+	if changed {
+		s.rootErr = ErrRootChanged
+	}
+	s.rootMu.Unlock()
+
+	if changed {
+		return ErrRootChanged
+	}
+	s.refreshIntermediates(ctx, root)
+	return nil
+}
+
+// Root returns the pinned CA root, loading it if startup could not reach the CA
+func (s *StepClient) Root(ctx context.Context) (*x509.Certificate, error) {
+	s.rootMu.RLock()
+	root, rootErr, pinned := s.root, s.rootErr, s.pinned
+	s.rootMu.RUnlock()
+
+	if rootErr != nil {
+		return nil, rootErr
+	}
+	if root != nil {
+		return root, nil
+	}
+	return s.LoadRoot(ctx, pinned)
+}
+
+// RootInfo reports the pinned root and the outcome of the last check
+func (s *StepClient) RootInfo() *RootInfo {
+	s.rootMu.RLock()
+	defer s.rootMu.RUnlock()
+
+	if s.root == nil {
+		return nil
+	}
+	return &RootInfo{
+		Fingerprint: Fingerprint(s.root),
+		Subject:     s.root.Subject.String(),
+		NotBefore:   s.root.NotBefore,
+		NotAfter:    s.root.NotAfter,
+		PEM:         EncodeCertificates(s.root),
+		CheckedAt:   s.rootChecked,
+		Err:         s.rootErr,
+	}
+}
+
+// refreshIntermediates caches the CA's intermediates that chain to root.
+// Failures keep the previous cache, since the intermediates are only used
+// for distribution and as a fallback when building chains.
+func (s *StepClient) refreshIntermediates(ctx context.Context, root *x509.Certificate) {
+	certs, err := s.fetchIntermediates(ctx, caHTTPClient(root))
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to fetch CA intermediates", "error", err)
+		return
+	}
+
+	var valid []*x509.Certificate
+	for _, cert := range certs {
+		if cert.Equal(root) {
+			continue
+		}
+		if _, err := orderChain(cert, certs, root); err == nil {
+			valid = append(valid, cert)
+		}
+	}
+
+	s.rootMu.Lock()
+	s.intermediates = valid
+	s.rootMu.Unlock()
+}
+
+// Intermediates returns the cached intermediates of the pinned root
+func (s *StepClient) Intermediates(ctx context.Context) ([]*x509.Certificate, error) {
+	root, err := s.Root(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	s.rootMu.RLock()
+	certs := s.intermediates
+	s.rootMu.RUnlock()
+	if len(certs) == 0 {
+		s.refreshIntermediates(ctx, root)
+		s.rootMu.RLock()
+		certs = s.intermediates
+		s.rootMu.RUnlock()
+	}
+	return certs, nil
+}
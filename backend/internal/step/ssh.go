@@ -0,0 +1,222 @@
+package step
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"maps"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"step-ca-webui/internal/tracing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// SSH certificate types
+const (
+	SSHUserCert = "user"
+	SSHHostCert = "host"
+)
+
+// SSHSignOptions describe an SSH certificate for a client-held key
+type SSHSignOptions struct {
+	Auth            Auth
+	PublicKey       ssh.PublicKey
+	CertType        string // SSHUserCert or SSHHostCert
+	KeyID           string
+	Principals      []string
+	ValidAfter      time.Time
+	ValidBefore     time.Time
+	CriticalOptions map[string]string // user certificates only
+	Extensions      map[string]string // user certificates only; nil keeps the CA defaults
+}
+
+// sshSignRequest mirrors the body of step-ca's POST /1.0/ssh/sign. Keys
+// travel base64-encoded in the SSH wire format.
+type sshSignRequest struct {
+	PublicKey    []byte                 `json:"publicKey"`
+	OTT          string                 `json:"ott"`
+	CertType     string                 `json:"certType"`
+	KeyID        string                 `json:"keyID"`
+	Principals   []string               `json:"principals"`
+	ValidAfter   string                 `json:"validAfter,omitempty"`
+	ValidBefore  string                 `json:"validBefore,omitempty"`
+	TemplateData map[string]interface{} `json:"templateData,omitempty"`
+}
+
+type sshSignResponse struct {
+	Certificate string `json:"crt"`
+}
+
+type sshRootsResponse struct {
+	UserKeys []string `json:"userKey"`
+	HostKeys []string `json:"hostKey"`
+}
+
+// SSHRoots are the CA keys that sign SSH user and host certificates
+type SSHRoots struct {
+	UserKeys []ssh.PublicKey
+	HostKeys []ssh.PublicKey
+}
+
+// ParseSSHPublicKey reads a key in authorized_keys format
+func ParseSSHPublicKey(data string) (ssh.PublicKey, error) {
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid SSH public key: %w", err)
+	}
+	if _, ok := key.(*ssh.Certificate); ok {
+		return nil, fmt.Errorf("expected a public key, not a certificate")
+	}
+	return key, nil
+}
+
+// SignSSH has the CA sign an SSH user or host certificate. Critical options
+// and extensions are passed as template data; the CA only applies them when
+// its provisioner template does, so the issued certificate is checked
+// against the request.
+func (s *StepClient) SignSSH(ctx context.Context, opts SSHSignOptions) (_ *ssh.Certificate, err error) {
+	ctx, span := tracing.Start(ctx, "StepClient.SignSSH")
+	defer func() { tracing.End(span, err) }()
+
+	tempDir, err := os.MkdirTemp("", "step-ssh-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp dir: %w", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	root, err := s.Root(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// The token fixes the certificate type, key ID and principals
+	tokenArgs := []string{"--ssh"}
+	if opts.CertType == SSHHostCert {
+		tokenArgs = append(tokenArgs, "--host")
+	}
+	for _, principal := range opts.Principals {
+		tokenArgs = append(tokenArgs, "--principal", principal)
+	}
+	token, err := s.signToken(ctx, tempDir, root, opts.Auth, opts.KeyID, tokenArgs...)
+	if err != nil {
+		return nil, err
+	}
+
+	req := sshSignRequest{
+		PublicKey:   opts.PublicKey.Marshal(),
+		OTT:         token,
+		CertType:    opts.CertType,
+		KeyID:       opts.KeyID,
+		Principals:  opts.Principals,
+		ValidBefore: opts.ValidBefore.UTC().Format(time.RFC3339),
+	}
+	if !opts.ValidAfter.IsZero() {
+		req.ValidAfter = opts.ValidAfter.UTC().Format(time.RFC3339)
+	}
+	if len(opts.CriticalOptions) > 0 || opts.Extensions != nil {
+		req.TemplateData = map[string]interface{}{}
+		if len(opts.CriticalOptions) > 0 {
+			req.TemplateData["criticalOptions"] = opts.CriticalOptions
+		}
+		if opts.Extensions != nil {
+			req.TemplateData["extensions"] = opts.Extensions
+		}
+	}
+
+	var resp sshSignResponse
+	if err := s.caDo(ctx, caHTTPClient(root), http.MethodPost, "/1.0/ssh/sign", req, &resp); err != nil {
+		return nil, fmt.Errorf("failed to sign SSH certificate: %w", err)
+	}
+	cert, err := parseSSHCertificate(resp.Certificate)
+	if err != nil {
+		return nil, err
+	}
+
+	if !bytes.Equal(cert.Key.Marshal(), opts.PublicKey.Marshal()) {
+		return nil, fmt.Errorf("CA signed a different public key")
+	}
+	for name, value := range opts.CriticalOptions {
+		if got, ok := cert.CriticalOptions[name]; !ok || got != value {
+			return nil, fmt.Errorf("CA did not apply critical option %s; its provisioner template must include the requested criticalOptions", name)
+		}
+	}
+	if opts.Extensions != nil && !maps.Equal(cert.Extensions, opts.Extensions) {
+		return nil, fmt.Errorf("CA issued extensions %s instead of %s; its provisioner template must use the requested extensions", extensionNames(cert.Extensions), extensionNames(opts.Extensions))
+	}
+
+	return cert, nil
+}
+
+// SSHRoots returns the SSH user and host CA keys of the CA
+func (s *StepClient) SSHRoots(ctx context.Context) (*SSHRoots, error) {
+	root, err := s.Root(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp sshRootsResponse
+	if err := s.caDo(ctx, caHTTPClient(root), http.MethodGet, "/ssh/roots", nil, &resp); err != nil {
+		return nil, err
+	}
+
+	roots := &SSHRoots{}
+	for _, encoded := range resp.UserKeys {
+		key, err := parseSSHWireKey(encoded)
+		if err != nil {
+			return nil, err
+		}
+		roots.UserKeys = append(roots.UserKeys, key)
+	}
+	for _, encoded := range resp.HostKeys {
+		key, err := parseSSHWireKey(encoded)
+		if err != nil {
+			return nil, err
+		}
+		roots.HostKeys = append(roots.HostKeys, key)
+	}
+	return roots, nil
+}
+
+// MarshalSSHCertificate formats a certificate as a -cert.pub file line
+func MarshalSSHCertificate(cert *ssh.Certificate) string {
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(cert))) + " " + cert.KeyId
+}
+
+func parseSSHWireKey(encoded string) (ssh.PublicKey, error) {
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SSH key from CA: %w", err)
+	}
+	key, err := ssh.ParsePublicKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SSH key from CA: %w", err)
+	}
+	return key, nil
+}
+
+func parseSSHCertificate(encoded string) (*ssh.Certificate, error) {
+	key, err := parseSSHWireKey(encoded)
+	if err != nil {
+		return nil, err
+	}
+	cert, ok := key.(*ssh.Certificate)
+	if !ok {
+		return nil, fmt.Errorf("CA returned an SSH key instead of a certificate")
+	}
+	return cert, nil
+}
+
+func extensionNames(extensions map[string]string) string {
+	names := make([]string, 0, len(extensions))
+	for name := range extensions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return "[" + strings.Join(names, " ") + "]"
+}
@@ -0,0 +1,118 @@
+package step
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"crypto/aes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// MinZipPasswordLen is the shortest password accepted for AES encrypted ZIPs
+const MinZipPasswordLen = 8
+
+// WinZip AES (AE-2) parameters, see https://www.winzip.com/en/support/aes-encryption/
+const (
+	zipMethodAES      = 99
+	zipExtraAES       = 0x9901
+	zipAESVersion     = 2 // AE-2: no CRC, the HMAC authenticates the data
+	zipAESStrength256 = 3
+	zipAESSaltLen     = 16
+	zipAESKeyLen      = 32
+	zipAESIterations  = 1000
+	zipAESMACLen      = 10
+)
+
+// addAESFileToZip deflates data and stores it AES-256 encrypted in the WinZip
+// AE-2 format, which 7-Zip, WinZip, macOS Archive Utility and libarchive read
+func addAESFileToZip(zipWriter *zip.Writer, filename string, data []byte, password string) error {
+	var compressed bytes.Buffer
+	fw, err := flate.NewWriter(&compressed, flate.DefaultCompression)
+	if err != nil {
+		return err
+	}
+	if _, err := fw.Write(data); err != nil {
+		return err
+	}
+	if err := fw.Close(); err != nil {
+		return err
+	}
+
+	salt := make([]byte, zipAESSaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return fmt.Errorf("failed to generate salt: %w", err)
+	}
+	derived := pbkdf2.Key([]byte(password), salt, zipAESIterations, 2*zipAESKeyLen+2, sha1.New)
+	encKey, macKey, verifier := derived[:zipAESKeyLen], derived[zipAESKeyLen:2*zipAESKeyLen], derived[2*zipAESKeyLen:]
+
+	ciphertext, err := zipAESCTR(encKey, compressed.Bytes())
+	if err != nil {
+		return err
+	}
+	mac := hmac.New(sha1.New, macKey)
+	mac.Write(ciphertext)
+
+	payload := make([]byte, 0, len(salt)+len(verifier)+len(ciphertext)+zipAESMACLen)
+	payload = append(payload, salt...)
+	payload = append(payload, verifier...)
+	payload = append(payload, ciphertext...)
+	payload = append(payload, mac.Sum(nil)[:zipAESMACLen]...)
+
+	extra := make([]byte, 11)
+	binary.LittleEndian.PutUint16(extra[0:], zipExtraAES)
+	binary.LittleEndian.PutUint16(extra[2:], 7)
+	binary.LittleEndian.PutUint16(extra[4:], zipAESVersion)
+	copy(extra[6:], "AE")
+	extra[8] = zipAESStrength256
+	binary.LittleEndian.PutUint16(extra[9:], zip.Deflate)
+
+	header := &zip.FileHeader{
+		Name:               filename,
+		Method:             zipMethodAES,
+		Flags:              0x1, // encrypted
+		Extra:              extra,
+		CompressedSize64:   uint64(len(payload)),
+		UncompressedSize64: uint64(len(data)),
+	}
+	w, err := zipWriter.CreateRaw(header)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(payload)
+	return err
+}
+
+// zipAESCTR applies AES in the WinZip flavour of CTR mode: a little-endian
+// block counter starting at 1
+func zipAESCTR(key, data []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, len(data))
+	var counter, stream [aes.BlockSize]byte
+	for i := 0; i < len(data); i += aes.BlockSize {
+		for j := range counter {
+			counter[j]++
+			if counter[j] != 0 {
+				break
+			}
+		}
+		block.Encrypt(stream[:], counter[:])
+		end := i + aes.BlockSize
+		if end > len(data) {
+			end = len(data)
+		}
+		for j := i; j < end; j++ {
+			out[j] = data[j] ^ stream[j-i]
+		}
+	}
+	return out, nil
+}
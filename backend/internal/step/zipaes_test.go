@@ -0,0 +1,158 @@
+package step
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"crypto/aes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/binary"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// writeAESZip returns a ZIP holding files, each encrypted with password
+func writeAESZip(t *testing.T, files map[string][]byte, password string) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, data := range files {
+		if err := addAESFileToZip(zw, name, data, password); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// readAESFile decrypts a file written by addAESFileToZip following the
+// WinZip AE-2 specification
+func readAESFile(t *testing.T, f *zip.File, password string) ([]byte, bool) {
+	t.Helper()
+
+	if f.Method != zipMethodAES || f.Flags&0x1 == 0 {
+		t.Fatalf("%s: method %d, flags %#x; want AES encrypted", f.Name, f.Method, f.Flags)
+	}
+	extra := f.Extra
+	if len(extra) != 11 || binary.LittleEndian.Uint16(extra) != zipExtraAES ||
+		binary.LittleEndian.Uint16(extra[4:]) != zipAESVersion || string(extra[6:8]) != "AE" ||
+		extra[8] != zipAESStrength256 || binary.LittleEndian.Uint16(extra[9:]) != zip.Deflate {
+		t.Fatalf("%s: unexpected AES extra field %x", f.Name, extra)
+	}
+
+	r, err := f.OpenRaw()
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	salt := payload[:zipAESSaltLen]
+	verifier := payload[zipAESSaltLen : zipAESSaltLen+2]
+	ciphertext := payload[zipAESSaltLen+2 : len(payload)-zipAESMACLen]
+	tag := payload[len(payload)-zipAESMACLen:]
+
+	derived := pbkdf2.Key([]byte(password), salt, zipAESIterations, 2*zipAESKeyLen+2, sha1.New)
+	if !bytes.Equal(derived[2*zipAESKeyLen:], verifier) {
+		return nil, false
+	}
+	mac := hmac.New(sha1.New, derived[zipAESKeyLen:2*zipAESKeyLen])
+	mac.Write(ciphertext)
+	if !hmac.Equal(mac.Sum(nil)[:zipAESMACLen], tag) {
+		t.Fatalf("%s: authentication code mismatch", f.Name)
+	}
+
+	// CTR mode with a 64-bit little-endian block counter starting at 1
+	block, err := aes.NewCipher(derived[:zipAESKeyLen])
+	if err != nil {
+		t.Fatal(err)
+	}
+	compressed := make([]byte, len(ciphertext))
+	var counter, stream [aes.BlockSize]byte
+	for i := 0; i < len(ciphertext); i += aes.BlockSize {
+		binary.LittleEndian.PutUint64(counter[:], uint64(i/aes.BlockSize+1))
+		block.Encrypt(stream[:], counter[:])
+		for j := i; j < len(ciphertext) && j < i+aes.BlockSize; j++ {
+			compressed[j] = ciphertext[j] ^ stream[j-i]
+		}
+	}
+
+	data, err := io.ReadAll(flate.NewReader(bytes.NewReader(compressed)))
+	if err != nil {
+		t.Fatalf("%s: failed to inflate: %v", f.Name, err)
+	}
+	if uint64(len(data)) != f.UncompressedSize64 {
+		t.Errorf("%s: header size %d, content %d bytes", f.Name, f.UncompressedSize64, len(data))
+	}
+	return data, true
+}
+
+func TestAddAESFileToZip(t *testing.T) {
+	// Random data does not compress, so its 8 KiB take 512 blocks and the
+	// counter carries into its second byte
+	random := make([]byte, 8192)
+	if _, err := rand.Read(random); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string][]byte{
+		"cert.pem":   bytes.Repeat([]byte("-----BEGIN CERTIFICATE-----\n"), 40),
+		"empty.txt":  {},
+		"random.bin": random,
+	}
+	data := writeAESZip(t, files, "zip-password")
+
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(zr.File) != len(files) {
+		t.Fatalf("got %d files, want %d", len(zr.File), len(files))
+	}
+	for _, f := range zr.File {
+		got, ok := readAESFile(t, f, "zip-password")
+		if !ok {
+			t.Fatalf("%s: password verifier mismatch", f.Name)
+		}
+		if !bytes.Equal(got, files[f.Name]) {
+			t.Errorf("%s: decrypted content differs", f.Name)
+		}
+		if _, ok := readAESFile(t, f, "wrong-password"); ok {
+			t.Errorf("%s: wrong password passed the verifier", f.Name)
+		}
+	}
+}
+
+func TestAddAESFileToZipBsdtar(t *testing.T) {
+	bsdtar, err := exec.LookPath("bsdtar")
+	if err != nil {
+		t.Skip("bsdtar not installed")
+	}
+
+	content := []byte("key material\n")
+	path := filepath.Join(t.TempDir(), "bundle.zip")
+	if err := os.WriteFile(path, writeAESZip(t, map[string][]byte{"key.pem": content}, "zip-password"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := exec.Command(bsdtar, "-xOf", path, "--passphrase", "zip-password", "key.pem").Output()
+	if err != nil {
+		t.Fatalf("bsdtar failed to extract: %v", err)
+	}
+	if !bytes.Equal(out, content) {
+		t.Errorf("bsdtar extracted %q, want %q", out, content)
+	}
+	if err := exec.Command(bsdtar, "-xOf", path, "--passphrase", "wrong-password", "key.pem").Run(); err == nil {
+		t.Error("bsdtar extracted with the wrong password")
+	}
+}
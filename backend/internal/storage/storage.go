@@ -0,0 +1,46 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+
+	"step-ca-webui/internal/deploy"
+)
+
+// Backend types
+const (
+	TypeEphemeral = "ephemeral"
+	TypeVault     = "vault"
+)
+
+// Backend keeps the files of issued certificates. Store is called on every
+// issuance and renewal of a certificate and returns the reference recorded
+// as its StorageRef.
+type Backend interface {
+	Store(ctx context.Context, certID string, files *deploy.Files) (string, error)
+}
+
+// Config selects and configures the backend
+type Config struct {
+	Type  string
+	Vault VaultConfig
+}
+
+// New returns the configured backend
+func New(cfg Config) (Backend, error) {
+	switch cfg.Type {
+	case "", TypeEphemeral:
+		return ephemeral{}, nil
+	case TypeVault:
+		return newVaultBackend(cfg.Vault)
+	default:
+		return nil, fmt.Errorf("unsupported storage backend %q", cfg.Type)
+	}
+}
+
+// ephemeral keeps nothing; keys only exist in the one-time download
+type ephemeral struct{}
+
+func (ephemeral) Store(context.Context, string, *deploy.Files) (string, error) {
+	return TypeEphemeral, nil
+}
@@ -0,0 +1,223 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"step-ca-webui/internal/deploy"
+)
+
+// VaultConfig points at a KV version 2 secrets engine. Either Token or
+// RoleID and SecretID of an AppRole are required.
+type VaultConfig struct {
+	Addr         string
+	Namespace    string // Vault Enterprise namespace
+	CACert       string // PEM file trusted for the Vault server
+	Token        string
+	RoleID       string
+	SecretID     string
+	AppRoleMount string // defaults to approle
+	Mount        string // KV v2 mount, defaults to secret
+	Path         string // prefix below the mount, defaults to step-ca-webui
+}
+
+// vaultBackend writes one KV v2 secret per certificate. Renewals add a new
+// version of the same secret, so earlier certificates stay retrievable.
+type vaultBackend struct {
+	cfg    VaultConfig
+	client *http.Client
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time // zero for tokens that do not expire
+}
+
+func newVaultBackend(cfg VaultConfig) (*vaultBackend, error) {
+	u, err := url.Parse(cfg.Addr)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("VAULT_ADDR %q must be an http or https URL", cfg.Addr)
+	}
+	cfg.Addr = strings.TrimSuffix(cfg.Addr, "/")
+	if cfg.Token == "" && (cfg.RoleID == "" || cfg.SecretID == "") {
+		return nil, errors.New("set VAULT_TOKEN, or VAULT_ROLE_ID and VAULT_SECRET_ID for AppRole login")
+	}
+	if cfg.AppRoleMount == "" {
+		cfg.AppRoleMount = "approle"
+	}
+	if cfg.Mount == "" {
+		cfg.Mount = "secret"
+	}
+	if cfg.Path == "" {
+		cfg.Path = "step-ca-webui"
+	}
+	cfg.Mount = strings.Trim(cfg.Mount, "/")
+	cfg.Path = strings.Trim(cfg.Path, "/")
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if cfg.CACert != "" {
+		caPEM, err := os.ReadFile(cfg.CACert)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read VAULT_CACERT: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, errors.New("no certificates in VAULT_CACERT")
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	return &vaultBackend{
+		cfg: cfg,
+		client: &http.Client{
+			Timeout:   30 * time.Second,
+			Transport: &http.Transport{TLSClientConfig: tlsConfig, Proxy: http.ProxyFromEnvironment},
+		},
+		token: cfg.Token,
+	}, nil
+}
+
+// Store writes the certificate files to <mount>/<path>/<certID> and returns
+// a reference of the form vault:<mount>/<path>/<certID>
+func (v *vaultBackend) Store(ctx context.Context, certID string, files *deploy.Files) (string, error) {
+	data := map[string]string{
+		"certificate": string(files.Cert),
+		"chain":       string(files.Chain),
+		"fullchain":   string(files.FullChain),
+		"root":        string(files.Root),
+		"serial":      files.Serial,
+		"not_after":   files.NotAfter.UTC().Format(time.RFC3339),
+	}
+	if len(files.Key) > 0 {
+		data["private_key"] = string(files.Key)
+	}
+
+	secretPath := v.cfg.Path + "/" + certID
+	endpoint := fmt.Sprintf("%s/v1/%s/data/%s", v.cfg.Addr, v.cfg.Mount, secretPath)
+	if err := v.write(ctx, endpoint, map[string]interface{}{"data": data}); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("vault:%s/%s", v.cfg.Mount, secretPath), nil
+}
+
+// write sends an authenticated request, logging in again once if an AppRole
+// token was revoked or expired early
+func (v *vaultBackend) write(ctx context.Context, endpoint string, body interface{}) error {
+	token, err := v.currentToken(ctx, false)
+	if err != nil {
+		return err
+	}
+	err = v.do(ctx, endpoint, token, body, nil)
+	var verr *vaultError
+	if errors.As(err, &verr) && verr.code == http.StatusForbidden && v.cfg.Token == "" {
+		if token, err = v.currentToken(ctx, true); err != nil {
+			return err
+		}
+		err = v.do(ctx, endpoint, token, body, nil)
+	}
+	return err
+}
+
+// currentToken returns the static token, or an AppRole token that is
+// renewed by logging in again shortly before its lease ends
+func (v *vaultBackend) currentToken(ctx context.Context, force bool) (string, error) {
+	if v.cfg.Token != "" {
+		return v.cfg.Token, nil
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if !force && v.token != "" && (v.expires.IsZero() || time.Now().Before(v.expires)) {
+		return v.token, nil
+	}
+
+	var resp struct {
+		Auth struct {
+			ClientToken   string `json:"client_token"`
+			LeaseDuration int    `json:"lease_duration"`
+		} `json:"auth"`
+	}
+	endpoint := fmt.Sprintf("%s/v1/auth/%s/login", v.cfg.Addr, strings.Trim(v.cfg.AppRoleMount, "/"))
+	login := map[string]string{"role_id": v.cfg.RoleID, "secret_id": v.cfg.SecretID}
+	if err := v.do(ctx, endpoint, "", login, &resp); err != nil {
+		return "", fmt.Errorf("AppRole login failed: %w", err)
+	}
+	if resp.Auth.ClientToken == "" {
+		return "", errors.New("AppRole login returned no token")
+	}
+
+	v.token = resp.Auth.ClientToken
+	v.expires = time.Time{}
+	if lease := time.Duration(resp.Auth.LeaseDuration) * time.Second; lease > 0 {
+		v.expires = time.Now().Add(lease * 4 / 5)
+	}
+	return v.token, nil
+}
+
+// vaultError is an error response of the Vault API
+type vaultError struct {
+	code   int
+	errors []string
+}
+
+func (e *vaultError) Error() string {
+	if len(e.errors) == 0 {
+		return fmt.Sprintf("Vault returned %d", e.code)
+	}
+	return fmt.Sprintf("Vault returned %d: %s", e.code, strings.Join(e.errors, "; "))
+}
+
+// do posts a JSON body to the Vault API and decodes the response into out
+func (v *vaultBackend) do(ctx context.Context, endpoint, token string, body, out interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	if v.cfg.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.cfg.Namespace)
+	}
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach Vault: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respData, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		verr := &vaultError{code: resp.StatusCode}
+		var status struct {
+			Errors []string `json:"errors"`
+		}
+		if json.Unmarshal(respData, &status) == nil {
+			verr.errors = status.Errors
+		}
+		return verr
+	}
+	if out != nil {
+		return json.Unmarshal(respData, out)
+	}
+	return nil
+}
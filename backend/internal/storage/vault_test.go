@@ -0,0 +1,271 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"step-ca-webui/internal/deploy"
+)
+
+// fakeVault serves AppRole login and KV v2 writes from memory
+type fakeVault struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	tokens   map[string]bool // valid tokens
+	logins   int
+	writes   int
+	lease    int // lease_duration of issued tokens in seconds
+	secrets  map[string]map[string]interface{}
+	headers  http.Header // of the last write
+	denyNext int         // writes to reject although their token is valid
+}
+
+func newFakeVault(t *testing.T) *fakeVault {
+	f := &fakeVault{
+		tokens:  map[string]bool{"static-token": true},
+		secrets: make(map[string]map[string]interface{}),
+	}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.Close)
+	return f
+}
+
+// revoke invalidates every token except the static one
+func (f *fakeVault) revoke() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.tokens = map[string]bool{"static-token": true}
+}
+
+func (f *fakeVault) serve(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	fail := func(code int, message string) {
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(map[string]interface{}{"errors": []string{message}})
+	}
+	var body map[string]interface{}
+	if r.Method != http.MethodPost || json.NewDecoder(r.Body).Decode(&body) != nil {
+		fail(http.StatusBadRequest, "bad request")
+		return
+	}
+
+	switch {
+	case r.URL.Path == "/v1/auth/approle/login":
+		f.logins++
+		if body["role_id"] != "role" || body["secret_id"] != "secret" {
+			fail(http.StatusBadRequest, "invalid role or secret ID")
+			return
+		}
+		token := fmt.Sprintf("approle-token-%d", f.logins)
+		f.tokens[token] = true
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"auth": map[string]interface{}{"client_token": token, "lease_duration": f.lease},
+		})
+
+	case strings.HasPrefix(r.URL.Path, "/v1/"):
+		f.writes++
+		f.headers = r.Header.Clone()
+		if !f.tokens[r.Header.Get("X-Vault-Token")] || f.denyNext > 0 {
+			f.denyNext--
+			fail(http.StatusForbidden, "permission denied")
+			return
+		}
+		data, _ := body["data"].(map[string]interface{})
+		f.secrets[strings.TrimPrefix(r.URL.Path, "/v1/")] = data
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{"version": 1}})
+
+	default:
+		fail(http.StatusNotFound, "unsupported path")
+	}
+}
+
+var testFiles = &deploy.Files{
+	Cert:      []byte("leaf\n"),
+	Chain:     []byte("intermediate\n"),
+	FullChain: []byte("leaf\nintermediate\n"),
+	Key:       []byte("key\n"),
+	Root:      []byte("root\n"),
+	Serial:    "0A1B",
+	NotAfter:  time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC),
+}
+
+func newTestVault(t *testing.T, cfg VaultConfig) *vaultBackend {
+	t.Helper()
+	v, err := newVaultBackend(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestVaultStore(t *testing.T) {
+	f := newFakeVault(t)
+	v := newTestVault(t, VaultConfig{Addr: f.URL + "/", Token: "static-token", Namespace: "pki", Mount: "/kv/", Path: "/certs/"})
+
+	ref, err := v.Store(context.Background(), "cert-1", testFiles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ref != "vault:kv/certs/cert-1" {
+		t.Errorf("reference is %q", ref)
+	}
+	if got := f.headers.Get("X-Vault-Namespace"); got != "pki" {
+		t.Errorf("namespace header is %q", got)
+	}
+
+	data, ok := f.secrets["kv/data/certs/cert-1"]
+	if !ok {
+		t.Fatalf("nothing written to kv/data/certs/cert-1, secrets: %v", f.secrets)
+	}
+	want := map[string]string{
+		"certificate": "leaf\n",
+		"chain":       "intermediate\n",
+		"fullchain":   "leaf\nintermediate\n",
+		"root":        "root\n",
+		"private_key": "key\n",
+		"serial":      "0A1B",
+		"not_after":   "2030-01-02T03:04:05Z",
+	}
+	for key, value := range want {
+		if data[key] != value {
+			t.Errorf("%s is %v, want %q", key, data[key], value)
+		}
+	}
+	if f.logins != 0 {
+		t.Errorf("static token logged in %d times", f.logins)
+	}
+
+	// Certificates signed from a CSR have no key to store
+	files := *testFiles
+	files.Key = nil
+	if _, err := v.Store(context.Background(), "cert-2", &files); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := f.secrets["kv/data/certs/cert-2"]["private_key"]; ok {
+		t.Error("private_key written for a certificate without key")
+	}
+}
+
+func TestVaultStaticTokenDenied(t *testing.T) {
+	f := newFakeVault(t)
+	v := newTestVault(t, VaultConfig{Addr: f.URL, Token: "revoked-token"})
+
+	_, err := v.Store(context.Background(), "cert-1", testFiles)
+	if err == nil || !strings.Contains(err.Error(), "403: permission denied") {
+		t.Fatalf("Store returned %v, want permission denied", err)
+	}
+	if f.writes != 1 || f.logins != 0 {
+		t.Errorf("made %d writes and %d logins, want 1 and 0", f.writes, f.logins)
+	}
+}
+
+func TestVaultAppRole(t *testing.T) {
+	f := newFakeVault(t)
+	v := newTestVault(t, VaultConfig{Addr: f.URL, RoleID: "role", SecretID: "secret"})
+
+	for _, id := range []string{"cert-1", "cert-2"} {
+		if _, err := v.Store(context.Background(), id, testFiles); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if f.logins != 1 {
+		t.Errorf("logged in %d times for two writes, want once", f.logins)
+	}
+	if len(f.secrets) != 2 {
+		t.Errorf("wrote %d secrets, want 2", len(f.secrets))
+	}
+}
+
+func TestVaultAppRoleRelogin(t *testing.T) {
+	f := newFakeVault(t)
+	v := newTestVault(t, VaultConfig{Addr: f.URL, RoleID: "role", SecretID: "secret"})
+
+	if _, err := v.Store(context.Background(), "cert-1", testFiles); err != nil {
+		t.Fatal(err)
+	}
+
+	// A revoked token is answered with 403, and the write is retried once
+	// with a new token
+	f.revoke()
+	if _, err := v.Store(context.Background(), "cert-2", testFiles); err != nil {
+		t.Fatal(err)
+	}
+	if f.logins != 2 || f.writes != 3 {
+		t.Errorf("made %d logins and %d writes, want 2 and 3", f.logins, f.writes)
+	}
+	if _, ok := f.secrets["secret/data/step-ca-webui/cert-2"]; !ok {
+		t.Error("retried write was not stored")
+	}
+	if v.token != "approle-token-2" {
+		t.Errorf("token is %q after the new login", v.token)
+	}
+
+	// A write denied with a fresh token is not retried again
+	f.denyNext = 2
+	_, err := v.Store(context.Background(), "cert-3", testFiles)
+	if err == nil || !strings.Contains(err.Error(), "403") {
+		t.Fatalf("Store returned %v, want permission denied", err)
+	}
+	if f.logins != 3 || f.writes != 5 {
+		t.Errorf("made %d logins and %d writes, want 3 and 5", f.logins, f.writes)
+	}
+}
+
+func TestVaultAppRoleLease(t *testing.T) {
+	f := newFakeVault(t)
+	f.lease = 3600
+	v := newTestVault(t, VaultConfig{Addr: f.URL, RoleID: "role", SecretID: "secret"})
+
+	if _, err := v.Store(context.Background(), "cert-1", testFiles); err != nil {
+		t.Fatal(err)
+	}
+	// Tokens are renewed after four fifths of their lease
+	if remaining := time.Until(v.expires); remaining < 47*time.Minute || remaining > 48*time.Minute {
+		t.Errorf("token renews in %s, want 48m", remaining)
+	}
+
+	v.expires = time.Now().Add(-time.Second)
+	if _, err := v.Store(context.Background(), "cert-2", testFiles); err != nil {
+		t.Fatal(err)
+	}
+	if f.logins != 2 || f.writes != 2 {
+		t.Errorf("made %d logins and %d writes, want 2 and 2", f.logins, f.writes)
+	}
+}
+
+func TestVaultAppRoleLoginFailure(t *testing.T) {
+	f := newFakeVault(t)
+	v := newTestVault(t, VaultConfig{Addr: f.URL, RoleID: "role", SecretID: "wrong"})
+
+	_, err := v.Store(context.Background(), "cert-1", testFiles)
+	if err == nil || !strings.Contains(err.Error(), "AppRole login failed") || !strings.Contains(err.Error(), "invalid role or secret ID") {
+		t.Fatalf("Store returned %v, want a login failure", err)
+	}
+	if f.writes != 0 {
+		t.Errorf("made %d writes without a token", f.writes)
+	}
+}
+
+func TestNewVaultBackend(t *testing.T) {
+	for _, cfg := range []VaultConfig{
+		{Addr: "vault:8200", Token: "t"},
+		{Addr: "ftp://vault", Token: "t"},
+		{Addr: "https://vault"},
+		{Addr: "https://vault", RoleID: "role"},
+		{Addr: "https://vault", Token: "t", CACert: "/nonexistent/ca.pem"},
+	} {
+		if _, err := newVaultBackend(cfg); err == nil {
+			t.Errorf("config %+v was accepted", cfg)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package tracing
+
+import (
+	"net/http"
+
+	"step-ca-webui/internal/auth"
+	"step-ca-webui/internal/logging"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// untracedRoutes are polled by health checks and scrapers
+var untracedRoutes = map[string]bool{
+	"/health":  true,
+	"/metrics": true,
+}
+
+// Middleware starts a server span for every request, continuing the trace
+// of a W3C traceparent header. Spans are named after the route pattern, and
+// like the request log they leave one-time download tokens out of the path.
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		route := c.FullPath()
+		if untracedRoutes[route] {
+			c.Next()
+			return
+		}
+
+		name := c.Request.Method
+		if route != "" {
+			name += " " + route
+		}
+		path := c.Request.URL.Path
+		if c.Param("token") != "" {
+			path = route
+		}
+		ctx := otel.GetTextMapPropagator().Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+		ctx, span := Start(ctx, name,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(c.Request.Method),
+				semconv.HTTPRoute(route),
+				semconv.URLPath(path),
+				semconv.ClientAddress(c.ClientIP()),
+				semconv.UserAgentOriginal(c.Request.UserAgent()),
+				attribute.String("request_id", logging.RequestID(ctx)),
+			))
+		defer span.End()
+		c.Request = c.Request.WithContext(ctx)
+
+		c.Next()
+
+		status := c.Writer.Status()
+		span.SetAttributes(
+			semconv.HTTPResponseStatusCode(status),
+			semconv.EnduserID(auth.FromContext(c).User),
+		)
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+	}
+}
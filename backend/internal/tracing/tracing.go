@@ -0,0 +1,75 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"step-ca-webui/internal/logging"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName names the tracer and is the default service name
+const instrumentationName = "step-ca-webui"
+
+// Setup exports spans over OTLP/HTTP. The exporter reads its endpoint,
+// headers, TLS settings and timeout from the standard OTEL_EXPORTER_OTLP_*
+// variables, the SDK its sampler from OTEL_TRACES_SAMPLER, and the service
+// name and resource can be changed with OTEL_SERVICE_NAME and
+// OTEL_RESOURCE_ATTRIBUTES. The returned function flushes pending spans and
+// stops the exporter. Without Setup spans are not recorded.
+func Setup(ctx context.Context) (func(context.Context) error, error) {
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP exporter: %w", err)
+	}
+	provider, err := NewProvider(ctx, exporter)
+	if err != nil {
+		return nil, err
+	}
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return provider.Shutdown, nil
+}
+
+// NewProvider returns a tracer provider that batches spans to exporter.
+// Installed with otel.SetTracerProvider, it also takes an in-memory exporter
+// to inspect spans locally.
+func NewProvider(ctx context.Context, exporter sdktrace.SpanExporter) (*sdktrace.TracerProvider, error) {
+	res, err := resource.New(ctx,
+		resource.WithAttributes(semconv.ServiceName(instrumentationName)),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build trace resource: %w", err)
+	}
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	), nil
+}
+
+// Start starts a span as a child of the span in ctx, if any
+func Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentationName).Start(ctx, name, opts...)
+}
+
+// End marks span as failed if err is set and ends it. Errors can quote CA
+// responses and step output, so they are redacted like log records.
+func End(span trace.Span, err error) {
+	if err != nil {
+		msg := logging.Redact(err.Error())
+		span.RecordError(errors.New(msg))
+		span.SetStatus(codes.Error, msg)
+	}
+	span.End()
+}
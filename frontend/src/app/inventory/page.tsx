@@ -203,7 +203,14 @@ export default function Inventory() {
                   <tr key={cert.id} className="hover:bg-gray-50">
                     <td className="px-6 py-4 whitespace-nowrap">
                       <div>
-                        <div className="text-sm font-medium text-gray-900">{cert.cn}</div>
+                        <div className="text-sm font-medium text-gray-900">
+                          {cert.cn}
+                          {cert.cert_type && cert.cert_type !== 'x509' && (
+                            <span className="ml-2 inline-flex items-center px-2 py-0.5 rounded text-xs font-medium bg-gray-100 text-gray-700">
+                              {cert.cert_type === 'ssh-host' ? 'SSH host' : 'SSH user'}
+                            </span>
+                          )}
+                        </div>
                         {cert.sans && cert.sans.length > 0 && (
                           <div className="text-sm text-gray-500">
                             {cert.sans.slice(0, 2).join(', ')}
@@ -233,13 +240,15 @@ export default function Inventory() {
                       <div className="flex space-x-2">
                         {cert.status === 'active' && (
                           <>
-                            <button
-                              onClick={() => handleRenew(cert)}
-                              className="text-blue-600 hover:text-blue-900"
-                              title="Renew Certificate"
-                            >
-                              <RotateCcw className="h-4 w-4" />
-                            </button>
+                            {(!cert.cert_type || cert.cert_type === 'x509') && cert.key_strategy !== 'acme' && (
+                              <button
+                                onClick={() => handleRenew(cert)}
+                                className="text-blue-600 hover:text-blue-900"
+                                title="Renew Certificate"
+                              >
+                                <RotateCcw className="h-4 w-4" />
+                              </button>
+                            )}
                             <button
                               onClick={() => handleRevoke(cert)}
                               className="text-red-600 hover:text-red-900"
@@ -306,6 +315,12 @@ export default function Inventory() {
                     <label className="text-sm font-medium text-gray-500">Key Strategy</label>
                     <p className="text-sm text-gray-900">{selectedCert.key_strategy}</p>
                   </div>
+                  {selectedCert.acme_account && (
+                    <div>
+                      <label className="text-sm font-medium text-gray-500">ACME Account</label>
+                      <p className="text-sm text-gray-900 break-all">{selectedCert.acme_account}</p>
+                    </div>
+                  )}
                   <div>
                     <label className="text-sm font-medium text-gray-500">Created</label>
                     <p className="text-sm text-gray-900">{formatDate(selectedCert.created_at)}</p>
@@ -2,27 +2,48 @@
 
 import { useState } from 'react'
 import { useForm } from 'react-hook-form'
-import { certificateApi, IssueRequest } from '@/lib/api'
+import { certificateApi, IssueRequest, BundleFormat, DownloadLink } from '@/lib/api'
 import { Shield, Download, AlertCircle } from 'lucide-react'
 import { toast } from 'react-hot-toast'
-import { downloadBase64File } from '@/lib/utils'
+import { downloadFile } from '@/lib/utils'
 import Link from 'next/link'
 
 interface FormData {
   cn: string
   sans: string
   not_after_days: number
-  format: 'pem' | 'pfx'
+  format: BundleFormat
   pfx_password?: string
+  pfx_encryption: 'modern' | 'legacy'
+  friendly_name?: string
+  keystore_alias?: string
+  keystore_password?: string
+  key_password?: string
+  zip_password?: string
+  secret_name?: string
+  namespace?: string
+  key_type: string
 }
 
+const formatOptions: { value: BundleFormat; label: string }[] = [
+  { value: 'pem', label: 'PEM Bundle (cert.pem, chain.pem, privkey.pem)' },
+  { value: 'pfx', label: 'PFX/PKCS#12 Bundle' },
+  { value: 'jks', label: 'Java Keystore (keystore.jks, truststore.p12)' },
+  { value: 'tar.gz', label: 'PEM Bundle as tar.gz' },
+  { value: 'combined', label: 'Combined PEM (key, certificate and chain in one file, for HAProxy)' },
+  { value: 'der', label: 'DER Certificate with PEM Key (ZIP)' },
+  { value: 'pkcs8', label: 'Encrypted PKCS#8 Private Key with Certificates (ZIP)' },
+  { value: 'p7b', label: 'PKCS#7 Certificate Chain with PEM Key (ZIP)' },
+  { value: 'k8s', label: 'Kubernetes TLS Secret (YAML)' },
+]
+
+const archiveFormats: BundleFormat[] = ['pem', 'pfx', 'jks', 'tar.gz']
+const zipFormats: BundleFormat[] = ['pem', 'pfx', 'jks']
+
 export default function IssueCertificate() {
   const [loading, setLoading] = useState(false)
-  const [downloadData, setDownloadData] = useState<{
-    data: string
-    filename: string
-    mime_type: string
-  } | null>(null)
+  const [downloadData, setDownloadData] = useState<DownloadLink | null>(null)
+  const [issuedFormat, setIssuedFormat] = useState<BundleFormat>('pem')
 
   const {
     register,
@@ -35,6 +56,8 @@ export default function IssueCertificate() {
       sans: '',
       not_after_days: 90,
       format: 'pem',
+      pfx_encryption: 'modern',
+      key_type: 'ec-p256',
     },
   })
 
@@ -55,10 +78,20 @@ export default function IssueCertificate() {
         not_after_days: data.not_after_days,
         format: data.format,
         pfx_password: data.pfx_password,
+        pfx_encryption: data.pfx_encryption,
+        friendly_name: data.friendly_name || undefined,
+        keystore_alias: data.keystore_alias || undefined,
+        keystore_password: data.keystore_password,
+        key_password: archiveFormats.includes(data.format) || data.format === 'pkcs8' ? data.key_password || undefined : undefined,
+        zip_password: zipFormats.includes(data.format) ? data.zip_password || undefined : undefined,
+        secret_name: data.secret_name || undefined,
+        namespace: data.namespace || undefined,
+        key_type: data.key_type,
       }
 
       const response = await certificateApi.issueCertificate(request)
       setDownloadData(response.download)
+      setIssuedFormat(data.format)
       toast.success('Certificate issued successfully!')
     } catch (error: any) {
       console.error('Failed to issue certificate:', error)
@@ -68,9 +101,14 @@ export default function IssueCertificate() {
     }
   }
 
-  const handleDownload = () => {
-    if (downloadData) {
-      downloadBase64File(downloadData.data, downloadData.filename, downloadData.mime_type)
+  const handleDownload = async () => {
+    if (!downloadData) return
+    try {
+      const blob = await certificateApi.fetchDownload(downloadData)
+      downloadFile(blob, downloadData.filename, downloadData.mime_type)
+    } catch (error: any) {
+      console.error('Failed to download certificate:', error)
+      toast.error('Download link was already used or has expired')
     }
   }
 
@@ -141,30 +179,41 @@ export default function IssueCertificate() {
               </select>
             </div>
 
+            {/* Key Type */}
+            <div>
+              <label htmlFor="key_type" className="block text-sm font-medium text-gray-700">
+                Key Type
+              </label>
+              <select
+                {...register('key_type')}
+                className="mt-1 block w-full border-gray-300 rounded-md shadow-sm focus:ring-blue-500 focus:border-blue-500 sm:text-sm"
+              >
+                <option value="ec-p256">EC P-256</option>
+                <option value="ec-p384">EC P-384</option>
+                <option value="rsa-2048">RSA 2048</option>
+                <option value="rsa-3072">RSA 3072</option>
+                <option value="rsa-4096">RSA 4096</option>
+                <option value="ed25519">Ed25519</option>
+              </select>
+            </div>
+
             {/* Output Format */}
             <div>
               <label className="block text-sm font-medium text-gray-700">
                 Output Format
               </label>
               <div className="mt-2 space-y-2">
-                <label className="flex items-center">
-                  <input
-                    {...register('format')}
-                    type="radio"
-                    value="pem"
-                    className="h-4 w-4 text-blue-600 focus:ring-blue-500 border-gray-300"
-                  />
-                  <span className="ml-2 text-sm text-gray-700">PEM Bundle (cert.pem, chain.pem, privkey.pem)</span>
-                </label>
-                <label className="flex items-center">
-                  <input
-                    {...register('format')}
-                    type="radio"
-                    value="pfx"
-                    className="h-4 w-4 text-blue-600 focus:ring-blue-500 border-gray-300"
-                  />
-                  <span className="ml-2 text-sm text-gray-700">PFX/PKCS#12 Bundle</span>
-                </label>
+                {formatOptions.map(option => (
+                  <label key={option.value} className="flex items-center">
+                    <input
+                      {...register('format')}
+                      type="radio"
+                      value={option.value}
+                      className="h-4 w-4 text-blue-600 focus:ring-blue-500 border-gray-300"
+                    />
+                    <span className="ml-2 text-sm text-gray-700">{option.label}</span>
+                  </label>
+                ))}
               </div>
             </div>
 
@@ -185,6 +234,131 @@ export default function IssueCertificate() {
                 {errors.pfx_password && (
                   <p className="mt-1 text-sm text-red-600">{errors.pfx_password.message}</p>
                 )}
+                <label htmlFor="pfx_encryption" className="mt-4 block text-sm font-medium text-gray-700">
+                  PFX Encryption
+                </label>
+                <select
+                  {...register('pfx_encryption')}
+                  className="mt-1 block w-full border-gray-300 rounded-md shadow-sm focus:ring-blue-500 focus:border-blue-500 sm:text-sm"
+                >
+                  <option value="modern">Modern (AES-256, SHA-256)</option>
+                  <option value="legacy">Legacy (3DES, SHA-1) for Windows Server 2016 and older</option>
+                </select>
+                <label htmlFor="friendly_name" className="mt-4 block text-sm font-medium text-gray-700">
+                  Friendly Name
+                </label>
+                <input
+                  {...register('friendly_name')}
+                  type="text"
+                  className="mt-1 block w-full border-gray-300 rounded-md shadow-sm focus:ring-blue-500 focus:border-blue-500 sm:text-sm"
+                  placeholder="Defaults to the common name"
+                />
+              </div>
+            )}
+
+            {/* Java Keystore */}
+            {format === 'jks' && (
+              <div className="space-y-4">
+                <div>
+                  <label htmlFor="keystore_alias" className="block text-sm font-medium text-gray-700">
+                    Key Alias
+                  </label>
+                  <input
+                    {...register('keystore_alias')}
+                    type="text"
+                    className="mt-1 block w-full border-gray-300 rounded-md shadow-sm focus:ring-blue-500 focus:border-blue-500 sm:text-sm"
+                    placeholder="Defaults to the common name"
+                  />
+                </div>
+                <div>
+                  <label htmlFor="keystore_password" className="block text-sm font-medium text-gray-700">
+                    Keystore Password
+                  </label>
+                  <input
+                    {...register('keystore_password', {
+                      required: format === 'jks' ? 'Keystore password is required' : false,
+                      minLength: { value: 6, message: 'Keystore password must be at least 6 characters' },
+                    })}
+                    type="password"
+                    className="mt-1 block w-full border-gray-300 rounded-md shadow-sm focus:ring-blue-500 focus:border-blue-500 sm:text-sm"
+                    placeholder="Protects keystore.jks and truststore.p12"
+                  />
+                  {errors.keystore_password && (
+                    <p className="mt-1 text-sm text-red-600">{errors.keystore_password.message}</p>
+                  )}
+                </div>
+              </div>
+            )}
+
+            {/* Key and ZIP Passwords */}
+            {(archiveFormats.includes(format) || format === 'pkcs8') && (
+              <div className="space-y-4">
+                <div>
+                  <label htmlFor="key_password" className="block text-sm font-medium text-gray-700">
+                    Key Password{format === 'pkcs8' ? '' : ' (optional)'}
+                  </label>
+                  <input
+                    {...register('key_password', {
+                      required: format === 'pkcs8' ? 'Key password is required' : false,
+                      minLength: { value: 4, message: 'Key password must be at least 4 characters' },
+                    })}
+                    type="password"
+                    className="mt-1 block w-full border-gray-300 rounded-md shadow-sm focus:ring-blue-500 focus:border-blue-500 sm:text-sm"
+                    placeholder="Encrypts the private key as PKCS#8"
+                  />
+                  {errors.key_password && (
+                    <p className="mt-1 text-sm text-red-600">{errors.key_password.message}</p>
+                  )}
+                </div>
+                {zipFormats.includes(format) && (
+                  <div>
+                    <label htmlFor="zip_password" className="block text-sm font-medium text-gray-700">
+                      ZIP Password (optional)
+                    </label>
+                    <input
+                      {...register('zip_password', {
+                        minLength: { value: 8, message: 'ZIP password must be at least 8 characters' },
+                      })}
+                      type="password"
+                      className="mt-1 block w-full border-gray-300 rounded-md shadow-sm focus:ring-blue-500 focus:border-blue-500 sm:text-sm"
+                      placeholder="Encrypts the whole ZIP with AES-256"
+                    />
+                    {errors.zip_password && (
+                      <p className="mt-1 text-sm text-red-600">{errors.zip_password.message}</p>
+                    )}
+                    <p className="mt-1 text-sm text-gray-500">
+                      Open with 7-Zip, WinZip or macOS Archive Utility; Windows Explorer cannot read AES ZIPs.
+                    </p>
+                  </div>
+                )}
+              </div>
+            )}
+
+            {/* Kubernetes Secret */}
+            {format === 'k8s' && (
+              <div className="space-y-4">
+                <div>
+                  <label htmlFor="secret_name" className="block text-sm font-medium text-gray-700">
+                    Secret Name
+                  </label>
+                  <input
+                    {...register('secret_name')}
+                    type="text"
+                    className="mt-1 block w-full border-gray-300 rounded-md shadow-sm focus:ring-blue-500 focus:border-blue-500 sm:text-sm"
+                    placeholder="Defaults to <common name>-tls"
+                  />
+                </div>
+                <div>
+                  <label htmlFor="namespace" className="block text-sm font-medium text-gray-700">
+                    Namespace
+                  </label>
+                  <input
+                    {...register('namespace')}
+                    type="text"
+                    className="mt-1 block w-full border-gray-300 rounded-md shadow-sm focus:ring-blue-500 focus:border-blue-500 sm:text-sm"
+                    placeholder="Leave empty to apply to the current namespace"
+                  />
+                </div>
               </div>
             )}
 
@@ -225,18 +399,23 @@ export default function IssueCertificate() {
                 className="inline-flex items-center px-4 py-2 border border-transparent text-sm font-medium rounded-md text-white bg-green-600 hover:bg-green-700"
               >
                 <Download className="h-4 w-4 mr-2" />
-                Download Certificate Bundle
+                Download {downloadData.filename}
               </button>
+              <p className="mt-2 text-sm text-green-600">
+                The link works once and expires at {new Date(downloadData.expires_at).toLocaleTimeString()}.
+              </p>
             </div>
-            <div className="mt-4 p-4 bg-green-100 rounded-md">
-              <h4 className="text-sm font-medium text-green-800 mb-2">Installation Instructions:</h4>
-              <ul className="text-sm text-green-700 space-y-1">
-                <li>• Extract the downloaded ZIP file</li>
-                <li>• Use <code className="bg-green-200 px-1 rounded">fullchain.pem</code> as your certificate</li>
-                <li>• Use <code className="bg-green-200 px-1 rounded">privkey.pem</code> as your private key</li>
-                <li>• See README.txt for detailed installation instructions</li>
-              </ul>
-            </div>
+            {archiveFormats.includes(issuedFormat) && (
+              <div className="mt-4 p-4 bg-green-100 rounded-md">
+                <h4 className="text-sm font-medium text-green-800 mb-2">Installation Instructions:</h4>
+                <ul className="text-sm text-green-700 space-y-1">
+                  <li>• Extract the downloaded archive</li>
+                  <li>• Use <code className="bg-green-200 px-1 rounded">fullchain.pem</code> as your certificate</li>
+                  <li>• Use <code className="bg-green-200 px-1 rounded">privkey.pem</code> as your private key</li>
+                  <li>• See README.txt for detailed installation instructions</li>
+                </ul>
+              </div>
+            )}
           </div>
         )}
       </div>
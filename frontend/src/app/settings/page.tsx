@@ -9,6 +9,7 @@ import Link from 'next/link'
 export default function Settings() {
   const [settings, setSettings] = useState<CASettings | null>(null)
   const [loading, setLoading] = useState(true)
+  const [trustBase, setTrustBase] = useState('')
 
   useEffect(() => {
     loadSettings()
@@ -18,6 +19,7 @@ export default function Settings() {
     try {
       const response = await certificateApi.getCASettings()
       setSettings(response)
+      setTrustBase((await certificateApi.trustUrl('')).replace(/\/$/, ''))
     } catch (error) {
       console.error('Failed to load CA settings:', error)
       toast.error('Failed to load CA settings')
@@ -31,11 +33,26 @@ export default function Settings() {
     toast.success('Copied to clipboard!')
   }
 
-  const downloadRootCA = () => {
-    // This would typically fetch the root CA certificate
-    toast('Root CA download would be implemented here')
+  const downloadTrustFile = async (file: string) => {
+    window.location.href = await certificateApi.trustUrl(file)
   }
 
+  const downloadRootCA = () => downloadTrustFile('root.pem')
+
+  const trustDownloads = [
+    { file: 'root.der', label: 'Root (DER)' },
+    { file: 'intermediates.pem', label: 'Intermediates (PEM)' },
+    { file: 'bundle.pem', label: 'Bundle (PEM)' },
+    { file: 'bundle.p7b', label: 'Bundle (PKCS#7)' },
+  ]
+
+  const installScripts = [
+    { file: 'install/debian.sh', label: 'Debian / Ubuntu' },
+    { file: 'install/rhel.sh', label: 'RHEL / Fedora' },
+    { file: 'install/alpine.sh', label: 'Alpine' },
+    { file: 'install/java.sh', label: 'Java truststore' },
+  ]
+
   if (loading) {
     return (
       <div className="flex items-center justify-center min-h-screen">
@@ -102,6 +119,27 @@ export default function Settings() {
                     <Copy className="h-4 w-4" />
                   </button>
                 </div>
+                {settings?.root && (
+                  <dl className="mt-2 grid grid-cols-1 gap-1 text-sm text-gray-600">
+                    <div>
+                      <dt className="inline font-medium">Subject: </dt>
+                      <dd className="inline">{settings.root.subject}</dd>
+                    </div>
+                    <div>
+                      <dt className="inline font-medium">Valid: </dt>
+                      <dd className="inline">
+                        {new Date(settings.root.not_before).toLocaleDateString()} &ndash;{' '}
+                        {new Date(settings.root.not_after).toLocaleDateString()}
+                      </dd>
+                    </div>
+                    <div>
+                      <dt className="inline font-medium">Status: </dt>
+                      <dd className={`inline ${settings.root.status === 'ok' ? 'text-green-600' : 'text-red-600'}`}>
+                        {settings.root.status} (checked {new Date(settings.root.checked_at).toLocaleString()})
+                      </dd>
+                    </div>
+                  </dl>
+                )}
               </div>
 
               <div>
@@ -147,23 +185,64 @@ export default function Settings() {
                 </div>
               </div>
 
+              {/* Downloads */}
+              <div>
+                <h3 className="text-lg font-medium text-gray-900 mb-3">Downloads</h3>
+                <div className="flex flex-wrap gap-2">
+                  <button
+                    onClick={downloadRootCA}
+                    className="inline-flex items-center px-3 py-2 border border-transparent text-sm font-medium rounded-md text-white bg-blue-600 hover:bg-blue-700"
+                  >
+                    <Download className="h-4 w-4 mr-1" />
+                    Download Root CA
+                  </button>
+                  {trustDownloads.map(({ file, label }) => (
+                    <button
+                      key={file}
+                      onClick={() => downloadTrustFile(file)}
+                      className="inline-flex items-center px-3 py-2 border border-gray-300 shadow-sm text-sm font-medium rounded-md text-gray-700 bg-white hover:bg-gray-50"
+                    >
+                      <Download className="h-4 w-4 mr-1" />
+                      {label}
+                    </button>
+                  ))}
+                </div>
+                <h4 className="mt-4 text-sm font-medium text-gray-900">Install scripts</h4>
+                <div className="mt-2 space-y-2">
+                  {installScripts.map(({ file, label }) => (
+                    <div key={file} className="flex items-center">
+                      <span className="w-40 text-sm text-gray-700">{label}</span>
+                      <code className="flex-1 text-xs bg-gray-50 rounded px-2 py-1 font-mono">
+                        {`curl -fsSL ${trustBase}/${file} | sudo sh`}
+                      </code>
+                      <button
+                        onClick={() => copyToClipboard(`curl -fsSL ${trustBase}/${file} | sudo sh`)}
+                        className="ml-2 inline-flex items-center px-3 py-2 border border-gray-300 shadow-sm text-sm leading-4 font-medium rounded-md text-gray-700 bg-white hover:bg-gray-50"
+                      >
+                        <Copy className="h-4 w-4" />
+                      </button>
+                    </div>
+                  ))}
+                </div>
+              </div>
+
               {/* Linux/Ubuntu Instructions */}
               <div>
                 <h3 className="text-lg font-medium text-gray-900 mb-3">Linux/Ubuntu</h3>
                 <div className="bg-gray-50 rounded-md p-4">
                   <pre className="text-sm text-gray-800 whitespace-pre-wrap">
 {`# Download the root CA certificate
-curl -O ${settings?.ca_url}/roots.pem
+curl -fsSLO ${trustBase}/root.pem
 
 # Install the certificate
-sudo cp roots.pem /usr/local/share/ca-certificates/my-ca.crt
+sudo cp root.pem /usr/local/share/ca-certificates/my-ca.crt
 sudo update-ca-certificates
 
 # Verify installation
 openssl verify -CAfile /etc/ssl/certs/my-ca.pem /path/to/your/certificate.pem`}
                   </pre>
                   <button
-                    onClick={() => copyToClipboard(`curl -O ${settings?.ca_url}/roots.pem\nsudo cp roots.pem /usr/local/share/ca-certificates/my-ca.crt\nsudo update-ca-certificates`)}
+                    onClick={() => copyToClipboard(`curl -fsSLO ${trustBase}/root.pem\nsudo cp root.pem /usr/local/share/ca-certificates/my-ca.crt\nsudo update-ca-certificates`)}
                     className="mt-2 inline-flex items-center px-3 py-1 border border-transparent text-sm font-medium rounded text-blue-700 bg-blue-100 hover:bg-blue-200"
                   >
                     <Copy className="h-4 w-4 mr-1" />
@@ -179,16 +258,16 @@ openssl verify -CAfile /etc/ssl/certs/my-ca.pem /path/to/your/certificate.pem`}
                   <pre className="text-sm text-gray-800 whitespace-pre-wrap">
 {`# PowerShell (Run as Administrator)
 # Download the root CA certificate
-Invoke-WebRequest -Uri "${settings?.ca_url}/roots.pem" -OutFile "roots.pem"
+Invoke-WebRequest -Uri "${trustBase}/root.pem" -OutFile "root.pem"
 
 # Import the certificate
-Import-Certificate -FilePath "roots.pem" -CertStoreLocation Cert:\LocalMachine\Root
+Import-Certificate -FilePath "root.pem" -CertStoreLocation Cert:\LocalMachine\Root
 
 # Verify installation
 Get-ChildItem -Path Cert:\LocalMachine\Root | Where-Object {$_.Subject -like "*Your CA Name*"}`}
                   </pre>
                   <button
-                    onClick={() => copyToClipboard(`Invoke-WebRequest -Uri "${settings?.ca_url}/roots.pem" -OutFile "roots.pem"\nImport-Certificate -FilePath "roots.pem" -CertStoreLocation Cert:\LocalMachine\Root`)}
+                    onClick={() => copyToClipboard(`Invoke-WebRequest -Uri "${trustBase}/root.pem" -OutFile "root.pem"\nImport-Certificate -FilePath "root.pem" -CertStoreLocation Cert:\LocalMachine\Root`)}
                     className="mt-2 inline-flex items-center px-3 py-1 border border-transparent text-sm font-medium rounded text-blue-700 bg-blue-100 hover:bg-blue-200"
                   >
                     <Copy className="h-4 w-4 mr-1" />
@@ -203,19 +282,19 @@ Get-ChildItem -Path Cert:\LocalMachine\Root | Where-Object {$_.Subject -like "*Y
                 <div className="bg-gray-50 rounded-md p-4">
                   <pre className="text-sm text-gray-800 whitespace-pre-wrap">
 {`# Download the root CA certificate
-curl -O ${settings?.ca_url}/roots.pem
+curl -fsSLO ${trustBase}/root.pem
 
 # Add to Keychain (double-click the .pem file)
-open roots.pem
+open root.pem
 
 # Or via command line
-sudo security add-trusted-cert -d -r trustRoot -k /Library/Keychains/System.keychain roots.pem
+sudo security add-trusted-cert -d -r trustRoot -k /Library/Keychains/System.keychain root.pem
 
 # Verify installation
 security find-certificate -a -c "Your CA Name" /Library/Keychains/System.keychain`}
                   </pre>
                   <button
-                    onClick={() => copyToClipboard(`curl -O ${settings?.ca_url}/roots.pem\nsudo security add-trusted-cert -d -r trustRoot -k /Library/Keychains/System.keychain roots.pem`)}
+                    onClick={() => copyToClipboard(`curl -fsSLO ${trustBase}/root.pem\nsudo security add-trusted-cert -d -r trustRoot -k /Library/Keychains/System.keychain root.pem`)}
                     className="mt-2 inline-flex items-center px-3 py-1 border border-transparent text-sm font-medium rounded text-blue-700 bg-blue-100 hover:bg-blue-200"
                   >
                     <Copy className="h-4 w-4 mr-1" />
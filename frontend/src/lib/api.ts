@@ -44,38 +44,289 @@ api.interceptors.request.use(async (config: any) => {
 
 export interface Certificate {
   id: string
+  cert_type: 'x509' | 'ssh-user' | 'ssh-host'
+  serial?: string
   cn: string
   sans: string[]
   not_after: string
   status: string
   key_strategy: string
+  key_type?: string
+  profile?: string
+  storage_ref: string
+  acme_account?: string
+  acme_order?: string
   created_at: string
   updated_at: string
 }
 
+export type BundleFormat = 'pem' | 'pfx' | 'jks' | 'tar.gz' | 'combined' | 'der' | 'pkcs8' | 'p7b' | 'k8s'
+
 export interface IssueRequest {
   cn: string
   sans: string[]
-  not_after_days: number
-  format: 'pem' | 'pfx'
+  not_after_days?: number
+  not_before?: string
+  not_after?: string
+  format: BundleFormat
   pfx_password?: string
+  pfx_encryption?: 'modern' | 'legacy'
+  friendly_name?: string
+  keystore_alias?: string
+  keystore_password?: string
+  key_password?: string
+  zip_password?: string
+  secret_name?: string
+  namespace?: string
+  profile?: string
+  key_type?: string
+  exclude_root?: boolean
+  targets?: TargetRequest[]
+}
+
+export interface IssuanceProfile {
+  name: string
+  description: string
+  default_days: number
+  max_days: number
+  allowed_sans: string[]
+  key_type: string
+  key_usages: string[]
+  ext_key_usages: string[]
+  format: string
+  provisioner?: { type: string; name: string }
 }
 
 export interface SignCSRRequest {
   csr_pem: string
-  not_after_days: number
+  not_after_days?: number
+  not_before?: string
+  not_after?: string
+  exclude_root?: boolean
+  targets?: TargetRequest[]
+}
+
+export interface SSHSignRequest {
+  public_key: string
+  cert_type: 'user' | 'host'
+  key_id?: string
+  principals: string[]
+  valid_for?: string
+  not_before?: string
+  not_after?: string
+  critical_options?: Record<string, string>
+  extensions?: Record<string, string>
+}
+
+export interface SSHSignResponse {
+  certificate: Certificate
+  ssh_certificate: string
+  valid_after: string
+  valid_before: string
+}
+
+export interface SSHRoots {
+  user_ca_keys: string[]
+  host_ca_keys: string[]
+  known_hosts: string[]
+}
+
+export interface SFTPTargetConfig {
+  host: string
+  port?: number
+  user: string
+  host_key?: string
+  cert_path?: string
+  chain_path?: string
+  fullchain_path?: string
+  key_path?: string
+  owner?: string
+  mode?: string
+  key_mode?: string
+  post_command?: string
+  timeout?: string
+}
+
+export interface KubernetesTargetConfig {
+  name: string
+  namespace?: string
+  context?: string
+  labels?: Record<string, string>
+  timeout?: string
+}
+
+export interface FilesystemTargetConfig {
+  cert_path?: string
+  chain_path?: string
+  fullchain_path?: string
+  key_path?: string
+  owner?: string
+  mode?: string
+  key_mode?: string
+  keep?: number
+  post_command?: string
+  timeout?: string
+}
+
+export interface TargetRequest {
+  name: string
+  type: 'sftp' | 'kubernetes' | 'filesystem'
+  config: SFTPTargetConfig | KubernetesTargetConfig | FilesystemTargetConfig
+  enabled?: boolean
+}
+
+export interface DeployTarget extends TargetRequest {
+  id: string
+  cert_id: string
+  enabled: boolean
+  created_by: string
+  created_at: string
+  updated_at: string
+}
+
+export interface Deployment {
+  id: string
+  target_id: string
+  cert_id: string
+  trigger: 'issued' | 'renewed'
+  status: 'pending' | 'running' | 'succeeded' | 'failed'
+  serial: string
+  output: string
+  error: string
+  started_at: string | null
+  finished_at: string | null
+  created_at: string
 }
 
-export interface CertBundle {
-  data: string
+export interface FieldError {
+  field: string
+  message: string
+}
+
+// One-time link to a rendered bundle, valid until expires_at
+export interface DownloadLink {
+  url: string
   filename: string
   mime_type: string
+  expires_at: string
+}
+
+export interface ApprovalRequest {
+  id: string
+  kind: 'issue' | 'sign_csr' | 'renew'
+  cn: string
+  sans: string[]
+  profile?: string
+  status: 'pending' | 'approved' | 'rejected' | 'expired' | 'failed'
+  matched_rules: string[]
+  requested_by: string
+  decided_by?: string
+  reason?: string
+  cert_id?: string
+  expires_at: string
+  decided_at?: string
+  created_at: string
+}
+
+export interface CARoot {
+  fingerprint: string
+  subject: string
+  not_before: string
+  not_after: string
+  checked_at: string
+  status: string
+}
+
+export interface ACMEProvisioner {
+  name: string
+  directory: string
 }
 
 export interface CASettings {
   ca_url: string
-  root_fingerprint: string
+  root_fingerprint: string | null
+  root?: CARoot
   acme_directories: string[]
+  acme_provisioners?: ACMEProvisioner[]
+  acme_provisioners_error?: string
+}
+
+export interface ACMEAccount {
+  id: string
+  contact: string[]
+  status: string
+  provisioners: string[]
+  orders: Record<string, number>
+  certificates: number
+  created_at: string
+}
+
+export interface ACMEOrder {
+  id: string
+  provisioner: string
+  identifiers: { type: string; value: string }[]
+  status: string
+  cert_id?: string
+  created_at: string
+  expires_at: string
+}
+
+export type ProvisionerType = 'JWK' | 'OIDC' | 'ACME' | 'X5C' | 'K8SSA'
+
+export interface ProvisionerClaims {
+  default_tls_duration?: string
+  min_tls_duration?: string
+  max_tls_duration?: string
+  disable_renewal?: boolean
+}
+
+export interface Provisioner {
+  type: string
+  name: string
+  claims: ProvisionerClaims
+  details?: Record<string, unknown>
+}
+
+export interface ProvisionerRequest {
+  type?: ProvisionerType
+  name?: string
+  claims?: ProvisionerClaims
+  jwk?: { password?: string; public_key?: Record<string, unknown>; encrypted_private_key?: string }
+  oidc?: {
+    client_id?: string
+    client_secret?: string
+    configuration_endpoint?: string
+    admins?: string[]
+    domains?: string[]
+    groups?: string[]
+    listen_address?: string
+    tenant_id?: string
+  }
+  acme?: { force_cn?: boolean; require_eab?: boolean; challenges?: string[] }
+  x5c?: { roots: string }
+  k8ssa?: { public_keys: string }
+}
+
+export interface RevocationCheck {
+  source: 'crl' | 'ocsp'
+  url: string
+  status: 'good' | 'revoked' | 'unknown'
+  revoked_at?: string
+  reason?: string
+  this_update?: string
+  next_update?: string
+  error?: string
+}
+
+export interface RevocationStatus {
+  cert_id: string
+  serial: string
+  inventory_status: string
+  ca_status: 'good' | 'revoked' | 'unknown'
+  consistent?: boolean
+  reconciled: boolean
+  checks: RevocationCheck[]
+  checked_at: string
 }
 
 export const certificateApi = {
@@ -98,12 +349,28 @@ export const certificateApi = {
     limit?: number
     offset?: number
     status?: string
+    type?: Certificate['cert_type']
+    acme_account?: string
   }) => {
     const client = await createApiClient()
     const response = await client.get('/api/certs', { params })
     return response.data
   },
 
+  // Sign an SSH user or host certificate
+  signSSH: async (data: SSHSignRequest) => {
+    const client = await createApiClient()
+    const response = await client.post('/api/ssh/sign', data)
+    return response.data
+  },
+
+  // Get the SSH user and host CA keys
+  getSSHRoots: async () => {
+    const client = await createApiClient()
+    const response = await client.get('/api/ssh/roots')
+    return response.data
+  },
+
   // Get a specific certificate
   getCertificate: async (id: string) => {
     const client = await createApiClient()
@@ -125,6 +392,146 @@ export const certificateApi = {
     return response.data
   },
 
+  // Check a certificate against the CA's CRL and OCSP responder
+  getRevocationStatus: async (id: string) => {
+    const client = await createApiClient()
+    const response = await client.get(`/api/certs/${id}/revocation-status`)
+    return response.data
+  },
+
+  // List deployment targets of a certificate
+  listTargets: async (certId: string) => {
+    const client = await createApiClient()
+    const response = await client.get(`/api/certs/${certId}/targets`)
+    return response.data
+  },
+
+  // Add a deployment target
+  createTarget: async (certId: string, data: TargetRequest) => {
+    const client = await createApiClient()
+    const response = await client.post(`/api/certs/${certId}/targets`, data)
+    return response.data
+  },
+
+  // Replace a deployment target
+  updateTarget: async (certId: string, targetId: string, data: TargetRequest) => {
+    const client = await createApiClient()
+    const response = await client.put(`/api/certs/${certId}/targets/${targetId}`, data)
+    return response.data
+  },
+
+  // Remove a deployment target
+  deleteTarget: async (certId: string, targetId: string) => {
+    const client = await createApiClient()
+    const response = await client.delete(`/api/certs/${certId}/targets/${targetId}`)
+    return response.data
+  },
+
+  // Recent deployments of a certificate
+  listDeployments: async (certId: string) => {
+    const client = await createApiClient()
+    const response = await client.get(`/api/certs/${certId}/deployments`)
+    return response.data
+  },
+
+  // List approval requests
+  listApprovals: async (params?: { status?: string; limit?: number; offset?: number }) => {
+    const client = await createApiClient()
+    const response = await client.get('/api/approvals', { params })
+    return response.data
+  },
+
+  // Approve a pending request
+  approveRequest: async (id: string, reason?: string) => {
+    const client = await createApiClient()
+    const response = await client.post(`/api/approvals/${id}/approve`, { reason })
+    return response.data
+  },
+
+  // Reject a pending request
+  rejectRequest: async (id: string, reason?: string) => {
+    const client = await createApiClient()
+    const response = await client.post(`/api/approvals/${id}/reject`, { reason })
+    return response.data
+  },
+
+  // Collect the bundle of an approved request
+  downloadApproved: async (id: string, data: Partial<Omit<IssueRequest, 'cn' | 'sans' | 'profile' | 'key_type' | 'exclude_root'>>) => {
+    const client = await createApiClient()
+    const response = await client.post(`/api/approvals/${id}/download`, data)
+    return response.data
+  },
+
+  // Fetch the file behind a download link; each link works once
+  fetchDownload: async (link: DownloadLink) => {
+    const client = await createApiClient()
+    const response = await client.get(link.url, { responseType: 'blob' })
+    return response.data as Blob
+  },
+
+  // Dry-run the naming policy
+  evaluatePolicy: async (data: { cn: string; sans: string[]; csr_pem?: string }) => {
+    const client = await createApiClient()
+    const response = await client.post('/api/policy/evaluate', data)
+    return response.data
+  },
+
+  // List issuance profiles
+  listProfiles: async () => {
+    const client = await createApiClient()
+    const response = await client.get('/api/profiles')
+    return response.data
+  },
+
+  // List the ACME accounts of the CA
+  listACMEAccounts: async () => {
+    const client = await createApiClient()
+    const response = await client.get('/api/acme/accounts')
+    return response.data
+  },
+
+  // List the orders of an ACME account
+  listACMEOrders: async (accountId: string) => {
+    const client = await createApiClient()
+    const response = await client.get(`/api/acme/accounts/${accountId}/orders`)
+    return response.data
+  },
+
+  // List the provisioners of the CA
+  listProvisioners: async () => {
+    const client = await createApiClient()
+    const response = await client.get('/api/provisioners')
+    return response.data
+  },
+
+  // Get a provisioner with its type specific settings
+  getProvisioner: async (name: string) => {
+    const client = await createApiClient()
+    const response = await client.get(`/api/provisioners/${encodeURIComponent(name)}`)
+    return response.data
+  },
+
+  // Create a provisioner
+  createProvisioner: async (data: ProvisionerRequest) => {
+    const client = await createApiClient()
+    const response = await client.post('/api/provisioners', data)
+    return response.data
+  },
+
+  // Change the claims or settings of a provisioner
+  updateProvisioner: async (name: string, data: ProvisionerRequest) => {
+    const client = await createApiClient()
+    const response = await client.put(`/api/provisioners/${encodeURIComponent(name)}`, data)
+    return response.data
+  },
+
+  // Remove a provisioner
+  deleteProvisioner: async (name: string) => {
+    const client = await createApiClient()
+    const response = await client.delete(`/api/provisioners/${encodeURIComponent(name)}`)
+    return response.data
+  },
+
   // Get CA settings
   getCASettings: async () => {
     const client = await createApiClient()
@@ -132,6 +539,12 @@ export const certificateApi = {
     return response.data
   },
 
+  // URL of a trust file such as root.pem, bundle.p7b or install/debian.sh
+  trustUrl: async (file: string) => {
+    const apiUrl = await getApiUrl()
+    return `${apiUrl.replace(/\/$/, '')}/trust/${file}`
+  },
+
   // Health check
   health: async () => {
     const client = await createApiClient()
@@ -31,7 +31,7 @@ export function getExpiryStatus(notAfter: string) {
   return 'good'
 }
 
-export function downloadFile(data: string, filename: string, mimeType: string) {
+export function downloadFile(data: BlobPart, filename: string, mimeType: string) {
   const blob = new Blob([data], { type: mimeType })
   const url = window.URL.createObjectURL(blob)
   const link = document.createElement('a')
@@ -42,21 +42,3 @@ export function downloadFile(data: string, filename: string, mimeType: string) {
   document.body.removeChild(link)
   window.URL.revokeObjectURL(url)
 }
-
-export function downloadBase64File(base64Data: string, filename: string, mimeType: string) {
-  const byteCharacters = atob(base64Data)
-  const byteNumbers = new Array(byteCharacters.length)
-  for (let i = 0; i < byteCharacters.length; i++) {
-    byteNumbers[i] = byteCharacters.charCodeAt(i)
-  }
-  const byteArray = new Uint8Array(byteNumbers)
-  const blob = new Blob([byteArray], { type: mimeType })
-  const url = window.URL.createObjectURL(blob)
-  const link = document.createElement('a')
-  link.href = url
-  link.download = filename
-  document.body.appendChild(link)
-  link.click()
-  document.body.removeChild(link)
-  window.URL.revokeObjectURL(url)
-}